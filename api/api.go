@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +20,9 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/products"
+	"github.com/netlify/gocommerce/settings"
+	"github.com/netlify/gocommerce/vatnumbers"
 )
 
 const (
@@ -31,11 +35,15 @@ var (
 
 // API is the main REST API
 type API struct {
-	handler    http.Handler
-	db         *gorm.DB
-	config     *conf.GlobalConfiguration
-	httpClient *http.Client
-	version    string
+	handler http.Handler
+	db      *gorm.DB
+	config  *conf.GlobalConfiguration
+	version string
+
+	productCaches   map[string]*products.Cache
+	settingsCaches  map[string]*settings.Cache
+	vatNumberCaches map[string]*vatnumbers.Cache
+	cachesLock      sync.Mutex
 }
 
 // ListenAndServe starts the REST API.
@@ -80,10 +88,13 @@ func NewAPI(globalConfig *conf.GlobalConfiguration, log logrus.FieldLogger, db *
 // NewAPIWithVersion instantiates a new REST API.
 func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfiguration, log logrus.FieldLogger, db *gorm.DB, version string) *API {
 	api := &API{
-		config:     globalConfig,
-		db:         db,
-		httpClient: &http.Client{},
-		version:    version,
+		config:  globalConfig,
+		db:      db,
+		version: version,
+
+		productCaches:   map[string]*products.Cache{},
+		settingsCaches:  map[string]*settings.Cache{},
+		vatNumberCaches: map[string]*vatnumbers.Cache{},
 	}
 
 	xffmw, _ := xff.Default()
@@ -125,6 +136,80 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 			})
 		})
 
+		r.With(adminRequired).Get("/events", api.EventList)
+
+		r.Route("/returns", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.ReturnList)
+			r.Route("/{return_id}", func(r *router) {
+				r.Get("/", api.ReturnView)
+				r.Post("/approve", api.ReturnApprove)
+				r.Post("/reject", api.ReturnReject)
+				r.Post("/receive", api.ReturnReceive)
+			})
+		})
+
+		r.Route("/subscriptions", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.SubscriptionList)
+			r.Route("/{subscription_id}", func(r *router) {
+				r.Get("/", api.SubscriptionView)
+				r.Post("/pause", api.SubscriptionPause)
+				r.Post("/resume", api.SubscriptionResume)
+				r.Post("/cancel", api.SubscriptionCancel)
+			})
+		})
+
+		r.Route("/gift-cards", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.GiftCardList)
+			r.Post("/", api.GiftCardIssue)
+			r.Route("/{gift_card_id}", func(r *router) {
+				r.Get("/", api.GiftCardView)
+				r.Post("/adjust", api.GiftCardAdjust)
+				r.Post("/void", api.GiftCardVoid)
+			})
+		})
+
+		r.Route("/inventory", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.InventoryList)
+			r.Route("/{sku}", func(r *router) {
+				r.Get("/", api.InventoryView)
+				r.Put("/", api.InventorySet)
+				r.Delete("/", api.InventoryDelete)
+				r.Post("/adjust", api.InventoryAdjust)
+			})
+		})
+
+		r.Route("/exchange-rates", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.ExchangeRateList)
+			r.Route("/{from}/{to}", func(r *router) {
+				r.Get("/", api.ExchangeRateView)
+				r.Put("/", api.ExchangeRateSet)
+				r.Delete("/", api.ExchangeRateDelete)
+			})
+		})
+
+		r.Get("/automatic-promotions", api.AutomaticPromotionList)
+
+		r.Route("/promotions", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.PromotionList)
+			r.Route("/{promotion_id}", func(r *router) {
+				r.Get("/", api.PromotionView)
+				r.Put("/", api.PromotionSet)
+				r.Delete("/", api.PromotionDelete)
+			})
+		})
+
 		r.Route("/paypal", func(r *router) {
 			r.With(addGetBody).Post("/", api.PreauthorizePayment)
 		})
@@ -134,6 +219,8 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 
 			r.Get("/sales", api.SalesReport)
 			r.Get("/products", api.ProductsReport)
+			r.Get("/abandoned", api.AbandonedCheckoutReport)
+			r.Get("/at-risk", api.AtRiskRevenueReport)
 		})
 
 		r.Route("/coupons", func(r *router) {
@@ -141,7 +228,14 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 			r.Get("/{coupon_code}", api.CouponView)
 		})
 
-		r.Get("/settings", api.ViewSettings)
+		r.Route("/settings", func(r *router) {
+			r.Get("/", api.ViewSettings)
+			r.With(adminRequired).Get("/validation", api.SettingsValidation)
+		})
+
+		r.Route("/products", func(r *router) {
+			r.With(adminRequired).Delete("/cache", api.ProductCachePurge)
+		})
 
 		r.With(authRequired).Post("/claim", api.ClaimOrders)
 	})
@@ -189,11 +283,25 @@ func (a *API) orderRoutes(r *router) {
 			r.With(authRequired).Get("/", a.PaymentListForOrder)
 			r.With(addGetBody).Post("/", a.PaymentCreate)
 		})
+		r.Post("/complete", a.PaymentCreateFree)
+		r.Route("/invoice", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Post("/", a.InvoiceIssue)
+			r.Post("/paid", a.InvoicePaid)
+		})
 
 		r.Route("/downloads", func(r *router) {
 			r.Get("/", a.DownloadList)
 			r.Post("/refresh", a.DownloadRefresh)
 		})
+		r.With(adminRequired).Get("/events", a.EventListForOrder)
+
+		r.Route("/returns", func(r *router) {
+			r.Get("/", a.ReturnListForOrder)
+			r.Post("/", a.ReturnCreate)
+		})
+
 		r.Get("/receipt", a.ReceiptView)
 		r.Post("/receipt", a.ResendOrderReceipt)
 	})
@@ -222,6 +330,12 @@ func (a *API) userRoutes(r *router) {
 				r.With(adminRequired).Delete("/", a.AddressDelete)
 			})
 		})
+
+		r.Route("/payment_methods", func(r *router) {
+			r.Get("/", a.PaymentMethodList)
+			r.With(addGetBody).Post("/", a.PaymentMethodCreate)
+			r.Delete("/{payment_method_id}", a.PaymentMethodDelete)
+		})
 	})
 }
 
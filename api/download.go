@@ -137,7 +137,6 @@ func (a *API) DownloadList(w http.ResponseWriter, r *http.Request) error {
 func (a *API) DownloadRefresh(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	orderID := gcontext.GetOrderID(ctx)
-	config := gcontext.GetConfig(ctx)
 	log := getLogEntry(r)
 
 	order := &models.Order{}
@@ -163,7 +162,7 @@ func (a *API) DownloadRefresh(w http.ResponseWriter, r *http.Request) error {
 		return unauthorizedError("This order has not been completed yet")
 	}
 
-	if err := order.UpdateDownloads(config, log); err != nil {
+	if err := order.UpdateDownloads(gcontext.GetProducts(ctx), log); err != nil {
 		return internalServerError("Error during updating downloads").WithInternalError(err)
 	}
 
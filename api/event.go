@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+// EventList lists the events of all orders, newest first.
+func (a *API) EventList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	orderTable := db.NewScope(models.Order{}).QuotedTableName()
+	eventTable := db.NewScope(models.Event{}).QuotedTableName()
+	query := db.
+		Joins("JOIN "+orderTable+" ON "+eventTable+".order_id = "+orderTable+".id").
+		Where(orderTable+".instance_id = ?", instanceID)
+
+	query, err := parseEventQueryParams(query, r.URL.Query())
+	if err != nil {
+		return badRequestError("Bad parameters in query: %v", err)
+	}
+	return a.sendEvents(w, r, query)
+}
+
+// EventListForOrder lists the events of a single order, newest first.
+func (a *API) EventListForOrder(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+
+	order, httpErr := queryForOrder(db, gcontext.GetOrderID(r.Context()), log)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	eventTable := db.NewScope(models.Event{}).QuotedTableName()
+	query, err := parseEventQueryParams(db.Where(eventTable+".order_id = ?", order.ID), r.URL.Query())
+	if err != nil {
+		return badRequestError("Bad parameters in query: %v", err)
+	}
+	return a.sendEvents(w, r, query)
+}
+
+func (a *API) sendEvents(w http.ResponseWriter, r *http.Request, query *gorm.DB) error {
+	offset, limit, err := paginate(w, r, query.Model(&models.Event{}))
+	if err != nil {
+		return badRequestError("Bad Pagination Parameters: %v", err)
+	}
+
+	eventTable := query.NewScope(models.Event{}).QuotedTableName()
+	var events []models.Event
+	if result := query.Select(eventTable + ".*").Order(eventTable + ".created_at desc, " + eventTable + ".id desc").Offset(offset).Limit(limit).Find(&events); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/models"
+)
+
+func TestEventListForOrder(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+
+	op := &orderRequestParams{
+		Email:            "mrfreeze@dc.com",
+		FulfillmentState: "shipping",
+	}
+	recorder := runOrderUpdate(test, test.Data.firstOrder, op, token)
+	extractPayload(t, http.StatusOK, recorder, new(models.Order))
+
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/"+test.Data.firstOrder.ID+"/events", nil, token)
+	events := []models.Event{}
+	extractPayload(t, http.StatusOK, recorder, &events)
+
+	require.Len(t, events, 1)
+	event := events[0]
+	assert.Equal(t, string(models.EventUpdated), event.Type)
+	assert.Equal(t, "admin-yo", event.UserID)
+	assert.Equal(t, "email,fulfillment_state", event.Changes)
+	require.Len(t, event.Values, 2)
+	assert.Equal(t, models.FieldChange{Field: "email", Old: test.Data.firstOrder.Email, New: "mrfreeze@dc.com"}, event.Values[0])
+	assert.Equal(t, models.FieldChange{Field: "fulfillment_state", Old: test.Data.firstOrder.FulfillmentState, New: "shipping"}, event.Values[1])
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/"+test.Data.firstOrder.ID+"/events", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+	t.Run("UnknownOrder", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/nothing/events", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
+
+func TestEventList(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+
+	models.LogEvent(test.DB, "127.0.0.1", test.Data.testUser.ID, test.Data.firstOrder.ID, models.EventCreated, nil)
+	models.LogEvent(test.DB, "127.0.0.1", test.Data.testUser.ID, test.Data.secondOrder.ID, models.EventCreated, nil)
+	models.LogChanges(test.DB, "127.0.0.2", "admin-yo", test.Data.secondOrder.ID, models.EventUpdated, []models.FieldChange{
+		{Field: "email", Old: "a@example.com", New: "b@example.com"},
+	})
+
+	t.Run("All", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/events", nil, token)
+		events := []models.Event{}
+		extractPayload(t, http.StatusOK, recorder, &events)
+		assert.Len(t, events, 3)
+		assert.Equal(t, "3", recorder.Header().Get("X-Total-Count"))
+	})
+	t.Run("Filtered", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/events?type=updated&order_id="+test.Data.secondOrder.ID, nil, token)
+		events := []models.Event{}
+		extractPayload(t, http.StatusOK, recorder, &events)
+		require.Len(t, events, 1)
+		assert.Equal(t, "admin-yo", events[0].UserID)
+		assert.Equal(t, "email", events[0].Changes)
+	})
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/events", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
+)
+
+type exchangeRateParams struct {
+	Rate string `json:"rate"`
+}
+
+// ExchangeRateList lists the exchange rates used to derive missing prices.
+func (a *API) ExchangeRateList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	var rates []models.ExchangeRate
+	result := db.Where("instance_id = ?", instanceID).Order("from_currency asc, to_currency asc").Find(&rates)
+	if result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, rates)
+}
+
+// ExchangeRateView shows the exchange rate between two currencies.
+func (a *API) ExchangeRateView(w http.ResponseWriter, r *http.Request) error {
+	from, to := exchangeRatePair(r)
+	rate, httpErr := getExchangeRate(a.DB(r), gcontext.GetInstanceID(r.Context()), from, to)
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, rate)
+}
+
+// ExchangeRateSet sets the exchange rate between two currencies.
+func (a *API) ExchangeRateSet(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+	from, to := exchangeRatePair(r)
+
+	params := &exchangeRateParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if !money.KnownCurrency(from) || !money.KnownCurrency(to) {
+		return badRequestError("Unknown currency in %s/%s", from, to)
+	}
+	if from == to {
+		return badRequestError("Can't set an exchange rate from %s to itself", from)
+	}
+	if _, err := money.ParseRate(params.Rate); err != nil {
+		return badRequestError(err.Error())
+	}
+
+	rate, err := models.GetExchangeRate(db, instanceID, from, to)
+	if err != nil {
+		return internalServerError("Error during database query").WithInternalError(err)
+	}
+	if rate == nil {
+		rate = &models.ExchangeRate{InstanceID: instanceID, FromCurrency: from, ToCurrency: to}
+	}
+	rate.Rate = params.Rate
+	if result := db.Save(rate); result.Error != nil {
+		return internalServerError("Error saving exchange rate").WithInternalError(result.Error)
+	}
+
+	log.WithField("from", from).WithField("to", to).Infof("Set exchange rate to %s", rate.Rate)
+	return sendJSON(w, http.StatusOK, rate)
+}
+
+// ExchangeRateDelete removes the exchange rate between two currencies.
+func (a *API) ExchangeRateDelete(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+	from, to := exchangeRatePair(r)
+
+	rate, httpErr := getExchangeRate(db, gcontext.GetInstanceID(r.Context()), from, to)
+	if httpErr != nil {
+		return httpErr
+	}
+	if result := db.Delete(rate); result.Error != nil {
+		return internalServerError("Error deleting exchange rate").WithInternalError(result.Error)
+	}
+
+	log.WithField("from", from).WithField("to", to).Info("Deleted exchange rate")
+	return sendJSON(w, http.StatusOK, map[string]string{})
+}
+
+func exchangeRatePair(r *http.Request) (string, string) {
+	return strings.ToUpper(chi.URLParam(r, "from")), strings.ToUpper(chi.URLParam(r, "to"))
+}
+
+func getExchangeRate(db *gorm.DB, instanceID, from, to string) (*models.ExchangeRate, *HTTPError) {
+	rate, err := models.GetExchangeRate(db, instanceID, from, to)
+	if err != nil {
+		return nil, internalServerError("Error during database query").WithInternalError(err)
+	}
+	if rate == nil {
+		return nil, notFoundError("No exchange rate from %s to %s", from, to)
+	}
+	return rate, nil
+}
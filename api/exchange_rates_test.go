@@ -0,0 +1,155 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
+)
+
+func TestExchangeRateEndpoints(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("magical-unicorn", "")
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/exchange-rates", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPut, "/exchange-rates/usd/eur", strings.NewReader(`{"rate": "0.92"}`), token)
+		rate := &models.ExchangeRate{}
+		extractPayload(t, http.StatusOK, recorder, rate)
+		assert.Equal(t, "USD", rate.FromCurrency)
+		assert.Equal(t, "EUR", rate.ToCurrency)
+		assert.Equal(t, "0.92", rate.Rate)
+
+		recorder = test.TestEndpoint(http.MethodPut, "/exchange-rates/USD/EUR", strings.NewReader(`{"rate": "0.95"}`), token)
+		extractPayload(t, http.StatusOK, recorder, rate)
+		assert.Equal(t, "0.95", rate.Rate)
+
+		recorder = test.TestEndpoint(http.MethodPut, "/exchange-rates/USD/EUR", strings.NewReader(`{"rate": "-1"}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "Invalid exchange rate")
+		recorder = test.TestEndpoint(http.MethodPut, "/exchange-rates/USD/EURO", strings.NewReader(`{"rate": "1"}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "Unknown currency")
+		recorder = test.TestEndpoint(http.MethodPut, "/exchange-rates/USD/USD", strings.NewReader(`{"rate": "1"}`), token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("ViewAndList", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPut, "/exchange-rates/USD/JPY", strings.NewReader(`{"rate": "149.5"}`), token)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/exchange-rates/USD/JPY", nil, token)
+		rate := &models.ExchangeRate{}
+		extractPayload(t, http.StatusOK, recorder, rate)
+		assert.Equal(t, "149.5", rate.Rate)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/exchange-rates", nil, token)
+		rates := []models.ExchangeRate{}
+		extractPayload(t, http.StatusOK, recorder, &rates)
+		require.Len(t, rates, 2)
+		assert.Equal(t, "EUR", rates[0].ToCurrency)
+		assert.Equal(t, "JPY", rates[1].ToCurrency)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodDelete, "/exchange-rates/USD/JPY", nil, token)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/exchange-rates/USD/JPY", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
+
+func TestOrderCreateWithExchangeRate(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	payload := func(currency, path string) *strings.Reader {
+		return strings.NewReader(`{
+			"email": "info@example.com",
+			"currency": "` + currency + `",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "` + path + `", "quantity": 1}]
+		}`)
+	}
+
+	t.Run("MissingRate", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("EUR", "/simple-product"), test.Data.testUserToken)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+
+	t.Run("Converted", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.ExchangeRates.Rounding = money.RoundNinetyNine
+		require.NoError(t, test.DB.Create(&models.ExchangeRate{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.9215"}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("EUR", "/simple-product"), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "EUR", order.Currency)
+		assert.EqualValues(t, 999, order.LineItems[0].Price)
+		assert.EqualValues(t, 999, order.Total)
+		require.Len(t, order.ExchangeRates, 1)
+		assert.Equal(t, models.AppliedExchangeRate{From: "USD", To: "EUR", Rate: "0.9215", Rounding: "99"}, *order.ExchangeRates[0])
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		require.Len(t, saved.ExchangeRates, 1)
+		assert.Equal(t, "0.9215", saved.ExchangeRates[0].Rate)
+	})
+
+	t.Run("ConvertedBundle", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		require.NoError(t, test.DB.Create(&models.ExchangeRate{FromCurrency: "USD", ToCurrency: "JPY", Rate: "150"}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("JPY", "/bundle-product"), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		item := order.LineItems[0]
+		assert.EqualValues(t, 1499, item.Price)
+		require.Len(t, item.PriceItems, 2)
+		assert.EqualValues(t, 1050, item.PriceItems[0].Amount)
+		assert.EqualValues(t, 449, item.PriceItems[1].Amount)
+	})
+
+	t.Run("ListedPriceWins", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		require.NoError(t, test.DB.Create(&models.ExchangeRate{FromCurrency: "USD", ToCurrency: "EUR", Rate: "2"}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USD", "/simple-product"), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.EqualValues(t, 999, order.LineItems[0].Price)
+		assert.Empty(t, order.ExchangeRates)
+	})
+
+	t.Run("UnknownRounding", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.ExchangeRates.Rounding = "sideways"
+		require.NoError(t, test.DB.Create(&models.ExchangeRate{FromCurrency: "USD", ToCurrency: "EUR", Rate: "0.9215"}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USD", "/simple-product"), test.Data.testUserToken)
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", payload("EUR", "/simple-product"), test.Data.testUserToken)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
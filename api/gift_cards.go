@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
+)
+
+type giftCardIssueParams struct {
+	Amount         uint64 `json:"amount"`
+	Currency       string `json:"currency"`
+	RecipientEmail string `json:"recipient_email"`
+	RecipientName  string `json:"recipient_name"`
+	Message        string `json:"message"`
+	Note           string `json:"note"`
+}
+
+type giftCardAdjustParams struct {
+	Amount int64  `json:"amount"`
+	Note   string `json:"note"`
+}
+
+type giftCardVoidParams struct {
+	Note string `json:"note"`
+}
+
+// GiftCardList lists the gift cards.
+func (a *API) GiftCardList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	query := db.Where("instance_id = ?", instanceID)
+	query, err := parseGiftCardQueryParams(query, r.URL.Query())
+	if err != nil {
+		return badRequestError("Bad parameters in query: %v", err)
+	}
+
+	offset, limit, err := paginate(w, r, query.Model(&models.GiftCard{}))
+	if err != nil {
+		return badRequestError("Bad Pagination Parameters: %v", err)
+	}
+
+	var cards []models.GiftCard
+	if result := query.Order("created_at desc").Offset(offset).Limit(limit).Find(&cards); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, cards)
+}
+
+// GiftCardView shows a gift card with its ledger.
+func (a *API) GiftCardView(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r).Preload("Entries", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id asc")
+	})
+	card, httpErr := getGiftCard(db, gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "gift_card_id"))
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, card)
+}
+
+// GiftCardIssue creates a gift card with a balance, and sends it to the
+// recipient if there is one.
+func (a *API) GiftCardIssue(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+
+	params := &giftCardIssueParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if params.Amount == 0 {
+		return badRequestError("Issuing a gift card requires an amount")
+	}
+	if !money.KnownCurrency(params.Currency) {
+		return badRequestError("Unknown currency '%s'", params.Currency)
+	}
+
+	card, err := models.NewGiftCard(gcontext.GetInstanceID(ctx), params.Currency)
+	if err != nil {
+		return internalServerError("Error creating gift card").WithInternalError(err)
+	}
+	card.Balance = params.Amount
+	card.RecipientEmail = params.RecipientEmail
+	card.RecipientName = params.RecipientName
+	card.Message = params.Message
+
+	tx := a.DB(r).Begin()
+	if err := card.Issue(tx, "", gcontext.GetClaims(ctx).Subject, params.Note); err != nil {
+		tx.Rollback()
+		return internalServerError("Error issuing gift card").WithInternalError(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error issuing gift card").WithInternalError(err)
+	}
+
+	log.WithField("gift_card_id", card.ID).Infof("Issued gift card")
+	if card.RecipientEmail != "" {
+		go sendGiftCards(ctx, log, []*models.GiftCard{card}, nil)
+	}
+	return sendJSON(w, http.StatusCreated, card)
+}
+
+// GiftCardAdjust adds to or takes from the balance of a gift card.
+func (a *API) GiftCardAdjust(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+
+	params := &giftCardAdjustParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if params.Amount == 0 {
+		return badRequestError("Adjusting a gift card requires an amount")
+	}
+
+	tx := a.DB(r).Begin()
+	card, httpErr := getGiftCard(tx, gcontext.GetInstanceID(ctx), chi.URLParam(r, "gift_card_id"))
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+	if card.Status != models.GiftCardActiveState {
+		tx.Rollback()
+		return badRequestError("Gift card is %s", card.Status)
+	}
+	if err := card.Post(tx, models.GiftCardAdjustEntry, params.Amount, "", gcontext.GetClaims(ctx).Subject, params.Note); err != nil {
+		tx.Rollback()
+		if err == models.ErrInsufficientBalance {
+			return badRequestError(err.Error())
+		}
+		return internalServerError("Error adjusting gift card").WithInternalError(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error adjusting gift card").WithInternalError(err)
+	}
+
+	log.WithField("gift_card_id", card.ID).Infof("Adjusted gift card by %d", params.Amount)
+	return sendJSON(w, http.StatusOK, card)
+}
+
+// GiftCardVoid takes the remaining balance off a gift card, so it can't be
+// redeemed anymore.
+func (a *API) GiftCardVoid(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+
+	params := &giftCardVoidParams{}
+	if r.Body != nil && r.Body != http.NoBody {
+		if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+			return badRequestError("Could not read params: %v", err)
+		}
+	}
+
+	tx := a.DB(r).Begin()
+	card, httpErr := getGiftCard(tx, gcontext.GetInstanceID(ctx), chi.URLParam(r, "gift_card_id"))
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+	if card.Status != models.GiftCardActiveState {
+		tx.Rollback()
+		return badRequestError("Gift card is %s", card.Status)
+	}
+	if err := card.Void(tx, gcontext.GetClaims(ctx).Subject, params.Note); err != nil {
+		tx.Rollback()
+		return internalServerError("Error voiding gift card").WithInternalError(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error voiding gift card").WithInternalError(err)
+	}
+
+	log.WithField("gift_card_id", card.ID).Info("Voided gift card")
+	return sendJSON(w, http.StatusOK, card)
+}
+
+// giftCardRejected is the error for every gift card that can't be redeemed,
+// so unknown codes can't be told apart from voided or used up gift cards.
+const giftCardRejected = "Gift card can't be redeemed for this order"
+
+// redeemGiftCard pays the order with the gift card with the code, as far as
+// its balance goes.
+func redeemGiftCard(tx *gorm.DB, order *models.Order, code string, log logrus.FieldLogger) *HTTPError {
+	card, err := models.FindGiftCard(tx, order.InstanceID, code)
+	if err != nil {
+		return internalServerError("Error during database query").WithInternalError(err)
+	}
+	if card == nil {
+		log.Info("Rejected unknown gift card")
+		return badRequestError(giftCardRejected)
+	}
+	log = log.WithField("gift_card_id", card.ID)
+	if card.Status != models.GiftCardActiveState || card.Currency != order.Currency {
+		log.Infof("Rejected %s gift card in %s", card.Status, card.Currency)
+		return badRequestError(giftCardRejected)
+	}
+	if err := models.RedeemGiftCard(tx, order, card); err != nil {
+		if err == models.ErrInsufficientBalance {
+			log.Info("Rejected gift card without balance")
+			return badRequestError(giftCardRejected)
+		}
+		return internalServerError("Error redeeming gift card").WithInternalError(err)
+	}
+	return nil
+}
+
+func sendGiftCards(ctx context.Context, log logrus.FieldLogger, cards []*models.GiftCard, order *models.Order) {
+	mailer := gcontext.GetMailer(ctx)
+	for _, card := range cards {
+		if err := mailer.GiftCardMail(card, order); err != nil {
+			log.WithError(err).WithField("gift_card_id", card.ID).Error("Error sending gift card mail")
+		}
+	}
+}
+
+func getGiftCard(db *gorm.DB, instanceID, cardID string) (*models.GiftCard, *HTTPError) {
+	card := &models.GiftCard{}
+	if result := db.First(card, "instance_id = ? AND id = ?", instanceID, cardID); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, notFoundError("Gift card not found")
+		}
+		return nil, internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return card, nil
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+func giftCardOrderBody(code string, items string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"gift_card": %q,
+		"line_items": [%s]
+	}`, code, items))
+}
+
+func issueGiftCard(t *testing.T, test *RouteTest, token *jwt.Token, amount uint64, currency string) *models.GiftCard {
+	body := strings.NewReader(fmt.Sprintf(`{"amount": %d, "currency": %q, "note": "Customer service"}`, amount, currency))
+	recorder := test.TestEndpoint(http.MethodPost, "/gift-cards", body, token)
+	card := &models.GiftCard{}
+	extractPayload(t, http.StatusCreated, recorder, card)
+	return card
+}
+
+func TestGiftCardPurchase(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody("", `{
+		"path": "/gift-card", "quantity": 2,
+		"meta": {"recipient_email": "friend@example.com", "message": "Enjoy!"}
+	}`), test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.Len(t, order.LineItems, 1)
+	assert.True(t, order.LineItems[0].GiftCard)
+
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) error {
+		intent := v.(*stripe.PaymentIntent)
+		intent.ID = stripePaymentIntentID
+		intent.Status = stripe.PaymentIntentStatusSucceeded
+		return nil
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	payment, err := json.Marshal(&stripePaymentParams{
+		Amount:                order.Total,
+		Currency:              order.Currency,
+		StripePaymentMethodID: "payment-method-gift",
+		Provider:              payments.StripeProvider,
+	})
+	require.NoError(t, err)
+	recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", bytes.NewBuffer(payment), test.Data.testUserToken)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	cards := []models.GiftCard{}
+	require.NoError(t, test.DB.Preload("Entries").Where("order_id = ?", order.ID).Find(&cards).Error)
+	require.Len(t, cards, 2)
+	assert.NotEqual(t, cards[0].Code, cards[1].Code)
+	for _, card := range cards {
+		assert.EqualValues(t, 2500, card.Balance)
+		assert.Equal(t, "USD", card.Currency)
+		assert.Equal(t, "friend@example.com", card.RecipientEmail)
+		assert.Equal(t, "Enjoy!", card.Message)
+		require.Len(t, card.Entries, 1)
+		assert.Equal(t, models.GiftCardIssueEntry, card.Entries[0].Type)
+		assert.EqualValues(t, 2500, card.Entries[0].Amount)
+	}
+}
+
+func TestGiftCardRedeem(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	token := testAdminToken("magical-unicorn", "")
+	simpleProduct := `{"path": "/simple-product", "quantity": 1}`
+
+	t.Run("Partial", func(t *testing.T) {
+		card := issueGiftCard(t, test, token, 500, "USD")
+
+		// codes are accepted without dashes and in lower case
+		code := strings.ToLower(strings.Replace(card.Code, "-", "", -1))
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody(code, simpleProduct), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, card.Code, order.GiftCardCode)
+		assert.EqualValues(t, 500, order.GiftCardAmount)
+		assert.EqualValues(t, 999-500, order.Total)
+
+		redeemed := &models.GiftCard{}
+		require.NoError(t, test.DB.First(redeemed, "id = ?", card.ID).Error)
+		assert.EqualValues(t, 0, redeemed.Balance)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody(card.Code, simpleProduct), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, giftCardRejected)
+	})
+
+	t.Run("Full", func(t *testing.T) {
+		card := issueGiftCard(t, test, token, 5000, "USD")
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody(card.Code, simpleProduct), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.EqualValues(t, 999, order.GiftCardAmount)
+		assert.EqualValues(t, 0, order.Total)
+
+		redeemed := &models.GiftCard{}
+		require.NoError(t, test.DB.First(redeemed, "id = ?", card.ID).Error)
+		assert.EqualValues(t, 5000-999, redeemed.Balance)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		// unknown codes fail the same way as existing gift cards
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody("NOPE-NOPE", simpleProduct), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, giftCardRejected)
+
+		euro := issueGiftCard(t, test, token, 5000, "EUR")
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody(euro.Code, simpleProduct), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, giftCardRejected)
+
+		voided := issueGiftCard(t, test, token, 5000, "USD")
+		recorder = test.TestEndpoint(http.MethodPost, "/gift-cards/"+voided.ID+"/void", nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", giftCardOrderBody(voided.Code, simpleProduct), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, giftCardRejected)
+	})
+}
+
+func TestGiftCardAdmin(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("magical-unicorn", "")
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/gift-cards", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	card := issueGiftCard(t, test, token, 1000, "USD")
+	url := "/gift-cards/" + card.ID
+
+	t.Run("Adjust", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, url+"/adjust", strings.NewReader(`{"amount": 500, "note": "Goodwill"}`), token)
+		adjusted := &models.GiftCard{}
+		extractPayload(t, http.StatusOK, recorder, adjusted)
+		assert.EqualValues(t, 1500, adjusted.Balance)
+
+		recorder = test.TestEndpoint(http.MethodPost, url+"/adjust", strings.NewReader(`{"amount": -2000}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "Gift card balance is insufficient")
+
+		recorder = test.TestEndpoint(http.MethodPost, url+"/adjust", strings.NewReader(`{"amount": -300}`), token)
+		extractPayload(t, http.StatusOK, recorder, adjusted)
+		assert.EqualValues(t, 1200, adjusted.Balance)
+	})
+
+	t.Run("Void", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, url+"/void", strings.NewReader(`{"note": "Lost"}`), token)
+		voided := &models.GiftCard{}
+		extractPayload(t, http.StatusOK, recorder, voided)
+		assert.Equal(t, models.GiftCardVoidedState, voided.Status)
+		assert.EqualValues(t, 0, voided.Balance)
+
+		recorder = test.TestEndpoint(http.MethodPost, url+"/adjust", strings.NewReader(`{"amount": 100}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "Gift card is voided")
+	})
+
+	t.Run("ListAndView", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/gift-cards?status=voided", nil, token)
+		cards := []models.GiftCard{}
+		extractPayload(t, http.StatusOK, recorder, &cards)
+		require.Len(t, cards, 1)
+		assert.Equal(t, card.ID, cards[0].ID)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/gift-cards?code="+strings.ToLower(card.Code), nil, token)
+		extractPayload(t, http.StatusOK, recorder, &cards)
+		assert.Len(t, cards, 1)
+
+		recorder = test.TestEndpoint(http.MethodGet, url, nil, token)
+		viewed := &models.GiftCard{}
+		extractPayload(t, http.StatusOK, recorder, viewed)
+		ledger := []string{}
+		balance := int64(0)
+		for _, entry := range viewed.Entries {
+			ledger = append(ledger, fmt.Sprintf("%s %d", entry.Type, entry.Amount))
+			balance += entry.Amount
+			assert.EqualValues(t, balance, entry.Balance)
+		}
+		assert.Equal(t, []string{"issue 1000", "adjust 500", "adjust -300", "void -1200"}, ledger)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/gift-cards/missing", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
+
+func TestGiftCardPurchaseDiscounted(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+	couponServer := startCouponList("HALF-OFF", 50)
+	defer couponServer.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Coupons.URL = couponServer.URL
+	token := testAdminToken("magical-unicorn", "")
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"coupon": "HALF-OFF",
+		"line_items": [{"path": "/gift-card", "quantity": 2}]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.EqualValues(t, 2500, order.Total)
+
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) error {
+		switch path {
+		case "/v1/payment_intents":
+			intent := v.(*stripe.PaymentIntent)
+			intent.ID = stripePaymentIntentID
+			intent.Status = stripe.PaymentIntentStatusSucceeded
+		case "/v1/refunds":
+			v.(*stripe.Refund).ID = "re_gift_card"
+		default:
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+		return nil
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	payment, err := json.Marshal(&stripePaymentParams{
+		Amount:                order.Total,
+		Currency:              order.Currency,
+		StripePaymentMethodID: "payment-method-gift",
+		Provider:              payments.StripeProvider,
+	})
+	require.NoError(t, err)
+	recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", bytes.NewBuffer(payment), test.Data.testUserToken)
+	trans := &models.Transaction{}
+	extractPayload(t, http.StatusOK, recorder, trans)
+
+	balances := func() []uint64 {
+		cards := []models.GiftCard{}
+		require.NoError(t, test.DB.Where("order_id = ?", order.ID).Order("balance desc").Find(&cards).Error)
+		result := []uint64{}
+		for _, card := range cards {
+			result = append(result, card.Balance)
+		}
+		return result
+	}
+	// the gift cards are worth what was paid for them
+	assert.Equal(t, []uint64{1250, 1250}, balances())
+
+	refund := func(amount uint64) {
+		body, err := json.Marshal(&PaymentParams{Amount: amount, Currency: "USD"})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/payments/"+trans.ID+"/refund", bytes.NewBuffer(body), token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	// refunds can't leave the customer with both the money and the balance
+	refund(1000)
+	assert.Equal(t, []uint64{1250, 250}, balances())
+	refund(1500)
+	assert.Equal(t, []uint64{0, 0}, balances())
+}
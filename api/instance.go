@@ -53,6 +53,11 @@ func (a *API) CreateInstance(w http.ResponseWriter, r *http.Request) error {
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
 		return badRequestError("Error decoding params: %v", err)
 	}
+	if params.BaseConfig != nil {
+		if err := params.BaseConfig.Validate(); err != nil {
+			return badRequestError("Invalid config: %v", err)
+		}
+	}
 
 	_, err := models.GetInstanceByUUID(db, params.UUID)
 	if err != nil {
@@ -94,6 +99,9 @@ func (a *API) UpdateInstance(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if params.BaseConfig != nil {
+		if err := params.BaseConfig.Validate(); err != nil {
+			return badRequestError("Invalid config: %v", err)
+		}
 		i.BaseConfig = params.BaseConfig
 	}
 
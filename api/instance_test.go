@@ -74,6 +74,29 @@ func (ts *InstanceTestSuite) TestCreate() {
 	assert.NotNil(ts.T(), i.BaseConfig)
 }
 
+func (ts *InstanceTestSuite) TestCreateInvalidConfig() {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"uuid": testUUID,
+		"config": map[string]interface{}{
+			"exchange_rates": map[string]interface{}{
+				"rounding": "sideways",
+			},
+		},
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/instances", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+operatorToken)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	_, err := models.GetInstanceByUUID(ts.API.db, testUUID)
+	assert.True(ts.T(), models.IsNotFoundError(err))
+}
+
 func (ts *InstanceTestSuite) TestGet() {
 	instanceID := uuid.NewRandom().String()
 	err := models.CreateInstance(ts.API.db, &models.Instance{
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type inventoryParams struct {
+	Stock *int64 `json:"stock"`
+}
+
+type inventoryAdjustParams struct {
+	Delta int64 `json:"delta"`
+}
+
+// InventoryList lists the stock of all tracked SKUs.
+func (a *API) InventoryList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	inventoryTable := db.NewScope(models.Inventory{}).QuotedTableName()
+	query := addFilters(db.Where(inventoryTable+".instance_id = ?", instanceID), inventoryTable, r.URL.Query(), []string{"sku"})
+
+	offset, limit, err := paginate(w, r, query.Model(&models.Inventory{}))
+	if err != nil {
+		return badRequestError("Bad Pagination Parameters: %v", err)
+	}
+
+	var inventory []models.Inventory
+	if result := query.Order("sku asc").Offset(offset).Limit(limit).Find(&inventory); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, inventory)
+}
+
+// InventoryView shows the stock of a single SKU.
+func (a *API) InventoryView(w http.ResponseWriter, r *http.Request) error {
+	inventory, httpErr := getInventory(a.DB(r), gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "sku"))
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, inventory)
+}
+
+// InventorySet sets the stock of a SKU, starting to track it if needed.
+func (a *API) InventorySet(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+	sku := chi.URLParam(r, "sku")
+
+	params := &inventoryParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if params.Stock == nil {
+		return badRequestError("Setting the inventory requires the 'stock'")
+	}
+	if *params.Stock < 0 {
+		return badRequestError("Stock can't be negative")
+	}
+
+	tx := db.Begin()
+	inventory, err := models.GetInventory(tx, instanceID, sku)
+	if err != nil {
+		tx.Rollback()
+		return internalServerError("Error during database query").WithInternalError(err)
+	}
+	if inventory == nil {
+		inventory = &models.Inventory{InstanceID: instanceID, Sku: sku}
+	}
+	inventory.Stock = *params.Stock
+	if result := tx.Save(inventory); result.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving inventory").WithInternalError(result.Error)
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return internalServerError("Error saving inventory").WithInternalError(result.Error)
+	}
+
+	log.WithField("sku", sku).Infof("Set stock to %d", inventory.Stock)
+	return sendJSON(w, http.StatusOK, inventory)
+}
+
+// InventoryAdjust adds to or removes from the stock of a tracked SKU.
+func (a *API) InventoryAdjust(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+	sku := chi.URLParam(r, "sku")
+
+	params := &inventoryAdjustParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+
+	inventory, httpErr := getInventory(db, instanceID, sku)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	result := db.Model(inventory).
+		Where("stock + ? >= 0", params.Delta).
+		UpdateColumn("stock", gorm.Expr("stock + ?", params.Delta))
+	if result.Error != nil {
+		return internalServerError("Error saving inventory").WithInternalError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return badRequestError("Stock of %s can't go below zero", sku)
+	}
+
+	inventory, httpErr = getInventory(db, instanceID, sku)
+	if httpErr != nil {
+		return httpErr
+	}
+	log.WithField("sku", sku).Infof("Adjusted stock by %d to %d", params.Delta, inventory.Stock)
+	return sendJSON(w, http.StatusOK, inventory)
+}
+
+// InventoryDelete stops tracking the stock of a SKU.
+func (a *API) InventoryDelete(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+
+	inventory, httpErr := getInventory(db, gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "sku"))
+	if httpErr != nil {
+		return httpErr
+	}
+	if result := db.Delete(inventory); result.Error != nil {
+		return internalServerError("Error deleting inventory").WithInternalError(result.Error)
+	}
+
+	log.WithField("sku", inventory.Sku).Info("Stopped tracking stock")
+	return sendJSON(w, http.StatusOK, map[string]string{})
+}
+
+func getInventory(db *gorm.DB, instanceID, sku string) (*models.Inventory, *HTTPError) {
+	inventory, err := models.GetInventory(db, instanceID, sku)
+	if err != nil {
+		return nil, internalServerError("Error during database query").WithInternalError(err)
+	}
+	if inventory == nil {
+		return nil, notFoundError("SKU %s isn't tracked", sku)
+	}
+	return inventory, nil
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+func TestInventoryEndpoints(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("magical-unicorn", "")
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/inventory", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPut, "/inventory/batarang", strings.NewReader(`{"stock": 10}`), token)
+		inventory := &models.Inventory{}
+		extractPayload(t, http.StatusOK, recorder, inventory)
+		assert.Equal(t, "batarang", inventory.Sku)
+		assert.EqualValues(t, 10, inventory.Stock)
+
+		recorder = test.TestEndpoint(http.MethodPut, "/inventory/batarang", strings.NewReader(`{"stock": -1}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "negative")
+	})
+
+	t.Run("Adjust", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, "/inventory/batarang/adjust", strings.NewReader(`{"delta": -3}`), token)
+		inventory := &models.Inventory{}
+		extractPayload(t, http.StatusOK, recorder, inventory)
+		assert.EqualValues(t, 7, inventory.Stock)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/inventory/batarang/adjust", strings.NewReader(`{"delta": -8}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "below zero")
+
+		recorder = test.TestEndpoint(http.MethodPost, "/inventory/batmobile/adjust", strings.NewReader(`{"delta": 1}`), token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+
+	t.Run("View", func(t *testing.T) {
+		test.DB.Model(&models.Inventory{}).Where("sku = ?", "batarang").UpdateColumn("reserved", 2)
+
+		recorder := test.TestEndpoint(http.MethodGet, "/inventory/batarang", nil, token)
+		inventory := map[string]interface{}{}
+		extractPayload(t, http.StatusOK, recorder, &inventory)
+		assert.EqualValues(t, 7, inventory["stock"])
+		assert.EqualValues(t, 2, inventory["reserved"])
+		assert.EqualValues(t, 5, inventory["available"])
+	})
+
+	t.Run("List", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPut, "/inventory/grapple", strings.NewReader(`{"stock": 1}`), token)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/inventory", nil, token)
+		inventory := []models.Inventory{}
+		extractPayload(t, http.StatusOK, recorder, &inventory)
+		require.Len(t, inventory, 2)
+		assert.Equal(t, "batarang", inventory[0].Sku)
+		assert.Equal(t, "grapple", inventory[1].Sku)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/inventory?sku=grapple", nil, token)
+		extractPayload(t, http.StatusOK, recorder, &inventory)
+		require.Len(t, inventory, 1)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodDelete, "/inventory/grapple", nil, token)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/inventory/grapple", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
+
+func TestOrderCreateInventory(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	payload := func(path string, quantity int) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "%s", "quantity": %d}]
+		}`, path, quantity))
+	}
+
+	t.Run("Reserve", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		require.NoError(t, test.DB.Create(&models.Inventory{Sku: "product-1", Stock: 3}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("/simple-product", 2), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, models.InventoryReservedState, order.InventoryState)
+
+		inventory, err := models.GetInventory(test.DB, "", "product-1")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, inventory.Reserved)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", payload("/simple-product", 2), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "Not enough stock for product-1: 2 requested but only 1 available")
+	})
+
+	t.Run("Untracked", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("/simple-product", 100), test.Data.testUserToken)
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+	})
+
+	t.Run("Backorder", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		require.NoError(t, test.DB.Create(&models.Inventory{Sku: "product-2", Stock: 1}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("/backorder-product", 3), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Len(t, order.LineItems, 1)
+		assert.True(t, order.LineItems[0].Backorder)
+
+		inventory, err := models.GetInventory(test.DB, "", "product-2")
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, inventory.Reserved)
+		assert.EqualValues(t, -2, inventory.Available())
+	})
+}
+
+func TestPaymentConfirmCommitsInventory(t *testing.T) {
+	for _, example := range []struct {
+		name             string
+		state            string
+		stock, reserved  int64
+		expectedStock    int64
+		expectedReserved int64
+		expectedState    string
+	}{
+		{"Reserved", models.InventoryReservedState, 5, 2, 3, 0, models.InventoryCommittedState},
+		{"Released", models.InventoryReleasedState, 5, 0, 3, 0, models.InventoryCommittedState},
+		// the stock was sold to others after the order released it
+		{"ReleasedOutOfStock", models.InventoryReleasedState, 5, 4, 5, 4, models.InventoryOversoldState},
+	} {
+		t.Run(example.name, func(t *testing.T) {
+			test := NewRouteTest(t)
+			test.Config.Payment.Stripe.Enabled = true
+			test.Config.Payment.Stripe.SecretKey = "secret"
+
+			order := test.Data.firstOrder
+			order.PaymentState = models.PendingState
+			order.InventoryState = example.state
+			require.NoError(t, test.DB.Save(order).Error)
+			transaction := test.Data.firstTransaction
+			transaction.Status = models.PendingState
+			require.NoError(t, test.DB.Save(transaction).Error)
+			require.NoError(t, test.DB.Create(&models.Inventory{Sku: "123-i-can-fly-456", Stock: example.stock, Reserved: example.reserved}).Error)
+
+			globalConfig := new(conf.GlobalConfiguration)
+			provider := &memProvider{name: payments.StripeProvider}
+			ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, test.Config, "")
+			require.NoError(t, err)
+			ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: provider})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/payments/"+transaction.ID+"/confirm", bytes.NewBuffer(nil))
+			require.NoError(t, signHTTPRequest(r, test.Data.testUserToken, test.Config.JWT.Secret))
+			NewAPIWithVersion(ctx, test.GlobalConfig, logrus.StandardLogger(), test.DB, defaultVersion).handler.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			inventory, err := models.GetInventory(test.DB, "", "123-i-can-fly-456")
+			require.NoError(t, err)
+			assert.EqualValues(t, example.expectedStock, inventory.Stock)
+			assert.EqualValues(t, example.expectedReserved, inventory.Reserved)
+
+			saved := &models.Order{}
+			require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+			assert.Equal(t, example.expectedState, saved.InventoryState)
+
+			events := []models.Event{}
+			require.NoError(t, test.DB.Where("order_id = ? AND changes = ?", order.ID, "inventory_state").Find(&events).Error)
+			if example.expectedState == models.InventoryOversoldState {
+				assert.Len(t, events, 1)
+			} else {
+				assert.Empty(t, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type invoiceIssueParams struct {
+	NetDays int `json:"net_days"`
+}
+
+type invoicePaidParams struct {
+	Reference string `json:"reference"`
+}
+
+// InvoiceIssue lets an unpaid order be paid by a manual invoice on net terms.
+// The order keeps its stock and doesn't expire while the invoice is open, and
+// the customer is reminded on the dunning schedule once it is due.
+func (a *API) InvoiceIssue(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+
+	params := &invoiceIssueParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if params.NetDays < 0 {
+		return badRequestError("Net days can't be negative")
+	}
+
+	tx := a.DB(r).Begin()
+	order, httpErr := loadInvoiceOrder(tx, gcontext.GetOrderID(ctx))
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+	if order.PaymentProcessor == models.InvoicePaymentProcessor && order.PaymentState == models.PendingState {
+		tx.Rollback()
+		return badRequestError("An invoice was already issued for this order")
+	}
+	if order.Recurring() {
+		tx.Rollback()
+		return badRequestError("Subscriptions can't be paid by invoice")
+	}
+	if _, httpErr := preparePayment(tx, order); httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+
+	changes := []models.FieldChange{{Field: "payment_processor", Old: order.PaymentProcessor, New: models.InvoicePaymentProcessor}}
+	if order.PaymentState != models.PendingState {
+		changes = append(changes, models.FieldChange{Field: "payment_state", Old: order.PaymentState, New: models.PendingState})
+	}
+	order.IssueInvoice(time.Now(), params.NetDays)
+	order.PaymentState = models.PendingState
+	if result := tx.Save(order); result.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error issuing invoice").WithInternalError(result.Error)
+	}
+	models.LogChanges(tx, r.RemoteAddr, gcontext.GetClaims(ctx).Subject, order.ID, models.EventUpdated, changes)
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error issuing invoice").WithInternalError(err)
+	}
+
+	log.WithField("order_id", order.ID).Infof("Issued invoice %d due on %v", order.InvoiceNumber, order.PaymentDueAt)
+	return sendJSON(w, http.StatusOK, order)
+}
+
+// InvoicePaid records the payment of a manual invoice, like a received bank
+// transfer, and completes the order. Invoices canceled by dunning can still
+// be paid while their stock is available.
+func (a *API) InvoicePaid(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+
+	params := &invoicePaidParams{}
+	if r.Body != nil && r.Body != http.NoBody {
+		if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+			return badRequestError("Could not read params: %v", err)
+		}
+	}
+
+	tx := a.DB(r).Begin()
+	order, httpErr := loadInvoiceOrder(tx, gcontext.GetOrderID(ctx))
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+	if order.PaymentProcessor != models.InvoicePaymentProcessor {
+		tx.Rollback()
+		return badRequestError("No invoice was issued for this order")
+	}
+	invoiceNumber, httpErr := preparePayment(tx, order)
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+
+	tr := models.NewTransaction(order)
+	tr.ProcessorID = params.Reference
+	tr.InvoiceNumber = invoiceNumber
+	order.NextInvoiceReminderAt = nil
+
+	giftCards := paymentComplete(r, tx, tr, order)
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Saving payment failed").WithInternalError(err)
+	}
+
+	log.WithField("transaction_id", tr.ID).Infof("Invoice %d was paid", order.InvoiceNumber)
+	go sendOrderConfirmation(ctx, log, tr)
+	go sendGiftCards(ctx, log, giftCards, order)
+
+	return sendJSON(w, http.StatusOK, tr)
+}
+
+// loadInvoiceOrder loads an unpaid order with everything needed to complete
+// its payment.
+func loadInvoiceOrder(tx *gorm.DB, orderID string) (*models.Order, *HTTPError) {
+	order := &models.Order{}
+	loader := tx.
+		Preload("LineItems").
+		Preload("Downloads").
+		Preload("BillingAddress").
+		Preload("ShippingAddress")
+	if result := loader.First(order, "id = ?", orderID); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, notFoundError("No order with this ID found")
+		}
+		return nil, internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	if order.PaymentState == models.PaidState {
+		return nil, badRequestError("This order has already been paid")
+	}
+	if order.PaymentState == models.ExpiredState {
+		return nil, badRequestError("This order has expired")
+	}
+	return order, nil
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/models"
+)
+
+func TestInvoice(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+
+	order := models.NewOrder("", "session3", "bruce@wayneindustries.com", "USD")
+	order.LineItems = []*models.LineItem{{Sku: "batarang", Title: "Batarang", Price: 1000, Quantity: 2}}
+	order.Total = 2000
+	require.NoError(t, test.DB.Create(order).Error)
+	invoiceURL := "/orders/" + order.ID + "/invoice"
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, invoiceURL, strings.NewReader(`{"net_days": 30}`), test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("NotIssued", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, invoiceURL+"/paid", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "No invoice was issued for this order")
+	})
+
+	t.Run("Issue", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, invoiceURL, strings.NewReader(`{"net_days": 30}`), token)
+		issued := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, issued)
+		assert.Equal(t, models.InvoicePaymentProcessor, issued.PaymentProcessor)
+		assert.Equal(t, models.PendingState, issued.PaymentState)
+		assert.NotZero(t, issued.InvoiceNumber)
+		require.NotNil(t, issued.PaymentDueAt)
+		assert.WithinDuration(t, time.Now().AddDate(0, 0, 30), *issued.PaymentDueAt, time.Minute)
+		require.NotNil(t, issued.NextInvoiceReminderAt)
+
+		recorder = test.TestEndpoint(http.MethodPost, invoiceURL, strings.NewReader(`{"net_days": 14}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "An invoice was already issued for this order")
+	})
+
+	t.Run("Paid", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, invoiceURL+"/paid", strings.NewReader(`{"reference": "wire-1234"}`), token)
+		tr := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, tr)
+		assert.Equal(t, models.PaidState, tr.Status)
+		assert.Equal(t, "wire-1234", tr.ProcessorID)
+		assert.EqualValues(t, 2000, tr.Amount)
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		assert.Equal(t, models.PaidState, saved.PaymentState)
+		assert.Equal(t, tr.InvoiceNumber, saved.InvoiceNumber)
+		assert.Nil(t, saved.NextInvoiceReminderAt)
+
+		recorder = test.TestEndpoint(http.MethodPost, invoiceURL+"/paid", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "This order has already been paid")
+	})
+}
@@ -13,6 +13,10 @@ import (
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/mailer"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments/providers"
+	"github.com/netlify/gocommerce/products"
+	"github.com/netlify/gocommerce/settings"
+	"github.com/netlify/gocommerce/vatnumbers"
 	"github.com/pkg/errors"
 )
 
@@ -104,6 +108,10 @@ func (api *API) loadInstanceConfig(w http.ResponseWriter, r *http.Request) (cont
 	if err != nil {
 		return nil, internalServerError("Error loading instance config").WithInternalError(err)
 	}
+	// the caches have to outlive the request to be of any use
+	ctx = gcontext.WithProducts(ctx, api.productCache(instanceID, config))
+	ctx = gcontext.WithSettings(ctx, api.settingsCache(instanceID, config))
+	ctx = gcontext.WithVATNumbers(ctx, api.vatNumberCache(instanceID, config))
 
 	return ctx, nil
 }
@@ -115,6 +123,9 @@ func WithInstanceConfig(ctx context.Context, smtp conf.SMTPConfiguration, config
 	if err != nil {
 		return nil, err
 	}
+	ctx = gcontext.WithProducts(ctx, products.NewCache(config))
+	ctx = gcontext.WithSettings(ctx, settings.NewCache(config))
+	ctx = gcontext.WithVATNumbers(ctx, vatnumbers.NewCache(config, vatnumbers.VIES{}))
 
 	mailer := mailer.NewMailer(smtp, config)
 	ctx = gcontext.WithMailer(ctx, mailer)
@@ -125,7 +136,7 @@ func WithInstanceConfig(ctx context.Context, smtp conf.SMTPConfiguration, config
 	}
 	ctx = gcontext.WithAssetStore(ctx, store)
 
-	provs, err := createPaymentProviders(config)
+	provs, err := providers.New(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating payment providers")
 	}
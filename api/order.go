@@ -3,17 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"sync"
 
 	"github.com/go-chi/chi"
 	"github.com/jinzhu/gorm"
-	"github.com/mattes/vat"
 	"github.com/netlify/gocommerce/calculator"
 	"github.com/netlify/gocommerce/claims"
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
 	"github.com/pborman/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -27,6 +26,8 @@ type orderLineItem struct {
 	Quantity uint64                 `json:"quantity"`
 	Addons   []orderAddon           `json:"addons"`
 	MetaData map[string]interface{} `json:"meta"`
+	// Amount is the price the customer chose for a pay what you want product.
+	Amount string `json:"amount"`
 }
 
 type orderAddon struct {
@@ -57,6 +58,8 @@ type orderRequestParams struct {
 	FulfillmentState string `json:"fulfillment_state"`
 
 	CouponCode string `json:"coupon"`
+
+	GiftCardCode string `json:"gift_card"`
 }
 
 type receiptParams struct {
@@ -170,7 +173,7 @@ func (a *API) ReceiptView(w http.ResponseWriter, r *http.Request) error {
 
 	mailer := gcontext.GetMailer(ctx)
 	for _, transaction := range order.Transactions {
-		if transaction.Type == models.ChargeTransactionType {
+		if transaction.Type == models.ChargeTransactionType || transaction.Type == models.FreeTransactionType {
 			transaction.Order = order
 			html, err := mailer.OrderConfirmationMailBody(transaction, template)
 			if err != nil {
@@ -217,7 +220,7 @@ func (a *API) ResendOrderReceipt(w http.ResponseWriter, r *http.Request) error {
 
 	mailer := gcontext.GetMailer(ctx)
 	for _, transaction := range order.Transactions {
-		if transaction.Type == models.ChargeTransactionType {
+		if transaction.Type == models.ChargeTransactionType || transaction.Type == models.FreeTransactionType {
 			transaction.Order = order
 			if mailErr := mailer.OrderConfirmationMail(transaction); mailErr != nil {
 				log.WithError(mailErr).Errorf("Error sending order confirmation mail")
@@ -384,16 +387,14 @@ func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if params.VATNumber != "" {
-		valid, err := vat.IsValidVAT(params.VATNumber)
-		if err != nil {
-			tx.Rollback()
-			return internalServerError("Error verifying VAT number").WithInternalError(err)
-		}
-		if !valid {
+		verified, httpError := verifyVATNumber(ctx, params.VATNumber, log)
+		if httpError != nil {
 			tx.Rollback()
-			return badRequestError("Vat number %v is not valid", order.VATNumber)
+			return httpError
 		}
 		order.VATNumber = params.VATNumber
+		order.VATNumberValid = true
+		order.VATNumberUnverified = !verified
 	}
 
 	if httpError := a.createLineItems(ctx, tx, order, params.LineItems, log); httpError != nil {
@@ -404,6 +405,21 @@ func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
 
 	log.WithField("subtotal", order.SubTotal).Debug("Successfully processed all the line items")
 
+	if err := models.ReserveInventory(tx, order); err != nil {
+		tx.Rollback()
+		if stockErr, ok := err.(*models.OutOfStockError); ok {
+			return badRequestError(stockErr.Error())
+		}
+		return internalServerError("Error reserving inventory").WithInternalError(err)
+	}
+
+	if params.GiftCardCode != "" {
+		if httpError := redeemGiftCard(tx, order, params.GiftCardCode, log); httpError != nil {
+			tx.Rollback()
+			return httpError
+		}
+	}
+
 	tx.Create(order)
 	models.LogEvent(tx, r.RemoteAddr, order.UserID, order.ID, models.EventCreated, nil)
 	if config.Webhooks.Order != "" {
@@ -432,7 +448,7 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 	log := getLogEntry(r)
 	claims := gcontext.GetClaims(ctx)
 	config := gcontext.GetConfig(ctx)
-	changes := []string{}
+	changes := []models.FieldChange{}
 
 	orderParams := new(orderRequestParams)
 	err := json.NewDecoder(r.Body).Decode(orderParams)
@@ -458,16 +474,17 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 	//
 	if orderParams.SessionID != "" {
 		log.Debugf("Updating session id from '%s' to '%s'", existingOrder.SessionID, orderParams.SessionID)
+		changes = append(changes, models.FieldChange{Field: "session_id", Old: existingOrder.SessionID, New: orderParams.SessionID})
 		existingOrder.SessionID = orderParams.SessionID
-		changes = append(changes, "session_id")
 	}
 	if orderParams.Email != "" {
 		log.Debugf("Updating email from '%s' to '%s'", existingOrder.Email, orderParams.Email)
+		changes = append(changes, models.FieldChange{Field: "email", Old: existingOrder.Email, New: orderParams.Email})
 		existingOrder.Email = orderParams.Email
-		changes = append(changes, "email")
 	}
 
 	if orderParams.MetaData != nil {
+		changes = append(changes, models.FieldChange{Field: "meta_data", Old: existingOrder.MetaData, New: orderParams.MetaData})
 		existingOrder.MetaData = orderParams.MetaData
 	}
 
@@ -476,17 +493,27 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 			return badRequestError("Can't update the currency after payment has been processed")
 		}
 		log.Debugf("Updating currency from '%v' to '%v'", existingOrder.Currency, orderParams.Currency)
+		changes = append(changes, models.FieldChange{Field: "currency", Old: existingOrder.Currency, New: orderParams.Currency})
 		existingOrder.Currency = orderParams.Currency
-		changes = append(changes, "currency")
 	}
 	if orderParams.VATNumber != "" {
 		if alreadyPaid {
 			return badRequestError("Can't update the VAT number after payment has been processed")
 		}
 
+		verified, httpError := verifyVATNumber(ctx, orderParams.VATNumber, log)
+		if httpError != nil {
+			return httpError
+		}
+
 		log.Debugf("Updating vat number from '%v' to '%v'", existingOrder.VATNumber, orderParams.VATNumber)
+		changes = append(changes, models.FieldChange{Field: "vatnumber", Old: existingOrder.VATNumber, New: orderParams.VATNumber})
+		if existingOrder.VATNumberUnverified == verified {
+			changes = append(changes, models.FieldChange{Field: "vatnumber_unverified", Old: existingOrder.VATNumberUnverified, New: !verified})
+		}
 		existingOrder.VATNumber = orderParams.VATNumber
-		changes = append(changes, "vatnumber")
+		existingOrder.VATNumberValid = true
+		existingOrder.VATNumberUnverified = !verified
 	}
 
 	tx := db.Begin()
@@ -509,7 +536,7 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 			"address_id":     addr.ID,
 			"old_address_id": old,
 		}).Debugf("Updated the billing address id to %s", addr.ID)
-		changes = append(changes, "billing_address")
+		changes = append(changes, models.FieldChange{Field: "billing_address", Old: old, New: addr.ID})
 	}
 
 	if orderParams.ShippingAddress != nil || orderParams.ShippingAddressID != "" {
@@ -528,7 +555,7 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 			"address_id":     addr.ID,
 			"old_address_id": old,
 		}).Debugf("Updated the shipping address id to %s", addr.ID)
-		changes = append(changes, "shipping_address")
+		changes = append(changes, models.FieldChange{Field: "shipping_address", Old: old, New: addr.ID})
 	}
 
 	if orderParams.FulfillmentState != "" {
@@ -543,8 +570,8 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 			tx.Rollback()
 			return badRequestError("Bad fulfillment state: " + orderParams.FulfillmentState)
 		}
+		changes = append(changes, models.FieldChange{Field: "fulfillment_state", Old: existingOrder.FulfillmentState, New: orderParams.FulfillmentState})
 		existingOrder.FulfillmentState = orderParams.FulfillmentState
-		changes = append(changes, "fulfillment_state")
 	}
 
 	//
@@ -555,8 +582,22 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 		updatedItems[item.Sku] = item
 	}
 
+	// stock reserved for the old quantities is given back and reserved again
+	// for the new ones below
+	reserved := len(updatedItems) > 0 && existingOrder.InventoryState == models.InventoryReservedState
+	if reserved {
+		if err := models.ReleaseInventory(tx, existingOrder); err != nil {
+			tx.Rollback()
+			return internalServerError("Error releasing inventory").WithInternalError(err)
+		}
+	}
+
+	oldQuantities := make(map[string]uint64)
+	newQuantities := make(map[string]uint64)
 	for _, item := range existingOrder.LineItems {
 		if update, exists := updatedItems[item.Sku]; exists {
+			oldQuantities[item.Sku] = item.Quantity
+			newQuantities[item.Sku] = update.Quantity
 			item.Quantity = update.Quantity
 			if update.Path != "" {
 				item.Path = update.Path
@@ -565,7 +606,17 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if len(updatedItems) > 0 {
-		changes = append(changes, "line_items")
+		changes = append(changes, models.FieldChange{Field: "line_items", Old: oldQuantities, New: newQuantities})
+	}
+
+	if reserved {
+		if err := models.ReserveInventory(tx, existingOrder); err != nil {
+			tx.Rollback()
+			if stockErr, ok := err.(*models.OutOfStockError); ok {
+				return badRequestError(stockErr.Error())
+			}
+			return internalServerError("Error reserving inventory").WithInternalError(err)
+		}
 	}
 
 	log.Info("Saving order updates")
@@ -574,7 +625,7 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("Error saving order updates").WithInternalError(rsp.Error)
 	}
 
-	models.LogEvent(tx, r.RemoteAddr, claims.Subject, existingOrder.ID, models.EventUpdated, changes)
+	models.LogChanges(tx, r.RemoteAddr, claims.Subject, existingOrder.ID, models.EventUpdated, changes)
 	if config.Webhooks.Update != "" {
 		// TODO should this be claims.Subject or existingOrder.UserID ?
 		hook, err := models.NewHook("update", config.SiteURL, config.Webhooks.Update, claims.Subject, config.Webhooks.Secret, existingOrder)
@@ -632,16 +683,38 @@ func setOrderEmail(tx *gorm.DB, order *models.Order, claims *claims.JWTClaims, l
 }
 
 func (a *API) createLineItems(ctx context.Context, tx *gorm.DB, order *models.Order, items []*orderLineItem, log logrus.FieldLogger) *HTTPError {
+	config := gcontext.GetConfig(ctx)
+	converter, err := models.LoadCurrencyConverter(tx, order.InstanceID, config.ExchangeRates.Rounding)
+	if err != nil {
+		return internalServerError("Error loading exchange rates").WithInternalError(err)
+	}
+	order.UseCurrencyConverter(converter)
+
+	// parse the chosen prices up front, so no lookups are running when one
+	// of them is invalid
+	chosenPrices := make([]*uint64, len(items))
+	for i, orderItem := range items {
+		if orderItem.Amount == "" {
+			continue
+		}
+		amount, err := money.Parse(orderItem.Amount, order.Currency)
+		if err != nil {
+			return badRequestError("Invalid amount '%s' for %s: %v", orderItem.Amount, orderItem.Path, err)
+		}
+		chosenPrices[i] = &amount
+	}
+
 	sem := make(chan int, MaxConcurrentLookups)
 	var wg sync.WaitGroup
 	sharedErr := verificationError{}
-	for _, orderItem := range items {
+	for i, orderItem := range items {
 		lineItem := &models.LineItem{
-			Sku:      orderItem.Sku,
-			Quantity: orderItem.Quantity,
-			MetaData: orderItem.MetaData,
-			Path:     orderItem.Path,
-			OrderID:  order.ID,
+			Sku:         orderItem.Sku,
+			Quantity:    orderItem.Quantity,
+			MetaData:    orderItem.MetaData,
+			Path:        orderItem.Path,
+			OrderID:     order.ID,
+			ChosenPrice: chosenPrices[i],
 		}
 
 		for _, addon := range orderItem.Addons {
@@ -671,6 +744,9 @@ func (a *API) createLineItems(ctx context.Context, tx *gorm.DB, order *models.Or
 	wg.Wait()
 
 	if sharedErr.err != nil {
+		if priceErr, ok := sharedErr.err.(*models.ChosenPriceError); ok {
+			return badRequestError(priceErr.Error())
+		}
 		return internalServerError("Error processing line item").WithInternalError(sharedErr.err)
 	}
 
@@ -691,28 +767,22 @@ func (a *API) createLineItems(ctx context.Context, tx *gorm.DB, order *models.Or
 	if err != nil {
 		return internalServerError(err.Error()).WithInternalError(err)
 	}
+	promotions, err := models.LoadPromotions(tx, order.InstanceID)
+	if err != nil {
+		return internalServerError("Error loading promotions").WithInternalError(err)
+	}
+	settings = settings.WithPromotions(promotions)
 
-	order.CalculateTotal(settings, gcontext.GetClaimsAsMap(ctx), log)
+	claims := gcontext.GetClaimsAsMap(ctx)
+	if order.Recurring() {
+		order.Claims = claims
+	}
+	order.CalculateTotal(settings, claims, log)
 	return nil
 }
 
 func (a *API) loadSettings(ctx context.Context) (*calculator.Settings, error) {
-	config := gcontext.GetConfig(ctx)
-
-	settings := &calculator.Settings{}
-	resp, err := a.httpClient.Get(config.SettingsURL())
-	if err != nil {
-		return nil, fmt.Errorf("Error loading site settings: %v", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
-		decoder := json.NewDecoder(resp.Body)
-		if err := decoder.Decode(settings); err != nil {
-			return nil, fmt.Errorf("Error parsing site settings: %v", err)
-		}
-	}
-
-	return settings, nil
+	return gcontext.GetSettings(ctx).Load()
 }
 
 func (a *API) processAddress(tx *gorm.DB, order *models.Order, name string, address *models.Address, id string) (*models.Address, *HTTPError) {
@@ -745,10 +815,9 @@ func (a *API) processAddress(tx *gorm.DB, order *models.Order, name string, addr
 }
 
 func (a *API) processLineItem(ctx context.Context, order *models.Order, item *models.LineItem) error {
-	config := gcontext.GetConfig(ctx)
 	jwtClaims := gcontext.GetClaimsAsMap(ctx)
 
-	return item.Process(config, jwtClaims, order)
+	return item.Process(gcontext.GetProducts(ctx), jwtClaims, order)
 }
 
 func orderQuery(db *gorm.DB) *gorm.DB {
@@ -139,6 +139,14 @@ func TestOrderCreate(t *testing.T) {
 		assert.Equal(t, "Germany", order.BillingAddress.Country)
 		assert.Equal(t, total, order.Total, fmt.Sprintf("Total should be 1069, was %v", order.Total))
 		assert.Equal(t, taxes, order.Taxes, fmt.Sprintf("Total should be 70, was %v", order.Total))
+
+		require.Len(t, order.LineItems, 1)
+		require.Len(t, order.LineItems[0].CalculationDetail.TaxItems, 1)
+		assert.Equal(t, calculator.TaxItem{Percentage: 7, Taxable: 999, Amount: 70}, order.LineItems[0].CalculationDetail.TaxItems[0])
+
+		saved := &models.LineItem{}
+		require.NoError(t, test.DB.First(saved, order.LineItems[0].ID).Error)
+		assert.Equal(t, order.LineItems[0].CalculationDetail.TaxItems, saved.CalculationDetail.TaxItems)
 	})
 
 	t.Run("BundleWithTaxes", func(t *testing.T) {
@@ -165,6 +173,15 @@ func TestOrderCreate(t *testing.T) {
 		assert.Equal(t, "Germany", order.BillingAddress.Country)
 		assert.Equal(t, total, order.Total, fmt.Sprintf("Total should be 1105, was %v", order.Total))
 		assert.Equal(t, taxes, order.Taxes, fmt.Sprintf("Total should be 106, was %v", order.Taxes))
+
+		require.Len(t, order.TaxItems, 2)
+		assert.Equal(t, 7.0, order.TaxItems[0].Percentage)
+		assert.Equal(t, 19.0, order.TaxItems[1].Percentage)
+		assert.Equal(t, taxes, order.TaxItems[0].Amount+order.TaxItems[1].Amount)
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		assert.Equal(t, order.TaxItems, saved.TaxItems)
 	})
 
 	t.Run("WithCoupon", func(t *testing.T) {
@@ -250,6 +267,35 @@ func TestOrderCreate(t *testing.T) {
 		assert.Equal(t, uint64(0), discountItem.Fixed)
 	})
 
+	t.Run("WithQuantityTier", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := strings.NewReader(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "/tiered-product", "quantity": 10}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.EqualValues(t, 10000, order.SubTotal)
+		assert.EqualValues(t, 2000, order.Discount)
+		assert.EqualValues(t, 8000, order.Total)
+		require.Len(t, order.LineItems, 1)
+
+		lineItem := order.LineItems[0]
+		assert.EqualValues(t, 1000, lineItem.Price)
+		assert.EqualValues(t, 800, lineItem.TierPrice)
+		require.Len(t, lineItem.CalculationDetail.DiscountItems, 1)
+		assert.Equal(t, calculator.DiscountTypeTier, lineItem.CalculationDetail.DiscountItems[0].Type)
+		assert.EqualValues(t, 200, lineItem.CalculationDetail.DiscountItems[0].Fixed)
+	})
+
 	t.Run("MultipleItemsWithDownloads", func(t *testing.T) {
 		test := NewRouteTest(t)
 
@@ -273,6 +319,9 @@ func TestOrderCreate(t *testing.T) {
 					}`,
 				))
 				return
+			case "/gocommerce/settings.json":
+				fmt.Fprint(w, `{}`)
+				return
 			}
 			w.WriteHeader(http.StatusNotFound)
 		}))
@@ -1143,3 +1192,96 @@ func validateExistingUserEmail(t *testing.T, db *gorm.DB, order *models.Order, c
 	assert.Equal(t, claims.Subject, order.UserID)
 	assert.Equal(t, expectedOrderEmail, order.Email)
 }
+
+func TestOrderCreatePayWhatYouWant(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	payload := func(country, lineItem string) *strings.Reader {
+		return strings.NewReader(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "Berlin", "country": "` + country + `", "zip": "94107"
+			},
+			"line_items": [` + lineItem + `]
+		}`)
+	}
+
+	t.Run("ChosenPriceWithTaxes", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("Germany", `{"path": "/pay-what-you-want", "quantity": 1, "amount": "20.00"}`), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Len(t, order.LineItems, 1)
+		lineItem := order.LineItems[0]
+		assert.EqualValues(t, 2000, lineItem.Price)
+		require.NotNil(t, lineItem.ChosenPrice)
+		assert.EqualValues(t, 2000, *lineItem.ChosenPrice)
+
+		// the chosen price is split like the suggested one, 14.00 at 7% and 6.00 at 19%
+		require.Len(t, lineItem.PriceItems, 2)
+		assert.EqualValues(t, 1400, lineItem.PriceItems[0].Amount)
+		assert.EqualValues(t, 600, lineItem.PriceItems[1].Amount)
+		assert.EqualValues(t, 212, order.Taxes)
+		assert.EqualValues(t, 2212, order.Total)
+		assert.Len(t, order.Downloads, 1)
+	})
+
+	t.Run("SuggestedPrice", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USA", `{"path": "/pay-what-you-want", "quantity": 1}`), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.EqualValues(t, 1000, order.LineItems[0].Price)
+		assert.Nil(t, order.LineItems[0].ChosenPrice)
+		assert.EqualValues(t, 1000, order.Total)
+	})
+
+	t.Run("WithAddon", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USA", `{"path": "/pay-what-you-want", "quantity": 2, "amount": "5", "addons": [{"sku": "gift-wrap"}]}`), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.EqualValues(t, 500, order.LineItems[0].Price)
+		assert.EqualValues(t, 200, order.LineItems[0].AddonPrice)
+		assert.Len(t, order.Downloads, 1)
+	})
+
+	t.Run("BelowMinimum", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USA", `{"path": "/pay-what-you-want", "quantity": 1, "amount": "4.99"}`), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "The price of product-4 must be at least 5.00 USD")
+	})
+
+	t.Run("FixedPrice", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USA", `{"path": "/simple-product", "quantity": 1, "amount": "20.00"}`), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "The price of product-1 can't be chosen")
+	})
+
+	t.Run("InvalidAmount", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		// the valid item before it isn't looked up anymore
+		items := `{"path": "/simple-product", "quantity": 1}, {"path": "/pay-what-you-want", "quantity": 1, "amount": "20.001"}`
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", payload("USA", items), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "Invalid amount '20.001'")
+
+		var count int
+		require.NoError(t, test.DB.Model(&models.LineItem{}).Where("path = ?", "/simple-product").Count(&count).Error)
+		assert.Equal(t, 0, count)
+	})
+}
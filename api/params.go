@@ -53,6 +53,51 @@ func parsePaymentQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error
 	return parseTimeQueryParams(query, transactionTable, params)
 }
 
+func parseEventQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
+	eventTable := query.NewScope(models.Event{}).QuotedTableName()
+	query = addFilters(query, eventTable, params, []string{
+		"order_id",
+		"user_id",
+		"type",
+		"ip",
+	})
+	return parseTimeQueryParams(query, eventTable, params)
+}
+
+func parseReturnQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
+	returnTable := query.NewScope(models.Return{}).QuotedTableName()
+	query = addFilters(query, returnTable, params, []string{
+		"order_id",
+		"user_id",
+		"state",
+	})
+	return parseTimeQueryParams(query, returnTable, params)
+}
+
+func parseSubscriptionQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
+	subscriptionTable := query.NewScope(models.Subscription{}).QuotedTableName()
+	query = addFilters(query, subscriptionTable, params, []string{
+		"order_id",
+		"user_id",
+		"sku",
+		"status",
+	})
+	return parseTimeQueryParams(query, subscriptionTable, params)
+}
+
+func parseGiftCardQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
+	giftCardTable := query.NewScope(models.GiftCard{}).QuotedTableName()
+	if code := params.Get("code"); code != "" {
+		query = query.Where(giftCardTable+".code = ?", models.NormalizeGiftCardCode(code))
+	}
+	query = addFilters(query, giftCardTable, params, []string{
+		"order_id",
+		"currency",
+		"status",
+	})
+	return parseTimeQueryParams(query, giftCardTable, params)
+}
+
 func parseUserBulkDeleteParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
 	if _, ok := params["id"]; !ok {
 		return nil, errors.New("User ID field is required")
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+// PaymentMethodParams holds the parameters for saving a payment method. The
+// details of the method depend on the provider, like the
+// stripe_payment_method_id for Stripe.
+type PaymentMethodParams struct {
+	ProviderType string `json:"provider"`
+}
+
+// PaymentMethodList lists the payment methods a user saved.
+func (a *API) PaymentMethodList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	userID := gcontext.GetUserID(ctx)
+	if gcontext.GetUser(ctx) == nil {
+		return notFoundError("Couldn't find a record for " + userID)
+	}
+
+	methods := []models.PaymentMethod{}
+	if result := a.DB(r).Where("instance_id = ? AND user_id = ?", gcontext.GetInstanceID(ctx), userID).Order("created_at desc").Find(&methods); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, methods)
+}
+
+// PaymentMethodCreate saves a payment method of the user with the provider, to
+// pay later orders with it.
+func (a *API) PaymentMethodCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+	userID := gcontext.GetUserID(ctx)
+	user := gcontext.GetUser(ctx)
+	if user == nil {
+		return notFoundError("Couldn't find a record for " + userID)
+	}
+
+	params := &PaymentMethodParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if params.ProviderType == "" {
+		return badRequestError("Saving a payment method requires specifying a 'provider'")
+	}
+	provider := gcontext.GetPaymentProviders(ctx)[strings.ToLower(params.ProviderType)]
+	if provider == nil {
+		return badRequestError("Payment provider '%s' not configured", params.ProviderType)
+	}
+	save, err := provider.NewMethodSaver(ctx, r, log.WithField("component", "payment_provider"))
+	if err != nil {
+		return badRequestError("Error creating payment provider: %v", err)
+	}
+
+	db := a.DB(r)
+	customerID, err := models.PaymentCustomerID(db, gcontext.GetInstanceID(ctx), userID, provider.Name())
+	if err != nil {
+		return internalServerError("Error during database query").WithInternalError(err)
+	}
+	method, err := save(customerID, user)
+	if err != nil {
+		return badRequestError("Error saving payment method: %v", err)
+	}
+	method.InstanceID = gcontext.GetInstanceID(ctx)
+	method.ID = uuid.NewRandom().String()
+	method.UserID = userID
+	if result := db.Create(method); result.Error != nil {
+		return internalServerError("Error saving payment method").WithInternalError(result.Error)
+	}
+
+	log.WithField("payment_method_id", method.ID).Info("Saved payment method")
+	return sendJSON(w, http.StatusCreated, method)
+}
+
+// PaymentMethodDelete removes a saved payment method from the provider and
+// deletes it.
+func (a *API) PaymentMethodDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+	db := a.DB(r)
+
+	method, httpErr := getPaymentMethod(db, gcontext.GetInstanceID(ctx), gcontext.GetUserID(ctx), chi.URLParam(r, "payment_method_id"))
+	if httpErr != nil {
+		return httpErr
+	}
+
+	provider := gcontext.GetPaymentProviders(ctx)[method.PaymentProcessor]
+	if provider == nil {
+		return badRequestError("Payment provider '%s' not configured", method.PaymentProcessor)
+	}
+	remove, err := provider.NewMethodRemover(ctx, r, log.WithField("component", "payment_provider"))
+	if err != nil {
+		return badRequestError("Error creating payment provider: %v", err)
+	}
+	if err := remove(method); err != nil {
+		return internalServerError("Error removing payment method").WithInternalError(err)
+	}
+	if result := db.Delete(method); result.Error != nil {
+		return internalServerError("Error deleting payment method").WithInternalError(result.Error)
+	}
+
+	log.WithField("payment_method_id", method.ID).Info("Deleted payment method")
+	return sendJSON(w, http.StatusOK, map[string]string{})
+}
+
+func getPaymentMethod(db *gorm.DB, instanceID, userID, methodID string) (*models.PaymentMethod, *HTTPError) {
+	method := &models.PaymentMethod{}
+	if result := db.First(method, "instance_id = ? AND id = ? AND user_id = ?", instanceID, methodID, userID); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, notFoundError("Payment method not found")
+		}
+		return nil, internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return method, nil
+}
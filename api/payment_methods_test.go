@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+func TestPaymentMethods(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	userURL := "/users/" + test.Data.testUser.ID + "/payment_methods"
+
+	customers := 0
+	detached := []string{}
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) error {
+		switch path {
+		case "/v1/customers":
+			customers++
+			customerParams := params.(*stripe.CustomerParams)
+			assert.Equal(t, test.Data.testUser.Email, *customerParams.Email)
+			v.(*stripe.Customer).ID = "cus_batman"
+		case "/v1/payment_methods/pm_visa/attach", "/v1/payment_methods/pm_amex/attach":
+			assert.Equal(t, "cus_batman", *params.(*stripe.PaymentMethodAttachParams).Customer)
+			pm := v.(*stripe.PaymentMethod)
+			pm.ID = strings.Split(path, "/")[3]
+			pm.Card = &stripe.PaymentMethodCard{Brand: "visa", Last4: "4242", ExpMonth: 12, ExpYear: 2030}
+		case "/v1/payment_methods/pm_visa/detach":
+			detached = append(detached, "pm_visa")
+		case "/v1/payment_intents":
+			intentParams := params.(*stripe.PaymentIntentParams)
+			assert.Equal(t, "cus_batman", *intentParams.Customer)
+			assert.Equal(t, "pm_visa", *intentParams.PaymentMethod)
+			intent := v.(*stripe.PaymentIntent)
+			intent.ID = stripePaymentIntentID
+			intent.Status = stripe.PaymentIntentStatusSucceeded
+		default:
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+		return nil
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	// the same user in another instance has their own customer and methods
+	other := &models.PaymentMethod{
+		InstanceID:       "other-instance",
+		ID:               "other-method",
+		UserID:           test.Data.testUser.ID,
+		PaymentProcessor: payments.StripeProvider,
+		ProcessorID:      "pm_other",
+		CustomerID:       "cus_other",
+	}
+	require.NoError(t, test.DB.Create(other).Error)
+
+	visa := &models.PaymentMethod{}
+	t.Run("Save", func(t *testing.T) {
+		body := strings.NewReader(`{"provider": "stripe", "stripe_payment_method_id": "pm_visa"}`)
+		recorder := test.TestEndpoint(http.MethodPost, userURL, body, test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, visa)
+		assert.Equal(t, "visa", visa.Brand)
+		assert.Equal(t, "4242", visa.Last4)
+		assert.EqualValues(t, 12, visa.ExpMonth)
+		assert.EqualValues(t, 2030, visa.ExpYear)
+		assert.NotContains(t, recorder.Body.String(), "pm_visa")
+
+		// the second method is saved on the same customer
+		body = strings.NewReader(`{"provider": "stripe", "stripe_payment_method_id": "pm_amex"}`)
+		recorder = test.TestEndpoint(http.MethodPost, userURL, body, test.Data.testUserToken)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		assert.Equal(t, 1, customers)
+
+		body = strings.NewReader(`{"provider": "stripe"}`)
+		recorder = test.TestEndpoint(http.MethodPost, userURL, body, test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, userURL, nil, test.Data.testUserToken)
+		methods := []models.PaymentMethod{}
+		extractPayload(t, http.StatusOK, recorder, &methods)
+		assert.Len(t, methods, 2)
+
+		recorder = test.TestEndpoint(http.MethodGet, userURL, nil, testToken("joker", "joker@example.com"))
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Pay", func(t *testing.T) {
+		body := strings.NewReader(`{
+			"shipping_address": {
+				"name": "Bruce Wayne",
+				"address1": "1007 Mountain Drive",
+				"city": "Gotham", "state": "NJ", "country": "USA", "zip": "07001"
+			},
+			"line_items": [{"path": "/simple-product", "quantity": 1}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		payment, err := json.Marshal(&PaymentParams{
+			Amount:          order.Total,
+			Currency:        order.Currency,
+			ProviderType:    payments.StripeProvider,
+			PaymentMethodID: visa.ID,
+		})
+		require.NoError(t, err)
+		url := "/orders/" + order.ID + "/payments"
+
+		recorder = test.TestEndpoint(http.MethodPost, url, bytes.NewBuffer(payment), testToken("joker", "joker@example.com"))
+		validateError(t, http.StatusNotFound, recorder)
+
+		recorder = test.TestEndpoint(http.MethodPost, url, bytes.NewBuffer(payment), test.Data.testUserToken)
+		trans := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, trans)
+		assert.Equal(t, models.PaidState, trans.Status)
+		assert.Equal(t, stripePaymentIntentID, trans.ProcessorID)
+	})
+
+	t.Run("PaySubscription", func(t *testing.T) {
+		body := strings.NewReader(`{
+			"shipping_address": {
+				"name": "Bruce Wayne",
+				"address1": "1007 Mountain Drive",
+				"city": "Gotham", "state": "NJ", "country": "USA", "zip": "07001"
+			},
+			"line_items": [{"path": "/subscription-product", "quantity": 1}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		// a new card is kept on the customer of the saved methods
+		payment, err := json.Marshal(&stripePaymentParams{
+			Amount:                order.Total,
+			Currency:              order.Currency,
+			StripePaymentMethodID: "pm_visa",
+			Provider:              payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", bytes.NewBuffer(payment), test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, 1, customers)
+
+		subscription := &models.Subscription{}
+		require.NoError(t, test.DB.First(subscription, "order_id = ?", order.ID).Error)
+		assert.Equal(t, "cus_batman", subscription.CustomerID)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodDelete, userURL+"/"+visa.ID, nil, test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, []string{"pm_visa"}, detached)
+
+		recorder = test.TestEndpoint(http.MethodDelete, userURL+"/"+visa.ID, nil, test.Data.testUserToken)
+		validateError(t, http.StatusNotFound, recorder)
+		recorder = test.TestEndpoint(http.MethodDelete, userURL+"/"+other.ID, nil, test.Data.testUserToken)
+		validateError(t, http.StatusNotFound, recorder)
+
+		recorder = test.TestEndpoint(http.MethodGet, userURL, nil, test.Data.testUserToken)
+		methods := []models.PaymentMethod{}
+		extractPayload(t, http.StatusOK, recorder, &methods)
+		assert.Len(t, methods, 1)
+	})
+}
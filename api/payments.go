@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"strings"
 
@@ -18,12 +19,9 @@ import (
 	"mime"
 
 	"github.com/netlify/gocommerce/claims"
-	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
 	"github.com/netlify/gocommerce/payments"
-	"github.com/netlify/gocommerce/payments/paypal"
-	"github.com/netlify/gocommerce/payments/stripe"
 )
 
 // PaymentParams holds the parameters for creating a payment
@@ -32,6 +30,9 @@ type PaymentParams struct {
 	Currency     string `json:"currency"`
 	ProviderType string `json:"provider"`
 	Description  string `json:"description"`
+	// PaymentMethodID pays with a payment method the user saved, instead of
+	// the payment details for the provider.
+	PaymentMethodID string `json:"payment_method_id"`
 }
 
 // PaymentListForUser is the endpoint for listing transactions for a user.
@@ -80,7 +81,9 @@ func (a *API) PaymentListForOrder(w http.ResponseWriter, r *http.Request) error
 	return sendJSON(w, http.StatusOK, order.Transactions)
 }
 
-func paymentComplete(r *http.Request, tx *gorm.DB, tr *models.Transaction, order *models.Order) {
+// paymentComplete marks the order as paid, and returns the gift cards that
+// were bought with it.
+func paymentComplete(r *http.Request, tx *gorm.DB, tr *models.Transaction, order *models.Order) []*models.GiftCard {
 	ctx := r.Context()
 	log := getLogEntry(r)
 	config := gcontext.GetConfig(ctx)
@@ -92,6 +95,22 @@ func paymentComplete(r *http.Request, tx *gorm.DB, tr *models.Transaction, order
 		tx.Save(tr)
 	}
 	order.PaymentState = models.PaidState
+	if order.LineItems == nil {
+		tx.Where("order_id = ?", order.ID).Find(&order.LineItems)
+	}
+	if err := models.CommitInventory(tx, order); err != nil {
+		if _, ok := err.(*models.OutOfStockError); ok {
+			// the payment went through already, so the order is flagged for
+			// the shop to restock or refund it
+			log.WithError(err).Error("Paid order is out of stock")
+			models.LogChanges(tx, r.RemoteAddr, order.UserID, order.ID, models.EventUpdated, []models.FieldChange{
+				{Field: "inventory_state", Old: order.InventoryState, New: models.InventoryOversoldState},
+			})
+			order.InventoryState = models.InventoryOversoldState
+		} else {
+			log.WithError(err).Error("Failed to update inventory")
+		}
+	}
 	tx.Save(order)
 
 	if config.Webhooks.Payment != "" {
@@ -101,6 +120,28 @@ func paymentComplete(r *http.Request, tx *gorm.DB, tr *models.Transaction, order
 		}
 		tx.Save(hook)
 	}
+
+	if order.SubscriptionID == "" {
+		for _, subscription := range models.NewSubscriptions(order, tr.ProcessorID, time.Now()) {
+			tx.Create(subscription)
+			saveSubscriptionHook(config, tx, subscription, log)
+			log.WithField("subscription_id", subscription.ID).Infof("Started subscription to %s", subscription.Sku)
+		}
+	} else {
+		renewPaidSubscription(config, tx, order, tr.ProcessorID, log)
+	}
+
+	giftCards, err := models.NewGiftCards(order)
+	if err != nil {
+		log.WithError(err).Error("Failed to create gift cards")
+		return nil
+	}
+	for _, card := range giftCards {
+		if err := card.Issue(tx, order.ID, order.UserID, ""); err != nil {
+			log.WithError(err).Error("Failed to issue gift card")
+		}
+	}
+	return giftCards
 }
 
 func sendOrderConfirmation(ctx context.Context, log logrus.FieldLogger, tr *models.Transaction) {
@@ -132,30 +173,16 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 	if provider == nil {
 		return badRequestError("Payment provider '%s' not configured", params.ProviderType)
 	}
-	charge, err := provider.NewCharger(ctx, r, log.WithField("component", "payment_provider"))
-	if err != nil {
-		return badRequestError("Error creating payment provider: %v", err)
+	charge, httpErr := a.newPaymentCharger(r, provider, params)
+	if httpErr != nil {
+		return httpErr
 	}
 
-	orderID := gcontext.GetOrderID(ctx)
 	tx := a.DB(r).Begin()
-	order := &models.Order{}
-	loader := tx.
-		Preload("LineItems").
-		Preload("Downloads").
-		Preload("BillingAddress").
-		Preload("ShippingAddress")
-	if result := loader.First(order, "id = ?", orderID); result.Error != nil {
-		tx.Rollback()
-		if result.RecordNotFound() {
-			return notFoundError("No order with this ID found")
-		}
-		return internalServerError("Error during database query").WithInternalError(result.Error)
-	}
-
-	if order.PaymentState == models.PaidState {
+	order, httpErr := loadOrderForPayment(ctx, tx, gcontext.GetOrderID(ctx))
+	if httpErr != nil {
 		tx.Rollback()
-		return badRequestError("This order has already been paid")
+		return httpErr
 	}
 
 	if order.Currency != params.Currency {
@@ -163,22 +190,19 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError("Currencies doesn't match - %v vs %v", order.Currency, params.Currency)
 	}
 
-	token := gcontext.GetToken(ctx)
-	if order.UserID == "" {
-		if token != nil {
-			claims := token.Claims.(*claims.JWTClaims)
-			order.UserID = claims.Subject
-			tx.Save(order)
-		}
-	} else {
-		if token == nil {
+	if order.Recurring() {
+		// the subscriptions started by the order must be renewable
+		if _, err := provider.NewRenewer(ctx, r, log.WithField("component", "payment_provider")); err != nil {
 			tx.Rollback()
-			return unauthorizedError("You must be logged in to pay for this order")
+			return badRequestError("Subscriptions can't be paid with '%s': %v", provider.Name(), err)
 		}
-		claims := token.Claims.(*claims.JWTClaims)
-		if order.UserID != claims.Subject {
-			tx.Rollback()
-			return unauthorizedError("You must be logged in to pay for this order")
+		if order.UserID != "" && order.PaymentCustomerID == "" {
+			// keep the cards of a user on the same customer
+			order.PaymentCustomerID, err = models.PaymentCustomerID(tx, order.InstanceID, order.UserID, provider.Name())
+			if err != nil {
+				tx.Rollback()
+				return internalServerError("Error loading payment customer").WithInternalError(err)
+			}
 		}
 	}
 
@@ -188,15 +212,10 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("We failed to authorize the amount for this order: %v", err)
 	}
 
-	invoiceNumber := order.InvoiceNumber
-	if invoiceNumber == 0 {
-		var err error
-		invoiceNumber, err = models.NextInvoiceNumber(tx, order.InstanceID)
-		if err != nil {
-			tx.Rollback()
-			return internalServerError("We failed to generate a valid invoice ID, please try again later: %v", err)
-		}
-		order.InvoiceNumber = invoiceNumber
+	invoiceNumber, httpErr := preparePayment(tx, order)
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
 	}
 
 	tr := models.NewTransaction(order)
@@ -219,20 +238,174 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 		tr.FailureDescription = err.Error()
 		tr.Status = models.FailedState
 		tx.Create(tr)
+		if err := models.ReleaseInventory(tx, order); err != nil {
+			log.WithError(err).Error("Failed to release inventory")
+		} else {
+			tx.Model(order).UpdateColumn("inventory_state", order.InventoryState)
+		}
 		tx.Commit()
 		return internalServerError("There was an error charging your card: %v", err).WithInternalError(err)
 	}
 
-	paymentComplete(r, tx, tr, order)
+	giftCards := paymentComplete(r, tx, tr, order)
 	if err := tx.Commit().Error; err != nil {
 		return internalServerError("Saving payment failed").WithInternalError(err)
 	}
 
 	go sendOrderConfirmation(ctx, log, tr)
+	go sendGiftCards(ctx, log, giftCards, order)
 
 	return sendJSON(w, http.StatusOK, tr)
 }
 
+// PaymentCreateFree is the endpoint for completing an order with a zero total,
+// like one paid for with a coupon or gift card, without a payment provider.
+func (a *API) PaymentCreateFree(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	log := getLogEntry(r)
+
+	tx := a.DB(r).Begin()
+	order, httpErr := loadOrderForPayment(ctx, tx, gcontext.GetOrderID(ctx))
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+
+	if order.Total != 0 {
+		tx.Rollback()
+		return badRequestError("Only orders with a zero total can be completed without a payment")
+	}
+	for _, item := range order.LineItems {
+		if item.Recurring() {
+			tx.Rollback()
+			return badRequestError("Subscriptions can't be started without a payment")
+		}
+	}
+
+	invoiceNumber, httpErr := preparePayment(tx, order)
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+
+	tr := models.NewTransaction(order)
+	tr.Type = models.FreeTransactionType
+	tr.InvoiceNumber = invoiceNumber
+
+	giftCards := paymentComplete(r, tx, tr, order)
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Saving payment failed").WithInternalError(err)
+	}
+
+	log.WithField("transaction_id", tr.ID).Info("Completed order without a payment")
+	go sendOrderConfirmation(ctx, log, tr)
+	go sendGiftCards(ctx, log, giftCards, order)
+
+	return sendJSON(w, http.StatusOK, tr)
+}
+
+// loadOrderForPayment loads an unpaid order with everything needed to pay it,
+// and assigns anonymous orders to the logged in user.
+func loadOrderForPayment(ctx context.Context, tx *gorm.DB, orderID string) (*models.Order, *HTTPError) {
+	order := &models.Order{}
+	loader := tx.
+		Preload("LineItems").
+		Preload("Downloads").
+		Preload("BillingAddress").
+		Preload("ShippingAddress")
+	if result := loader.First(order, "id = ?", orderID); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, notFoundError("No order with this ID found")
+		}
+		return nil, internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	if order.PaymentState == models.PaidState {
+		return nil, badRequestError("This order has already been paid")
+	}
+	if order.PaymentState == models.ExpiredState {
+		// its stock and gift card were already given back
+		return nil, badRequestError("This order has expired")
+	}
+
+	token := gcontext.GetToken(ctx)
+	if order.UserID == "" {
+		if token != nil {
+			claims := token.Claims.(*claims.JWTClaims)
+			order.UserID = claims.Subject
+			tx.Save(order)
+		}
+	} else {
+		if token == nil {
+			return nil, unauthorizedError("You must be logged in to pay for this order")
+		}
+		claims := token.Claims.(*claims.JWTClaims)
+		if order.UserID != claims.Subject {
+			return nil, unauthorizedError("You must be logged in to pay for this order")
+		}
+	}
+	return order, nil
+}
+
+// preparePayment reserves the stock of the order again if an earlier payment
+// released it, and returns the invoice number of the order, assigning one if
+// it has none yet.
+func preparePayment(tx *gorm.DB, order *models.Order) (int64, *HTTPError) {
+	if order.InventoryState == models.InventoryReleasedState {
+		// the stock was given back after an earlier payment failed
+		if err := models.ReserveInventory(tx, order); err != nil {
+			if stockErr, ok := err.(*models.OutOfStockError); ok {
+				return 0, badRequestError(stockErr.Error())
+			}
+			return 0, internalServerError("Error reserving inventory").WithInternalError(err)
+		}
+		tx.Model(order).UpdateColumn("inventory_state", order.InventoryState)
+	}
+
+	if order.InvoiceNumber == 0 {
+		invoiceNumber, err := models.NextInvoiceNumber(tx, order.InstanceID)
+		if err != nil {
+			return 0, internalServerError("We failed to generate a valid invoice ID, please try again later: %v", err)
+		}
+		order.InvoiceNumber = invoiceNumber
+	}
+	return order.InvoiceNumber, nil
+}
+
+// newPaymentCharger creates the charger for the payment details in the request,
+// or for the saved payment method of the logged in user.
+func (a *API) newPaymentCharger(r *http.Request, provider payments.Provider, params PaymentParams) (payments.Charger, *HTTPError) {
+	ctx := r.Context()
+	log := getLogEntry(r).WithField("component", "payment_provider")
+
+	if params.PaymentMethodID == "" {
+		charge, err := provider.NewCharger(ctx, r, log)
+		if err != nil {
+			return nil, badRequestError("Error creating payment provider: %v", err)
+		}
+		return charge, nil
+	}
+
+	claims := gcontext.GetClaims(ctx)
+	if claims == nil {
+		return nil, unauthorizedError("You must be logged in to pay with a saved payment method")
+	}
+	method, httpErr := getPaymentMethod(a.DB(r), gcontext.GetInstanceID(ctx), claims.Subject, params.PaymentMethodID)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if method.PaymentProcessor != provider.Name() {
+		return nil, badRequestError("Payment method was saved with '%s'", method.PaymentProcessor)
+	}
+	chargeMethod, err := provider.NewMethodCharger(ctx, r, log)
+	if err != nil {
+		return nil, badRequestError("Error creating payment provider: %v", err)
+	}
+	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
+		return chargeMethod(method, amount, currency, order, invoiceNumber)
+	}, nil
+}
+
 // PaymentConfirm allows client to confirm if a pending transaction has been completed. Updates transaction and order
 func (a *API) PaymentConfirm(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
@@ -300,12 +473,13 @@ func (a *API) PaymentConfirm(w http.ResponseWriter, r *http.Request) error {
 		trans.InvoiceNumber = invoiceNumber
 	}
 
-	paymentComplete(r, tx, trans, order)
+	giftCards := paymentComplete(r, tx, trans, order)
 	if err := tx.Commit().Error; err != nil {
 		return internalServerError("Saving payment failed").WithInternalError(err)
 	}
 
 	go sendOrderConfirmation(ctx, log, trans)
+	go sendGiftCards(ctx, log, giftCards, order)
 
 	return sendJSON(w, http.StatusOK, trans)
 }
@@ -341,9 +515,7 @@ func (a *API) PaymentView(w http.ResponseWriter, r *http.Request) error {
 // PaymentRefund refunds a transaction for a specific amount. This allows partial
 // refunds if desired. It is only available to admins.
 func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
-	ctx := r.Context()
 	db := a.DB(r)
-	config := gcontext.GetConfig(ctx)
 	params := PaymentParams{Currency: "USD"}
 	err := json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
@@ -377,36 +549,56 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 	if httpErr != nil {
 		return httpErr
 	}
+
+	m, httpErr := refundTransaction(r, db, order, trans, params.Amount, params.Currency)
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, m)
+}
+
+// refundTransaction refunds an amount of a paid transaction with the payment
+// provider of the order. The refund is recorded as a new transaction, which is
+// saved as pending before the provider is called and marked as failed if the
+// provider rejects the refund. It commits its own database transactions, so
+// the refund is recorded no matter what the caller does afterwards.
+func refundTransaction(r *http.Request, db *gorm.DB, order *models.Order, trans *models.Transaction, amount uint64, currency string) (*models.Transaction, *HTTPError) {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+
 	if order.PaymentProcessor == "" {
-		return badRequestError("Order does not specify a payment provider")
+		return nil, badRequestError("Order does not specify a payment provider")
 	}
 
 	provider := gcontext.GetPaymentProviders(ctx)[order.PaymentProcessor]
 	if provider == nil {
-		return badRequestError("Payment provider '%s' not configured", order.PaymentProcessor)
+		return nil, badRequestError("Payment provider '%s' not configured", order.PaymentProcessor)
 	}
 	refund, err := provider.NewRefunder(ctx, r, log.WithField("component", "payment_provider"))
 	if err != nil {
-		return badRequestError("Error creating payment provider: %v", err)
+		return nil, badRequestError("Error creating payment provider: %v", err)
 	}
 
 	// ok make the refund
 	m := &models.Transaction{
 		InstanceID: order.InstanceID,
 		ID:         uuid.NewRandom().String(),
-		Amount:     params.Amount,
-		Currency:   params.Currency,
+		Amount:     amount,
+		Currency:   currency,
 		UserID:     trans.UserID,
 		OrderID:    trans.OrderID,
 		Type:       models.RefundTransactionType,
 		Status:     models.PendingState,
 	}
 
-	tx := db.Begin()
-	tx.Create(m)
+	if result := db.Create(m); result.Error != nil {
+		return nil, internalServerError("Error saving refund").WithInternalError(result.Error)
+	}
 	provID := provider.Name()
 	log.Debugf("Starting refund to %s", provID)
-	refundID, err := refund(trans.ProcessorID, params.Amount, params.Currency)
+	refundID, err := refund(trans.ProcessorID, amount, currency)
+	tx := db.Begin()
 	if err != nil {
 		log.WithError(err).Info("Failed to refund value")
 		m.FailureCode = strconv.FormatInt(http.StatusInternalServerError, 10)
@@ -415,6 +607,16 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 	} else {
 		m.ProcessorID = refundID
 		m.Status = models.PaidState
+
+		var refunded uint64
+		for _, t := range order.Transactions {
+			if t.Type == models.RefundTransactionType && t.Status == models.PaidState {
+				refunded += t.Amount
+			}
+		}
+		if err := models.RevokeGiftCards(tx, order, refunded, amount, "Order refunded"); err != nil {
+			log.WithError(err).Error("Failed to take the refund off the gift cards")
+		}
 	}
 
 	log.Infof("Finished transaction with %s: %s", provID, m.ProcessorID)
@@ -426,8 +628,10 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 		}
 		tx.Save(hook)
 	}
-	tx.Commit()
-	return sendJSON(w, http.StatusOK, m)
+	if err := tx.Commit().Error; err != nil {
+		return nil, internalServerError("Error saving refund").WithInternalError(err)
+	}
+	return m, nil
 }
 
 // PreauthorizePayment creates a new payment that can be authorized in the browser
@@ -530,30 +734,3 @@ func queryForTransactions(db *gorm.DB, log logrus.FieldLogger, clause, id string
 
 	return trans, nil
 }
-
-// createPaymentProviders creates instance(s) of Provider based on the configuration
-// provided.
-func createPaymentProviders(c *conf.Configuration) (map[string]payments.Provider, error) {
-	provs := map[string]payments.Provider{}
-	if c.Payment.Stripe.Enabled {
-		p, err := stripe.NewPaymentProvider(stripe.Config{
-			SecretKey: c.Payment.Stripe.SecretKey,
-		})
-		if err != nil {
-			return nil, err
-		}
-		provs[p.Name()] = p
-	}
-	if c.Payment.PayPal.Enabled {
-		p, err := paypal.NewPaymentProvider(paypal.Config{
-			Env:      c.Payment.PayPal.Env,
-			ClientID: c.Payment.PayPal.ClientID,
-			Secret:   c.Payment.PayPal.Secret,
-		})
-		if err != nil {
-			return nil, err
-		}
-		provs[p.Name()] = p
-	}
-	return provs, nil
-}
@@ -424,6 +424,45 @@ func TestPaymentCreate(t *testing.T) {
 			assert.Equal(t, 1, loginCount, "too many login calls")
 			assert.Equal(t, 3, paymentCount, "too many payment calls")
 		})
+
+		t.Run("Subscription", func(t *testing.T) {
+			test := NewRouteTest(t)
+			test.Data.secondOrder.PaymentState = models.PendingState
+			require.NoError(t, test.DB.Save(test.Data.secondOrder).Error)
+			test.Data.secondLineItem1.Interval = models.MonthInterval
+			require.NoError(t, test.DB.Save(test.Data.secondLineItem1).Error)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/oauth2/token" {
+					w.WriteHeader(500)
+					t.Fatalf("unknown PayPal API call to %s", r.URL.Path)
+				}
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprint(w, `{"access_token":"EEwJ6tF9x5WCIZDYzyZGaz6Khbw7raYRIBV_WxVvgmsG","expires_in":100000}`)
+			}))
+			defer server.Close()
+			test.Config.Payment.PayPal.Enabled = true
+			test.Config.Payment.PayPal.ClientID = "clientid"
+			test.Config.Payment.PayPal.Secret = "secret"
+			test.Config.Payment.PayPal.Env = server.URL
+
+			body, err := json.Marshal(&paypalPaymentParams{
+				Amount:       test.Data.secondOrder.Total,
+				Currency:     test.Data.secondOrder.Currency,
+				PaypalID:     "4CF18861HF410323V",
+				PaypalUserID: "456",
+				Provider:     payments.PayPalProvider,
+				OrderID:      test.Data.secondOrder.ID,
+			})
+			require.NoError(t, err)
+
+			recorder := test.TestEndpoint(http.MethodPost, "/orders/second-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+			validateError(t, http.StatusBadRequest, recorder, "Subscriptions can't be paid with 'paypal': Paypal does not support renewing subscriptions")
+
+			unpaid := &models.Order{}
+			require.NoError(t, test.DB.First(unpaid, "id = ?", test.Data.secondOrder.ID).Error)
+			assert.Equal(t, models.PendingState, unpaid.PaymentState)
+		})
 	})
 	t.Run("Stripe", func(t *testing.T) {
 		t.Run("PaymentIntent", func(t *testing.T) {
@@ -517,6 +556,103 @@ func TestPaymentCreate(t *testing.T) {
 			}
 		})
 	})
+	t.Run("Expired", func(t *testing.T) {
+		test := NewRouteTest(t)
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) error {
+			t.Fatalf("unexpected Stripe API call to %s", path)
+			return nil
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		test.Data.firstOrder.PaymentState = models.ExpiredState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:                test.Data.firstOrder.Total,
+			Currency:              test.Data.firstOrder.Currency,
+			StripePaymentMethodID: "payment-method-simple",
+			Provider:              payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "This order has expired")
+	})
+}
+
+func TestPaymentCreateFree(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+	couponServer := startCouponList("FREE-LUNCH", 100)
+	defer couponServer.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Coupons.URL = couponServer.URL
+	test.Config.Webhooks.Payment = "https://example.com/hooks/payment"
+
+	createOrder := func(t *testing.T, coupon string) *models.Order {
+		body := strings.NewReader(fmt.Sprintf(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "/simple-product", "quantity": 1}],
+			"coupon": %q
+		}`, coupon))
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		return order
+	}
+
+	t.Run("Free", func(t *testing.T) {
+		order := createOrder(t, "FREE-LUNCH")
+		require.EqualValues(t, 0, order.Total)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/complete", nil, test.Data.testUserToken)
+		trans := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, trans)
+		assert.Equal(t, models.FreeTransactionType, trans.Type)
+		assert.Equal(t, models.PaidState, trans.Status)
+		assert.EqualValues(t, 0, trans.Amount)
+		assert.NotZero(t, trans.InvoiceNumber)
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		assert.Equal(t, models.PaidState, saved.PaymentState)
+		assert.Equal(t, trans.InvoiceNumber, saved.InvoiceNumber)
+
+		hooks := []models.Hook{}
+		require.NoError(t, test.DB.Where("type = ?", "payment").Find(&hooks).Error)
+		assert.Len(t, hooks, 1)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/"+order.ID+"/receipt", nil, test.Data.testUserToken)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/complete", nil, test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "This order has already been paid")
+	})
+
+	t.Run("NotFree", func(t *testing.T) {
+		order := createOrder(t, "")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/complete", nil, test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "Only orders with a zero total can be completed without a payment")
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		order := createOrder(t, "FREE-LUNCH")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/complete", nil, testToken("joker", "joker@example.com"))
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		order := createOrder(t, "FREE-LUNCH")
+		require.NoError(t, test.DB.Model(order).UpdateColumn("payment_state", models.ExpiredState).Error)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/complete", nil, test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "This order has expired")
+	})
 }
 
 func TestPaymentConfirm(t *testing.T) {
@@ -762,7 +898,10 @@ type paypalPaymentCreateParams struct {
 
 type memProvider struct {
 	refundCalls []refundCall
+	voidCalls   []string
 	name        string
+	refundErr   error
+	onRefund    func()
 }
 
 type refundCall struct {
@@ -786,11 +925,30 @@ func (mp *memProvider) NewPreauthorizer(ctx context.Context, r *http.Request, lo
 func (mp *memProvider) NewConfirmer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Confirmer, error) {
 	return mp.confirm, nil
 }
+func (mp *memProvider) NewVoider(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Voider, error) {
+	return mp.void, nil
+}
+func (mp *memProvider) NewRenewer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Renewer, error) {
+	return mp.renew, nil
+}
+func (mp *memProvider) NewMethodSaver(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodSaver, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (mp *memProvider) NewMethodRemover(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodRemover, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (mp *memProvider) NewMethodCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodCharger, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
 
 func (mp *memProvider) charge(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
 	return "", errors.New("Shouldn't have called this")
 }
 
+func (mp *memProvider) renew(previousPaymentID string, amount uint64, currency string, order *models.Order, invoiceNumber int64, idempotencyKey string) (string, error) {
+	return "", errors.New("Shouldn't have called this")
+}
+
 func (mp *memProvider) refund(transactionID string, amount uint64, currency string) (string, error) {
 	if mp.refundCalls == nil {
 		mp.refundCalls = []refundCall{}
@@ -800,6 +958,12 @@ func (mp *memProvider) refund(transactionID string, amount uint64, currency stri
 		id:       transactionID,
 		currency: currency,
 	})
+	if mp.onRefund != nil {
+		mp.onRefund()
+	}
+	if mp.refundErr != nil {
+		return "", mp.refundErr
+	}
 
 	return fmt.Sprintf("trans-%d", len(mp.refundCalls)), nil
 }
@@ -812,6 +976,11 @@ func (mp *memProvider) confirm(paymentID string) error {
 	return nil
 }
 
+func (mp *memProvider) void(paymentID string) error {
+	mp.voidCalls = append(mp.voidCalls, paymentID)
+	return nil
+}
+
 type stripeCallFunc func(method, path, key string, params stripe.ParamsContainer, v interface{}) error
 
 func NewTrackingStripeBackend(fn stripeCallFunc) stripe.Backend {
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/products"
+)
+
+// ProductCachePurge removes cached product metadata, either of the page given
+// by the path query parameter or of the whole site.
+func (a *API) ProductCachePurge(w http.ResponseWriter, r *http.Request) error {
+	log := getLogEntry(r)
+	path := r.URL.Query().Get("path")
+
+	purged := gcontext.GetProducts(r.Context()).Purge(path)
+	log.WithField("path", path).Infof("Purged %d product pages from the cache", purged)
+	return sendJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// productCache returns the product cache of an instance, creating it on first
+// use or when the instance's site changed.
+func (a *API) productCache(instanceID string, config *conf.Configuration) *products.Cache {
+	a.cachesLock.Lock()
+	defer a.cachesLock.Unlock()
+
+	cache, ok := a.productCaches[instanceID]
+	if !ok || !cache.UsesConfig(config) {
+		cache = products.NewCache(config)
+		a.productCaches[instanceID] = cache
+	}
+	return cache
+}
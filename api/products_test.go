@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/products"
+)
+
+func TestProductCachePurge(t *testing.T) {
+	var productCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/simple-product" {
+			productCalls++
+		}
+		handleTestProducts(w, r)
+	}))
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Products.CacheTTL = 60
+
+	ctx, err := WithInstanceConfig(context.Background(), new(conf.GlobalConfiguration).SMTP, test.Config, "")
+	require.NoError(t, err)
+	ctx = gcontext.WithProducts(ctx, products.NewCache(test.Config))
+	api := NewAPIWithVersion(ctx, test.GlobalConfig, logrus.StandardLogger(), test.DB, "")
+	serve := func(method, url string, body io.Reader, token *jwt.Token) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, baseURL+url, body)
+		if token != nil {
+			require.NoError(t, signHTTPRequest(req, token, test.Config.JWT.Secret))
+		}
+		api.handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := serve(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "product-1", order.LineItems[0].Sku)
+	}
+	assert.Equal(t, 1, productCalls)
+
+	recorder := serve(http.MethodDelete, "/products/cache", nil, test.Data.testUserToken)
+	validateError(t, http.StatusUnauthorized, recorder)
+
+	recorder = serve(http.MethodDelete, "/products/cache", nil, testAdminToken("magical-unicorn", ""))
+	purged := map[string]int{}
+	extractPayload(t, http.StatusOK, recorder, &purged)
+	assert.Equal(t, 1, purged["purged"])
+
+	recorder = serve(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, 2, productCalls)
+}
+
+func TestOrderCreateFromCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gocommerce/products.json":
+			fmt.Fprint(w, `{"products": [
+				{"sku": "product-1", "title": "Product 1", "type": "Book", "prices": [{"amount": "9.99", "currency": "USD"}]}
+			]}`)
+		case "/gocommerce/settings.json":
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Products.Source = products.JSONSource
+	test.Config.Products.CatalogURL = "/gocommerce/products.json"
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [{"sku": "product-1", "quantity": 2}]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.Len(t, order.LineItems, 1)
+	assert.Equal(t, "Product 1", order.LineItems[0].Title)
+	assert.EqualValues(t, 999, order.LineItems[0].Price)
+	assert.EqualValues(t, 1998, order.Total)
+}
+
+func TestProductCachePerInstance(t *testing.T) {
+	a := &API{productCaches: map[string]*products.Cache{}}
+	config := &conf.Configuration{SiteURL: "https://example.com"}
+
+	first := a.productCache("first", config)
+	assert.True(t, first == a.productCache("first", config))
+	assert.False(t, first == a.productCache("second", config))
+
+	config.SiteURL = "https://example.org"
+	assert.False(t, first == a.productCache("first", config))
+}
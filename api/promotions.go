@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/calculator"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+// PromotionList lists the stored promotion rules.
+func (a *API) PromotionList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	var promotions []models.Promotion
+	if result := db.Where("instance_id = ?", instanceID).Order("id asc").Find(&promotions); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, promotions)
+}
+
+// PromotionView shows a stored promotion rule.
+func (a *API) PromotionView(w http.ResponseWriter, r *http.Request) error {
+	promotion, httpErr := getPromotion(a.DB(r), gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "promotion_id"))
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, promotion)
+}
+
+// PromotionSet creates or replaces a stored promotion rule.
+func (a *API) PromotionSet(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+	promotionID := chi.URLParam(r, "promotion_id")
+
+	rule := &calculator.Promotion{}
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	rule.ID = promotionID
+	if errs := rule.Validate(); len(errs) > 0 {
+		return badRequestError(errs[0].Error())
+	}
+
+	promotion, err := models.GetPromotion(db, instanceID, promotionID)
+	if err != nil {
+		return internalServerError("Error during database query").WithInternalError(err)
+	}
+	if promotion == nil {
+		promotion = &models.Promotion{InstanceID: instanceID, PromotionID: promotionID}
+	}
+	promotion.Rule = rule
+	if result := db.Save(promotion); result.Error != nil {
+		return internalServerError("Error saving promotion").WithInternalError(result.Error)
+	}
+
+	log.WithField("promotion_id", promotionID).Infof("Set %s promotion", rule.Type)
+	return sendJSON(w, http.StatusOK, promotion)
+}
+
+// PromotionDelete removes a stored promotion rule.
+func (a *API) PromotionDelete(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+	promotionID := chi.URLParam(r, "promotion_id")
+
+	promotion, httpErr := getPromotion(db, gcontext.GetInstanceID(r.Context()), promotionID)
+	if httpErr != nil {
+		return httpErr
+	}
+	if result := db.Delete(promotion); result.Error != nil {
+		return internalServerError("Error deleting promotion").WithInternalError(result.Error)
+	}
+
+	log.WithField("promotion_id", promotionID).Info("Deleted promotion")
+	return sendJSON(w, http.StatusOK, map[string]string{})
+}
+
+// AutomaticPromotionList lists the automatic promotions that are active now,
+// so storefronts can show them. The country and currency query parameters
+// leave out the promotions that aren't given for them.
+func (a *API) AutomaticPromotionList(w http.ResponseWriter, r *http.Request) error {
+	settings, err := a.loadSettings(r.Context())
+	if err != nil {
+		return internalServerError("Error loading site settings").WithInternalError(err)
+	}
+
+	query := r.URL.Query()
+	return sendJSON(w, http.StatusOK, settings.ActivePromotions(time.Now(), query.Get("country"), query.Get("currency")))
+}
+
+func getPromotion(db *gorm.DB, instanceID, promotionID string) (*models.Promotion, *HTTPError) {
+	promotion, err := models.GetPromotion(db, instanceID, promotionID)
+	if err != nil {
+		return nil, internalServerError("Error during database query").WithInternalError(err)
+	}
+	if promotion == nil {
+		return nil, notFoundError("Promotion not found")
+	}
+	return promotion, nil
+}
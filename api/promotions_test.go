@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+)
+
+func TestPromotionEndpoints(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("magical-unicorn", "")
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/promotions", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		body := `{"type": "bundle", "buy": {"quantity": 3, "product_types": ["ebook"]}, "get": {"quantity": 1}, "percentage": 100}`
+		recorder := test.TestEndpoint(http.MethodPut, "/promotions/3-for-2", strings.NewReader(body), token)
+		promotion := &models.Promotion{}
+		extractPayload(t, http.StatusOK, recorder, promotion)
+		assert.Equal(t, "3-for-2", promotion.PromotionID)
+		require.NotNil(t, promotion.Rule)
+		assert.Equal(t, "3-for-2", promotion.Rule.ID)
+		assert.Equal(t, calculator.PromotionBundle, promotion.Rule.Type)
+
+		body = `{"type": "bundle", "buy": {"quantity": 4, "product_types": ["ebook"]}, "get": {"quantity": 1}, "percentage": 100}`
+		recorder = test.TestEndpoint(http.MethodPut, "/promotions/3-for-2", strings.NewReader(body), token)
+		extractPayload(t, http.StatusOK, recorder, promotion)
+		assert.EqualValues(t, 4, promotion.Rule.Buy.Quantity)
+
+		recorder = test.TestEndpoint(http.MethodPut, "/promotions/broken", strings.NewReader(`{"type": "free"}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "Promotion has the unknown type 'free'")
+	})
+
+	t.Run("ViewAndList", func(t *testing.T) {
+		body := `{"type": "buy_x_get_y", "buy": {"quantity": 1, "products": ["a"]}, "get": {"quantity": 1, "products": ["b"]}, "percentage": 50}`
+		recorder := test.TestEndpoint(http.MethodPut, "/promotions/a-gets-b", strings.NewReader(body), token)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/promotions/a-gets-b", nil, token)
+		promotion := &models.Promotion{}
+		extractPayload(t, http.StatusOK, recorder, promotion)
+		assert.EqualValues(t, 50, promotion.Rule.Percentage)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/promotions", nil, token)
+		promotions := []models.Promotion{}
+		extractPayload(t, http.StatusOK, recorder, &promotions)
+		require.Len(t, promotions, 2)
+		assert.Equal(t, "3-for-2", promotions[0].PromotionID)
+		assert.Equal(t, "a-gets-b", promotions[1].PromotionID)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodDelete, "/promotions/a-gets-b", nil, token)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/promotions/a-gets-b", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
+
+func TestOrderCreateWithPromotion(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	require.NoError(t, test.DB.Create(&models.Promotion{
+		PromotionID: "3-for-2",
+		Rule: &calculator.Promotion{
+			Type:       calculator.PromotionBundle,
+			Buy:        calculator.PromotionItems{Quantity: 3, Products: []string{"product-1"}},
+			Get:        calculator.PromotionItems{Quantity: 1},
+			Percentage: 100,
+		},
+	}).Error)
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [{"path": "/simple-product", "quantity": 3}]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	assert.EqualValues(t, 999, order.Discount)
+	assert.EqualValues(t, 1998, order.Total)
+	require.Len(t, order.LineItems, 1)
+
+	discountItems := order.LineItems[0].CalculationDetail.DiscountItems
+	require.Len(t, discountItems, 1)
+	assert.Equal(t, calculator.DiscountTypePromotion, discountItems[0].Type)
+	assert.Equal(t, "3-for-2", discountItems[0].Promotion)
+	// the calculation is per unit, each unit gets a share of the free one
+	assert.EqualValues(t, 333, discountItems[0].Fixed)
+}
+
+func TestAutomaticPromotions(t *testing.T) {
+	started := time.Now().Add(-time.Hour)
+	ended := time.Now().Add(-time.Minute)
+	settings := calculator.Settings{
+		AutomaticPromotions: []*calculator.AutomaticPromotion{
+			{ID: "book-sale", Title: "20% off books", Percentage: 20, ProductTypes: []string{"Book"}, StartsAt: &started},
+			{ID: "euro-sale", Percentage: 10, Currencies: []string{"EUR"}},
+			{ID: "over", Percentage: 50, StartsAt: &started, EndsAt: &ended},
+		},
+	}
+	server := startTestSiteWithSettings(settings)
+	defer server.Close()
+
+	t.Run("List", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodGet, "/automatic-promotions", nil, nil)
+		promotions := []calculator.AutomaticPromotion{}
+		extractPayload(t, http.StatusOK, recorder, &promotions)
+		require.Len(t, promotions, 2)
+		assert.Equal(t, "book-sale", promotions[0].ID)
+		assert.Equal(t, "20% off books", promotions[0].Title)
+		assert.Equal(t, "euro-sale", promotions[1].ID)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/automatic-promotions?currency=USD", nil, nil)
+		extractPayload(t, http.StatusOK, recorder, &promotions)
+		require.Len(t, promotions, 1)
+		assert.Equal(t, "book-sale", promotions[0].ID)
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.EqualValues(t, 200, order.Discount)
+		assert.EqualValues(t, 799, order.Total)
+
+		discountItems := order.LineItems[0].CalculationDetail.DiscountItems
+		require.Len(t, discountItems, 1)
+		assert.Equal(t, calculator.DiscountTypePromotion, discountItems[0].Type)
+		assert.Equal(t, "book-sale", discountItems[0].Promotion)
+	})
+}
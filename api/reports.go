@@ -2,29 +2,54 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
 )
 
 type salesRow struct {
-	Total    uint64 `json:"total"`
-	SubTotal uint64 `json:"subtotal"`
-	Taxes    uint64 `json:"taxes"`
-	Currency string `json:"currency"`
-	Orders   uint64 `json:"orders"`
+	Total          uint64 `json:"total"`
+	SubTotal       uint64 `json:"subtotal"`
+	Taxes          uint64 `json:"taxes"`
+	Currency       string `json:"currency"`
+	Exponent       int    `json:"exponent"`
+	FormattedTotal string `json:"formatted_total"`
+	Orders         uint64 `json:"orders"`
 }
 
 type productsRow struct {
-	Sku      string `json:"sku"`
-	Path     string `json:"path"`
-	Total    uint64 `json:"total"`
-	Currency string `json:"currency"`
+	Sku            string `json:"sku"`
+	Path           string `json:"path"`
+	Total          uint64 `json:"total"`
+	Currency       string `json:"currency"`
+	Exponent       int    `json:"exponent"`
+	FormattedTotal string `json:"formatted_total"`
+}
+
+type abandonedCheckoutRow struct {
+	Currency                string `json:"currency"`
+	Exponent                int    `json:"exponent"`
+	Reminded                uint64 `json:"reminded"`
+	Recovered               uint64 `json:"recovered"`
+	RecoveredTotal          uint64 `json:"recovered_total"`
+	FormattedRecoveredTotal string `json:"formatted_recovered_total"`
+}
+
+type atRiskRow struct {
+	Currency             string `json:"currency"`
+	Exponent             int    `json:"exponent"`
+	Subscriptions        uint64 `json:"subscriptions"`
+	Invoices             uint64 `json:"invoices"`
+	AtRiskTotal          uint64 `json:"at_risk_total"`
+	FormattedAtRiskTotal string `json:"formatted_at_risk_total"`
 }
 
 // SalesReport lists the sales numbers for a period
 func (a *API) SalesReport(w http.ResponseWriter, r *http.Request) error {
 	instanceID := gcontext.GetInstanceID(r.Context())
+	config := gcontext.GetConfig(r.Context())
 
 	query := a.DB(r).
 		Model(&models.Order{}).
@@ -49,6 +74,8 @@ func (a *API) SalesReport(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return internalServerError("Database error").WithInternalError(err)
 		}
+		row.Exponent = money.Exponent(row.Currency)
+		row.FormattedTotal = money.Format(row.Total, row.Currency, config.Locale)
 		result = append(result, row)
 	}
 
@@ -59,6 +86,7 @@ func (a *API) SalesReport(w http.ResponseWriter, r *http.Request) error {
 func (a *API) ProductsReport(w http.ResponseWriter, r *http.Request) error {
 	db := a.DB(r)
 	instanceID := gcontext.GetInstanceID(r.Context())
+	config := gcontext.GetConfig(r.Context())
 	ordersTable := db.NewScope(models.Order{}).QuotedTableName()
 	itemsTable := db.NewScope(models.LineItem{}).QuotedTableName()
 	query := db.
@@ -92,8 +120,115 @@ func (a *API) ProductsReport(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return internalServerError("Database error").WithInternalError(err)
 		}
+		row.Exponent = money.Exponent(row.Currency)
+		row.FormattedTotal = money.Format(row.Total, row.Currency, config.Locale)
+		result = append(result, row)
+	}
+
+	return sendJSON(w, http.StatusOK, result)
+}
+
+// AbandonedCheckoutReport lists how many orders got checkout reminders within
+// a period and the revenue recovered from the ones paid afterwards
+func (a *API) AbandonedCheckoutReport(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+	config := gcontext.GetConfig(r.Context())
+
+	query := a.DB(r).
+		Model(&models.Order{}).
+		Select("currency, count(*) as reminded, "+
+			"sum(case when payment_state = 'paid' then 1 else 0 end) as recovered, "+
+			"sum(case when payment_state = 'paid' then total else 0 end) as recovered_total").
+		Where("checkout_reminders_sent > 0 AND instance_id = ?", instanceID).
+		Group("currency")
+
+	query, err := parseTimeQueryParams(query, query.NewScope(models.Order{}).QuotedTableName(), r.URL.Query())
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return internalServerError("Database error").WithInternalError(err)
+	}
+	defer rows.Close()
+	result := []*abandonedCheckoutRow{}
+	for rows.Next() {
+		row := &abandonedCheckoutRow{}
+		err = rows.Scan(&row.Currency, &row.Reminded, &row.Recovered, &row.RecoveredTotal)
+		if err != nil {
+			return internalServerError("Database error").WithInternalError(err)
+		}
+		row.Exponent = money.Exponent(row.Currency)
+		row.FormattedRecoveredTotal = money.Format(row.RecoveredTotal, row.Currency, config.Locale)
 		result = append(result, row)
 	}
 
 	return sendJSON(w, http.StatusOK, result)
 }
+
+// AtRiskRevenueReport lists the past due subscriptions and overdue invoices
+// with the revenue of their unpaid orders, which is lost unless dunning
+// succeeds
+func (a *API) AtRiskRevenueReport(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+	config := gcontext.GetConfig(r.Context())
+	ordersTable := db.NewScope(models.Order{}).QuotedTableName()
+	subscriptionsTable := db.NewScope(models.Subscription{}).QuotedTableName()
+	query := db.
+		Model(&models.Subscription{}).
+		Select(ordersTable+".currency, count(*) as subscriptions, sum("+ordersTable+".total) as at_risk_total").
+		Joins("JOIN "+ordersTable+" ON "+ordersTable+".id = "+subscriptionsTable+".past_due_order_id").
+		Where(subscriptionsTable+".status = ? AND "+subscriptionsTable+".instance_id = ?", models.SubscriptionPastDueState, instanceID).
+		Group(ordersTable + ".currency")
+
+	rows, err := query.Rows()
+	if err != nil {
+		return internalServerError("Database error").WithInternalError(err)
+	}
+	defer rows.Close()
+	result := []*atRiskRow{}
+	byCurrency := map[string]*atRiskRow{}
+	for rows.Next() {
+		row := &atRiskRow{}
+		err = rows.Scan(&row.Currency, &row.Subscriptions, &row.AtRiskTotal)
+		if err != nil {
+			return internalServerError("Database error").WithInternalError(err)
+		}
+		byCurrency[row.Currency] = row
+		result = append(result, row)
+	}
+
+	invoiceRows, err := db.
+		Model(&models.Order{}).
+		Select("currency, count(*) as invoices, sum(total) as at_risk_total").
+		Where("instance_id = ? AND payment_state = ? AND payment_processor = ?", instanceID, models.PendingState, models.InvoicePaymentProcessor).
+		Where("payment_due_at < ?", time.Now()).
+		Group("currency").
+		Rows()
+	if err != nil {
+		return internalServerError("Database error").WithInternalError(err)
+	}
+	defer invoiceRows.Close()
+	for invoiceRows.Next() {
+		var currency string
+		var invoices, total uint64
+		if err := invoiceRows.Scan(&currency, &invoices, &total); err != nil {
+			return internalServerError("Database error").WithInternalError(err)
+		}
+		row, ok := byCurrency[currency]
+		if !ok {
+			row = &atRiskRow{Currency: currency}
+			result = append(result, row)
+		}
+		row.Invoices = invoices
+		row.AtRiskTotal += total
+	}
+
+	for _, row := range result {
+		row.Exponent = money.Exponent(row.Currency)
+		row.FormattedAtRiskTotal = money.Format(row.AtRiskTotal, row.Currency, config.Locale)
+	}
+	return sendJSON(w, http.StatusOK, result)
+}
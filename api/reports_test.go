@@ -3,8 +3,12 @@ package api
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/models"
 )
 
 func TestSalesReport(t *testing.T) {
@@ -21,8 +25,22 @@ func TestSalesReport(t *testing.T) {
 		assert.Equal(t, uint64(79), row.SubTotal)
 		assert.Equal(t, uint64(0), row.Taxes)
 		assert.Equal(t, "USD", row.Currency)
+		assert.Equal(t, 2, row.Exponent)
+		assert.Equal(t, "$0.79", row.FormattedTotal)
 		assert.Equal(t, uint64(2), row.Orders)
 	})
+
+	t.Run("Locale", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Locale = "de-DE"
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales", nil, token)
+
+		report := []salesRow{}
+		extractPayload(t, http.StatusOK, recorder, &report)
+		require.Len(t, report, 1)
+		assert.Equal(t, "0,79 $", report[0].FormattedTotal)
+	})
 }
 
 func TestProductsReport(t *testing.T) {
@@ -43,3 +61,70 @@ func TestProductsReport(t *testing.T) {
 	assert.Equal(t, "456-i-rollover-all-things", prod3.Sku)
 	assert.Equal(t, uint64(10), prod3.Total)
 }
+
+func TestAbandonedCheckoutReport(t *testing.T) {
+	test := NewRouteTest(t)
+	test.DB.Model(test.Data.firstOrder).UpdateColumn("checkout_reminders_sent", 1)
+	test.DB.Model(test.Data.secondOrder).UpdateColumns(map[string]interface{}{
+		"checkout_reminders_sent": 2,
+		"payment_state":           models.PendingState,
+	})
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	recorder := test.TestEndpoint(http.MethodGet, "/reports/abandoned", nil, token)
+
+	report := []abandonedCheckoutRow{}
+	extractPayload(t, http.StatusOK, recorder, &report)
+	require.Len(t, report, 1)
+	row := report[0]
+	assert.Equal(t, "USD", row.Currency)
+	assert.Equal(t, uint64(2), row.Reminded)
+	assert.Equal(t, uint64(1), row.Recovered)
+	assert.Equal(t, test.Data.firstOrder.Total, row.RecoveredTotal)
+}
+
+func TestAtRiskRevenueReport(t *testing.T) {
+	test := NewRouteTest(t)
+	now := time.Now()
+
+	test.Data.firstOrder.LineItems[0].Interval = models.MonthInterval
+	pastDue := models.NewSubscriptions(test.Data.firstOrder, "pi_1", now)[0]
+	pastDue.PaymentFailed(test.Data.secondOrder, now, []int{24})
+	require.NoError(t, test.DB.Create(pastDue).Error)
+
+	active := models.NewSubscriptions(test.Data.firstOrder, "pi_2", now)[0]
+	require.NoError(t, test.DB.Create(active).Error)
+
+	for _, invoice := range []struct {
+		currency string
+		total    uint64
+		issuedAt time.Time
+	}{
+		{"USD", 300, now.AddDate(0, 0, -31)},
+		{"EUR", 500, now.AddDate(0, 0, -31)},
+		{"USD", 700, now},
+	} {
+		order := models.NewOrder("", "session", "bruce@wayneindustries.com", invoice.currency)
+		order.Total = invoice.total
+		order.IssueInvoice(invoice.issuedAt, 30)
+		require.NoError(t, test.DB.Create(order).Error)
+	}
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	recorder := test.TestEndpoint(http.MethodGet, "/reports/at-risk", nil, token)
+
+	report := []atRiskRow{}
+	extractPayload(t, http.StatusOK, recorder, &report)
+	require.Len(t, report, 2)
+	row := report[0]
+	assert.Equal(t, test.Data.secondOrder.Currency, row.Currency)
+	assert.Equal(t, uint64(1), row.Subscriptions)
+	assert.Equal(t, uint64(1), row.Invoices)
+	assert.Equal(t, test.Data.secondOrder.Total+300, row.AtRiskTotal)
+
+	row = report[1]
+	assert.Equal(t, "EUR", row.Currency)
+	assert.Equal(t, uint64(0), row.Subscriptions)
+	assert.Equal(t, uint64(1), row.Invoices)
+	assert.Equal(t, uint64(500), row.AtRiskTotal)
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type returnItemParams struct {
+	LineItemID int64  `json:"line_item_id"`
+	Sku        string `json:"sku"`
+	Quantity   uint64 `json:"quantity"`
+}
+
+type returnParams struct {
+	Reason string              `json:"reason"`
+	Items  []*returnItemParams `json:"items"`
+}
+
+type returnTransitionParams struct {
+	Note string `json:"note"`
+}
+
+// ReturnListForOrder lists the return requests of an order.
+func (a *API) ReturnListForOrder(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.DB(r)
+	log := getLogEntry(r)
+
+	order, httpErr := queryForOrder(db, gcontext.GetOrderID(ctx), log)
+	if httpErr != nil {
+		return httpErr
+	}
+	if !hasOrderAccess(ctx, order) {
+		return unauthorizedError("You don't have access to this order")
+	}
+
+	var returns []models.Return
+	if result := db.Preload("Items").Where("order_id = ?", order.ID).Order("created_at desc").Find(&returns); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, returns)
+}
+
+// ReturnCreate opens a return request for line items of a paid order.
+func (a *API) ReturnCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.DB(r)
+	log := getLogEntry(r)
+	config := gcontext.GetConfig(ctx)
+
+	params := &returnParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read params: %v", err)
+	}
+	if len(params.Items) == 0 {
+		return badRequestError("A return must include at least one line item")
+	}
+
+	order := &models.Order{}
+	if result := orderQuery(db).First(order, "id = ?", gcontext.GetOrderID(ctx)); result.Error != nil {
+		if result.RecordNotFound() {
+			return notFoundError("Order not found")
+		}
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	if !hasOrderAccess(ctx, order) {
+		return unauthorizedError("You don't have access to this order")
+	}
+	if order.PaymentState != models.PaidState {
+		return badRequestError("Can't return items of an order that hasn't been paid")
+	}
+
+	returned, err := models.ReturnedQuantities(db, order.ID)
+	if err != nil {
+		return internalServerError("Error during database query").WithInternalError(err)
+	}
+
+	ret := models.NewReturn(order, params.Reason)
+	for _, itemParams := range params.Items {
+		item := findReturnLineItem(order, itemParams)
+		if item == nil {
+			return badRequestError("Line item not found in order: %v", itemParams.Sku)
+		}
+		if itemParams.Quantity == 0 {
+			return badRequestError("Quantity for %v must be greater than 0", item.Sku)
+		}
+		if returned[item.ID]+itemParams.Quantity > item.Quantity {
+			return badRequestError("Can't return more than %d of %v", item.Quantity-returned[item.ID], item.Sku)
+		}
+		returned[item.ID] += itemParams.Quantity
+		ret.AddItem(item, itemParams.Quantity)
+	}
+
+	claims := gcontext.GetClaims(ctx)
+	subject := ""
+	if claims != nil {
+		subject = claims.Subject
+	}
+
+	tx := db.Begin()
+	if result := tx.Create(ret); result.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error creating return").WithInternalError(result.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, subject, order.ID, models.EventReturnRequested, []string{ret.ID})
+	if config.Webhooks.Return != "" {
+		hook, err := models.NewHook("return", config.SiteURL, config.Webhooks.Return, ret.UserID, config.Webhooks.Secret, ret)
+		if err != nil {
+			log.WithError(err).Error("Failed to process webhook")
+		}
+		tx.Save(hook)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error creating return").WithInternalError(err)
+	}
+
+	log.WithField("return_id", ret.ID).Infof("Created return for order %s", order.ID)
+	return sendJSON(w, http.StatusCreated, ret)
+}
+
+// ReturnList lists the return requests of all orders.
+func (a *API) ReturnList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	query := db.Where("instance_id = ?", instanceID)
+	query, err := parseReturnQueryParams(query, r.URL.Query())
+	if err != nil {
+		return badRequestError("Bad parameters in query: %v", err)
+	}
+
+	offset, limit, err := paginate(w, r, query.Model(&models.Return{}))
+	if err != nil {
+		return badRequestError("Bad Pagination Parameters: %v", err)
+	}
+
+	var returns []models.Return
+	if result := query.Preload("Items").Order("created_at desc").Offset(offset).Limit(limit).Find(&returns); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, returns)
+}
+
+// ReturnView shows a single return request.
+func (a *API) ReturnView(w http.ResponseWriter, r *http.Request) error {
+	ret, httpErr := getReturn(a.DB(r), gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "return_id"))
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, ret)
+}
+
+// ReturnApprove approves a return request and refunds the returned items.
+// The refund covers the per unit total of every returned item including
+// taxes and discounts, but never exceeds what is left to refund on the order.
+// What was paid with a gift card is put back on the gift card, once the rest
+// of the order was refunded.
+func (a *API) ReturnApprove(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	log := getLogEntry(r)
+
+	ret, params, httpErr := a.prepareReturnTransition(r, models.ReturnApprovedState)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	order, httpErr := queryForOrder(db, ret.OrderID, log)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	var charge *models.Transaction
+	var refunded uint64
+	for _, t := range order.Transactions {
+		if t.Status != models.PaidState {
+			continue
+		}
+		switch t.Type {
+		case models.ChargeTransactionType, models.FreeTransactionType:
+			if charge == nil {
+				charge = t
+			}
+		case models.RefundTransactionType:
+			refunded += t.Amount
+		}
+	}
+	if charge == nil {
+		return badRequestError("Order has no paid charge to refund")
+	}
+
+	amount := ret.RefundAmount
+	if refunded >= charge.Amount {
+		amount = 0
+	} else if amount > charge.Amount-refunded {
+		amount = charge.Amount - refunded
+	}
+
+	var giftCardAmount uint64
+	if order.GiftCardAmount > 0 && ret.RefundAmount > amount {
+		credited, err := models.ReturnedToGiftCard(db, order.ID)
+		if err != nil {
+			return internalServerError("Error during database query").WithInternalError(err)
+		}
+		if order.GiftCardAmount > credited {
+			giftCardAmount = ret.RefundAmount - amount
+			if giftCardAmount > order.GiftCardAmount-credited {
+				giftCardAmount = order.GiftCardAmount - credited
+			}
+		}
+	}
+
+	// claim the return before refunding it, so concurrent approvals don't
+	// refund it twice
+	previousState := ret.State
+	result := db.Model(ret).
+		Where("state = ?", previousState).
+		UpdateColumn("state", models.ReturnApprovedState)
+	if result.Error != nil {
+		return internalServerError("Error saving return").WithInternalError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return badRequestError("The return was changed in the meantime")
+	}
+	reopen := func() {
+		if result := db.Model(ret).UpdateColumn("state", previousState); result.Error != nil {
+			log.WithError(result.Error).Error("Failed to reopen return")
+		}
+	}
+
+	if amount > 0 {
+		m, httpErr := refundTransaction(r, db, order, charge, amount, charge.Currency)
+		if httpErr != nil {
+			reopen()
+			return httpErr
+		}
+		if m.Status == models.FailedState {
+			// the failed refund is recorded, the return stays open
+			reopen()
+			return internalServerError("Failed to refund return: %s", m.FailureDescription)
+		}
+		ret.RefundTransactionID = m.ID
+	}
+	ret.RefundAmount = amount
+
+	tx := db.Begin()
+	credited, err := models.CreditGiftCard(tx, order, giftCardAmount, gcontext.GetClaims(r.Context()).Subject, "Return "+ret.ID)
+	if err != nil {
+		tx.Rollback()
+		return internalServerError("Error crediting gift card").WithInternalError(err)
+	}
+	ret.GiftCardAmount = credited
+
+	return a.finishReturnTransition(w, r, tx, ret, params, models.ReturnApprovedState, models.EventReturnApproved)
+}
+
+// ReturnReject rejects a return request.
+func (a *API) ReturnReject(w http.ResponseWriter, r *http.Request) error {
+	ret, params, httpErr := a.prepareReturnTransition(r, models.ReturnRejectedState)
+	if httpErr != nil {
+		return httpErr
+	}
+	return a.finishReturnTransition(w, r, a.DB(r).Begin(), ret, params, models.ReturnRejectedState, models.EventReturnRejected)
+}
+
+// ReturnReceive marks the items of an approved return as received.
+func (a *API) ReturnReceive(w http.ResponseWriter, r *http.Request) error {
+	ret, params, httpErr := a.prepareReturnTransition(r, models.ReturnReceivedState)
+	if httpErr != nil {
+		return httpErr
+	}
+	return a.finishReturnTransition(w, r, a.DB(r).Begin(), ret, params, models.ReturnReceivedState, models.EventReturnReceived)
+}
+
+func (a *API) prepareReturnTransition(r *http.Request, state string) (*models.Return, *returnTransitionParams, *HTTPError) {
+	params := &returnTransitionParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil && err != io.EOF {
+		return nil, nil, badRequestError("Could not read params: %v", err)
+	}
+
+	ret, httpErr := getReturn(a.DB(r), gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "return_id"))
+	if httpErr != nil {
+		return nil, nil, httpErr
+	}
+	if !ret.CanTransition(state) {
+		return nil, nil, badRequestError("Can't change return from %v to %v", ret.State, state)
+	}
+	return ret, params, nil
+}
+
+func (a *API) finishReturnTransition(w http.ResponseWriter, r *http.Request, tx *gorm.DB, ret *models.Return, params *returnTransitionParams, state string, eventType models.EventType) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+
+	ret.State = state
+	if params.Note != "" {
+		ret.Note = params.Note
+	}
+	if result := tx.Save(ret); result.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving return").WithInternalError(result.Error)
+	}
+
+	models.LogEvent(tx, r.RemoteAddr, gcontext.GetClaims(ctx).Subject, ret.OrderID, eventType, []string{ret.ID})
+	if config.Webhooks.Return != "" {
+		hook, err := models.NewHook("return", config.SiteURL, config.Webhooks.Return, ret.UserID, config.Webhooks.Secret, ret)
+		if err != nil {
+			log.WithError(err).Error("Failed to process webhook")
+		}
+		tx.Save(hook)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error saving return").WithInternalError(err)
+	}
+
+	log.WithField("return_id", ret.ID).Infof("Return is now %s", state)
+	return sendJSON(w, http.StatusOK, ret)
+}
+
+func findReturnLineItem(order *models.Order, params *returnItemParams) *models.LineItem {
+	for _, item := range order.LineItems {
+		if params.LineItemID != 0 && item.ID == params.LineItemID {
+			return item
+		}
+		if params.LineItemID == 0 && params.Sku != "" && item.Sku == params.Sku {
+			return item
+		}
+	}
+	return nil
+}
+
+func getReturn(db *gorm.DB, instanceID, returnID string) (*models.Return, *HTTPError) {
+	ret := &models.Return{}
+	if result := db.Preload("Items").First(ret, "instance_id = ? AND id = ?", instanceID, returnID); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, notFoundError("Return not found")
+		}
+		return nil, internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return ret, nil
+}
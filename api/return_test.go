@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+func TestReturnCreate(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 1)
+
+		assert.Equal(t, models.ReturnRequestedState, ret.State)
+		assert.Equal(t, test.Data.firstOrder.ID, ret.OrderID)
+		assert.Equal(t, test.Data.testUser.ID, ret.UserID)
+		assert.Equal(t, "too fast", ret.Reason)
+		require.Len(t, ret.Items, 1)
+		assert.Equal(t, test.Data.firstLineItem.ID, ret.Items[0].LineItemID)
+		assert.EqualValues(t, 1, ret.Items[0].Quantity)
+		assert.EqualValues(t, 12, ret.RefundAmount)
+
+		events := []models.Event{}
+		test.DB.Where("order_id = ? AND type = ?", test.Data.firstOrder.ID, models.EventReturnRequested).Find(&events)
+		assert.Len(t, events, 1)
+	})
+	t.Run("TooManyItems", func(t *testing.T) {
+		test := NewRouteTest(t)
+		createTestReturn(t, test, 1)
+
+		w := runReturnCreate(test, test.Data.firstOrder.ID, 2)
+		validateError(t, http.StatusBadRequest, w, "Can't return more than 1")
+	})
+	t.Run("Unpaid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Data.firstOrder.PaymentState = models.PendingState
+		test.DB.Save(test.Data.firstOrder)
+
+		w := runReturnCreate(test, test.Data.firstOrder.ID, 1)
+		validateError(t, http.StatusBadRequest, w, "hasn't been paid")
+	})
+	t.Run("NoAccess", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body, err := json.Marshal(&returnParams{
+			Items: []*returnItemParams{{LineItemID: test.Data.firstLineItem.ID, Quantity: 1}},
+		})
+		require.NoError(t, err)
+		token := testToken("stranger", "stranger@wayneindustries.com")
+		w := test.TestEndpoint(http.MethodPost, "/orders/"+test.Data.firstOrder.ID+"/returns", bytes.NewBuffer(body), token)
+		validateError(t, http.StatusUnauthorized, w)
+	})
+}
+
+func TestReturnTransitions(t *testing.T) {
+	t.Run("Approve", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 2)
+		provider := &memProvider{name: payments.StripeProvider}
+
+		w := runReturnTransition(t, test, provider, ret.ID, "approve")
+		rsp := new(models.Return)
+		extractPayload(t, http.StatusOK, w, rsp)
+
+		assert.Equal(t, models.ReturnApprovedState, rsp.State)
+		assert.EqualValues(t, 24, rsp.RefundAmount)
+		assert.NotEmpty(t, rsp.RefundTransactionID)
+
+		require.Len(t, provider.refundCalls, 1)
+		assert.EqualValues(t, 24, provider.refundCalls[0].amount)
+		assert.Equal(t, test.Data.firstTransaction.ProcessorID, provider.refundCalls[0].id)
+
+		refund := &models.Transaction{ID: rsp.RefundTransactionID}
+		require.NoError(t, test.DB.First(refund).Error)
+		assert.Equal(t, models.RefundTransactionType, refund.Type)
+		assert.Equal(t, models.PaidState, refund.Status)
+		assert.EqualValues(t, 24, refund.Amount)
+
+		w = runReturnTransition(t, test, provider, ret.ID, "receive")
+		extractPayload(t, http.StatusOK, w, rsp)
+		assert.Equal(t, models.ReturnReceivedState, rsp.State)
+	})
+	t.Run("Reject", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 1)
+		provider := &memProvider{name: payments.StripeProvider}
+
+		w := runReturnTransition(t, test, provider, ret.ID, "reject")
+		rsp := new(models.Return)
+		extractPayload(t, http.StatusOK, w, rsp)
+		assert.Equal(t, models.ReturnRejectedState, rsp.State)
+		assert.Empty(t, provider.refundCalls)
+
+		w = runReturnTransition(t, test, provider, ret.ID, "approve")
+		validateError(t, http.StatusBadRequest, w, "Can't change return")
+
+		// the rejected quantity can be returned again
+		createTestReturn(t, test, 2)
+	})
+	t.Run("ApproveGiftCard", func(t *testing.T) {
+		test := NewRouteTest(t)
+		card, err := models.NewGiftCard("", "USD")
+		require.NoError(t, err)
+		card.Balance = 100
+		require.NoError(t, card.Issue(test.DB, "", "", ""))
+
+		// 14 of the 24 were paid with the gift card
+		test.Data.firstOrder.GiftCardCode = card.Code
+		test.Data.firstOrder.GiftCardAmount = 14
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		test.Data.firstTransaction.Amount = 10
+		require.NoError(t, test.DB.Save(test.Data.firstTransaction).Error)
+
+		ret := createTestReturn(t, test, 2)
+		provider := &memProvider{name: payments.StripeProvider}
+		w := runReturnTransition(t, test, provider, ret.ID, "approve")
+		rsp := new(models.Return)
+		extractPayload(t, http.StatusOK, w, rsp)
+		assert.EqualValues(t, 10, rsp.RefundAmount)
+		assert.EqualValues(t, 14, rsp.GiftCardAmount)
+		require.Len(t, provider.refundCalls, 1)
+		assert.EqualValues(t, 10, provider.refundCalls[0].amount)
+
+		credited := &models.GiftCard{}
+		require.NoError(t, test.DB.Preload("Entries").First(credited, "id = ?", card.ID).Error)
+		assert.EqualValues(t, 114, credited.Balance)
+		require.Len(t, credited.Entries, 2)
+		assert.Equal(t, models.GiftCardRefundEntry, credited.Entries[1].Type)
+		assert.Equal(t, test.Data.firstOrder.ID, credited.Entries[1].OrderID)
+	})
+	t.Run("ApproveConcurrently", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 2)
+		provider := &memProvider{name: payments.StripeProvider}
+		provider.onRefund = func() {
+			provider.onRefund = nil
+			w := runReturnTransition(t, test, provider, ret.ID, "approve")
+			validateError(t, http.StatusBadRequest, w, "Can't change return from approved to approved")
+		}
+
+		w := runReturnTransition(t, test, provider, ret.ID, "approve")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, provider.refundCalls, 1)
+	})
+	t.Run("RefundFailed", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 2)
+		provider := &memProvider{name: payments.StripeProvider, refundErr: errors.New("card expired")}
+
+		w := runReturnTransition(t, test, provider, ret.ID, "approve")
+		validateError(t, http.StatusInternalServerError, w)
+
+		saved := &models.Return{}
+		require.NoError(t, test.DB.First(saved, "id = ?", ret.ID).Error)
+		assert.Equal(t, models.ReturnRequestedState, saved.State)
+		refunds := []models.Transaction{}
+		require.NoError(t, test.DB.Where("order_id = ? AND type = ?", ret.OrderID, models.RefundTransactionType).Find(&refunds).Error)
+		require.Len(t, refunds, 1)
+		assert.Equal(t, models.FailedState, refunds[0].Status)
+	})
+	t.Run("NotAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 1)
+
+		w := test.TestEndpoint(http.MethodPost, "/returns/"+ret.ID+"/approve", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, w)
+	})
+	t.Run("OtherInstance", func(t *testing.T) {
+		test := NewRouteTest(t)
+		ret := createTestReturn(t, test, 1)
+		require.NoError(t, test.DB.Model(ret).UpdateColumn("instance_id", "other-instance").Error)
+		provider := &memProvider{name: payments.StripeProvider}
+
+		w := runReturnTransition(t, test, provider, ret.ID, "approve")
+		validateError(t, http.StatusNotFound, w)
+		assert.Empty(t, provider.refundCalls)
+	})
+}
+
+func TestReturnList(t *testing.T) {
+	test := NewRouteTest(t)
+	ret := createTestReturn(t, test, 1)
+
+	token := testAdminToken("magical-unicorn", "")
+	w := test.TestEndpoint(http.MethodGet, "/returns?state=requested", nil, token)
+	returns := []models.Return{}
+	extractPayload(t, http.StatusOK, w, &returns)
+	require.Len(t, returns, 1)
+	assert.Equal(t, ret.ID, returns[0].ID)
+
+	w = test.TestEndpoint(http.MethodGet, "/orders/"+test.Data.firstOrder.ID+"/returns", nil, test.Data.testUserToken)
+	extractPayload(t, http.StatusOK, w, &returns)
+	require.Len(t, returns, 1)
+	assert.Equal(t, ret.ID, returns[0].ID)
+}
+
+func runReturnCreate(test *RouteTest, orderID string, quantity uint64) *httptest.ResponseRecorder {
+	body, err := json.Marshal(&returnParams{
+		Reason: "too fast",
+		Items:  []*returnItemParams{{LineItemID: test.Data.firstLineItem.ID, Quantity: quantity}},
+	})
+	require.NoError(test.T, err)
+	return test.TestEndpoint(http.MethodPost, "/orders/"+orderID+"/returns", bytes.NewBuffer(body), test.Data.testUserToken)
+}
+
+func createTestReturn(t *testing.T, test *RouteTest, quantity uint64) *models.Return {
+	w := runReturnCreate(test, test.Data.firstOrder.ID, quantity)
+	ret := new(models.Return)
+	extractPayload(t, http.StatusCreated, w, ret)
+	return ret
+}
+
+func runReturnTransition(t *testing.T, test *RouteTest, provider payments.Provider, returnID, action string) *httptest.ResponseRecorder {
+	globalConfig := new(conf.GlobalConfiguration)
+	ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, test.Config, "")
+	require.NoError(t, err)
+	ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: provider})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/returns/%s/%s", returnID, action), nil)
+	require.NoError(t, signHTTPRequest(r, testAdminToken("magical-unicorn", ""), test.Config.JWT.Secret))
+
+	NewAPIWithVersion(ctx, test.GlobalConfig, logrus.StandardLogger(), test.DB, defaultVersion).handler.ServeHTTP(w, r)
+	return w
+}
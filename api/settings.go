@@ -5,7 +5,9 @@ import (
 	"net/http"
 
 	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/settings"
 )
 
 func (a *API) ViewSettings(w http.ResponseWriter, r *http.Request) error {
@@ -32,3 +34,27 @@ func (a *API) ViewSettings(w http.ResponseWriter, r *http.Request) error {
 	sendJSON(w, 200, settings)
 	return nil
 }
+
+// SettingsValidation reports the problems found in the site settings, and
+// why they couldn't be fetched if that failed.
+func (a *API) SettingsValidation(w http.ResponseWriter, r *http.Request) error {
+	cache := gcontext.GetSettings(r.Context())
+	if _, err := cache.Load(); err != nil {
+		getLogEntry(r).WithError(err).Warn("Failed to load site settings")
+	}
+	return sendJSON(w, http.StatusOK, cache.Status())
+}
+
+// settingsCache returns the settings cache of an instance, creating it on
+// first use or when the instance's site changed.
+func (a *API) settingsCache(instanceID string, config *conf.Configuration) *settings.Cache {
+	a.cachesLock.Lock()
+	defer a.cachesLock.Unlock()
+
+	cache, ok := a.settingsCaches[instanceID]
+	if !ok || !cache.UsesConfig(config) {
+		cache = settings.NewCache(config)
+		a.settingsCaches[instanceID] = cache
+	}
+	return cache
+}
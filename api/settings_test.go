@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/settings"
+)
+
+func TestSettingsValidation(t *testing.T) {
+	server := startTestSiteWithSettings(map[string]interface{}{
+		"taxes": []map[string]interface{}{
+			{"percentage": 19, "countries": []string{"Germany"}},
+			{"percentage": 7, "countries": []string{"Atlantis"}},
+		},
+	})
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	recorder := test.TestEndpoint(http.MethodGet, "/settings/validation", nil, test.Data.testUserToken)
+	validateError(t, http.StatusUnauthorized, recorder)
+
+	recorder = test.TestEndpoint(http.MethodGet, "/settings/validation", nil, testAdminToken("magical-unicorn", ""))
+	status := &settings.Status{}
+	extractPayload(t, http.StatusOK, recorder, status)
+	assert.Nil(t, status.FetchedAt)
+	assert.Equal(t, []string{"Tax 2 has the unknown country 'Atlantis'"}, status.Errors)
+	assert.Equal(t, "Site settings are invalid: Tax 2 has the unknown country 'Atlantis'", status.FetchError)
+
+	// orders aren't priced with invalid settings
+	recorder = test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+	validateError(t, http.StatusInternalServerError, recorder)
+}
+
+func TestOrderCreateWithBrokenSettings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gocommerce/settings.json" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		handleTestProducts(w, r)
+	}))
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+	validateError(t, http.StatusInternalServerError, recorder)
+}
+
+func TestSettingsCachePerInstance(t *testing.T) {
+	a := &API{settingsCaches: map[string]*settings.Cache{}}
+	config := &conf.Configuration{SiteURL: "https://example.com"}
+
+	first := a.settingsCache("first", config)
+	assert.True(t, first == a.settingsCache("first", config))
+	assert.False(t, first == a.settingsCache("second", config))
+
+	config.SiteURL = "https://example.org"
+	assert.False(t, first == a.settingsCache("first", config))
+}
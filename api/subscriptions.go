@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+// SubscriptionList lists the subscriptions of all customers.
+func (a *API) SubscriptionList(w http.ResponseWriter, r *http.Request) error {
+	db := a.DB(r)
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	query := db.Where("instance_id = ?", instanceID)
+	query, err := parseSubscriptionQueryParams(query, r.URL.Query())
+	if err != nil {
+		return badRequestError("Bad parameters in query: %v", err)
+	}
+
+	offset, limit, err := paginate(w, r, query.Model(&models.Subscription{}))
+	if err != nil {
+		return badRequestError("Bad Pagination Parameters: %v", err)
+	}
+
+	var subscriptions []models.Subscription
+	if result := query.Order("created_at desc").Offset(offset).Limit(limit).Find(&subscriptions); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return sendJSON(w, http.StatusOK, subscriptions)
+}
+
+// SubscriptionView shows a single subscription.
+func (a *API) SubscriptionView(w http.ResponseWriter, r *http.Request) error {
+	subscription, httpErr := getSubscription(a.DB(r), gcontext.GetInstanceID(r.Context()), chi.URLParam(r, "subscription_id"))
+	if httpErr != nil {
+		return httpErr
+	}
+	return sendJSON(w, http.StatusOK, subscription)
+}
+
+// SubscriptionPause stops a subscription from renewing until it is resumed.
+func (a *API) SubscriptionPause(w http.ResponseWriter, r *http.Request) error {
+	return a.transitionSubscription(w, r, models.SubscriptionPausedState, models.EventSubscriptionPaused)
+}
+
+// SubscriptionResume lets a paused subscription renew again. If its period
+// ended while it was paused, it renews right away.
+func (a *API) SubscriptionResume(w http.ResponseWriter, r *http.Request) error {
+	return a.transitionSubscription(w, r, models.SubscriptionActiveState, models.EventSubscriptionResumed)
+}
+
+// SubscriptionCancel ends a subscription. The current period stays paid, but
+// the subscription doesn't renew anymore.
+func (a *API) SubscriptionCancel(w http.ResponseWriter, r *http.Request) error {
+	return a.transitionSubscription(w, r, models.SubscriptionCanceledState, models.EventSubscriptionCanceled)
+}
+
+func (a *API) transitionSubscription(w http.ResponseWriter, r *http.Request, state string, eventType models.EventType) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+
+	tx := a.DB(r).Begin()
+	subscription, httpErr := getSubscription(tx, gcontext.GetInstanceID(ctx), chi.URLParam(r, "subscription_id"))
+	if httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
+	if !subscription.CanTransition(state) {
+		tx.Rollback()
+		return badRequestError("Can't change subscription from %v to %v", subscription.Status, state)
+	}
+
+	subscription.Transition(state, time.Now())
+	if result := tx.Save(subscription); result.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving subscription").WithInternalError(result.Error)
+	}
+
+	models.LogEvent(tx, r.RemoteAddr, gcontext.GetClaims(ctx).Subject, subscription.OrderID, eventType, []string{subscription.ID})
+	saveSubscriptionHook(config, tx, subscription, log)
+	if err := tx.Commit().Error; err != nil {
+		return internalServerError("Error saving subscription").WithInternalError(err)
+	}
+
+	log.WithField("subscription_id", subscription.ID).Infof("Subscription is now %s", state)
+	return sendJSON(w, http.StatusOK, subscription)
+}
+
+// saveSubscriptionHook queues the subscription webhook for a change of the
+// subscription, if one is configured.
+func saveSubscriptionHook(config *conf.Configuration, tx *gorm.DB, subscription *models.Subscription, log logrus.FieldLogger) {
+	if config.Webhooks.Subscription == "" {
+		return
+	}
+	hook, err := models.NewHook("subscription", config.SiteURL, config.Webhooks.Subscription, subscription.UserID, config.Webhooks.Secret, subscription)
+	if err != nil {
+		log.WithError(err).Error("Failed to process webhook")
+	}
+	tx.Save(hook)
+}
+
+// renewPaidSubscription renews the subscription of a past due renewal order
+// that was paid through the API instead of by the dunning retries.
+func renewPaidSubscription(config *conf.Configuration, tx *gorm.DB, order *models.Order, paymentID string, log logrus.FieldLogger) {
+	subscription := &models.Subscription{}
+	if result := tx.First(subscription, "id = ?", order.SubscriptionID); result.Error != nil {
+		log.WithError(result.Error).Error("Failed to load subscription")
+		return
+	}
+	if subscription.PastDueOrderID != order.ID || subscription.Status == models.SubscriptionCanceledState {
+		return
+	}
+	subscription.PaymentProcessor = order.PaymentProcessor
+	subscription.Renewed(order, paymentID)
+	tx.Save(subscription)
+	saveSubscriptionHook(config, tx, subscription, log)
+	log.WithField("subscription_id", subscription.ID).Info("Renewed past due subscription")
+}
+
+func getSubscription(db *gorm.DB, instanceID, subscriptionID string) (*models.Subscription, *HTTPError) {
+	subscription := &models.Subscription{}
+	if result := db.First(subscription, "instance_id = ? AND id = ?", instanceID, subscriptionID); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, notFoundError("Subscription not found")
+		}
+		return nil, internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	return subscription, nil
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+func TestSubscriptionFromPaidOrder(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Webhooks.Subscription = "https://example.com/hooks/subscription"
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [{"path": "/subscription-product", "quantity": 1}, {"path": "/simple-product", "quantity": 1}]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.Len(t, order.LineItems, 2)
+	assert.Equal(t, "monthly", order.LineItems[0].Plan)
+	assert.Equal(t, models.MonthInterval, order.LineItems[0].Interval)
+	assert.False(t, order.LineItems[1].Recurring())
+
+	// renewals are priced with the claims of the buyer
+	saved := &models.Order{}
+	require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, test.Data.testUser.Email, saved.Claims["email"])
+
+	customerID := "customer-for-renewals"
+	customersCreated := 0
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) error {
+		switch path {
+		case "/v1/customers":
+			customersCreated++
+			customerParams := params.(*stripe.CustomerParams)
+			assert.Equal(t, "info@example.com", *customerParams.Email)
+			assert.Equal(t, "payment-method-simple", *customerParams.PaymentMethod)
+			v.(*stripe.Customer).ID = customerID
+		case "/v1/payment_intents":
+			intentParams := params.(*stripe.PaymentIntentParams)
+			require.NotNil(t, intentParams.Customer)
+			assert.Equal(t, customerID, *intentParams.Customer)
+			assert.Equal(t, "off_session", *intentParams.SetupFutureUsage)
+			intent := v.(*stripe.PaymentIntent)
+			intent.ID = stripePaymentIntentID
+			intent.Status = stripe.PaymentIntentStatusSucceeded
+		default:
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+		return nil
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	payment, err := json.Marshal(&stripePaymentParams{
+		Amount:                order.Total,
+		Currency:              order.Currency,
+		StripePaymentMethodID: "payment-method-simple",
+		Provider:              payments.StripeProvider,
+	})
+	require.NoError(t, err)
+	recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", bytes.NewBuffer(payment), test.Data.testUserToken)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	subscriptions := []models.Subscription{}
+	require.NoError(t, test.DB.Where("order_id = ?", order.ID).Find(&subscriptions).Error)
+	require.Len(t, subscriptions, 1)
+	subscription := subscriptions[0]
+	assert.Equal(t, "product-5", subscription.Sku)
+	assert.Equal(t, "monthly", subscription.Plan)
+	assert.Equal(t, models.SubscriptionActiveState, subscription.Status)
+	assert.Equal(t, payments.StripeProvider, subscription.PaymentProcessor)
+	assert.Equal(t, stripePaymentIntentID, subscription.PaymentID)
+	assert.Equal(t, customerID, subscription.CustomerID)
+	assert.Equal(t, subscription.CurrentPeriodStart.AddDate(0, 1, 0), subscription.CurrentPeriodEnd)
+
+	hooks := []models.Hook{}
+	require.NoError(t, test.DB.Where("type = ?", "subscription").Find(&hooks).Error)
+	assert.Len(t, hooks, 1)
+
+	t.Run("PayPastDueRenewal", func(t *testing.T) {
+		paid := &models.Order{}
+		loader := test.DB.Preload("LineItems").Preload("ShippingAddress").Preload("BillingAddress")
+		require.NoError(t, loader.First(paid, "id = ?", order.ID).Error)
+		renewal, err := subscription.NewRenewalOrder(paid)
+		require.NoError(t, err)
+		renewal.Total = paid.Total
+		renewal.PaymentState = models.FailedState
+		require.NoError(t, test.DB.Create(renewal).Error)
+		subscription.PaymentFailed(renewal, time.Now(), []int{24})
+		require.NoError(t, test.DB.Save(&subscription).Error)
+
+		payment, err := json.Marshal(&stripePaymentParams{
+			Amount:                renewal.Total,
+			Currency:              renewal.Currency,
+			StripePaymentMethodID: "payment-method-simple",
+			Provider:              payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/"+renewal.ID+"/payments", bytes.NewBuffer(payment), test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		renewed := &models.Subscription{}
+		require.NoError(t, test.DB.First(renewed, "id = ?", subscription.ID).Error)
+		assert.Equal(t, models.SubscriptionActiveState, renewed.Status)
+		assert.Equal(t, renewal.ID, renewed.LastOrderID)
+		assert.Empty(t, renewed.PastDueOrderID)
+		assert.Zero(t, renewed.FailedAttempts)
+		assert.Nil(t, renewed.NextRetryAt)
+		assert.Equal(t, 1, customersCreated)
+	})
+
+	t.Run("ReuseCustomer", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "/subscription-product", "quantity": 1}]
+		}`), test.Data.testUserToken)
+		second := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, second)
+
+		payment, err := json.Marshal(&stripePaymentParams{
+			Amount:                second.Total,
+			Currency:              second.Currency,
+			StripePaymentMethodID: "payment-method-simple",
+			Provider:              payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+second.ID+"/payments", bytes.NewBuffer(payment), test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, 1, customersCreated)
+
+		started := &models.Subscription{}
+		require.NoError(t, test.DB.First(started, "order_id = ?", second.ID).Error)
+		assert.Equal(t, customerID, started.CustomerID)
+	})
+}
+
+func TestSubscriptionEndpoints(t *testing.T) {
+	test := NewRouteTest(t)
+	token := testAdminToken("magical-unicorn", "")
+
+	test.Data.firstOrder.LineItems[0].Interval = models.WeekInterval
+	test.Data.firstOrder.PaymentProcessor = payments.StripeProvider
+	subscription := models.NewSubscriptions(test.Data.firstOrder, "pi_1", time.Now().AddDate(0, 0, -10))[0]
+	require.NoError(t, test.DB.Create(subscription).Error)
+	url := "/subscriptions/" + subscription.ID
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/subscriptions", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("ListAndView", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodGet, "/subscriptions?status=active", nil, token)
+		subscriptions := []models.Subscription{}
+		extractPayload(t, http.StatusOK, recorder, &subscriptions)
+		require.Len(t, subscriptions, 1)
+		assert.Equal(t, subscription.ID, subscriptions[0].ID)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/subscriptions?status=paused", nil, token)
+		extractPayload(t, http.StatusOK, recorder, &subscriptions)
+		assert.Len(t, subscriptions, 0)
+
+		recorder = test.TestEndpoint(http.MethodGet, url, nil, token)
+		viewed := &models.Subscription{}
+		extractPayload(t, http.StatusOK, recorder, viewed)
+		assert.Equal(t, models.WeekInterval, viewed.Interval)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/subscriptions/missing", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+
+		other := models.NewSubscriptions(test.Data.firstOrder, "pi_2", time.Now())[0]
+		other.InstanceID = "other-instance"
+		require.NoError(t, test.DB.Create(other).Error)
+		recorder = test.TestEndpoint(http.MethodGet, "/subscriptions/"+other.ID, nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+		recorder = test.TestEndpoint(http.MethodPost, "/subscriptions/"+other.ID+"/pause", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+
+	t.Run("PauseResumeCancel", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, url+"/resume", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "Can't change subscription from active to active")
+
+		recorder = test.TestEndpoint(http.MethodPost, url+"/pause", nil, token)
+		paused := &models.Subscription{}
+		extractPayload(t, http.StatusOK, recorder, paused)
+		assert.Equal(t, models.SubscriptionPausedState, paused.Status)
+		assert.NotNil(t, paused.PausedAt)
+
+		// the period ended while paused, so it renews right away
+		recorder = test.TestEndpoint(http.MethodPost, url+"/resume", nil, token)
+		resumed := &models.Subscription{}
+		extractPayload(t, http.StatusOK, recorder, resumed)
+		assert.Equal(t, models.SubscriptionActiveState, resumed.Status)
+		assert.Nil(t, resumed.PausedAt)
+		assert.WithinDuration(t, time.Now(), resumed.CurrentPeriodEnd, time.Minute)
+
+		recorder = test.TestEndpoint(http.MethodPost, url+"/cancel", nil, token)
+		canceled := &models.Subscription{}
+		extractPayload(t, http.StatusOK, recorder, canceled)
+		assert.Equal(t, models.SubscriptionCanceledState, canceled.Status)
+		assert.NotNil(t, canceled.CanceledAt)
+
+		recorder = test.TestEndpoint(http.MethodPost, url+"/resume", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "Can't change subscription from canceled to active")
+
+		events := []models.Event{}
+		require.NoError(t, test.DB.Where("order_id = ?", subscription.OrderID).Find(&events).Error)
+		eventTypes := []string{}
+		for _, event := range events {
+			eventTypes = append(eventTypes, event.Type)
+		}
+		assert.Subset(t, eventTypes, []string{"subscription_paused", "subscription_resumed", "subscription_canceled"})
+	})
+}
@@ -446,6 +446,43 @@ func handleTestProducts(w http.ResponseWriter, r *http.Request) {
 					{"amount": "2.99", "type": "E-Book"}
 				]}
 			]}`))
+	case "/backorder-product":
+		fmt.Fprintln(w, productMetaFrame(`
+			{"sku": "product-2", "title": "Product 2", "type": "Book", "backorder": true, "prices": [
+				{"amount": "9.99", "currency": "USD"}
+			]}`))
+	case "/tiered-product":
+		fmt.Fprintln(w, productMetaFrame(`
+			{"sku": "product-3", "title": "Product 3", "type": "Book", "prices": [
+				{"amount": "10.00", "currency": "USD", "tiers": [
+					{"min_quantity": 10, "amount": "8.00"},
+					{"min_quantity": 50, "amount": "7.00"}
+				]}
+			]}`))
+	case "/pay-what-you-want":
+		fmt.Fprintln(w, productMetaFrame(`
+			{"sku": "product-4", "title": "Product 4", "type": "Book", "prices": [
+				{"amount": "10.00", "currency": "USD", "minimum": "5.00", "items": [
+					{"amount": "7.00", "type": "Book"},
+					{"amount": "3.00", "type": "E-Book"}
+				]}
+			], "addons": [
+				{"sku": "gift-wrap", "title": "Gift Wrap", "prices": [{"amount": "2.00", "currency": "USD"}]}
+			], "downloads": [
+				{"title": "E-Book", "url": "/assets/product-4"}
+			]}`))
+	case "/subscription-product":
+		fmt.Fprintln(w, productMetaFrame(`
+			{"sku": "product-5", "title": "Product 5", "type": "Magazine", "prices": [
+				{"amount": "4.99", "currency": "USD"}
+			], "recurring": {"plan": "monthly", "interval": "month"}}`))
+	case "/gift-card":
+		fmt.Fprintln(w, productMetaFrame(`
+			{"sku": "gift-card-25", "title": "Gift Card", "type": "Gift Card", "prices": [
+				{"amount": "25.00", "currency": "USD"}
+			], "gift_card": true}`))
+	case "/gocommerce/settings.json":
+		fmt.Fprintln(w, `{}`)
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
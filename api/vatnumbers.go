@@ -1,18 +1,25 @@
 package api
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/go-chi/chi"
-	"github.com/mattes/vat"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/vatnumbers"
+	"github.com/sirupsen/logrus"
 )
 
 // VatNumberLookup looks up information on a VAT number
 func (a *API) VatNumberLookup(w http.ResponseWriter, r *http.Request) error {
 	number := chi.URLParam(r, "vat_number")
 
-	response, err := vat.CheckVAT(number)
+	response, err := gcontext.GetVATNumbers(r.Context()).Check(number)
 	if err != nil {
+		if _, ok := err.(*vatnumbers.UnavailableError); ok {
+			return httpError(http.StatusServiceUnavailable, "VAT number validation service is unavailable").WithInternalError(err)
+		}
 		return internalServerError("Failed to lookup VAT Number").WithInternalError(err)
 	}
 
@@ -23,3 +30,43 @@ func (a *API) VatNumberLookup(w http.ResponseWriter, r *http.Request) error {
 		"address": response.Address,
 	})
 }
+
+// verifyVATNumber checks the VAT number of an order. If the number can't be
+// checked right now, the configured policy decides whether the order fails
+// or the number is taken as valid. It returns false for numbers taken as
+// valid that the order should be flagged for.
+func verifyVATNumber(ctx context.Context, number string, log logrus.FieldLogger) (bool, *HTTPError) {
+	config := gcontext.GetConfig(ctx)
+	result, err := gcontext.GetVATNumbers(ctx).Check(number)
+	if err != nil {
+		if _, ok := err.(*vatnumbers.UnavailableError); !ok {
+			return false, internalServerError("Error verifying VAT number").WithInternalError(err)
+		}
+		log.WithError(err).WithField("policy", config.VAT.UnavailablePolicy).Warn("Could not verify VAT number")
+		switch config.VAT.UnavailablePolicy {
+		case vatnumbers.PolicyAccept:
+			return true, nil
+		case vatnumbers.PolicyFlag:
+			return false, nil
+		}
+		return false, internalServerError("Error verifying VAT number").WithInternalError(err)
+	}
+	if !result.Valid {
+		return false, badRequestError("Vat number %v is not valid", number)
+	}
+	return true, nil
+}
+
+// vatNumberCache returns the VAT number cache of an instance, creating it on
+// first use or when the instance's cache TTL changed.
+func (a *API) vatNumberCache(instanceID string, config *conf.Configuration) *vatnumbers.Cache {
+	a.cachesLock.Lock()
+	defer a.cachesLock.Unlock()
+
+	cache, ok := a.vatNumberCaches[instanceID]
+	if !ok || !cache.UsesConfig(config) {
+		cache = vatnumbers.NewCache(config, vatnumbers.VIES{})
+		a.vatNumberCaches[instanceID] = cache
+	}
+	return cache
+}
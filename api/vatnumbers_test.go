@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/vatnumbers"
+)
+
+type testVATChecker struct {
+	unavailable bool
+}
+
+func (c *testVATChecker) Check(number string) (*vatnumbers.Result, error) {
+	if c.unavailable {
+		return nil, &vatnumbers.UnavailableError{Err: errors.New("MS_UNAVAILABLE")}
+	}
+	return &vatnumbers.Result{CountryCode: number[:2], Number: number, Valid: number == "ATU13585627", Name: "Test GmbH"}, nil
+}
+
+func testEndpointWithVATChecker(test *RouteTest, checker vatnumbers.Checker, method, url string, body io.Reader, token *jwt.Token) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(method, baseURL+url, body)
+	if token != nil {
+		require.NoError(test.T, signHTTPRequest(req, token, test.Config.JWT.Secret))
+	}
+
+	globalConfig := new(conf.GlobalConfiguration)
+	ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, test.Config, "")
+	require.NoError(test.T, err)
+	ctx = gcontext.WithVATNumbers(ctx, vatnumbers.NewCache(test.Config, checker))
+	NewAPIWithVersion(ctx, test.GlobalConfig, logrus.StandardLogger(), test.DB, "").handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestVatNumberLookup(t *testing.T) {
+	test := NewRouteTest(t)
+
+	recorder := testEndpointWithVATChecker(test, &testVATChecker{}, http.MethodGet, "/vatnumbers/ATU13585627", nil, nil)
+	result := map[string]interface{}{}
+	extractPayload(t, http.StatusOK, recorder, &result)
+	assert.Equal(t, true, result["valid"])
+	assert.Equal(t, "Test GmbH", result["company"])
+
+	recorder = testEndpointWithVATChecker(test, &testVATChecker{unavailable: true}, http.MethodGet, "/vatnumbers/ATU13585627", nil, nil)
+	validateError(t, http.StatusServiceUnavailable, recorder)
+}
+
+func TestOrderCreateWithVATNumber(t *testing.T) {
+	server := startTestSiteWithSettings(map[string]interface{}{
+		"seller_country": "DE",
+		"taxes": []map[string]interface{}{
+			{"percentage": 7, "product_types": []string{"Book"}, "countries": []string{"Germany"}},
+			{"percentage": 10, "product_types": []string{"Book"}, "countries": []string{"Austria"}},
+		},
+	})
+	defer server.Close()
+
+	payload := func(number string) *strings.Reader {
+		return strings.NewReader(`{
+			"email": "info@example.com",
+			"vatnumber": "` + number + `",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "Stephansplatz 1",
+				"city": "Wien", "country": "Austria", "zip": "1010"
+			},
+			"line_items": [{"path": "/simple-product", "quantity": 1}]
+		}`)
+	}
+
+	t.Run("ReverseCharge", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := testEndpointWithVATChecker(test, &testVATChecker{}, http.MethodPost, "/orders", payload("ATU13585627"), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.True(t, order.ReverseCharge)
+		assert.True(t, order.VATNumberValid)
+		assert.False(t, order.VATNumberUnverified)
+		assert.EqualValues(t, 0, order.Taxes)
+		assert.EqualValues(t, 999, order.Total)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := testEndpointWithVATChecker(test, &testVATChecker{}, http.MethodPost, "/orders", payload("ATU13585628"), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "Vat number ATU13585628 is not valid")
+	})
+
+	policies := map[string]int{
+		vatnumbers.PolicyReject: http.StatusInternalServerError,
+		vatnumbers.PolicyAccept: http.StatusCreated,
+		vatnumbers.PolicyFlag:   http.StatusCreated,
+	}
+	for policy, status := range policies {
+		t.Run("Unavailable/"+policy, func(t *testing.T) {
+			test := NewRouteTest(t)
+			test.Config.SiteURL = server.URL
+			test.Config.VAT.UnavailablePolicy = policy
+
+			recorder := testEndpointWithVATChecker(test, &testVATChecker{unavailable: true}, http.MethodPost, "/orders", payload("ATU13585627"), test.Data.testUserToken)
+			require.Equal(t, status, recorder.Code, recorder.Body.String())
+			if status != http.StatusCreated {
+				return
+			}
+			order := &models.Order{}
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(order))
+			assert.True(t, order.ReverseCharge)
+			assert.Equal(t, policy == vatnumbers.PolicyFlag, order.VATNumberUnverified)
+		})
+	}
+}
+
+func TestOrderUpdateWithVATNumber(t *testing.T) {
+	update := func(test *RouteTest, checker vatnumbers.Checker, number string) *httptest.ResponseRecorder {
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		return testEndpointWithVATChecker(test, checker, http.MethodPut, "/orders/"+test.Data.firstOrder.ID, strings.NewReader(`{"vatnumber": "`+number+`"}`), token)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Data.firstOrder.VATNumberUnverified = true
+		recorder := update(test, &testVATChecker{}, "ATU13585627")
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.Equal(t, "ATU13585627", order.VATNumber)
+		assert.True(t, order.VATNumberValid)
+		assert.False(t, order.VATNumberUnverified)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		recorder := update(test, &testVATChecker{}, "ATU13585628")
+		validateError(t, http.StatusBadRequest, recorder, "Vat number ATU13585628 is not valid")
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", test.Data.firstOrder.ID).Error)
+		assert.Empty(t, saved.VATNumber)
+		assert.False(t, saved.VATNumberValid)
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.VAT.UnavailablePolicy = vatnumbers.PolicyFlag
+		recorder := update(test, &testVATChecker{unavailable: true}, "ATU13585627")
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.True(t, order.VATNumberValid)
+		assert.True(t, order.VATNumberUnverified)
+	})
+}
@@ -0,0 +1,201 @@
+package calculator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/netlify/gocommerce/money"
+)
+
+// Precedences between coupons and automatic promotions on the same item.
+const (
+	// PrecedenceCombine applies both the coupon and the automatic promotion.
+	PrecedenceCombine = "combine"
+	// PrecedenceCoupon applies only the coupon.
+	PrecedenceCoupon = "coupon"
+	// PrecedencePromotion applies only the automatic promotion.
+	PrecedencePromotion = "promotion"
+	// PrecedenceBest applies whichever gives the larger discount.
+	PrecedenceBest = "best"
+)
+
+// AutomaticPromotion is a discount that applies without a coupon code during
+// its sale window, optionally limited to products, countries and currencies.
+// If several automatic promotions apply to an item, only the one with the
+// largest discount is given.
+type AutomaticPromotion struct {
+	ID           string                 `json:"id"`
+	Title        string                 `json:"title,omitempty"`
+	Description  string                 `json:"description,omitempty"`
+	Percentage   uint64                 `json:"percentage,omitempty"`
+	FixedAmount  []*FixedMemberDiscount `json:"fixed,omitempty"`
+	ProductTypes []string               `json:"product_types,omitempty"`
+	Products     []string               `json:"products,omitempty"`
+	Countries    []string               `json:"countries,omitempty"`
+	Currencies   []string               `json:"currencies,omitempty"`
+	StartsAt     *time.Time             `json:"starts_at,omitempty"`
+	EndsAt       *time.Time             `json:"ends_at,omitempty"`
+}
+
+// ActiveAt tells if the time is within the sale window of the promotion.
+func (p *AutomaticPromotion) ActiveAt(at time.Time) bool {
+	if p.StartsAt != nil && at.Before(*p.StartsAt) {
+		return false
+	}
+	if p.EndsAt != nil && !at.Before(*p.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// AvailableIn tells if the promotion is given for orders to the country in
+// the currency. An empty country or currency matches every promotion.
+func (p *AutomaticPromotion) AvailableIn(country, currency string) bool {
+	if country != "" && len(p.Countries) > 0 {
+		found := false
+		for _, c := range p.Countries {
+			if sameCountry(c, country) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if currency != "" && len(p.Currencies) > 0 {
+		found := false
+		for _, c := range p.Currencies {
+			if strings.EqualFold(c, currency) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidForItem tells if the promotion applies to the product type and sku.
+func (p *AutomaticPromotion) ValidForItem(productType, productSku string) bool {
+	if len(p.ProductTypes) == 0 && len(p.Products) == 0 {
+		return true
+	}
+	for _, validType := range p.ProductTypes {
+		if validType == productType {
+			return true
+		}
+	}
+	for _, sku := range p.Products {
+		if sku == productSku {
+			return true
+		}
+	}
+	return false
+}
+
+// FixedDiscount returns what the fixed discount amount is for a particular currency.
+func (p *AutomaticPromotion) FixedDiscount(currency string) uint64 {
+	for _, discount := range p.FixedAmount {
+		if discount.Currency == currency {
+			amount, _ := money.Parse(discount.Amount, currency)
+			return amount
+		}
+	}
+	return 0
+}
+
+// ActivePromotions returns the automatic promotions active at the time, for
+// orders to the country in the currency.
+func (s *Settings) ActivePromotions(at time.Time, country, currency string) []*AutomaticPromotion {
+	active := []*AutomaticPromotion{}
+	if s == nil {
+		return active
+	}
+	for _, promotion := range s.AutomaticPromotions {
+		if promotion != nil && promotion.ActiveAt(at) && promotion.AvailableIn(country, currency) {
+			active = append(active, promotion)
+		}
+	}
+	return active
+}
+
+// couponPrecedence returns the precedence between coupons and automatic
+// promotions, combining them by default.
+func (s *Settings) couponPrecedence() string {
+	if s == nil || s.CouponPrecedence == "" {
+		return PrecedenceCombine
+	}
+	return s.CouponPrecedence
+}
+
+// automaticDiscount returns the discount item of the automatic promotion with
+// the largest discount on the item, or nil if none applies.
+func (s *Settings) automaticDiscount(params PriceParameters, item Item, price, multiplier uint64) (*DiscountItem, uint64) {
+	var best *DiscountItem
+	var bestAmount uint64
+	for _, promotion := range s.ActivePromotions(params.time(), params.Country, params.Currency) {
+		if !promotion.ValidForItem(item.ProductType(), item.ProductSku()) {
+			continue
+		}
+		discountItem := &DiscountItem{
+			Type:       DiscountTypePromotion,
+			Percentage: promotion.Percentage,
+			Fixed:      promotion.FixedDiscount(params.Currency) * multiplier,
+			Promotion:  promotion.ID,
+		}
+		amount := calculateDiscount(price, discountItem.Percentage, discountItem.Fixed)
+		if amount > 0 && (best == nil || amount > bestAmount) {
+			best, bestAmount = discountItem, amount
+		}
+	}
+	return best, bestAmount
+}
+
+func validPrecedence(precedence string) bool {
+	switch precedence {
+	case "", PrecedenceCombine, PrecedenceCoupon, PrecedencePromotion, PrecedenceBest:
+		return true
+	}
+	return false
+}
+
+func (p *AutomaticPromotion) validate(name string) []error {
+	errs := []error{}
+	if p.ID == "" {
+		errs = append(errs, fmt.Errorf("%s has no id", name))
+	}
+	if p.Percentage > 100 {
+		errs = append(errs, fmt.Errorf("%s has a percentage above 100", name))
+	}
+	if p.Percentage == 0 && len(p.FixedAmount) == 0 {
+		errs = append(errs, fmt.Errorf("%s has neither a percentage nor a fixed amount", name))
+	}
+	for fixedIndex, fixed := range p.FixedAmount {
+		fixedName := fmt.Sprintf("%s fixed amount %d", name, fixedIndex+1)
+		if fixed == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", fixedName))
+			continue
+		}
+		if _, err := money.Parse(fixed.Amount, fixed.Currency); err != nil || fixed.Currency == "" {
+			errs = append(errs, fmt.Errorf("%s has an invalid amount '%s'", fixedName, fixed.Amount))
+		}
+	}
+	for _, country := range p.Countries {
+		if _, ok := CountryCode(country); !ok {
+			errs = append(errs, fmt.Errorf("%s has the unknown country '%s'", name, country))
+		}
+	}
+	for _, currency := range p.Currencies {
+		if !money.KnownCurrency(strings.ToUpper(currency)) {
+			errs = append(errs, fmt.Errorf("%s has the unknown currency '%s'", name, currency))
+		}
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.StartsAt.Before(*p.EndsAt) {
+		errs = append(errs, fmt.Errorf("%s ends before it starts", name))
+	}
+	return errs
+}
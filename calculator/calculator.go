@@ -2,9 +2,11 @@ package calculator
 
 import (
 	"math"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/netlify/gocommerce/claims"
+	"github.com/netlify/gocommerce/money"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,6 +15,7 @@ type DiscountItem struct {
 	Type       DiscountType `json:"type"`
 	Percentage uint64       `json:"percentage"`
 	Fixed      uint64       `json:"fixed"`
+	Promotion  string       `json:"promotion,omitempty"`
 }
 
 // Price represents the total price of all line items.
@@ -24,6 +27,15 @@ type Price struct {
 	NetTotal uint64
 	Taxes    uint64
 	Total    int64
+
+	// TaxItems break the taxes of all items down by rate, for invoices.
+	TaxItems []TaxItem
+	// PricesIncludeTaxes tells if the item prices included the taxes.
+	PricesIncludeTaxes bool
+
+	// ReverseCharge is set when no VAT is charged because the buyer is a
+	// business in another EU country, which accounts for the VAT itself.
+	ReverseCharge bool
 }
 
 // ItemPrice is the price of a single line item.
@@ -37,6 +49,17 @@ type ItemPrice struct {
 	Total    int64
 
 	DiscountItems []DiscountItem
+	TaxItems      []TaxItem
+}
+
+// TaxItem is a tax applied to a line item, like the state and county sales
+// taxes of the shipping address, as listed on invoices.
+type TaxItem struct {
+	Name       string  `json:"name,omitempty"`
+	Percentage float64 `json:"percentage"`
+	Compound   bool    `json:"compound,omitempty"`
+	Taxable    uint64  `json:"taxable"`
+	Amount     uint64  `json:"amount"`
 }
 
 // PaymentMethods settings
@@ -55,21 +78,42 @@ type PaymentMethods struct {
 // Settings represent the site-wide settings for price calculation.
 type Settings struct {
 	PricesIncludeTaxes bool              `json:"prices_include_taxes"`
+	SellerCountry      string            `json:"seller_country,omitempty"`
 	Taxes              []*Tax            `json:"taxes,omitempty"`
 	MemberDiscounts    []*MemberDiscount `json:"member_discounts,omitempty"`
-	PaymentMethods     *PaymentMethods   `json:"payment_methods,omitempty"`
+	VolumeDiscounts    []*VolumeDiscount `json:"volume_discounts,omitempty"`
+	Promotions         []*Promotion      `json:"promotions,omitempty"`
+	// AutomaticPromotions apply without a coupon, CouponPrecedence decides
+	// how they combine with coupons.
+	AutomaticPromotions []*AutomaticPromotion `json:"automatic_promotions,omitempty"`
+	CouponPrecedence    string                `json:"coupon_precedence,omitempty"`
+	PaymentMethods      *PaymentMethods       `json:"payment_methods,omitempty"`
 }
 
-// Tax represents a tax, potentially specific to countries and product types.
+// Tax represents a tax, potentially specific to countries, regions, postal
+// codes and product types. Only the first tax that applies to an item is
+// charged, unless later taxes are stacked on top of it. Compound taxes are
+// charged on the price including the taxes before them.
 type Tax struct {
-	Percentage   uint64   `json:"percentage"`
+	Name         string   `json:"name,omitempty"`
+	Percentage   float64  `json:"percentage"`
 	ProductTypes []string `json:"product_types"`
 	Countries    []string `json:"countries"`
+	Regions      []string `json:"regions,omitempty"`
+	PostalCodes  []string `json:"postal_codes,omitempty"`
+	Stack        bool     `json:"stack,omitempty"`
+	Compound     bool     `json:"compound,omitempty"`
 }
 
 type taxAmount struct {
-	price      uint64
-	percentage uint64
+	price uint64
+	rates []taxRate
+}
+
+type taxRate struct {
+	name       string
+	percentage float64
+	compound   bool
 }
 
 // FixedMemberDiscount represents a fixed discount given to members.
@@ -88,12 +132,35 @@ type MemberDiscount struct {
 	Products     []string               `json:"products"`
 }
 
+// VolumeDiscount represents a percentage discount on the items of an order
+// once it has at least the minimum quantity of them, optionally counting
+// only some product types.
+type VolumeDiscount struct {
+	MinQuantity  uint64   `json:"min_quantity"`
+	Percentage   uint64   `json:"percentage"`
+	ProductTypes []string `json:"product_types"`
+}
+
 // PriceParameters represents the order information to calculate prices.
+// ValidVATNumber tells if the buyer is a business with a validated VAT number.
 type PriceParameters struct {
-	Country  string
-	Currency string
-	Coupon   Coupon
-	Items    []Item
+	Country        string
+	Region         string
+	PostalCode     string
+	Currency       string
+	Coupon         Coupon
+	Items          []Item
+	ValidVATNumber bool
+	// Time is when the price is calculated, for the sale windows of
+	// automatic promotions. It defaults to now.
+	Time time.Time
+}
+
+func (p PriceParameters) time() time.Time {
+	if p.Time.IsZero() {
+		return time.Now()
+	}
+	return p.Time
 }
 
 // ValidForType returns whether a member discount is valid for a product type.
@@ -109,6 +176,19 @@ func (d *MemberDiscount) ValidForType(productType string) bool {
 	return false
 }
 
+// ValidForType returns whether a volume discount is valid for a product type.
+func (d *VolumeDiscount) ValidForType(productType string) bool {
+	if len(d.ProductTypes) == 0 {
+		return true
+	}
+	for _, validType := range d.ProductTypes {
+		if validType == productType {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidForProduct returns whether a member discount is valid for a product sku
 func (d *MemberDiscount) ValidForProduct(productSku string) bool {
 	if d.Products == nil || len(d.Products) == 0 {
@@ -130,6 +210,8 @@ type Item interface {
 	FixedVAT() uint64
 	TaxableItems() []Item
 	GetQuantity() uint64
+	// TierDiscount is the discount per unit from a quantity tier of the price.
+	TierDiscount() uint64
 }
 
 // Coupon is the interface for a coupon needed to do price calculation.
@@ -146,8 +228,8 @@ func (d *MemberDiscount) FixedDiscount(currency string) uint64 {
 	if d.FixedAmount != nil {
 		for _, discount := range d.FixedAmount {
 			if discount.Currency == currency {
-				amount, _ := strconv.ParseFloat(discount.Amount, 64)
-				return rint(amount * 100)
+				amount, _ := money.Parse(discount.Amount, currency)
+				return amount
 			}
 		}
 	}
@@ -155,8 +237,15 @@ func (d *MemberDiscount) FixedDiscount(currency string) uint64 {
 	return 0
 }
 
-// AppliesTo determines if the tax applies to the country AND product type provided.
+// AppliesTo determines if the tax applies to the country AND product type
+// provided. Taxes limited to regions or postal codes never apply.
 func (t *Tax) AppliesTo(country, productType string) bool {
+	return t.AppliesToAddress(country, "", "", productType)
+}
+
+// AppliesToAddress determines if the tax applies to the country, region AND
+// postal code of an address, and to the product type provided.
+func (t *Tax) AppliesToAddress(country, region, postalCode, productType string) bool {
 	applies := true
 	if t.ProductTypes != nil && len(t.ProductTypes) > 0 {
 		applies = false
@@ -173,7 +262,31 @@ func (t *Tax) AppliesTo(country, productType string) bool {
 	if t.Countries != nil && len(t.Countries) > 0 {
 		applies = false
 		for _, c := range t.Countries {
-			if c == country {
+			if sameCountry(c, country) {
+				applies = true
+				break
+			}
+		}
+	}
+	if !applies {
+		return false
+	}
+	if len(t.Regions) > 0 {
+		applies = false
+		for _, r := range t.Regions {
+			if strings.EqualFold(strings.TrimSpace(r), strings.TrimSpace(region)) {
+				applies = true
+				break
+			}
+		}
+	}
+	if !applies {
+		return false
+	}
+	if len(t.PostalCodes) > 0 {
+		applies = false
+		for _, pattern := range t.PostalCodes {
+			if matchPostalCode(pattern, postalCode) {
 				applies = true
 				break
 			}
@@ -182,22 +295,103 @@ func (t *Tax) AppliesTo(country, productType string) bool {
 	return applies
 }
 
-func calculateAmountsForSingleItem(settings *Settings, lineLogger logrus.FieldLogger, jwtClaims map[string]interface{}, params PriceParameters, item Item, multiplier uint64) ItemPrice {
+// ReverseCharge tells if the VAT of an order is reverse charged, as for
+// businesses with a valid VAT number buying from a seller in another EU
+// country. Without a seller country, VAT is always charged.
+func (s *Settings) ReverseCharge(params PriceParameters) bool {
+	if s == nil || !params.ValidVATNumber || s.SellerCountry == "" {
+		return false
+	}
+	return inEU(s.SellerCountry) && inEU(params.Country) && !sameCountry(s.SellerCountry, params.Country)
+}
+
+// taxRates returns the rates of the first tax that applies to the product
+// type, followed by those of the taxes stacked on top of it.
+func (s *Settings) taxRates(params PriceParameters, productType string) []taxRate {
+	rates := []taxRate{}
+	charged := false
+	for _, t := range s.Taxes {
+		if t == nil || (charged && !t.Stack) {
+			continue
+		}
+		if t.AppliesToAddress(params.Country, params.Region, params.PostalCode, productType) {
+			rates = append(rates, taxRate{name: t.Name, percentage: t.Percentage, compound: t.Compound})
+			charged = charged || !t.Stack
+		}
+	}
+	return rates
+}
+
+func calculateAmountsForSingleItem(settings *Settings, lineLogger logrus.FieldLogger, jwtClaims map[string]interface{}, params PriceParameters, item Item, multiplier uint64, volume *VolumeDiscount, promotions []promotionDiscount) ItemPrice {
 	itemPrice := ItemPrice{Quantity: item.GetQuantity()}
 
 	singlePrice := item.PriceInLowestUnit() * multiplier
-	_, itemPrice.Subtotal = calculateTaxes(singlePrice, item, params, settings)
+	_, itemPrice.Subtotal, _ = calculateTaxes(singlePrice, item, params, settings)
+
+	// the saving of a quantity tier is shown as a discount on the list price
+	if tier := item.TierDiscount(); tier > 0 {
+		discountItem := DiscountItem{
+			Type:  DiscountTypeTier,
+			Fixed: tier * multiplier,
+		}
+		itemPrice.Discount += calculateDiscount(singlePrice, 0, discountItem.Fixed)
+		itemPrice.DiscountItems = append(itemPrice.DiscountItems, discountItem)
+	}
+	if volume != nil {
+		lineLogger = lineLogger.WithField("volume_discount", volume.MinQuantity)
+		discountItem := DiscountItem{
+			Type:       DiscountTypeVolume,
+			Percentage: volume.Percentage,
+		}
+		itemPrice.Discount += calculateDiscount(singlePrice, discountItem.Percentage, 0)
+		itemPrice.DiscountItems = append(itemPrice.DiscountItems, discountItem)
+	}
+	// promotion discounts are for all units, a single unit gets its share
+	for _, promotion := range promotions {
+		discountItem := DiscountItem{
+			Type:      DiscountTypePromotion,
+			Fixed:     promotion.amount * multiplier / item.GetQuantity(),
+			Promotion: promotion.promotion,
+		}
+		itemPrice.Discount += calculateDiscount(singlePrice, 0, discountItem.Fixed)
+		itemPrice.DiscountItems = append(itemPrice.DiscountItems, discountItem)
+	}
 
 	// apply discount to original price
+	var couponItem *DiscountItem
+	var couponAmount uint64
 	coupon := params.Coupon
 	if coupon != nil && coupon.ValidForType(item.ProductType()) && coupon.ValidForProduct(item.ProductSku()) {
-		discountItem := DiscountItem{
+		couponItem = &DiscountItem{
 			Type:       DiscountTypeCoupon,
 			Percentage: coupon.PercentageDiscount(),
 			Fixed:      coupon.FixedDiscount(params.Currency) * multiplier,
 		}
-		itemPrice.Discount = calculateDiscount(singlePrice, discountItem.Percentage, discountItem.Fixed)
-		itemPrice.DiscountItems = append(itemPrice.DiscountItems, discountItem)
+		couponAmount = calculateDiscount(singlePrice, couponItem.Percentage, couponItem.Fixed)
+	}
+	automaticItem, automaticAmount := settings.automaticDiscount(params, item, singlePrice, multiplier)
+	if couponItem != nil && automaticItem != nil {
+		switch settings.couponPrecedence() {
+		case PrecedenceCoupon:
+			automaticItem = nil
+		case PrecedencePromotion:
+			couponItem = nil
+		case PrecedenceBest:
+			if automaticAmount > couponAmount {
+				couponItem = nil
+			} else {
+				automaticItem = nil
+			}
+		}
+	}
+	if automaticItem != nil {
+		lineLogger = lineLogger.WithField("automatic_promotion", automaticItem.Promotion)
+		itemPrice.Discount += automaticAmount
+		itemPrice.DiscountItems = append(itemPrice.DiscountItems, *automaticItem)
+	}
+	if couponItem != nil {
+		itemPrice.Discount += couponAmount
+		itemPrice.DiscountItems = append(itemPrice.DiscountItems, *couponItem)
 	}
 	if settings != nil && settings.MemberDiscounts != nil {
 		for _, discount := range settings.MemberDiscounts {
@@ -220,7 +414,12 @@ func calculateAmountsForSingleItem(settings *Settings, lineLogger logrus.FieldLo
 		discountedPrice = singlePrice - itemPrice.Discount
 	}
 
-	itemPrice.Taxes, itemPrice.NetTotal = calculateTaxes(discountedPrice, item, params, settings)
+	itemPrice.Taxes, itemPrice.NetTotal, itemPrice.TaxItems = calculateTaxes(discountedPrice, item, params, settings)
+	if settings.ReverseCharge(params) {
+		// the net price is charged, the buyer pays the VAT in its own country
+		itemPrice.Taxes = 0
+		itemPrice.TaxItems = nil
+	}
 	itemPrice.Total = int64(itemPrice.NetTotal + itemPrice.Taxes)
 
 	return itemPrice
@@ -229,7 +428,10 @@ func calculateAmountsForSingleItem(settings *Settings, lineLogger logrus.FieldLo
 // CalculatePrice will calculate the final total price. It takes into account
 // currency, country, coupons, and discounts.
 func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params PriceParameters, log logrus.FieldLogger) Price {
-	price := Price{}
+	price := Price{
+		PricesIncludeTaxes: settings != nil && settings.PricesIncludeTaxes,
+		ReverseCharge:      settings.ReverseCharge(params),
+	}
 
 	priceLogger := log.WithField("action", "calculate_price")
 	if am, ok := jwtClaims["app_metadata"]; ok {
@@ -240,13 +442,15 @@ func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params
 		}
 	}
 
-	for _, item := range params.Items {
+	promotions := settings.promotionDiscounts(params.Items)
+	for i, item := range params.Items {
 		lineLogger := priceLogger.WithFields(logrus.Fields{
 			"product_type": item.ProductType(),
 			"product_sku":  item.ProductSku(),
 		})
 
-		itemPrice := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, 1)
+		volume := settings.volumeDiscount(params.Items, item)
+		itemPrice := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, 1, volume, promotions[i])
 
 		lineLogger.WithFields(
 			logrus.Fields{
@@ -260,12 +464,15 @@ func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params
 		price.Items = append(price.Items, itemPrice)
 
 		// avoid issues with rounding when multiplying by quantity before taxation
-		itemPriceMultiple := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, item.GetQuantity())
+		itemPriceMultiple := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, item.GetQuantity(), volume, promotions[i])
 		price.Subtotal += itemPriceMultiple.Subtotal
 		price.Discount += itemPriceMultiple.Discount
 		price.NetTotal += itemPriceMultiple.NetTotal
 		price.Taxes += itemPriceMultiple.Taxes
 		price.Total += itemPriceMultiple.Total
+		for _, tax := range itemPriceMultiple.TaxItems {
+			price.TaxItems = addTaxItem(price.TaxItems, tax)
+		}
 	}
 
 	price.Total = int64(price.NetTotal + price.Taxes)
@@ -280,6 +487,30 @@ func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params
 	return price
 }
 
+// volumeDiscount returns the volume discount with the highest percentage that
+// the order qualifies for and that applies to the item.
+func (s *Settings) volumeDiscount(items []Item, item Item) *VolumeDiscount {
+	if s == nil {
+		return nil
+	}
+	var best *VolumeDiscount
+	for _, discount := range s.VolumeDiscounts {
+		if discount == nil || !discount.ValidForType(item.ProductType()) {
+			continue
+		}
+		var quantity uint64
+		for _, other := range items {
+			if discount.ValidForType(other.ProductType()) {
+				quantity += other.GetQuantity()
+			}
+		}
+		if quantity >= discount.MinQuantity && (best == nil || discount.Percentage > best.Percentage) {
+			best = discount
+		}
+	}
+	return best
+}
+
 func calculateDiscount(amountToDiscount, percentage, fixed uint64) uint64 {
 	var discount uint64
 	if percentage > 0 {
@@ -293,33 +524,23 @@ func calculateDiscount(amountToDiscount, percentage, fixed uint64) uint64 {
 	return discount
 }
 
-func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, settings *Settings) (taxes uint64, subtotal uint64) {
+func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, settings *Settings) (taxes uint64, subtotal uint64, taxItems []TaxItem) {
 	includeTaxes := settings != nil && settings.PricesIncludeTaxes
 	originalPrice := item.PriceInLowestUnit()
 
 	taxAmounts := []taxAmount{}
 	if item.FixedVAT() != 0 {
-		taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, percentage: item.FixedVAT()})
+		taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, rates: []taxRate{{percentage: float64(item.FixedVAT())}}})
 	} else if settings != nil && item.TaxableItems() != nil && len(item.TaxableItems()) > 0 {
 		for _, item := range item.TaxableItems() {
 			// because a discount may have been applied we need to determine the real price of this sub-item
 			priceShare := float64(item.PriceInLowestUnit()) / float64(originalPrice)
 			itemPrice := rint(float64(amountToTax) * priceShare)
-			amount := taxAmount{price: itemPrice}
-			for _, t := range settings.Taxes {
-				if t.AppliesTo(params.Country, item.ProductType()) {
-					amount.percentage = t.Percentage
-					break
-				}
-			}
-			taxAmounts = append(taxAmounts, amount)
+			taxAmounts = append(taxAmounts, taxAmount{price: itemPrice, rates: settings.taxRates(params, item.ProductType())})
 		}
 	} else if settings != nil {
-		for _, t := range settings.Taxes {
-			if t.AppliesTo(params.Country, item.ProductType()) {
-				taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, percentage: t.Percentage})
-				break
-			}
+		if rates := settings.taxRates(params, item.ProductType()); len(rates) > 0 {
+			taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, rates: rates})
 		}
 	}
 
@@ -331,19 +552,87 @@ func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, setti
 
 	subtotal = 0
 	for _, tax := range taxAmounts {
+		var amounts []uint64
 		if includeTaxes {
-			taxAmount := rint(float64(tax.price) / float64(100+tax.percentage) * 100 * (float64(tax.percentage) / 100))
-			tax.price -= taxAmount
-			taxes += taxAmount
+			amounts = includedTaxes(tax)
+			for _, amount := range amounts {
+				tax.price -= amount
+			}
 		} else {
-			taxes += rint(float64(tax.price) * float64(tax.percentage) / 100)
+			amounts = addedTaxes(tax)
+		}
+
+		var charged uint64
+		for i, rate := range tax.rates {
+			taxable := tax.price
+			if rate.compound {
+				taxable += charged
+			}
+			taxItems = addTaxItem(taxItems, TaxItem{
+				Name:       rate.name,
+				Percentage: rate.percentage,
+				Compound:   rate.compound,
+				Taxable:    taxable,
+				Amount:     amounts[i],
+			})
+			charged += amounts[i]
 		}
+		taxes += charged
 		subtotal += tax.price
 	}
 
 	return
 }
 
+// addedTaxes calculates the taxes to add to a price without taxes.
+func addedTaxes(tax taxAmount) []uint64 {
+	amounts := make([]uint64, len(tax.rates))
+	var charged uint64
+	for i, rate := range tax.rates {
+		taxable := tax.price
+		if rate.compound {
+			taxable += charged
+		}
+		amounts[i] = rint(float64(taxable) * rate.percentage / 100)
+		charged += amounts[i]
+	}
+	return amounts
+}
+
+// includedTaxes calculates the taxes contained in a price including taxes.
+// The rates of compound taxes are raised by the rates before them, so the
+// taxes can be split from the price all at once.
+func includedTaxes(tax taxAmount) []uint64 {
+	effective := make([]float64, len(tax.rates))
+	var total float64
+	for i, rate := range tax.rates {
+		effective[i] = rate.percentage
+		if rate.compound {
+			effective[i] += rate.percentage * total / 100
+		}
+		total += effective[i]
+	}
+
+	amounts := make([]uint64, len(tax.rates))
+	for i := range tax.rates {
+		amounts[i] = rint(float64(tax.price) / (100 + total) * 100 * (effective[i] / 100))
+	}
+	return amounts
+}
+
+// addTaxItem merges the tax into the tax item for the same rate, so the taxes
+// of the parts of a bundle, or of all items, are listed once per rate.
+func addTaxItem(items []TaxItem, tax TaxItem) []TaxItem {
+	for i, item := range items {
+		if item.Name == tax.Name && item.Percentage == tax.Percentage && item.Compound == tax.Compound {
+			items[i].Taxable += tax.Taxable
+			items[i].Amount += tax.Amount
+			return items
+		}
+	}
+	return append(items, tax)
+}
+
 // Nopes - no `round` method in go
 // See https://github.com/golang/go/blob/master/src/math/floor.go#L58
 
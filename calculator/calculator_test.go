@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -20,6 +21,7 @@ type TestItem struct {
 	vat      uint64
 	items    []Item
 	quantity uint64
+	tier     uint64
 }
 
 func (t *TestItem) ProductSku() string {
@@ -49,6 +51,10 @@ func (t *TestItem) GetQuantity() uint64 {
 	return 1
 }
 
+func (t *TestItem) TierDiscount() uint64 {
+	return t.tier
+}
+
 type TestCoupon struct {
 	itemSku    string
 	itemType   string
@@ -88,7 +94,7 @@ func validatePrice(t *testing.T, actual Price, expected Price) {
 }
 
 func TestNoItems(t *testing.T) {
-	params := PriceParameters{"USA", "USD", nil, nil}
+	params := PriceParameters{Country: "USA", Currency: "USD"}
 	price := CalculatePrice(nil, nil, params, testLogger)
 	validatePrice(t, price, Price{
 		Subtotal: 0,
@@ -100,7 +106,7 @@ func TestNoItems(t *testing.T) {
 }
 
 func TestNoTaxes(t *testing.T) {
-	params := PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test"}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test"}}}
 	price := CalculatePrice(nil, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -113,7 +119,7 @@ func TestNoTaxes(t *testing.T) {
 }
 
 func TestFixedVAT(t *testing.T) {
-	params := PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price := CalculatePrice(nil, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -126,7 +132,7 @@ func TestFixedVAT(t *testing.T) {
 }
 
 func TestFixedVATWhenPricesIncludeTaxes(t *testing.T) {
-	params := PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price := CalculatePrice(&Settings{PricesIncludeTaxes: true}, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -147,7 +153,7 @@ func TestCountryBasedVAT(t *testing.T) {
 		}},
 	}
 
-	params := PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test"}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test"}}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -161,7 +167,7 @@ func TestCountryBasedVAT(t *testing.T) {
 
 func TestCouponWithNoTaxes(t *testing.T) {
 	coupon := &TestCoupon{itemType: "test", percentage: 10}
-	params := PriceParameters{"USA", "USD", coupon, []Item{&TestItem{price: 100, itemType: "test"}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Coupon: coupon, Items: []Item{&TestItem{price: 100, itemType: "test"}}}
 	price := CalculatePrice(nil, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -175,7 +181,7 @@ func TestCouponWithNoTaxes(t *testing.T) {
 
 func TestCouponWithVAT(t *testing.T) {
 	coupon := &TestCoupon{itemType: "test", percentage: 10}
-	params := PriceParameters{"USA", "USD", coupon, []Item{&TestItem{price: 100, itemType: "test", vat: 10}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Coupon: coupon, Items: []Item{&TestItem{price: 100, itemType: "test", vat: 10}}}
 	price := CalculatePrice(nil, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -190,7 +196,7 @@ func TestCouponWithVAT(t *testing.T) {
 func TestCouponWithVATWhenPRiceIncludeTaxes(t *testing.T) {
 	coupon := &TestCoupon{itemType: "test", percentage: 10}
 	settings := &Settings{PricesIncludeTaxes: true}
-	params := PriceParameters{"USA", "USD", coupon, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Coupon: coupon, Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -205,7 +211,7 @@ func TestCouponWithVATWhenPRiceIncludeTaxes(t *testing.T) {
 func TestCouponWithVATWhenPRiceIncludeTaxesWithQuantity(t *testing.T) {
 	coupon := &TestCoupon{itemType: "test", percentage: 10}
 	settings := &Settings{PricesIncludeTaxes: true}
-	params := PriceParameters{"USA", "USD", coupon, []Item{&TestItem{quantity: 2, price: 100, itemType: "test", vat: 9}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Coupon: coupon, Items: []Item{&TestItem{quantity: 2, price: 100, itemType: "test", vat: 9}}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -238,7 +244,7 @@ func TestPricingItems(t *testing.T) {
 			itemType: "ebook",
 		}},
 	}
-	params := PriceParameters{"DE", "USD", nil, []Item{item}}
+	params := PriceParameters{Country: "DE", Currency: "USD", Items: []Item{item}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -255,7 +261,7 @@ func TestMemberDiscounts(t *testing.T) {
 		Claims:     map[string]string{"app_metadata.plan": "member"},
 		Percentage: 10,
 	}}}
-	params := PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -269,7 +275,7 @@ func TestMemberDiscounts(t *testing.T) {
 	claims := map[string]interface{}{}
 	require.NoError(t, json.Unmarshal([]byte(`{"app_metadata": {"plan": "member"}}`), &claims))
 
-	params = PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params = PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price = CalculatePrice(settings, claims, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -290,7 +296,7 @@ func TestFixedMemberDiscounts(t *testing.T) {
 		}},
 	}}}
 
-	params := PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -304,7 +310,7 @@ func TestFixedMemberDiscounts(t *testing.T) {
 	claims := map[string]interface{}{}
 	require.NoError(t, json.Unmarshal([]byte(`{"app_metadata": {"plan": "member"}}`), &claims))
 
-	params = PriceParameters{"USA", "USD", nil, []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
+	params = PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{price: 100, itemType: "test", vat: 9}}}
 	price = CalculatePrice(settings, claims, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -331,7 +337,7 @@ func TestMixedDiscounts(t *testing.T) {
 		price:    3490,
 	}
 
-	params := PriceParameters{"USA", "USD", nil, []Item{item}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{item}}
 	price := CalculatePrice(&settings, nil, params, testLogger)
 	assert.Equal(t, 3490, int(price.Total))
 
@@ -374,7 +380,7 @@ func TestRealWorldTaxCalculations(t *testing.T) {
 			Countries:    []string{"USA"},
 		}}
 
-		params := PriceParameters{"USA", "USD", nil, []Item{item1}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{item1}}
 		price := CalculatePrice(settings, nil, params, testLogger)
 
 		validatePrice(t, price, Price{
@@ -409,7 +415,7 @@ func TestRealWorldTaxCalculations(t *testing.T) {
 			}},
 		}
 
-		params := PriceParameters{"USA", "USD", nil, []Item{item1, item2}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{item1, item2}}
 		price := CalculatePrice(settings, nil, params, testLogger)
 
 		validatePrice(t, price, Price{
@@ -449,7 +455,7 @@ func TestRealWorldRelativeDiscountWithTaxes(t *testing.T) {
 	}
 
 	coupon := &TestCoupon{itemType: "book", percentage: 25}
-	params := PriceParameters{"Germany", "EUR", coupon, []Item{item}}
+	params := PriceParameters{Country: "Germany", Currency: "EUR", Coupon: coupon, Items: []Item{item}}
 	price := CalculatePrice(settings, nil, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -504,7 +510,7 @@ func TestRealWorldFixedDiscountWithTaxes(t *testing.T) {
 			},
 		},
 	}
-	params := PriceParameters{"Germany", "EUR", nil, []Item{item}}
+	params := PriceParameters{Country: "Germany", Currency: "EUR", Items: []Item{item}}
 	price := CalculatePrice(settings, claims, params, testLogger)
 
 	validatePrice(t, price, Price{
@@ -515,3 +521,539 @@ func TestRealWorldFixedDiscountWithTaxes(t *testing.T) {
 		Total:    2900,
 	})
 }
+
+func TestTaxAppliesToCountryCodes(t *testing.T) {
+	tax := &Tax{Percentage: 19, Countries: []string{"Germany", "AT"}}
+	assert.True(t, tax.AppliesTo("Germany", "Book"))
+	assert.True(t, tax.AppliesTo("DE", "Book"))
+	assert.True(t, tax.AppliesTo("deu", "Book"))
+	assert.True(t, tax.AppliesTo("Austria", "Book"))
+	assert.False(t, tax.AppliesTo("USA", "Book"))
+	assert.False(t, tax.AppliesTo("Atlantis", "Book"))
+}
+
+func TestRegionalSalesTaxes(t *testing.T) {
+	settings := &Settings{Taxes: []*Tax{
+		{Name: "California", Percentage: 7.25, Countries: []string{"USA"}, Regions: []string{"CA"}},
+		{Name: "San Francisco", Percentage: 1.375, Countries: []string{"USA"}, Regions: []string{"CA"}, PostalCodes: []string{"941*"}, Stack: true},
+	}}
+
+	cases := []struct {
+		region     string
+		postalCode string
+		taxes      uint64
+		taxItems   []TaxItem
+	}{
+		{"ca", "94103-1234", 87, []TaxItem{
+			{Name: "California", Percentage: 7.25, Taxable: 1000, Amount: 73},
+			{Name: "San Francisco", Percentage: 1.375, Taxable: 1000, Amount: 14},
+		}},
+		{"CA", "90012", 73, []TaxItem{
+			{Name: "California", Percentage: 7.25, Taxable: 1000, Amount: 73},
+		}},
+		{"NY", "10001", 0, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.region+" "+c.postalCode, func(t *testing.T) {
+			params := PriceParameters{Country: "USA", Region: c.region, PostalCode: c.postalCode, Currency: "USD", Items: []Item{&TestItem{price: 1000, itemType: "test"}}}
+			price := CalculatePrice(settings, nil, params, testLogger)
+			assert.Equal(t, c.taxes, price.Taxes)
+			assert.Equal(t, int64(1000+c.taxes), price.Total)
+			require.Len(t, price.Items, 1)
+			assert.Equal(t, c.taxItems, price.Items[0].TaxItems)
+		})
+	}
+}
+
+func TestStackedCanadianTaxes(t *testing.T) {
+	settings := &Settings{Taxes: []*Tax{
+		{Name: "HST", Percentage: 13, Countries: []string{"CA"}, Regions: []string{"ON"}},
+		{Name: "GST", Percentage: 5, Countries: []string{"CA"}},
+		{Name: "PST", Percentage: 7, Countries: []string{"CA"}, Regions: []string{"BC"}, Stack: true},
+		{Name: "QST", Percentage: 9.975, Countries: []string{"CA"}, Regions: []string{"QC"}, Stack: true},
+	}}
+
+	cases := map[string]uint64{"ON": 520, "BC": 480, "QC": 599, "AB": 200}
+	for region, taxes := range cases {
+		params := PriceParameters{Country: "Canada", Region: region, Currency: "CAD", Items: []Item{&TestItem{price: 4000, itemType: "test"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.Equal(t, taxes, price.Taxes, region)
+	}
+}
+
+func TestCompoundTaxes(t *testing.T) {
+	settings := &Settings{Taxes: []*Tax{
+		{Name: "GST", Percentage: 5, Countries: []string{"CA"}},
+		{Name: "QST", Percentage: 8.5, Countries: []string{"CA"}, Regions: []string{"QC"}, Stack: true, Compound: true},
+	}}
+	taxItems := []TaxItem{
+		{Name: "GST", Percentage: 5, Taxable: 10000, Amount: 500},
+		{Name: "QST", Percentage: 8.5, Compound: true, Taxable: 10500, Amount: 893},
+	}
+
+	t.Run("PricesExcludeTaxes", func(t *testing.T) {
+		params := PriceParameters{Country: "CA", Region: "QC", Currency: "CAD", Items: []Item{&TestItem{price: 10000, itemType: "test"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		validatePrice(t, price, Price{
+			Subtotal: 10000,
+			NetTotal: 10000,
+			Taxes:    1393,
+			Total:    11393,
+		})
+		assert.Equal(t, taxItems, price.Items[0].TaxItems)
+	})
+
+	t.Run("PricesIncludeTaxes", func(t *testing.T) {
+		settings.PricesIncludeTaxes = true
+		params := PriceParameters{Country: "CA", Region: "QC", Currency: "CAD", Items: []Item{&TestItem{price: 11393, itemType: "test"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		validatePrice(t, price, Price{
+			Subtotal: 10000,
+			NetTotal: 10000,
+			Taxes:    1393,
+			Total:    11393,
+		})
+		assert.Equal(t, taxItems, price.Items[0].TaxItems)
+	})
+}
+
+func TestBundleTaxItemsAreMerged(t *testing.T) {
+	settings := &Settings{Taxes: []*Tax{{Name: "VAT", Percentage: 10, ProductTypes: []string{"book"}}}}
+	item := &TestItem{price: 1000, itemType: "bundle", items: []Item{
+		&TestItem{price: 600, itemType: "book"},
+		&TestItem{price: 400, itemType: "book"},
+	}}
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{item}}
+	price := CalculatePrice(settings, nil, params, testLogger)
+	assert.Equal(t, []TaxItem{{Name: "VAT", Percentage: 10, Taxable: 1000, Amount: 100}}, price.Items[0].TaxItems)
+}
+
+func TestReverseCharge(t *testing.T) {
+	settings := &Settings{
+		SellerCountry: "Germany",
+		Taxes: []*Tax{
+			{Percentage: 19, Countries: []string{"DE"}},
+			{Percentage: 20, Countries: []string{"AT"}},
+			{Percentage: 10, Countries: []string{"USA"}},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		country       string
+		valid         bool
+		taxes         uint64
+		reverseCharge bool
+	}{
+		{"OtherEUCountry", "Austria", true, 0, true},
+		{"SellerCountry", "DE", true, 190, false},
+		{"WithoutVATNumber", "AT", false, 200, false},
+		{"OutsideEU", "USA", true, 100, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			params := PriceParameters{Country: c.country, Currency: "EUR", ValidVATNumber: c.valid, Items: []Item{&TestItem{price: 1000, itemType: "test"}}}
+			price := CalculatePrice(settings, nil, params, testLogger)
+			assert.Equal(t, c.taxes, price.Taxes)
+			assert.Equal(t, int64(1000+c.taxes), price.Total)
+			assert.Equal(t, c.reverseCharge, price.ReverseCharge)
+			if c.reverseCharge {
+				assert.Empty(t, price.Items[0].TaxItems)
+			}
+		})
+	}
+
+	t.Run("PricesIncludeTaxes", func(t *testing.T) {
+		settings := &Settings{SellerCountry: "DE", PricesIncludeTaxes: true, Taxes: []*Tax{{Percentage: 20, Countries: []string{"AT"}}}}
+		params := PriceParameters{Country: "AT", Currency: "EUR", ValidVATNumber: true, Items: []Item{&TestItem{price: 1200, itemType: "test"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		validatePrice(t, price, Price{
+			Subtotal:      1000,
+			NetTotal:      1000,
+			Total:         1000,
+			ReverseCharge: true,
+		})
+		assert.True(t, price.ReverseCharge)
+	})
+
+	t.Run("WithoutSellerCountry", func(t *testing.T) {
+		settings := &Settings{Taxes: []*Tax{{Percentage: 20, Countries: []string{"AT"}}}}
+		params := PriceParameters{Country: "AT", Currency: "EUR", ValidVATNumber: true, Items: []Item{&TestItem{price: 1000, itemType: "test"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 200, price.Taxes)
+		assert.False(t, price.ReverseCharge)
+	})
+}
+
+func TestTaxBreakdownByRate(t *testing.T) {
+	settings := &Settings{Taxes: []*Tax{
+		{Percentage: 7, ProductTypes: []string{"book"}, Countries: []string{"DE"}},
+		{Percentage: 19, ProductTypes: []string{"ebook"}, Countries: []string{"DE"}},
+	}}
+	bundle := &TestItem{quantity: 2, price: 2000, itemType: "bundle", items: []Item{
+		&TestItem{price: 1000, itemType: "book"},
+		&TestItem{price: 1000, itemType: "ebook"},
+	}}
+	ebook := &TestItem{price: 500, itemType: "ebook"}
+
+	params := PriceParameters{Country: "DE", Currency: "EUR", Items: []Item{bundle, ebook}}
+	price := CalculatePrice(settings, nil, params, testLogger)
+	validatePrice(t, price, Price{
+		Subtotal: 4500,
+		NetTotal: 4500,
+		Taxes:    615,
+		Total:    5115,
+	})
+	assert.False(t, price.PricesIncludeTaxes)
+	assert.Equal(t, []TaxItem{
+		{Percentage: 7, Taxable: 2000, Amount: 140},
+		{Percentage: 19, Taxable: 2500, Amount: 475},
+	}, price.TaxItems)
+	assert.Equal(t, []TaxItem{
+		{Percentage: 7, Taxable: 1000, Amount: 70},
+		{Percentage: 19, Taxable: 1000, Amount: 190},
+	}, price.Items[0].TaxItems)
+}
+
+func TestTierDiscount(t *testing.T) {
+	params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{quantity: 10, price: 1000, tier: 200, itemType: "test"}}}
+	price := CalculatePrice(nil, nil, params, testLogger)
+	validatePrice(t, price, Price{
+		Subtotal: 10000,
+		Discount: 2000,
+		NetTotal: 8000,
+		Taxes:    0,
+		Total:    8000,
+	})
+	assert.Equal(t, []DiscountItem{{Type: DiscountTypeTier, Fixed: 200}}, price.Items[0].DiscountItems)
+}
+
+func TestVolumeDiscount(t *testing.T) {
+	settings := &Settings{VolumeDiscounts: []*VolumeDiscount{
+		{MinQuantity: 10, Percentage: 5},
+		{MinQuantity: 20, Percentage: 10},
+		{MinQuantity: 2, Percentage: 50, ProductTypes: []string{"book"}},
+	}}
+
+	t.Run("Reached", func(t *testing.T) {
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{quantity: 6, price: 1000, itemType: "test"},
+			&TestItem{quantity: 6, price: 500, itemType: "other"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		validatePrice(t, price, Price{
+			Subtotal: 9000,
+			Discount: 450,
+			NetTotal: 8550,
+			Taxes:    0,
+			Total:    8550,
+		})
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypeVolume, Percentage: 5}}, price.Items[0].DiscountItems)
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypeVolume, Percentage: 5}}, price.Items[1].DiscountItems)
+	})
+
+	t.Run("NotReached", func(t *testing.T) {
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{quantity: 4, price: 1000, itemType: "test"},
+			&TestItem{quantity: 4, price: 500, itemType: "other"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 0, price.Discount)
+		assert.Empty(t, price.Items[0].DiscountItems)
+	})
+
+	t.Run("ProductTypes", func(t *testing.T) {
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{quantity: 2, price: 1000, itemType: "book"},
+			&TestItem{quantity: 1, price: 500, itemType: "other"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 1000, price.Discount)
+		assert.Empty(t, price.Items[1].DiscountItems)
+	})
+}
+
+func TestPromotions(t *testing.T) {
+	cheapestFree := &Promotion{ID: "3-for-2", Type: PromotionBundle, Buy: PromotionItems{Quantity: 3, ProductTypes: []string{"ebook"}}, Get: PromotionItems{Quantity: 1}, Percentage: 100}
+	halfPrice := &Promotion{ID: "a-gets-b", Type: PromotionBuyXGetY, Buy: PromotionItems{Quantity: 1, Products: []string{"a"}}, Get: PromotionItems{Quantity: 1, Products: []string{"b"}}, Percentage: 50}
+
+	t.Run("BundleCheapestFree", func(t *testing.T) {
+		settings := &Settings{Promotions: []*Promotion{cheapestFree}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{sku: "expensive", quantity: 2, price: 1000, itemType: "ebook"},
+			&TestItem{sku: "cheap", quantity: 1, price: 500, itemType: "ebook"},
+			&TestItem{sku: "book", quantity: 1, price: 100, itemType: "book"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		validatePrice(t, price, Price{
+			Subtotal: 2600,
+			Discount: 500,
+			NetTotal: 2100,
+			Taxes:    0,
+			Total:    2100,
+		})
+		assert.Empty(t, price.Items[0].DiscountItems)
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypePromotion, Fixed: 500, Promotion: "3-for-2"}}, price.Items[1].DiscountItems)
+		assert.Empty(t, price.Items[2].DiscountItems)
+	})
+
+	t.Run("BundleGroups", func(t *testing.T) {
+		settings := &Settings{Promotions: []*Promotion{cheapestFree}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{sku: "a", quantity: 4, price: 1000, itemType: "ebook"},
+			&TestItem{sku: "b", quantity: 3, price: 500, itemType: "ebook"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		// 1000 1000 [1000] | 1000 500 [500] | 500
+		assert.EqualValues(t, 1500, price.Discount)
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypePromotion, Fixed: 250, Promotion: "3-for-2"}}, price.Items[0].DiscountItems)
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypePromotion, Fixed: 166, Promotion: "3-for-2"}}, price.Items[1].DiscountItems)
+	})
+
+	t.Run("BuyXGetY", func(t *testing.T) {
+		settings := &Settings{Promotions: []*Promotion{halfPrice}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{sku: "a", quantity: 2, price: 1000, itemType: "book"},
+			&TestItem{sku: "b", quantity: 3, price: 400, itemType: "book"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		validatePrice(t, price, Price{
+			Subtotal: 3200,
+			Discount: 400,
+			NetTotal: 2800,
+			Taxes:    0,
+			Total:    2800,
+		})
+		assert.Empty(t, price.Items[0].DiscountItems)
+	})
+
+	t.Run("BuyXGetYWithoutGetItems", func(t *testing.T) {
+		settings := &Settings{Promotions: []*Promotion{halfPrice}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{sku: "a", quantity: 2, price: 1000, itemType: "book"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 0, price.Discount)
+	})
+
+	t.Run("BuyXGetYSameItems", func(t *testing.T) {
+		// buy 2 books, get the next one at 50%
+		promotion := &Promotion{ID: "books", Type: PromotionBuyXGetY, Buy: PromotionItems{Quantity: 2, ProductTypes: []string{"book"}}, Get: PromotionItems{Quantity: 1}, Percentage: 50}
+		settings := &Settings{Promotions: []*Promotion{promotion}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{
+			&TestItem{sku: "a", quantity: 3, price: 1000, itemType: "book"},
+			&TestItem{sku: "b", quantity: 2, price: 400, itemType: "book"},
+		}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		// bought: 1000 1000 1000 400, discounted: 400
+		assert.EqualValues(t, 200, price.Discount)
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypePromotion, Fixed: 100, Promotion: "books"}}, price.Items[1].DiscountItems)
+	})
+
+	t.Run("UnitsCountOnce", func(t *testing.T) {
+		settings := &Settings{Promotions: []*Promotion{
+			cheapestFree,
+			{ID: "all-ebooks", Type: PromotionBuyXGetY, Buy: PromotionItems{Quantity: 1, ProductTypes: []string{"ebook"}}, Get: PromotionItems{Quantity: 1}, Percentage: 10},
+		}}
+		params := PriceParameters{Country: "USA", Currency: "USD", Items: []Item{&TestItem{sku: "a", quantity: 5, price: 1000, itemType: "ebook"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		// three units in the bundle, one bought and one discounted by the second promotion
+		assert.EqualValues(t, 1100, price.Discount)
+		assert.Equal(t, []DiscountItem{
+			{Type: DiscountTypePromotion, Fixed: 200, Promotion: "3-for-2"},
+			{Type: DiscountTypePromotion, Fixed: 20, Promotion: "all-ebooks"},
+		}, price.Items[0].DiscountItems)
+	})
+
+	t.Run("WithPromotions", func(t *testing.T) {
+		settings := &Settings{Promotions: []*Promotion{cheapestFree}}
+		combined := settings.WithPromotions([]*Promotion{halfPrice})
+		assert.Equal(t, []*Promotion{cheapestFree, halfPrice}, combined.Promotions)
+		assert.Equal(t, []*Promotion{cheapestFree}, settings.Promotions)
+
+		var none *Settings
+		assert.Equal(t, []*Promotion{halfPrice}, none.WithPromotions([]*Promotion{halfPrice}).Promotions)
+	})
+}
+
+func TestAutomaticPromotions(t *testing.T) {
+	start := time.Date(2026, 11, 27, 0, 0, 0, 0, time.UTC)
+	end := start.Add(96 * time.Hour)
+	sale := &AutomaticPromotion{ID: "black-friday", Percentage: 20, ProductTypes: []string{"ebook"}, StartsAt: &start, EndsAt: &end}
+	europe := &AutomaticPromotion{ID: "europe", Percentage: 10, Countries: []string{"Germany", "FR"}, Currencies: []string{"EUR"}}
+	settings := &Settings{AutomaticPromotions: []*AutomaticPromotion{sale, europe}}
+
+	t.Run("SaleWindow", func(t *testing.T) {
+		params := PriceParameters{Country: "USA", Currency: "USD", Time: start, Items: []Item{&TestItem{price: 1000, itemType: "ebook"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 200, price.Discount)
+		assert.Equal(t, []DiscountItem{{Type: DiscountTypePromotion, Percentage: 20, Promotion: "black-friday"}}, price.Items[0].DiscountItems)
+
+		params.Time = end
+		price = CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 0, price.Discount)
+
+		params.Time = start.Add(-time.Second)
+		price = CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 0, price.Discount)
+	})
+
+	t.Run("CountriesAndCurrencies", func(t *testing.T) {
+		params := PriceParameters{Country: "DE", Currency: "EUR", Items: []Item{&TestItem{price: 1000, itemType: "book"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 100, price.Discount)
+
+		params.Currency = "USD"
+		price = CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 0, price.Discount)
+
+		params = PriceParameters{Country: "AT", Currency: "EUR", Items: []Item{&TestItem{price: 1000, itemType: "book"}}}
+		price = CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 0, price.Discount)
+	})
+
+	t.Run("LargestDiscount", func(t *testing.T) {
+		params := PriceParameters{Country: "DE", Currency: "EUR", Time: start, Items: []Item{&TestItem{price: 1000, itemType: "ebook"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 200, price.Discount)
+		assert.Len(t, price.Items[0].DiscountItems, 1)
+	})
+
+	t.Run("CouponPrecedence", func(t *testing.T) {
+		coupon := &TestCoupon{itemType: "ebook", percentage: 15}
+		cases := []struct {
+			precedence string
+			discount   uint64
+			types      []DiscountType
+		}{
+			{"", 350, []DiscountType{DiscountTypePromotion, DiscountTypeCoupon}},
+			{PrecedenceCombine, 350, []DiscountType{DiscountTypePromotion, DiscountTypeCoupon}},
+			{PrecedenceCoupon, 150, []DiscountType{DiscountTypeCoupon}},
+			{PrecedencePromotion, 200, []DiscountType{DiscountTypePromotion}},
+			{PrecedenceBest, 200, []DiscountType{DiscountTypePromotion}},
+		}
+		for _, c := range cases {
+			settings := &Settings{AutomaticPromotions: []*AutomaticPromotion{sale}, CouponPrecedence: c.precedence}
+			params := PriceParameters{Country: "USA", Currency: "USD", Coupon: coupon, Time: start, Items: []Item{&TestItem{price: 1000, itemType: "ebook"}}}
+			price := CalculatePrice(settings, nil, params, testLogger)
+			assert.Equal(t, c.discount, price.Discount, c.precedence)
+			types := []DiscountType{}
+			for _, item := range price.Items[0].DiscountItems {
+				types = append(types, item.Type)
+			}
+			assert.Equal(t, c.types, types, c.precedence)
+		}
+
+		settings := &Settings{AutomaticPromotions: []*AutomaticPromotion{sale}, CouponPrecedence: PrecedenceBest}
+		params := PriceParameters{Country: "USA", Currency: "USD", Coupon: &TestCoupon{itemType: "ebook", percentage: 25}, Time: start, Items: []Item{&TestItem{price: 1000, itemType: "ebook"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+		assert.EqualValues(t, 250, price.Discount)
+	})
+
+	t.Run("ActivePromotions", func(t *testing.T) {
+		assert.Equal(t, []*AutomaticPromotion{sale, europe}, settings.ActivePromotions(start, "", ""))
+		assert.Equal(t, []*AutomaticPromotion{sale}, settings.ActivePromotions(start, "US", "USD"))
+		assert.Equal(t, []*AutomaticPromotion{europe}, settings.ActivePromotions(end, "France", "eur"))
+	})
+}
+
+func TestMatchPostalCode(t *testing.T) {
+	cases := []struct {
+		pattern    string
+		postalCode string
+		matches    bool
+	}{
+		{"94103", "94103", true},
+		{"94103", "94103-1234", true},
+		{"94103", "94104", false},
+		{"941*", "94110", true},
+		{"941*", "94010", false},
+		{"94000-94999", "94110", true},
+		{"94000-94999", "95110", false},
+		{"94000-94999", "9411", false},
+		{"V6B*", "v6b 1a1", true},
+		{"941*", "", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.matches, matchPostalCode(c.pattern, c.postalCode), "%s %s", c.pattern, c.postalCode)
+	}
+}
+
+func TestSettingsValidateRegionalTaxes(t *testing.T) {
+	settings := &Settings{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"taxes": [
+			{"percentage": 7.25, "countries": ["USA"], "regions": ["CA"], "postal_codes": ["941*", "94000-94999", "94103-1234"]},
+			{"percentage": 5, "regions": ["BC"], "postal_codes": ["9*1", "94999-94000", "*"]}
+		]
+	}`), settings))
+
+	errs := []string{}
+	for _, err := range settings.Validate() {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"Tax 2 has regions but no countries",
+		"Tax 2 has the invalid postal code pattern '9*1'",
+		"Tax 2 has the invalid postal code pattern '94999-94000'",
+		"Tax 2 has the invalid postal code pattern '*'",
+	}, errs)
+}
+
+func TestSettingsValidate(t *testing.T) {
+	settings := &Settings{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"taxes": [
+			{"percentage": 19, "countries": ["Germany", "AT", "USA"]},
+			{"percentage": 190, "countries": ["Atlantis"]}
+		],
+		"member_discounts": [
+			{"claims": {"app_metadata.subscription.plan": "member"}, "percentage": 10},
+			{"claims": {"": "member"}, "percentage": 101},
+			{"claims": {"app_metadata.plan": "member"}, "fixed": [{"amount": "abc"}]},
+			{}
+		],
+		"volume_discounts": [
+			{"min_quantity": 10, "percentage": 5},
+			{"min_quantity": 1, "percentage": 110},
+			{"min_quantity": 20}
+		],
+		"promotions": [
+			{"id": "3-for-2", "type": "bundle", "buy": {"quantity": 3}, "get": {"quantity": 1}, "percentage": 100},
+			{"id": "3-for-2", "type": "bundle", "buy": {"quantity": 1}, "get": {"quantity": 1}, "percentage": 100},
+			{"type": "free", "buy": {"quantity": 1}, "percentage": 120}
+		],
+		"automatic_promotions": [
+			{"id": "sale", "percentage": 20, "countries": ["DE"], "currencies": ["EUR"], "starts_at": "2026-11-27T00:00:00Z", "ends_at": "2026-12-01T00:00:00Z"},
+			{"id": "3-for-2", "currencies": ["EURO"], "starts_at": "2026-11-27T00:00:00Z", "ends_at": "2026-11-26T00:00:00Z"}
+		],
+		"coupon_precedence": "first"
+	}`), settings))
+
+	errs := []string{}
+	for _, err := range settings.Validate() {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"Tax 2 has a percentage above 100",
+		"Tax 2 has the unknown country 'Atlantis'",
+		"Member discount 2 has an empty claim",
+		"Member discount 2 has a percentage above 100",
+		"Member discount 3 fixed amount 1 is missing a currency",
+		"Member discount 3 fixed amount 1 has an invalid amount 'abc'",
+		"Member discount 4 has no claims",
+		"Member discount 4 has neither a percentage nor a fixed amount",
+		"Volume discount 2 has a min_quantity below 2",
+		"Volume discount 2 has a percentage above 100",
+		"Volume discount 3 has no percentage",
+		"Promotion 2 discounts every item of its bundle",
+		"Promotion 2 has the duplicate id 3-for-2",
+		"Promotion 3 has no id",
+		"Promotion 3 has the unknown type 'free'",
+		"Promotion 3 has no get quantity",
+		"Promotion 3 has a percentage above 100",
+		"Automatic promotion 2 has neither a percentage nor a fixed amount",
+		"Automatic promotion 2 has the unknown currency 'EURO'",
+		"Automatic promotion 2 ends before it starts",
+		"Automatic promotion 2 has the duplicate id 3-for-2",
+		"Coupon precedence 'first' is unknown",
+	}, errs)
+}
@@ -0,0 +1,56 @@
+package calculator
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pariz/gountries"
+)
+
+var (
+	countryQuery     *gountries.Query
+	countryQueryOnce sync.Once
+)
+
+// CountryCode returns the ISO 3166-1 alpha-2 code of a country given by its
+// alpha-2 code, alpha-3 code or English name. It returns false for unknown
+// countries.
+func CountryCode(country string) (string, bool) {
+	countryQueryOnce.Do(func() {
+		countryQuery = gountries.New()
+	})
+
+	country = strings.TrimSpace(country)
+	if len(country) == 2 || len(country) == 3 {
+		if found, err := countryQuery.FindCountryByAlpha(country); err == nil {
+			return found.Codes.Alpha2, true
+		}
+	}
+	if found, err := countryQuery.FindCountryByName(country); err == nil {
+		return found.Codes.Alpha2, true
+	}
+	return "", false
+}
+
+// euCountries are the alpha-2 codes of the member states of the EU VAT area.
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true,
+	"DK": true, "EE": true, "ES": true, "FI": true, "FR": true, "GR": true,
+	"HR": true, "HU": true, "IE": true, "IT": true, "LT": true, "LU": true,
+	"LV": true, "MT": true, "NL": true, "PL": true, "PT": true, "RO": true,
+	"SE": true, "SI": true, "SK": true,
+}
+
+func inEU(country string) bool {
+	code, ok := CountryCode(country)
+	return ok && euCountries[code]
+}
+
+func sameCountry(a, b string) bool {
+	if a == b {
+		return true
+	}
+	codeA, okA := CountryCode(a)
+	codeB, okB := CountryCode(b)
+	return okA && okB && codeA == codeB
+}
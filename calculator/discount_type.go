@@ -12,6 +12,9 @@ type DiscountType int
 const (
 	DiscountTypeCoupon DiscountType = iota + 1
 	DiscountTypeMember
+	DiscountTypeTier
+	DiscountTypeVolume
+	DiscountTypePromotion
 )
 
 func (t DiscountType) String() string {
@@ -20,6 +23,12 @@ func (t DiscountType) String() string {
 		return "coupon"
 	case DiscountTypeMember:
 		return "member"
+	case DiscountTypeTier:
+		return "tier"
+	case DiscountTypeVolume:
+		return "volume"
+	case DiscountTypePromotion:
+		return "promotion"
 	}
 	return "unknown"
 }
@@ -45,6 +54,12 @@ func (t *DiscountType) UnmarshalJSON(b []byte) error {
 		*t = DiscountTypeCoupon
 	case "member":
 		*t = DiscountTypeMember
+	case "tier":
+		*t = DiscountTypeTier
+	case "volume":
+		*t = DiscountTypeVolume
+	case "promotion":
+		*t = DiscountTypePromotion
 	default:
 		*t = 0
 	}
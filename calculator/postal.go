@@ -0,0 +1,55 @@
+package calculator
+
+import (
+	"strings"
+)
+
+// matchPostalCode tells if the postal code matches the pattern. A pattern is
+// either a postal code like "94103", a prefix like "941*" or an inclusive
+// range like "94000-94999". Spaces and case are ignored, and ZIP+4 codes like
+// "94103-1234" match the patterns of their first five digits.
+func matchPostalCode(pattern, postalCode string) bool {
+	pattern, code := normalizePostalCode(pattern), normalizePostalCode(postalCode)
+	if pattern == "" || code == "" {
+		return false
+	}
+
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(code, strings.TrimSuffix(pattern, "*"))
+	}
+	if low, high, ok := postalRange(pattern); ok {
+		if len(code) < len(low) {
+			return false
+		}
+		code = code[:len(low)]
+		return low <= code && code <= high
+	}
+	return code == pattern || strings.HasPrefix(code, pattern+"-")
+}
+
+// validPostalPattern tells if the postal code pattern is well formed. Ranges
+// need bounds of the same length, the lower one first.
+func validPostalPattern(pattern string) bool {
+	pattern = normalizePostalCode(pattern)
+	prefix := strings.TrimSuffix(pattern, "*")
+	if prefix == "" || strings.Contains(prefix, "*") {
+		return false
+	}
+	if parts := strings.Split(pattern, "-"); len(parts) == 2 && len(parts[0]) == len(parts[1]) {
+		_, _, ok := postalRange(pattern)
+		return ok
+	}
+	return true
+}
+
+func postalRange(pattern string) (string, string, bool) {
+	parts := strings.Split(pattern, "-")
+	if len(parts) != 2 || parts[0] == "" || len(parts[0]) != len(parts[1]) || parts[0] > parts[1] {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func normalizePostalCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
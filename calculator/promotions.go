@@ -0,0 +1,300 @@
+package calculator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Types of promotion rules.
+const (
+	// PromotionBundle discounts the cheapest items of every group of items
+	// bought together, like "buy 3 ebooks, cheapest free".
+	PromotionBundle = "bundle"
+	// PromotionBuyXGetY discounts items for every quantity of other items
+	// bought, like "buy product A, get B at 50%".
+	PromotionBuyXGetY = "buy_x_get_y"
+)
+
+// Promotion is a discount rule evaluated across all items of an order.
+// Promotions are applied in order, and items bought or discounted by one
+// promotion don't count for the next ones.
+type Promotion struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Buy        PromotionItems `json:"buy"`
+	Get        PromotionItems `json:"get"`
+	Percentage uint64         `json:"percentage"`
+}
+
+// PromotionItems selects the items of a promotion by sku or product type.
+// Without products or product types every item is selected, except for the
+// discounted items of a promotion, which are then selected like the bought
+// ones.
+type PromotionItems struct {
+	Quantity     uint64   `json:"quantity"`
+	Products     []string `json:"products,omitempty"`
+	ProductTypes []string `json:"product_types,omitempty"`
+}
+
+func (p *PromotionItems) hasFilters() bool {
+	return len(p.Products) > 0 || len(p.ProductTypes) > 0
+}
+
+func (p *PromotionItems) matches(item Item) bool {
+	if !p.hasFilters() {
+		return true
+	}
+	for _, sku := range p.Products {
+		if sku == item.ProductSku() {
+			return true
+		}
+	}
+	for _, productType := range p.ProductTypes {
+		if productType == item.ProductType() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Promotion) getMatches(item Item) bool {
+	if p.Type == PromotionBundle || !p.Get.hasFilters() {
+		return p.Buy.matches(item)
+	}
+	return p.Get.matches(item)
+}
+
+// WithPromotions returns a copy of the settings with additional promotions,
+// applied after the ones of the settings.
+func (s *Settings) WithPromotions(promotions []*Promotion) *Settings {
+	copied := Settings{}
+	if s != nil {
+		copied = *s
+	}
+	copied.Promotions = make([]*Promotion, 0, len(copied.Promotions)+len(promotions))
+	if s != nil {
+		copied.Promotions = append(copied.Promotions, s.Promotions...)
+	}
+	copied.Promotions = append(copied.Promotions, promotions...)
+	return &copied
+}
+
+// Validate checks the promotion for mistakes.
+func (p *Promotion) Validate() []error {
+	return p.validate("Promotion")
+}
+
+func (p *Promotion) validate(name string) []error {
+	errs := []error{}
+	if p.ID == "" {
+		errs = append(errs, fmt.Errorf("%s has no id", name))
+	}
+	switch p.Type {
+	case PromotionBundle:
+		if p.Get.Quantity >= p.Buy.Quantity {
+			errs = append(errs, fmt.Errorf("%s discounts every item of its bundle", name))
+		}
+	case PromotionBuyXGetY:
+	default:
+		errs = append(errs, fmt.Errorf("%s has the unknown type '%s'", name, p.Type))
+	}
+	if p.Buy.Quantity == 0 {
+		errs = append(errs, fmt.Errorf("%s has no buy quantity", name))
+	}
+	if p.Get.Quantity == 0 {
+		errs = append(errs, fmt.Errorf("%s has no get quantity", name))
+	}
+	if p.Percentage == 0 {
+		errs = append(errs, fmt.Errorf("%s has no percentage", name))
+	}
+	if p.Percentage > 100 {
+		errs = append(errs, fmt.Errorf("%s has a percentage above 100", name))
+	}
+	return errs
+}
+
+// promotionDiscount is the discount a promotion gives on all units of an item.
+type promotionDiscount struct {
+	promotion string
+	amount    uint64
+}
+
+// promotionRun is a number of units of an item that are still available to
+// promotions.
+type promotionRun struct {
+	item  int
+	price uint64
+	count uint64
+}
+
+// promotionDiscounts applies the promotions to the items and returns the
+// discounts of every item, by item index.
+func (s *Settings) promotionDiscounts(items []Item) [][]promotionDiscount {
+	discounts := make([][]promotionDiscount, len(items))
+	if s == nil || len(s.Promotions) == 0 {
+		return discounts
+	}
+
+	remaining := make([]uint64, len(items))
+	for i, item := range items {
+		remaining[i] = item.GetQuantity()
+	}
+	for _, promotion := range s.Promotions {
+		if promotion == nil || len(promotion.validate("")) > 0 {
+			continue
+		}
+		var discounted []uint64
+		if promotion.Type == PromotionBundle {
+			discounted = promotion.applyBundle(items, remaining)
+		} else {
+			discounted = promotion.applyBuyXGetY(items, remaining)
+		}
+		for i, count := range discounted {
+			if count == 0 {
+				continue
+			}
+			amount := count * rint(float64(unitPrice(items[i]))*float64(promotion.Percentage)/100)
+			discounts[i] = append(discounts[i], promotionDiscount{promotion: promotion.ID, amount: amount})
+		}
+	}
+	return discounts
+}
+
+// applyBundle splits the matching units in bundles, most expensive first,
+// and discounts the cheapest units of every full bundle. It returns the
+// number of discounted units of every item.
+func (p *Promotion) applyBundle(items []Item, remaining []uint64) []uint64 {
+	runs := availableRuns(items, remaining, p.Buy.matches)
+	sortRuns(runs, false)
+
+	var total uint64
+	for _, run := range runs {
+		total += run.count
+	}
+	size, free := p.Buy.Quantity, p.Get.Quantity
+	used := total / size * size
+	// discountedBefore counts the discounted positions before the position
+	discountedBefore := func(position uint64) uint64 {
+		count := position / size * free
+		if rest := position % size; rest > size-free {
+			count += rest - (size - free)
+		}
+		return count
+	}
+
+	discounted := make([]uint64, len(items))
+	var position uint64
+	for _, run := range runs {
+		start, end := minUint(position, used), minUint(position+run.count, used)
+		discounted[run.item] += discountedBefore(end) - discountedBefore(start)
+		remaining[run.item] -= end - start
+		position += run.count
+	}
+	return discounted
+}
+
+// applyBuyXGetY uses the most expensive matching units as bought units and
+// discounts the cheapest units of the discounted items. Units that match both
+// are only bought once there are no other units to buy. The last time the
+// promotion applies, fewer units than the get quantity may be discounted.
+func (p *Promotion) applyBuyXGetY(items []Item, remaining []uint64) []uint64 {
+	buyRuns := availableRuns(items, remaining, func(item Item) bool {
+		return p.Buy.matches(item) && !p.getMatches(item)
+	})
+	getRuns := availableRuns(items, remaining, func(item Item) bool {
+		return !p.Buy.matches(item) && p.getMatches(item)
+	})
+	bothRuns := availableRuns(items, remaining, func(item Item) bool {
+		return p.Buy.matches(item) && p.getMatches(item)
+	})
+	buyOnly, getOnly, both := countRuns(buyRuns), countRuns(getRuns), countRuns(bothRuns)
+
+	// times the promotion applies, with enough units left to discount
+	feasible := func(times uint64) bool {
+		buy := times * p.Buy.Quantity
+		if buy > buyOnly+both {
+			return false
+		}
+		getAvailable := getOnly + both
+		if buy > buyOnly {
+			getAvailable -= buy - buyOnly
+		}
+		return getAvailable >= (times-1)*p.Get.Quantity+1
+	}
+	var times uint64
+	low, high := uint64(1), (buyOnly+both)/p.Buy.Quantity
+	for low <= high {
+		mid := low + (high-low)/2
+		if feasible(mid) {
+			times, low = mid, mid+1
+		} else {
+			high = mid - 1
+		}
+	}
+	discounted := make([]uint64, len(items))
+	if times == 0 {
+		return discounted
+	}
+
+	sortRuns(buyRuns, false)
+	sortRuns(bothRuns, false)
+	toBuy := times * p.Buy.Quantity
+	for _, runs := range [][]*promotionRun{buyRuns, bothRuns} {
+		for _, run := range runs {
+			bought := minUint(run.count, toBuy)
+			run.count -= bought
+			remaining[run.item] -= bought
+			toBuy -= bought
+		}
+	}
+
+	candidates := append(getRuns, bothRuns...)
+	sortRuns(candidates, true)
+	toGet := times * p.Get.Quantity
+	for _, run := range candidates {
+		got := minUint(run.count, toGet)
+		discounted[run.item] += got
+		remaining[run.item] -= got
+		toGet -= got
+	}
+	return discounted
+}
+
+func availableRuns(items []Item, remaining []uint64, matches func(Item) bool) []*promotionRun {
+	runs := []*promotionRun{}
+	for i, item := range items {
+		if remaining[i] > 0 && matches(item) {
+			runs = append(runs, &promotionRun{item: i, price: unitPrice(item), count: remaining[i]})
+		}
+	}
+	return runs
+}
+
+func countRuns(runs []*promotionRun) uint64 {
+	var count uint64
+	for _, run := range runs {
+		count += run.count
+	}
+	return count
+}
+
+func sortRuns(runs []*promotionRun, cheapestFirst bool) {
+	sort.SliceStable(runs, func(i, j int) bool {
+		if cheapestFirst {
+			return runs[i].price < runs[j].price
+		}
+		return runs[i].price > runs[j].price
+	})
+}
+
+// unitPrice is the price of a unit of the item, after its quantity tier.
+func unitPrice(item Item) uint64 {
+	return item.PriceInLowestUnit() - item.TierDiscount()
+}
+
+func minUint(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
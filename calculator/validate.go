@@ -0,0 +1,131 @@
+package calculator
+
+import (
+	"fmt"
+
+	"github.com/netlify/gocommerce/money"
+)
+
+// Validate checks the settings for mistakes, like percentages above 100 or
+// unknown countries. It returns every problem found.
+func (s *Settings) Validate() []error {
+	errs := []error{}
+	if s.SellerCountry != "" {
+		if _, ok := CountryCode(s.SellerCountry); !ok {
+			errs = append(errs, fmt.Errorf("Seller country '%s' is unknown", s.SellerCountry))
+		}
+	}
+	for index, tax := range s.Taxes {
+		name := fmt.Sprintf("Tax %d", index+1)
+		if tax == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", name))
+			continue
+		}
+		if tax.Percentage > 100 {
+			errs = append(errs, fmt.Errorf("%s has a percentage above 100", name))
+		}
+		for _, country := range tax.Countries {
+			if _, ok := CountryCode(country); !ok {
+				errs = append(errs, fmt.Errorf("%s has the unknown country '%s'", name, country))
+			}
+		}
+		if len(tax.Regions) > 0 && len(tax.Countries) == 0 {
+			errs = append(errs, fmt.Errorf("%s has regions but no countries", name))
+		}
+		for _, pattern := range tax.PostalCodes {
+			if !validPostalPattern(pattern) {
+				errs = append(errs, fmt.Errorf("%s has the invalid postal code pattern '%s'", name, pattern))
+			}
+		}
+	}
+
+	for index, discount := range s.MemberDiscounts {
+		name := fmt.Sprintf("Member discount %d", index+1)
+		if discount == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", name))
+			continue
+		}
+		errs = append(errs, discount.validate(name)...)
+	}
+
+	for index, discount := range s.VolumeDiscounts {
+		name := fmt.Sprintf("Volume discount %d", index+1)
+		if discount == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", name))
+			continue
+		}
+		if discount.MinQuantity < 2 {
+			errs = append(errs, fmt.Errorf("%s has a min_quantity below 2", name))
+		}
+		if discount.Percentage == 0 {
+			errs = append(errs, fmt.Errorf("%s has no percentage", name))
+		}
+		if discount.Percentage > 100 {
+			errs = append(errs, fmt.Errorf("%s has a percentage above 100", name))
+		}
+	}
+
+	ids := map[string]bool{}
+	for index, promotion := range s.Promotions {
+		name := fmt.Sprintf("Promotion %d", index+1)
+		if promotion == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", name))
+			continue
+		}
+		errs = append(errs, promotion.validate(name)...)
+		if promotion.ID != "" && ids[promotion.ID] {
+			errs = append(errs, fmt.Errorf("%s has the duplicate id %s", name, promotion.ID))
+		}
+		ids[promotion.ID] = true
+	}
+
+	for index, promotion := range s.AutomaticPromotions {
+		name := fmt.Sprintf("Automatic promotion %d", index+1)
+		if promotion == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", name))
+			continue
+		}
+		errs = append(errs, promotion.validate(name)...)
+		if promotion.ID != "" && ids[promotion.ID] {
+			errs = append(errs, fmt.Errorf("%s has the duplicate id %s", name, promotion.ID))
+		}
+		ids[promotion.ID] = true
+	}
+	if !validPrecedence(s.CouponPrecedence) {
+		errs = append(errs, fmt.Errorf("Coupon precedence '%s' is unknown", s.CouponPrecedence))
+	}
+	return errs
+}
+
+func (d *MemberDiscount) validate(name string) []error {
+	errs := []error{}
+	if len(d.Claims) == 0 {
+		errs = append(errs, fmt.Errorf("%s has no claims", name))
+	}
+	for claim, value := range d.Claims {
+		if claim == "" || value == "" {
+			errs = append(errs, fmt.Errorf("%s has an empty claim", name))
+			break
+		}
+	}
+	if d.Percentage > 100 {
+		errs = append(errs, fmt.Errorf("%s has a percentage above 100", name))
+	}
+	if d.Percentage == 0 && len(d.FixedAmount) == 0 {
+		errs = append(errs, fmt.Errorf("%s has neither a percentage nor a fixed amount", name))
+	}
+	for fixedIndex, fixed := range d.FixedAmount {
+		fixedName := fmt.Sprintf("%s fixed amount %d", name, fixedIndex+1)
+		if fixed == nil {
+			errs = append(errs, fmt.Errorf("%s is empty", fixedName))
+			continue
+		}
+		if fixed.Currency == "" {
+			errs = append(errs, fmt.Errorf("%s is missing a currency", fixedName))
+		}
+		if _, err := money.Parse(fixed.Amount, fixed.Currency); err != nil {
+			errs = append(errs, fmt.Errorf("%s has an invalid amount '%s'", fixedName, fixed.Amount))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/jobs"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var expireAllInstances bool
+
+var expireCmd = cobra.Command{
+	Use:  "expire",
+	Long: "Expire pending orders older than the configured TTL and delete expired orders past the retention period.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if expireAllInstances {
+			globalConfig, log, err := conf.LoadGlobal(configFile)
+			if err != nil {
+				logrus.Fatalf("Failed to load configuration: %+v", err)
+			}
+			expire(globalConfig, log, nil)
+			return
+		}
+		execWithConfig(cmd, expire)
+	},
+}
+
+func expire(globalConfig *conf.GlobalConfiguration, log logrus.FieldLogger, config *conf.Configuration) {
+	db, err := models.Connect(globalConfig, log.WithField("component", "db"))
+	if err != nil {
+		log.Fatalf("Error opening database: %+v", err)
+	}
+	defer db.Close()
+
+	if err := jobs.RunOnce(db, globalConfig, config, log.WithField("component", "jobs"), "expire_orders", "delete_expired_orders"); err != nil {
+		log.Fatalf("Error expiring orders: %+v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/netlify/gocommerce/api"
 	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/jobs"
 	"github.com/netlify/gocommerce/models"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -45,6 +46,7 @@ func multi(cmd *cobra.Command, args []string) {
 	logrus.Infof("GoCommerce API started on: %s", l)
 
 	models.RunHooks(bgDB, logrus.WithField("component", "hooks"))
+	jobs.Run(bgDB, globalConfig, nil, logrus.WithField("component", "jobs"))
 
 	api.ListenAndServe(l)
 }
@@ -21,7 +21,9 @@ var rootCmd = cobra.Command{
 // RootCmd will add flags and subcommands to the different commands
 func RootCmd() *cobra.Command {
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "The configuration file")
-	rootCmd.AddCommand(&serveCmd, &migrateCmd, &multiCmd, &versionCmd)
+	expireCmd.Flags().BoolVar(&expireAllInstances, "all-instances", false, "Expire orders of every instance in the database")
+	validateCatalogCmd.Flags().StringVar(&validateSitemap, "sitemap", "/sitemap.xml", "The sitemap listing the pages to validate when no paths are given")
+	rootCmd.AddCommand(&serveCmd, &migrateCmd, &multiCmd, &versionCmd, &expireCmd, &validateCatalogCmd)
 	return &rootCmd
 }
 
@@ -6,6 +6,7 @@ import (
 
 	"github.com/netlify/gocommerce/api"
 	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/jobs"
 	"github.com/netlify/gocommerce/models"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -42,6 +43,7 @@ func serve(globalConfig *conf.GlobalConfiguration, log logrus.FieldLogger, confi
 	log.Infof("GoCommerce API started on: %s", l)
 
 	models.RunHooks(bgDB, log.WithField("component", "hooks"))
+	jobs.Run(bgDB, globalConfig, config, log.WithField("component", "jobs"))
 
 	api.ListenAndServe(l)
 }
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/products"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var validateSitemap string
+
+var validateCatalogCmd = cobra.Command{
+	Use:  "validate-catalog [paths...]",
+	Long: "Fetch the product pages given as paths, or listed in the sitemap, or the product catalog, and check the metadata of every product. Exits with status 1 if a problem is found.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := conf.LoadConfig(configFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load configuration: %+v", err)
+		}
+		if !validateCatalog(config, args) {
+			os.Exit(1)
+		}
+	},
+}
+
+func validateCatalog(config *conf.Configuration, paths []string) bool {
+	cache := products.NewCache(config)
+
+	requireProducts := len(paths) > 0
+	if !requireProducts && config.Products.Source != products.JSONSource {
+		var err error
+		paths, err = cache.SitemapPaths(validateSitemap)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading sitemap: %v\n", err)
+			return false
+		}
+	}
+
+	problems := cache.Validate(paths, requireProducts)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("Found %d problems\n", len(problems))
+		return false
+	}
+	fmt.Println("No problems found")
+	return true
+}
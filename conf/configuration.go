@@ -1,10 +1,12 @@
 package conf
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/netlify/gocommerce/money"
 	"github.com/sirupsen/logrus"
 )
 
@@ -49,11 +51,16 @@ type GlobalConfiguration struct {
 type EmailContentConfiguration struct {
 	OrderConfirmation string `json:"order_confirmation" split_words:"true"`
 	OrderReceived     string `json:"order_received" split_words:"true"`
+	AbandonedCheckout string `json:"abandoned_checkout" split_words:"true"`
+	PaymentFailed     string `json:"payment_failed" split_words:"true"`
+	InvoiceOverdue    string `json:"invoice_overdue" split_words:"true"`
+	GiftCard          string `json:"gift_card" split_words:"true"`
 }
 
 // Configuration holds all the per-tenant configuration for gocommerce
 type Configuration struct {
 	SiteURL string           `json:"site_url" split_words:"true" required:"true"`
+	Locale  string           `json:"locale"`
 	JWT     JWTConfiguration `json:"jwt"`
 
 	SMTP SMTPConfiguration `json:"smtp"`
@@ -82,17 +89,55 @@ type Configuration struct {
 		NetlifyToken string `json:"netlify_token" split_words:"true"`
 	} `json:"downloads"`
 
+	Products struct {
+		Source     string `json:"source"`
+		CatalogURL string `json:"catalog_url" split_words:"true"`
+		CacheTTL   int    `json:"cache_ttl" split_words:"true"`
+	} `json:"products"`
+
+	Settings struct {
+		CacheTTL int `json:"cache_ttl" split_words:"true"`
+	} `json:"settings"`
+
+	ExchangeRates struct {
+		Rounding string `json:"rounding"`
+	} `json:"exchange_rates" split_words:"true"`
+
+	VAT struct {
+		CacheTTL          int    `json:"cache_ttl" split_words:"true"`
+		UnavailablePolicy string `json:"unavailable_policy" split_words:"true"`
+	} `json:"vat"`
+
 	Coupons struct {
 		URL      string `json:"url"`
 		User     string `json:"user"`
 		Password string `json:"password"`
 	} `json:"coupons"`
 
+	AbandonedCheckout struct {
+		Delays    []int  `json:"delays"`
+		MaxAge    int    `json:"max_age" split_words:"true"`
+		ResumeURL string `json:"resume_url" split_words:"true"`
+	} `json:"abandoned_checkout" split_words:"true"`
+
+	Dunning struct {
+		RetryDelays []int  `json:"retry_delays" split_words:"true"`
+		FinalAction string `json:"final_action" split_words:"true"`
+	} `json:"dunning"`
+
+	Expiry struct {
+		PendingTTL int `json:"pending_ttl" split_words:"true"`
+		Retention  int `json:"retention"`
+	} `json:"expiry"`
+
 	Webhooks struct {
 		Order   string `json:"order"`
 		Payment string `json:"payment"`
 		Update  string `json:"update"`
 		Refund  string `json:"refund"`
+		Return  string `json:"return"`
+
+		Subscription string `json:"subscription"`
 
 		Secret string `json:"secret"`
 	} `json:"webhooks"`
@@ -144,6 +189,9 @@ func LoadConfig(filename string) (*Configuration, error) {
 		return nil, err
 	}
 	config.ApplyDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
@@ -152,4 +200,37 @@ func (config *Configuration) ApplyDefaults() {
 	if config.JWT.AdminGroupName == "" {
 		config.JWT.AdminGroupName = "admin"
 	}
+	if config.AbandonedCheckout.MaxAge == 0 {
+		config.AbandonedCheckout.MaxAge = 7 * 24
+	}
+	if config.AbandonedCheckout.ResumeURL == "" {
+		config.AbandonedCheckout.ResumeURL = "/checkout"
+	}
+	if config.Dunning.FinalAction == "" {
+		config.Dunning.FinalAction = "cancel"
+	}
+	if config.Products.CatalogURL == "" {
+		config.Products.CatalogURL = "/gocommerce/products.json"
+	}
+	if config.Products.CacheTTL == 0 {
+		config.Products.CacheTTL = 5 * 60
+	}
+	if config.Settings.CacheTTL == 0 {
+		config.Settings.CacheTTL = 5 * 60
+	}
+	if config.VAT.CacheTTL == 0 {
+		config.VAT.CacheTTL = 24 * 60 * 60
+	}
+	if config.VAT.UnavailablePolicy == "" {
+		config.VAT.UnavailablePolicy = "reject"
+	}
+}
+
+// Validate checks the settings that can't be fixed with a default, so mistakes
+// are caught when the configuration is loaded instead of by orders.
+func (config *Configuration) Validate() error {
+	if !money.ValidRounding(config.ExchangeRates.Rounding) {
+		return fmt.Errorf("Unknown exchange rate rounding '%s'", config.ExchangeRates.Rounding)
+	}
+	return nil
 }
@@ -15,6 +15,9 @@ import (
 	"github.com/netlify/gocommerce/mailer"
 	"github.com/netlify/gocommerce/models"
 	"github.com/netlify/gocommerce/payments"
+	"github.com/netlify/gocommerce/products"
+	"github.com/netlify/gocommerce/settings"
+	"github.com/netlify/gocommerce/vatnumbers"
 )
 
 type contextKey string
@@ -27,6 +30,9 @@ const (
 	tokenKey           = contextKey("jwt")
 	configKey          = contextKey("config")
 	couponsKey         = contextKey("coupons")
+	productsKey        = contextKey("products")
+	settingsKey        = contextKey("settings")
+	vatNumbersKey      = contextKey("vat_numbers")
 	requestIDKey       = contextKey("request_id")
 	adminFlagKey       = contextKey("is_admin")
 	mailerKey          = contextKey("mailer")
@@ -74,6 +80,51 @@ func GetCoupons(ctx context.Context) coupons.Cache {
 	return obj.(coupons.Cache)
 }
 
+// WithProducts adds the product metadata cache to the context.
+func WithProducts(ctx context.Context, cache *products.Cache) context.Context {
+	return context.WithValue(ctx, productsKey, cache)
+}
+
+// GetProducts reads the product metadata cache from the context.
+func GetProducts(ctx context.Context) *products.Cache {
+	obj := ctx.Value(productsKey)
+	if obj == nil {
+		return nil
+	}
+
+	return obj.(*products.Cache)
+}
+
+// WithSettings adds the site settings cache to the context.
+func WithSettings(ctx context.Context, cache *settings.Cache) context.Context {
+	return context.WithValue(ctx, settingsKey, cache)
+}
+
+// GetSettings reads the site settings cache from the context.
+func GetSettings(ctx context.Context) *settings.Cache {
+	obj := ctx.Value(settingsKey)
+	if obj == nil {
+		return nil
+	}
+
+	return obj.(*settings.Cache)
+}
+
+// WithVATNumbers adds the VAT number cache to the context.
+func WithVATNumbers(ctx context.Context, cache *vatnumbers.Cache) context.Context {
+	return context.WithValue(ctx, vatNumbersKey, cache)
+}
+
+// GetVATNumbers reads the VAT number cache from the context.
+func GetVATNumbers(ctx context.Context) *vatnumbers.Cache {
+	obj := ctx.Value(vatNumbersKey)
+	if obj == nil {
+		return nil
+	}
+
+	return obj.(*vatnumbers.Cache)
+}
+
 // WithToken adds the JWT token to the context.
 func WithToken(ctx context.Context, token *jwt.Token) context.Context {
 	return context.WithValue(ctx, tokenKey, token)
@@ -0,0 +1,58 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validators identify the version of a cached response, to revalidate it
+// with a conditional request.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// ValidatorsOf reads the validators of a response.
+func ValidatorsOf(resp *http.Response) Validators {
+	return Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// Apply makes the request conditional on the cached version having changed.
+func (v Validators) Apply(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// Freshness determines how long a response can be used from its Cache-Control
+// header, falling back to the TTL. It returns false if the response must not
+// be stored at all.
+func Freshness(header http.Header, ttl time.Duration) (time.Duration, bool) {
+	maxAge := ttl
+	noCache := false
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case directive == "no-cache":
+			noCache = true
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds >= 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if noCache {
+		return 0, true
+	}
+	return maxAge, true
+}
@@ -0,0 +1,32 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreshness(t *testing.T) {
+	ttl := time.Minute
+	tests := map[string]struct {
+		maxAge time.Duration
+		store  bool
+	}{
+		"":                                {ttl, true},
+		"public, max-age=3600":            {time.Hour, true},
+		"max-age=3600, no-cache":          {0, true},
+		"no-store":                        {0, false},
+		"max-age=invalid":                 {ttl, true},
+		"public, MAX-AGE=0":               {0, true},
+		"private, max-age=5, s-maxage=10": {5 * time.Second, true},
+	}
+	for value, expected := range tests {
+		header := http.Header{}
+		header.Set("Cache-Control", value)
+		maxAge, store := Freshness(header, ttl)
+		assert.Equal(t, expected.maxAge, maxAge, value)
+		assert.Equal(t, expected.store, store, value)
+	}
+}
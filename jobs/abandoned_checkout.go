@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SendCheckoutReminders emails customers whose orders are still pending after
+// each of the configured delays. Orders get one reminder per delay until they
+// are paid, opted out or older than the configured max age. Manual invoices
+// get the invoice reminders instead.
+func SendCheckoutReminders(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error {
+	settings := instance.Config.AbandonedCheckout
+	if len(settings.Delays) == 0 {
+		return nil
+	}
+
+	delays := make([]time.Duration, len(settings.Delays))
+	for i, hours := range settings.Delays {
+		delays[i] = time.Duration(hours) * time.Hour
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+
+	orders := []*models.Order{}
+	query := db.
+		Preload("LineItems").
+		Where("instance_id = ? AND payment_state = ? AND email != ''", instance.ID, models.PendingState).
+		Where("checkout_reminders_sent < ?", len(delays)).
+		Where("payment_due_at IS NULL").
+		Where("created_at <= ? AND created_at > ?", now.Add(-delays[0]), now.Add(-time.Duration(settings.MaxAge)*time.Hour))
+	if result := query.Find(&orders); result.Error != nil {
+		return errors.Wrap(result.Error, "Error querying for abandoned orders")
+	}
+
+	for _, order := range orders {
+		if checkoutReminderOptOut(order) {
+			continue
+		}
+		if order.CreatedAt.After(now.Add(-delays[order.CheckoutRemindersSent])) {
+			continue
+		}
+
+		// claim the reminder first so concurrent runs don't send it twice
+		sent, remindedAt := order.CheckoutRemindersSent, order.CheckoutRemindedAt
+		result := db.Model(order).
+			Where("checkout_reminders_sent = ?", sent).
+			UpdateColumns(map[string]interface{}{
+				"checkout_reminders_sent": sent + 1,
+				"checkout_reminded_at":    now,
+			})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "Error updating order %s", order.ID)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		orderLog := log.WithField("order_id", order.ID)
+		if err := instance.Mailer.AbandonedCheckoutMail(order, checkoutResumeURL(instance.Config, order)); err != nil {
+			orderLog.WithError(err).Error("Error sending abandoned checkout reminder")
+			// release the claim, so the next run tries again
+			result := db.Model(order).
+				Where("checkout_reminders_sent = ?", sent+1).
+				UpdateColumns(map[string]interface{}{
+					"checkout_reminders_sent": sent,
+					"checkout_reminded_at":    remindedAt,
+				})
+			if result.Error != nil {
+				return errors.Wrapf(result.Error, "Error updating order %s", order.ID)
+			}
+			continue
+		}
+		models.LogChanges(db, "", "", order.ID, models.EventUpdated, []models.FieldChange{
+			{Field: "checkout_reminders_sent", Old: sent, New: sent + 1},
+		})
+		orderLog.Infof("Sent abandoned checkout reminder %d", sent+1)
+	}
+	return nil
+}
+
+func checkoutReminderOptOut(order *models.Order) bool {
+	switch v := order.MetaData[models.CheckoutReminderOptOutKey].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func checkoutResumeURL(config *conf.Configuration, order *models.Order) string {
+	resumeURL := config.AbandonedCheckout.ResumeURL
+	if !strings.HasPrefix(resumeURL, "http://") && !strings.HasPrefix(resumeURL, "https://") {
+		resumeURL = config.SiteURL + resumeURL
+	}
+
+	u, err := url.Parse(resumeURL)
+	if err != nil {
+		return resumeURL
+	}
+	query := u.Query()
+	query.Set("order_id", order.ID)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
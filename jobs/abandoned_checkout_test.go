@@ -0,0 +1,199 @@
+package jobs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+)
+
+var testLogger = logrus.NewEntry(logrus.StandardLogger())
+
+type reminder struct {
+	orderID   string
+	resumeURL string
+}
+
+type paymentFailure struct {
+	orderID string
+	attempt int
+	status  string
+}
+
+type invoiceReminder struct {
+	orderID string
+	attempt int
+	final   bool
+}
+
+type recordingMailer struct {
+	reminders       []reminder
+	paymentFailures []paymentFailure
+	invoiceOverdue  []invoiceReminder
+	reminderErr     error
+}
+
+func (m *recordingMailer) OrderConfirmationMail(transaction *models.Transaction) error {
+	return nil
+}
+func (m *recordingMailer) OrderReceivedMail(transaction *models.Transaction) error {
+	return nil
+}
+func (m *recordingMailer) OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error) {
+	return "", nil
+}
+func (m *recordingMailer) AbandonedCheckoutMail(order *models.Order, resumeURL string) error {
+	if m.reminderErr != nil {
+		return m.reminderErr
+	}
+	m.reminders = append(m.reminders, reminder{order.ID, resumeURL})
+	return nil
+}
+func (m *recordingMailer) GiftCardMail(card *models.GiftCard, order *models.Order) error {
+	return nil
+}
+func (m *recordingMailer) PaymentFailedMail(order *models.Order, subscription *models.Subscription) error {
+	m.paymentFailures = append(m.paymentFailures, paymentFailure{order.ID, subscription.FailedAttempts, subscription.Status})
+	return nil
+}
+func (m *recordingMailer) InvoiceOverdueMail(order *models.Order, final bool) error {
+	m.invoiceOverdue = append(m.invoiceOverdue, invoiceReminder{order.ID, order.InvoiceReminders, final})
+	return nil
+}
+
+func (m *recordingMailer) sentTo(orderID string) int {
+	count := 0
+	for _, r := range m.reminders {
+		if r.orderID == orderID {
+			count++
+		}
+	}
+	return count
+}
+
+func testDB(t *testing.T) *gorm.DB {
+	f, err := ioutil.TempFile("", "test-db")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	globalConfig := new(conf.GlobalConfiguration)
+	globalConfig.DB.Automigrate = true
+	globalConfig.DB.Namespace = "test"
+	globalConfig.DB.Driver = "sqlite3"
+	globalConfig.DB.URL = f.Name()
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	db, err := models.Connect(globalConfig, logrus.StandardLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testInstance() (*Instance, *recordingMailer) {
+	config := &conf.Configuration{SiteURL: "https://example.com"}
+	config.AbandonedCheckout.Delays = []int{72, 24}
+	config.ApplyDefaults()
+
+	m := &recordingMailer{}
+	return &Instance{Config: config, Mailer: m}, m
+}
+
+func createPendingOrder(t *testing.T, db *gorm.DB, email string, createdAt time.Time) *models.Order {
+	order := models.NewOrder("", "session", email, "USD")
+	require.NoError(t, db.Create(order).Error)
+	require.NoError(t, db.Model(order).UpdateColumn("created_at", createdAt).Error)
+	return order
+}
+
+func TestSendCheckoutReminders(t *testing.T) {
+	db := testDB(t)
+	instance, m := testInstance()
+	now := time.Now()
+
+	abandoned := createPendingOrder(t, db, "joker@example.com", now.Add(-25*time.Hour))
+	createPendingOrder(t, db, "riddler@example.com", now.Add(-1*time.Hour))
+	createPendingOrder(t, db, "", now.Add(-25*time.Hour))
+	createPendingOrder(t, db, "penguin@example.com", now.Add(-30*24*time.Hour))
+
+	paid := createPendingOrder(t, db, "catwoman@example.com", now.Add(-25*time.Hour))
+	paid.PaymentState = models.PaidState
+	require.NoError(t, db.Save(paid).Error)
+
+	optOut := createPendingOrder(t, db, "twoface@example.com", now.Add(-25*time.Hour))
+	optOut.MetaData = map[string]interface{}{models.CheckoutReminderOptOutKey: true}
+	require.NoError(t, db.Save(optOut).Error)
+
+	require.NoError(t, SendCheckoutReminders(db, instance, now, testLogger))
+	require.Len(t, m.reminders, 1)
+	assert.Equal(t, abandoned.ID, m.reminders[0].orderID)
+	assert.Equal(t, "https://example.com/checkout?order_id="+abandoned.ID, m.reminders[0].resumeURL)
+
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", abandoned.ID).Error)
+	assert.Equal(t, 1, saved.CheckoutRemindersSent)
+	assert.NotNil(t, saved.CheckoutRemindedAt)
+
+	// nothing new until the next delay passed
+	require.NoError(t, SendCheckoutReminders(db, instance, now.Add(time.Hour), testLogger))
+	assert.Len(t, m.reminders, 1)
+
+	require.NoError(t, SendCheckoutReminders(db, instance, now.Add(48*time.Hour), testLogger))
+	assert.Equal(t, 2, m.sentTo(abandoned.ID))
+
+	// all delays used up
+	require.NoError(t, SendCheckoutReminders(db, instance, now.Add(96*time.Hour), testLogger))
+	assert.Equal(t, 2, m.sentTo(abandoned.ID))
+}
+
+func TestSendCheckoutRemindersStopsWhenPaid(t *testing.T) {
+	db := testDB(t)
+	instance, m := testInstance()
+	now := time.Now()
+
+	order := createPendingOrder(t, db, "joker@example.com", now.Add(-25*time.Hour))
+	require.NoError(t, SendCheckoutReminders(db, instance, now, testLogger))
+	require.Len(t, m.reminders, 1)
+
+	require.NoError(t, db.Model(order).UpdateColumn("payment_state", models.PaidState).Error)
+	require.NoError(t, SendCheckoutReminders(db, instance, now.Add(48*time.Hour), testLogger))
+	assert.Len(t, m.reminders, 1)
+}
+
+func TestSendCheckoutRemindersSendFailed(t *testing.T) {
+	db := testDB(t)
+	instance, m := testInstance()
+	now := time.Now()
+
+	order := createPendingOrder(t, db, "joker@example.com", now.Add(-25*time.Hour))
+	m.reminderErr = errors.New("SMTP server unavailable")
+	require.NoError(t, SendCheckoutReminders(db, instance, now, testLogger))
+
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, 0, saved.CheckoutRemindersSent)
+	assert.Nil(t, saved.CheckoutRemindedAt)
+
+	// the next run sends the reminder again
+	m.reminderErr = nil
+	require.NoError(t, SendCheckoutReminders(db, instance, now.Add(time.Minute), testLogger))
+	assert.Equal(t, 1, m.sentTo(order.ID))
+}
+
+func TestSendCheckoutRemindersDisabled(t *testing.T) {
+	db := testDB(t)
+	instance, m := testInstance()
+	instance.Config.AbandonedCheckout.Delays = nil
+
+	createPendingOrder(t, db, "joker@example.com", time.Now().Add(-25*time.Hour))
+	require.NoError(t, SendCheckoutReminders(db, instance, time.Now(), testLogger))
+	assert.Empty(t, m.reminders)
+}
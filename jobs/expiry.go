@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ExpirePendingOrders marks orders that are still pending after the configured
+// TTL as expired. Pending payments of those orders are voided with the payment
+// provider where the provider supports it. Orders whose payments can't be
+// voided stay pending, as the payments might still complete, and are tried
+// again on the next run. Manual invoices are left to dunning instead.
+func ExpirePendingOrders(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error {
+	ttl := instance.Config.Expiry.PendingTTL
+	if ttl <= 0 {
+		return nil
+	}
+
+	orders := []*models.Order{}
+	query := db.
+		Preload("Transactions").
+		Preload("LineItems").
+		Where("instance_id = ? AND payment_state = ?", instance.ID, models.PendingState).
+		Where("created_at < ?", now.Add(-time.Duration(ttl)*time.Hour)).
+		Where("payment_due_at IS NULL")
+	if result := query.Find(&orders); result.Error != nil {
+		return errors.Wrap(result.Error, "Error querying for pending orders")
+	}
+
+	for _, order := range orders {
+		orderLog := log.WithField("order_id", order.ID)
+
+		// the provider is called before the order is locked
+		if err := voidPendingPayments(instance, order, orderLog); err != nil {
+			orderLog.WithError(err).Warn("Failed to void pending payment, not expiring order")
+			continue
+		}
+
+		tx := db.Begin()
+		result := tx.Model(order).
+			Where("payment_state = ?", models.PendingState).
+			UpdateColumns(map[string]interface{}{"payment_state": models.ExpiredState, "expired_at": now})
+		if result.Error != nil {
+			tx.Rollback()
+			return errors.Wrapf(result.Error, "Error expiring order %s", order.ID)
+		}
+		if result.RowsAffected == 0 {
+			// paid or expired in the meantime
+			tx.Rollback()
+			continue
+		}
+
+		if err := releaseUnpaidOrder(tx, order, "Order expired"); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for _, t := range order.Transactions {
+			if t.Status != models.PendingState {
+				continue
+			}
+			t.Status = models.FailedState
+			t.FailureDescription = "The payment expired before it was completed"
+			if result := tx.Save(t); result.Error != nil {
+				tx.Rollback()
+				return errors.Wrapf(result.Error, "Error updating transaction %s", t.ID)
+			}
+		}
+
+		models.LogChanges(tx, "", "", order.ID, models.EventUpdated, []models.FieldChange{
+			{Field: "payment_state", Old: models.PendingState, New: models.ExpiredState},
+		})
+		if result := tx.Commit(); result.Error != nil {
+			return errors.Wrapf(result.Error, "Error expiring order %s", order.ID)
+		}
+		orderLog.Info("Expired pending order")
+	}
+	return nil
+}
+
+// releaseUnpaidOrder gives the stock and the gift card amount of an order
+// that won't be paid anymore back.
+func releaseUnpaidOrder(tx *gorm.DB, order *models.Order, note string) error {
+	inventoryState := order.InventoryState
+	if err := models.ReleaseInventory(tx, order); err != nil {
+		return errors.Wrapf(err, "Error releasing inventory of order %s", order.ID)
+	}
+	if order.InventoryState != inventoryState {
+		if result := tx.Model(order).UpdateColumn("inventory_state", order.InventoryState); result.Error != nil {
+			return errors.Wrapf(result.Error, "Error updating order %s", order.ID)
+		}
+	}
+
+	if order.GiftCardAmount > 0 {
+		if err := models.ReleaseGiftCard(tx, order, note); err != nil {
+			return errors.Wrapf(err, "Error releasing gift card of order %s", order.ID)
+		}
+		updates := map[string]interface{}{"total": order.Total, "gift_card_amount": order.GiftCardAmount}
+		if result := tx.Model(order).UpdateColumns(updates); result.Error != nil {
+			return errors.Wrapf(result.Error, "Error updating order %s", order.ID)
+		}
+	}
+	return nil
+}
+
+// voidPendingPayments voids the pending payments of the order with its
+// payment provider. Payments the provider can't void are left to expire with
+// the provider.
+func voidPendingPayments(instance *Instance, order *models.Order, log logrus.FieldLogger) error {
+	for _, t := range order.Transactions {
+		if t.Status != models.PendingState || t.ProcessorID == "" {
+			continue
+		}
+		log := log.WithField("transaction_id", t.ID)
+
+		provs, err := instance.PaymentProviders()
+		if err != nil {
+			return err
+		}
+		provider := provs[order.PaymentProcessor]
+		if provider == nil {
+			log.Warnf("Can't void pending payment, payment provider '%s' not configured", order.PaymentProcessor)
+			continue
+		}
+		void, err := provider.NewVoider(context.Background(), nil, log)
+		if err != nil {
+			log.WithError(err).Debug("Payment provider doesn't void pending payments")
+			continue
+		}
+		if err := void(t.ProcessorID); err != nil {
+			return errors.Wrapf(err, "Error voiding transaction %s", t.ID)
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredOrders permanently deletes orders which expired longer than
+// the configured retention period ago, together with everything attached to
+// them. Addresses of anonymous orders are removed once no other order uses
+// them.
+func DeleteExpiredOrders(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error {
+	retention := instance.Config.Expiry.Retention
+	if retention <= 0 {
+		return nil
+	}
+
+	orders := []*models.Order{}
+	query := db.Unscoped().
+		Where("instance_id = ? AND payment_state = ?", instance.ID, models.ExpiredState).
+		Where("expired_at < ?", now.Add(-time.Duration(retention)*time.Hour))
+	if result := query.Find(&orders); result.Error != nil {
+		return errors.Wrap(result.Error, "Error querying for expired orders")
+	}
+
+	for _, order := range orders {
+		tx := db.Begin()
+		if err := models.HardDelete(tx, order); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "Error deleting order %s", order.ID)
+		}
+		if order.UserID == "" {
+			if err := deleteUnusedAddresses(tx, order.BillingAddressID, order.ShippingAddressID); err != nil {
+				tx.Rollback()
+				return errors.Wrapf(err, "Error deleting addresses of order %s", order.ID)
+			}
+		}
+		if result := tx.Commit(); result.Error != nil {
+			return errors.Wrapf(result.Error, "Error deleting order %s", order.ID)
+		}
+		log.WithField("order_id", order.ID).Info("Deleted expired order")
+	}
+	return nil
+}
+
+func deleteUnusedAddresses(tx *gorm.DB, ids ...string) error {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		var count int
+		if result := tx.Unscoped().Model(&models.Order{}).Where("billing_address_id = ? OR shipping_address_id = ?", id, id).Count(&count); result.Error != nil {
+			return result.Error
+		}
+		if count > 0 {
+			continue
+		}
+		if err := models.HardDelete(tx.Where("user_id = ''"), &models.Address{ID: id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
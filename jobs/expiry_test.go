@@ -0,0 +1,249 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+type voidingProvider struct {
+	voidCalls []string
+	voidErr   error
+}
+
+func (p *voidingProvider) Name() string {
+	return payments.StripeProvider
+}
+func (p *voidingProvider) NewCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Charger, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewRefunder(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Refunder, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewPreauthorizer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Preauthorizer, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewConfirmer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Confirmer, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewVoider(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Voider, error) {
+	return func(paymentID string) error {
+		p.voidCalls = append(p.voidCalls, paymentID)
+		return p.voidErr
+	}, nil
+}
+func (p *voidingProvider) NewRenewer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Renewer, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewMethodSaver(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodSaver, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewMethodRemover(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodRemover, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+func (p *voidingProvider) NewMethodCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodCharger, error) {
+	return nil, errors.New("Shouldn't have called this")
+}
+
+func TestExpirePendingOrders(t *testing.T) {
+	db := testDB(t)
+	instance, _ := testInstance()
+	instance.Config.Expiry.PendingTTL = 24
+	provider := &voidingProvider{}
+	instance.providers = map[string]payments.Provider{payments.StripeProvider: provider}
+	now := time.Now()
+
+	old := createPendingOrder(t, db, "joker@example.com", now.Add(-25*time.Hour))
+	old.PaymentProcessor = payments.StripeProvider
+	require.NoError(t, db.Save(old).Error)
+	pending := models.NewTransaction(old)
+	pending.ProcessorID = "pi_123"
+	pending.Status = models.PendingState
+	require.NoError(t, db.Create(pending).Error)
+
+	recent := createPendingOrder(t, db, "riddler@example.com", now.Add(-1*time.Hour))
+	paid := createPendingOrder(t, db, "catwoman@example.com", now.Add(-25*time.Hour))
+	require.NoError(t, db.Model(paid).UpdateColumn("payment_state", models.PaidState).Error)
+
+	require.NoError(t, ExpirePendingOrders(db, instance, now, testLogger))
+
+	states := map[string]string{
+		old.ID:    models.ExpiredState,
+		recent.ID: models.PendingState,
+		paid.ID:   models.PaidState,
+	}
+	for id, state := range states {
+		saved := &models.Order{}
+		require.NoError(t, db.First(saved, "id = ?", id).Error)
+		assert.Equal(t, state, saved.PaymentState)
+		if state == models.ExpiredState {
+			require.NotNil(t, saved.ExpiredAt)
+			assert.WithinDuration(t, now, *saved.ExpiredAt, time.Second)
+		} else {
+			assert.Nil(t, saved.ExpiredAt)
+		}
+	}
+
+	assert.Equal(t, []string{"pi_123"}, provider.voidCalls)
+	savedTransaction := &models.Transaction{}
+	require.NoError(t, db.First(savedTransaction, "id = ?", pending.ID).Error)
+	assert.Equal(t, models.FailedState, savedTransaction.Status)
+
+	events := []models.Event{}
+	require.NoError(t, db.Where("order_id = ?", old.ID).Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Equal(t, "payment_state", events[0].Changes)
+}
+
+func TestExpirePendingOrdersVoidFailed(t *testing.T) {
+	db := testDB(t)
+	instance, _ := testInstance()
+	instance.Config.Expiry.PendingTTL = 24
+	provider := &voidingProvider{voidErr: errors.New("payment already succeeded")}
+	instance.providers = map[string]payments.Provider{payments.StripeProvider: provider}
+	now := time.Now()
+
+	order := createPendingOrder(t, db, "joker@example.com", now.Add(-25*time.Hour))
+	order.PaymentProcessor = payments.StripeProvider
+	require.NoError(t, db.Save(order).Error)
+	pending := models.NewTransaction(order)
+	pending.ProcessorID = "pi_123"
+	pending.Status = models.PendingState
+	require.NoError(t, db.Create(pending).Error)
+
+	require.NoError(t, ExpirePendingOrders(db, instance, now, testLogger))
+	assert.Equal(t, []string{"pi_123"}, provider.voidCalls)
+
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, models.PendingState, saved.PaymentState)
+	savedTransaction := &models.Transaction{}
+	require.NoError(t, db.First(savedTransaction, "id = ?", pending.ID).Error)
+	assert.Equal(t, models.PendingState, savedTransaction.Status)
+}
+
+func TestExpirePendingOrdersReleasesInventory(t *testing.T) {
+	db := testDB(t)
+	instance, _ := testInstance()
+	instance.Config.Expiry.PendingTTL = 24
+	now := time.Now()
+
+	require.NoError(t, db.Create(&models.Inventory{InstanceID: instance.ID, Sku: "umbrella", Stock: 5, Reserved: 2}).Error)
+	order := models.NewOrder("", "session", "penguin@example.com", "USD")
+	order.LineItems = []*models.LineItem{{Sku: "umbrella", Quantity: 2, Price: 100}}
+	order.InventoryState = models.InventoryReservedState
+	require.NoError(t, db.Create(order).Error)
+	require.NoError(t, db.Model(order).UpdateColumn("created_at", now.Add(-25*time.Hour)).Error)
+
+	require.NoError(t, ExpirePendingOrders(db, instance, now, testLogger))
+
+	inventory, err := models.GetInventory(db, instance.ID, "umbrella")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, inventory.Stock)
+	assert.EqualValues(t, 0, inventory.Reserved)
+
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, models.InventoryReleasedState, saved.InventoryState)
+}
+
+func TestExpirePendingOrdersReleasesGiftCard(t *testing.T) {
+	db := testDB(t)
+	instance, _ := testInstance()
+	instance.Config.Expiry.PendingTTL = 24
+	now := time.Now()
+
+	card, err := models.NewGiftCard(instance.ID, "USD")
+	require.NoError(t, err)
+	card.Balance = 1000
+	require.NoError(t, card.Issue(db, "", "", ""))
+
+	order := createPendingOrder(t, db, "penguin@example.com", now.Add(-25*time.Hour))
+	order.InstanceID = instance.ID
+	order.Total = 1500
+	require.NoError(t, models.RedeemGiftCard(db, order, card))
+	require.NoError(t, db.Save(order).Error)
+
+	require.NoError(t, ExpirePendingOrders(db, instance, now, testLogger))
+
+	savedCard := &models.GiftCard{}
+	require.NoError(t, db.Preload("Entries").First(savedCard, "id = ?", card.ID).Error)
+	assert.EqualValues(t, 1000, savedCard.Balance)
+	require.Len(t, savedCard.Entries, 3)
+
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, models.ExpiredState, saved.PaymentState)
+	assert.EqualValues(t, 1500, saved.Total)
+	assert.EqualValues(t, 0, saved.GiftCardAmount)
+}
+
+func TestExpirePendingOrdersDisabled(t *testing.T) {
+	db := testDB(t)
+	instance, _ := testInstance()
+	now := time.Now()
+
+	order := createPendingOrder(t, db, "joker@example.com", now.Add(-365*24*time.Hour))
+	require.NoError(t, ExpirePendingOrders(db, instance, now, testLogger))
+
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, models.PendingState, saved.PaymentState)
+}
+
+func TestDeleteExpiredOrders(t *testing.T) {
+	db := testDB(t)
+	instance, _ := testInstance()
+	instance.Config.Expiry.Retention = 48
+	now := time.Now()
+
+	address := &models.Address{ID: "anon-address"}
+	address.Name = "Oswald Cobblepot"
+	require.NoError(t, db.Create(address).Error)
+
+	old := models.NewOrder("", "session", "penguin@example.com", "USD")
+	old.BillingAddressID = address.ID
+	old.ShippingAddressID = address.ID
+	old.LineItems = []*models.LineItem{{Sku: "umbrella", Quantity: 1, Price: 100}}
+	require.NoError(t, db.Create(old).Error)
+	require.NoError(t, db.Model(old).UpdateColumns(map[string]interface{}{
+		"created_at":    now.Add(-100 * time.Hour),
+		"payment_state": models.ExpiredState,
+		"expired_at":    now.Add(-50 * time.Hour),
+	}).Error)
+	models.LogEvent(db, "", "", old.ID, models.EventCreated, nil)
+
+	// created long ago, but only expired recently with a longer TTL
+	recent := createPendingOrder(t, db, "joker@example.com", now.Add(-100*time.Hour))
+	require.NoError(t, db.Model(recent).UpdateColumns(map[string]interface{}{
+		"payment_state": models.ExpiredState,
+		"expired_at":    now.Add(-10 * time.Hour),
+	}).Error)
+
+	var count int
+	db.Model(&models.LineItem{}).Where("order_id = ?", old.ID).Count(&count)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, DeleteExpiredOrders(db, instance, now, testLogger))
+
+	db.Unscoped().Model(&models.Order{}).Where("id = ?", old.ID).Count(&count)
+	assert.Equal(t, 0, count)
+	db.Unscoped().Model(&models.LineItem{}).Where("order_id = ?", old.ID).Count(&count)
+	assert.Equal(t, 0, count)
+	db.Unscoped().Model(&models.Event{}).Where("order_id = ?", old.ID).Count(&count)
+	assert.Equal(t, 0, count)
+	db.Unscoped().Model(&models.Address{}).Where("id = ?", address.ID).Count(&count)
+	assert.Equal(t, 0, count)
+
+	db.Model(&models.Order{}).Where("id = ?", recent.ID).Count(&count)
+	assert.Equal(t, 1, count)
+}
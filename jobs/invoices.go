@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RemindOverdueInvoices reminds customers of their unpaid manual invoices on
+// the dunning schedule, starting when an invoice is due. Every reminder is
+// recorded as a failed transaction of the order. Once all reminders were sent
+// the order is canceled, or with the pause action left unpaid without further
+// reminders.
+func RemindOverdueInvoices(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error {
+	orders, err := models.OverdueInvoices(db, instance.ID, now)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		orderLog := log.WithField("order_id", order.ID)
+		if err := remindOverdueInvoice(db, instance, order, now, orderLog); err != nil {
+			orderLog.WithError(err).Error("Error reminding overdue invoice")
+		}
+	}
+	return nil
+}
+
+func remindOverdueInvoice(db *gorm.DB, instance *Instance, order *models.Order, now time.Time, log logrus.FieldLogger) error {
+	config := instance.Config
+
+	tx := db.Begin()
+	claimed, err := order.ClaimInvoiceReminder(tx, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !claimed {
+		tx.Rollback()
+		log.Debug("Invoice is reminded by another process")
+		return nil
+	}
+
+	final := order.InvoiceOverdue(now, config.Dunning.RetryDelays)
+	tr := models.NewTransaction(order)
+	tr.InvoiceNumber = order.InvoiceNumber
+	tr.Status = models.FailedState
+	tr.FailureCode = models.InvoiceOverdueFailureCode
+	tr.FailureDescription = fmt.Sprintf("Invoice due on %s is unpaid, sent reminder %d", order.PaymentDueAt.Format("2006-01-02"), order.InvoiceReminders)
+	if result := tx.Create(tr); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "Error creating transaction")
+	}
+
+	updates := map[string]interface{}{"invoice_reminders": order.InvoiceReminders}
+	if order.NextInvoiceReminderAt != nil {
+		updates["next_invoice_reminder_at"] = *order.NextInvoiceReminderAt
+	}
+	canceled := final && config.Dunning.FinalAction != "pause"
+	if canceled {
+		updates["payment_state"] = models.FailedState
+	}
+	if result := tx.Model(order).UpdateColumns(updates); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrapf(result.Error, "Error updating order %s", order.ID)
+	}
+	if canceled {
+		order.PaymentState = models.FailedState
+		if err := releaseUnpaidOrder(tx, order, "Invoice canceled"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		models.LogChanges(tx, "", "", order.ID, models.EventUpdated, []models.FieldChange{
+			{Field: "payment_state", Old: models.PendingState, New: models.FailedState},
+		})
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return errors.Wrapf(result.Error, "Error reminding invoice of order %s", order.ID)
+	}
+
+	if err := instance.Mailer.InvoiceOverdueMail(order, final); err != nil {
+		log.WithError(err).Error("Error sending invoice overdue mail")
+	}
+	if canceled {
+		log.Warnf("Canceled order after %d invoice reminders", order.InvoiceReminders)
+	} else {
+		log.Infof("Sent invoice reminder %d", order.InvoiceReminders)
+	}
+	return nil
+}
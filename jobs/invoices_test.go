@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/models"
+)
+
+func createInvoice(t *testing.T, db *gorm.DB, issuedAt time.Time, netDays int) *models.Order {
+	order := models.NewOrder("", "session", "penguin@example.com", "USD")
+	order.LineItems = []*models.LineItem{{Sku: "umbrella", Quantity: 2, Price: 100}}
+	order.InventoryState = models.InventoryReservedState
+	order.InvoiceNumber = 42
+	order.Total = 200
+	order.IssueInvoice(issuedAt, netDays)
+	require.NoError(t, db.Create(order).Error)
+	require.NoError(t, db.Model(order).UpdateColumn("created_at", issuedAt).Error)
+	return order
+}
+
+func TestRemindOverdueInvoices(t *testing.T) {
+	for _, finalAction := range []string{"cancel", "pause"} {
+		t.Run(finalAction, func(t *testing.T) {
+			db := testDB(t)
+			instance, m := testInstance()
+			instance.Config.Dunning.RetryDelays = []int{24, 72}
+			instance.Config.Dunning.FinalAction = finalAction
+			now := time.Now()
+
+			require.NoError(t, db.Create(&models.Inventory{InstanceID: instance.ID, Sku: "umbrella", Stock: 5, Reserved: 2}).Error)
+			order := createInvoice(t, db, now.AddDate(0, 0, -30), 30)
+
+			// nothing to remind before the invoice is due
+			require.NoError(t, RemindOverdueInvoices(db, instance, now.Add(-time.Hour), testLogger))
+			assert.Empty(t, m.invoiceOverdue)
+
+			require.NoError(t, RemindOverdueInvoices(db, instance, now, testLogger))
+			require.Len(t, m.invoiceOverdue, 1)
+			saved := &models.Order{}
+			require.NoError(t, db.Preload("Transactions").First(saved, "id = ?", order.ID).Error)
+			assert.Equal(t, models.PendingState, saved.PaymentState)
+			assert.Equal(t, 1, saved.InvoiceReminders)
+			require.NotNil(t, saved.NextInvoiceReminderAt)
+			assert.WithinDuration(t, now.Add(24*time.Hour), *saved.NextInvoiceReminderAt, time.Second)
+			require.Len(t, saved.Transactions, 1)
+			assert.Equal(t, models.FailedState, saved.Transactions[0].Status)
+			assert.Equal(t, models.InvoiceOverdueFailureCode, saved.Transactions[0].FailureCode)
+			assert.EqualValues(t, 42, saved.Transactions[0].InvoiceNumber)
+
+			require.NoError(t, RemindOverdueInvoices(db, instance, now.Add(23*time.Hour), testLogger))
+			require.Len(t, m.invoiceOverdue, 1)
+			require.NoError(t, RemindOverdueInvoices(db, instance, now.Add(24*time.Hour), testLogger))
+			require.Len(t, m.invoiceOverdue, 2)
+			require.NoError(t, RemindOverdueInvoices(db, instance, now.Add(72*time.Hour), testLogger))
+			assert.Equal(t, []invoiceReminder{
+				{order.ID, 1, false},
+				{order.ID, 2, false},
+				{order.ID, 3, true},
+			}, m.invoiceOverdue)
+
+			final := &models.Order{}
+			require.NoError(t, db.Preload("Transactions").First(final, "id = ?", order.ID).Error)
+			assert.Len(t, final.Transactions, 3)
+			assert.Nil(t, final.NextInvoiceReminderAt)
+			inventory, err := models.GetInventory(db, instance.ID, "umbrella")
+			require.NoError(t, err)
+			if finalAction == "pause" {
+				assert.Equal(t, models.PendingState, final.PaymentState)
+				assert.EqualValues(t, 2, inventory.Reserved)
+			} else {
+				assert.Equal(t, models.FailedState, final.PaymentState)
+				assert.Equal(t, models.InventoryReleasedState, final.InventoryState)
+				assert.EqualValues(t, 0, inventory.Reserved)
+			}
+
+			require.NoError(t, RemindOverdueInvoices(db, instance, now.Add(100*time.Hour), testLogger))
+			assert.Len(t, m.invoiceOverdue, 3)
+		})
+	}
+}
+
+func TestRemindOverdueInvoicesPaid(t *testing.T) {
+	db := testDB(t)
+	instance, m := testInstance()
+	now := time.Now()
+
+	order := createInvoice(t, db, now.AddDate(0, 0, -30), 30)
+	require.NoError(t, db.Model(order).UpdateColumn("payment_state", models.PaidState).Error)
+
+	require.NoError(t, RemindOverdueInvoices(db, instance, now, testLogger))
+	assert.Empty(t, m.invoiceOverdue)
+}
+
+func TestInvoicesNotExpired(t *testing.T) {
+	db := testDB(t)
+	instance, m := testInstance()
+	instance.Config.Expiry.PendingTTL = 24
+	now := time.Now()
+
+	order := createInvoice(t, db, now.Add(-25*time.Hour), 30)
+
+	require.NoError(t, ExpirePendingOrders(db, instance, now, testLogger))
+	require.NoError(t, SendCheckoutReminders(db, instance, now, testLogger))
+	saved := &models.Order{}
+	require.NoError(t, db.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, models.PendingState, saved.PaymentState)
+	assert.Empty(t, m.reminders)
+}
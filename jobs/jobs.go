@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/netlify/gocommerce/payments/providers"
+	"github.com/netlify/gocommerce/settings"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const interval = 5 * time.Minute
+
+// Instance holds everything a job needs to work on a single instance.
+type Instance struct {
+	ID     string
+	Config *conf.Configuration
+	Mailer mailer.Mailer
+
+	providers map[string]payments.Provider
+	settings  *settings.Cache
+}
+
+// PaymentProviders returns the payment providers enabled for the instance.
+// They are only created on first use, as some providers call out to their
+// API when being set up.
+func (i *Instance) PaymentProviders() (map[string]payments.Provider, error) {
+	if i.providers == nil {
+		provs, err := providers.New(i.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating payment providers")
+		}
+		i.providers = provs
+	}
+	return i.providers, nil
+}
+
+// Settings returns the site settings of the instance. Like the payment
+// providers they are only fetched on first use.
+func (i *Instance) Settings() (*calculator.Settings, error) {
+	if i.settings == nil {
+		i.settings = settings.NewCache(i.Config)
+	}
+	return i.settings.Load()
+}
+
+// Job is a task that runs periodically for every instance.
+type Job func(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error
+
+var jobs = map[string]Job{
+	"abandoned_checkout":    SendCheckoutReminders,
+	"expire_orders":         ExpirePendingOrders,
+	"delete_expired_orders": DeleteExpiredOrders,
+	"renew_subscriptions":   RenewSubscriptions,
+	"retry_subscriptions":   RetrySubscriptionPayments,
+	"remind_invoices":       RemindOverdueInvoices,
+}
+
+// Run starts running all jobs in the background. With a config the jobs run
+// for that single instance, otherwise for every instance in the database.
+func Run(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log logrus.FieldLogger) {
+	go func() {
+		for {
+			instances, err := loadInstances(db, globalConfig, config)
+			if err != nil {
+				log.WithError(err).Error("Error loading instances for jobs")
+			}
+
+			now := time.Now()
+			for _, instance := range instances {
+				for name, job := range jobs {
+					jobLog := log.WithFields(logrus.Fields{
+						"job":         name,
+						"instance_id": instance.ID,
+					})
+					if err := job(db, instance, now, jobLog); err != nil {
+						jobLog.WithError(err).Error("Error running job")
+					}
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// RunOnce runs the named jobs a single time, for the same instances Run
+// would use.
+func RunOnce(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log logrus.FieldLogger, names ...string) error {
+	instances, err := loadInstances(db, globalConfig, config)
+	if err != nil {
+		return errors.Wrap(err, "error loading instances")
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		job, ok := jobs[name]
+		if !ok {
+			return fmt.Errorf("unknown job %s", name)
+		}
+		for _, instance := range instances {
+			jobLog := log.WithFields(logrus.Fields{
+				"job":         name,
+				"instance_id": instance.ID,
+			})
+			if err := job(db, instance, now, jobLog); err != nil {
+				return errors.Wrapf(err, "error running %s for instance '%s'", name, instance.ID)
+			}
+		}
+	}
+	return nil
+}
+
+func loadInstances(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration) ([]*Instance, error) {
+	if config != nil {
+		return []*Instance{{
+			Config: config,
+			Mailer: mailer.NewMailer(globalConfig.SMTP, config),
+		}}, nil
+	}
+
+	var rows []*models.Instance
+	if result := db.Find(&rows); result.Error != nil {
+		return nil, result.Error
+	}
+
+	instances := []*Instance{}
+	for _, row := range rows {
+		config, err := row.Config()
+		if err != nil {
+			continue
+		}
+		instances = append(instances, &Instance{
+			ID:     row.ID,
+			Config: config,
+			Mailer: mailer.NewMailer(globalConfig.SMTP, config),
+		})
+	}
+	return instances, nil
+}
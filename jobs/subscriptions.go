@@ -0,0 +1,289 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RenewSubscriptions renews the active subscriptions whose current period
+// ended. Every renewal is a new order with its own invoice number, charged
+// with the payment details of the last payment of the subscription. A
+// subscription whose renewal can't be charged is past due, and retried by
+// RetrySubscriptionPayments. Errors of a single subscription are logged and
+// don't stop the others from renewing.
+func RenewSubscriptions(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error {
+	subscriptions, err := models.DueSubscriptions(db, instance.ID, now)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	settings, err := instance.Settings()
+	if err != nil {
+		return errors.Wrap(err, "Error loading site settings")
+	}
+	for _, subscription := range subscriptions {
+		subLog := log.WithField("subscription_id", subscription.ID)
+		if err := renewSubscription(db, instance, settings, subscription, now, subLog); err != nil {
+			subLog.WithError(err).Error("Error renewing subscription")
+		}
+	}
+	return nil
+}
+
+func renewSubscription(db *gorm.DB, instance *Instance, settings *calculator.Settings, subscription *models.Subscription, now time.Time, log logrus.FieldLogger) error {
+	previous := &models.Order{}
+	query := db.
+		Preload("LineItems").
+		Preload("LineItems.PriceItems").
+		Preload("LineItems.AddonItems").
+		Preload("ShippingAddress").
+		Preload("BillingAddress")
+	if result := query.First(previous, "id = ?", subscription.LastOrderID); result.Error != nil {
+		err := errors.Wrapf(result.Error, "Error loading order %s", subscription.LastOrderID)
+		if result.RecordNotFound() {
+			return pauseBrokenSubscription(db, subscription, now, err)
+		}
+		return err
+	}
+	order, err := subscription.NewRenewalOrder(previous)
+	if err != nil {
+		return pauseBrokenSubscription(db, subscription, now, err)
+	}
+	order.CalculateTotal(settings, order.Claims, log)
+
+	tx := db.Begin()
+	claimed, err := subscription.ClaimRenewal(tx, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !claimed {
+		tx.Rollback()
+		log.Debug("Subscription is renewed by another process")
+		return nil
+	}
+	order.InvoiceNumber, err = models.NextInvoiceNumber(tx, order.InstanceID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "Error generating invoice number")
+	}
+	if result := tx.Create(order); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "Error creating renewal order")
+	}
+	return chargeRenewalOrder(db, tx, instance, subscription, order, now, log.WithField("order_id", order.ID))
+}
+
+// pauseBrokenSubscription pauses a subscription that can't be charged because
+// of its data, like a missing order, so it isn't picked up again on every
+// run. An admin can resume it once the problem is fixed.
+func pauseBrokenSubscription(db *gorm.DB, subscription *models.Subscription, now time.Time, cause error) error {
+	subscription.Transition(models.SubscriptionPausedState, now)
+	updates := map[string]interface{}{"status": subscription.Status, "paused_at": subscription.PausedAt}
+	if result := db.Model(subscription).UpdateColumns(updates); result.Error != nil {
+		return errors.Wrapf(result.Error, "Error pausing subscription after: %v", cause)
+	}
+	return errors.Wrap(cause, "Paused subscription")
+}
+
+// RetrySubscriptionPayments retries the payments of past due subscriptions
+// on the dunning schedule. Every attempt is recorded as a transaction of the
+// unpaid renewal order. Like renewals, errors of a single subscription don't
+// stop the others from being retried.
+func RetrySubscriptionPayments(db *gorm.DB, instance *Instance, now time.Time, log logrus.FieldLogger) error {
+	subscriptions, err := models.RetryDueSubscriptions(db, instance.ID, now)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		subLog := log.WithFields(logrus.Fields{
+			"subscription_id": subscription.ID,
+			"order_id":        subscription.PastDueOrderID,
+		})
+		if err := retrySubscriptionPayment(db, instance, subscription, now, subLog); err != nil {
+			subLog.WithError(err).Error("Error retrying subscription payment")
+		}
+	}
+	return nil
+}
+
+func retrySubscriptionPayment(db *gorm.DB, instance *Instance, subscription *models.Subscription, now time.Time, log logrus.FieldLogger) error {
+	order := &models.Order{}
+	query := db.
+		Preload("LineItems").
+		Preload("ShippingAddress").
+		Preload("BillingAddress")
+	if result := query.First(order, "id = ?", subscription.PastDueOrderID); result.Error != nil {
+		err := errors.Wrapf(result.Error, "Error loading order %s", subscription.PastDueOrderID)
+		if result.RecordNotFound() {
+			return pauseBrokenSubscription(db, subscription, now, err)
+		}
+		return err
+	}
+	if order.PaymentState == models.PaidState {
+		// the customer paid the renewal order in the meantime
+		return renewedWithPaidOrder(db, instance, subscription, order, log)
+	}
+
+	tx := db.Begin()
+	claimed, err := subscription.ClaimRetry(tx, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !claimed {
+		tx.Rollback()
+		log.Debug("Subscription payment is retried by another process")
+		return nil
+	}
+	return chargeRenewalOrder(db, tx, instance, subscription, order, now, log)
+}
+
+// renewedWithPaidOrder renews a past due subscription whose renewal order was
+// already paid, without charging it again.
+func renewedWithPaidOrder(db *gorm.DB, instance *Instance, subscription *models.Subscription, order *models.Order, log logrus.FieldLogger) error {
+	paymentID := subscription.PaymentID
+	paid := &models.Transaction{}
+	result := db.Where("order_id = ? AND type = ? AND status = ?", order.ID, models.ChargeTransactionType, models.PaidState).First(paid)
+	if result.Error != nil && !result.RecordNotFound() {
+		return errors.Wrapf(result.Error, "Error loading payment of order %s", order.ID)
+	}
+	if paid.ProcessorID != "" {
+		paymentID = paid.ProcessorID
+	}
+	subscription.Renewed(order, paymentID)
+
+	tx := db.Begin()
+	if result := tx.Save(subscription); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "Error saving subscription")
+	}
+	saveHook(tx, instance, "subscription", instance.Config.Webhooks.Subscription, subscription.UserID, subscription, log)
+	if result := tx.Commit(); result.Error != nil {
+		return errors.Wrap(result.Error, "Error renewing subscription")
+	}
+	log.Info("Renewal order was already paid, renewed subscription")
+	return nil
+}
+
+// chargeRenewalOrder charges the renewal order of the subscription. The
+// attempt is recorded as a pending transaction and committed with tx before
+// the payment provider is called, so a charge is never lost when saving its
+// result fails. A failed payment moves the subscription along the dunning
+// schedule, and once all retries failed the subscription is canceled or
+// paused.
+func chargeRenewalOrder(db *gorm.DB, tx *gorm.DB, instance *Instance, subscription *models.Subscription, order *models.Order, now time.Time, log logrus.FieldLogger) error {
+	config := instance.Config
+
+	tr := models.NewTransaction(order)
+	tr.InvoiceNumber = order.InvoiceNumber
+	tr.Status = models.PendingState
+	if result := tx.Create(tr); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "Error creating transaction")
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return errors.Wrap(result.Error, "Error creating transaction")
+	}
+
+	processorID, chargeErr := chargeRenewal(instance, subscription, order, log)
+	tr.ProcessorID = processorID
+	if chargeErr != nil {
+		tr.FailureCode = strconv.Itoa(http.StatusInternalServerError)
+		tr.FailureDescription = chargeErr.Error()
+		tr.Status = models.FailedState
+		order.PaymentState = models.FailedState
+		if subscription.PaymentFailed(order, now, config.Dunning.RetryDelays) {
+			if config.Dunning.FinalAction == "pause" {
+				subscription.Transition(models.SubscriptionPausedState, now)
+			} else {
+				subscription.Transition(models.SubscriptionCanceledState, now)
+			}
+		}
+	} else {
+		tr.Status = models.PaidState
+		order.PaymentState = models.PaidState
+		subscription.Renewed(order, processorID)
+	}
+
+	tx = db.Begin()
+	if result := tx.Save(tr); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrapf(result.Error, "Error saving transaction %s of payment %s", tr.ID, processorID)
+	}
+	if result := tx.Save(order); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "Error saving renewal order")
+	}
+	if result := tx.Save(subscription); result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "Error saving subscription")
+	}
+
+	if order.PaymentState == models.PaidState {
+		saveHook(tx, instance, "payment", config.Webhooks.Payment, order.UserID, order, log)
+	}
+	saveHook(tx, instance, "subscription", config.Webhooks.Subscription, subscription.UserID, subscription, log)
+	if result := tx.Commit(); result.Error != nil {
+		return errors.Wrap(result.Error, "Error renewing subscription")
+	}
+
+	if chargeErr != nil {
+		log.WithError(chargeErr).Warnf("Renewal payment failed, subscription is %s", subscription.Status)
+		if err := instance.Mailer.PaymentFailedMail(order, subscription); err != nil {
+			log.WithError(err).Error("Error sending payment failed mail")
+		}
+		return nil
+	}
+	if err := instance.Mailer.OrderConfirmationMail(tr); err != nil {
+		log.WithError(err).Error("Error sending order confirmation mail")
+	}
+	if err := instance.Mailer.OrderReceivedMail(tr); err != nil {
+		log.WithError(err).Error("Error sending order received mail")
+	}
+	log.Infof("Renewed subscription until %v", subscription.CurrentPeriodEnd)
+	return nil
+}
+
+// chargeRenewal charges the renewal order with the payment provider of the
+// subscription.
+func chargeRenewal(instance *Instance, subscription *models.Subscription, order *models.Order, log logrus.FieldLogger) (string, error) {
+	provs, err := instance.PaymentProviders()
+	if err != nil {
+		return "", err
+	}
+	provider := provs[subscription.PaymentProcessor]
+	if provider == nil {
+		return "", fmt.Errorf("Payment provider '%s' not configured", subscription.PaymentProcessor)
+	}
+	renew, err := provider.NewRenewer(context.Background(), nil, log.WithField("component", "payment_provider"))
+	if err != nil {
+		return "", err
+	}
+	return renew(subscription.PaymentID, order.Total, order.Currency, order, order.InvoiceNumber, subscription.RenewalKey())
+}
+
+func saveHook(tx *gorm.DB, instance *Instance, hookType, hookURL, userID string, payload interface{}, log logrus.FieldLogger) {
+	if hookURL == "" {
+		return
+	}
+	hook, err := models.NewHook(hookType, instance.Config.SiteURL, hookURL, userID, instance.Config.Webhooks.Secret, payload)
+	if err != nil {
+		log.WithError(err).Error("Failed to process webhook")
+		return
+	}
+	tx.Save(hook)
+}
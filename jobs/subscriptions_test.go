@@ -0,0 +1,437 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+)
+
+type renewCall struct {
+	previousPaymentID string
+	amount            uint64
+	invoiceNumber     int64
+}
+
+type renewingProvider struct {
+	voidingProvider
+	renewCalls []renewCall
+	keys       []string
+	fail       bool
+	onRenew    func(order *models.Order)
+}
+
+func (p *renewingProvider) NewRenewer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Renewer, error) {
+	return func(previousPaymentID string, amount uint64, currency string, order *models.Order, invoiceNumber int64, idempotencyKey string) (string, error) {
+		p.renewCalls = append(p.renewCalls, renewCall{previousPaymentID, amount, invoiceNumber})
+		p.keys = append(p.keys, idempotencyKey)
+		if p.onRenew != nil {
+			p.onRenew(order)
+		}
+		if p.fail {
+			return "", errors.New("Your card was declined")
+		}
+		return fmt.Sprintf("pi_%d", len(p.renewCalls)+1), nil
+	}, nil
+}
+
+func subscriptionInstance(t *testing.T) (*Instance, *renewingProvider) {
+	return subscriptionInstanceWithSettings(t, `{"taxes": [{"percentage": 7, "product_types": ["Magazine"], "countries": ["Germany"]}]}`)
+}
+
+func subscriptionInstanceWithSettings(t *testing.T, settings string) (*Instance, *renewingProvider) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, settings)
+	}))
+	t.Cleanup(site.Close)
+
+	instance, _ := testInstance()
+	instance.Config.SiteURL = site.URL
+	provider := &renewingProvider{}
+	instance.providers = map[string]payments.Provider{payments.StripeProvider: provider}
+	return instance, provider
+}
+
+func createSubscription(t *testing.T, db *gorm.DB, periodEnd time.Time) *models.Subscription {
+	order := models.NewOrder("", "session", "subscriber@example.com", "EUR")
+	order.PaymentState = models.PaidState
+	order.PaymentProcessor = payments.StripeProvider
+	order.ShippingAddress = models.Address{
+		ID:             "subscriber-address",
+		AddressRequest: models.AddressRequest{Name: "Subscriber", Address1: "Street 1", City: "Berlin", Country: "Germany", Zip: "10115"},
+	}
+	order.BillingAddress = order.ShippingAddress
+	order.LineItems = []*models.LineItem{{
+		OrderID:  order.ID,
+		Sku:      "magazine",
+		Title:    "Magazine",
+		Type:     "Magazine",
+		Price:    1000,
+		Quantity: 2,
+		Interval: models.MonthInterval,
+	}}
+	require.NoError(t, db.Create(order).Error)
+
+	subscription := models.NewSubscriptions(order, "pi_1", periodEnd.AddDate(0, -1, 0))[0]
+	require.NoError(t, db.Create(subscription).Error)
+	return subscription
+}
+
+func TestRenewSubscriptions(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	now := time.Now()
+	periodEnd := now.Add(-time.Hour).Truncate(time.Second)
+
+	due := createSubscription(t, db, periodEnd)
+	current := createSubscription(t, db, now.Add(time.Hour))
+	paused := createSubscription(t, db, periodEnd)
+	paused.Transition(models.SubscriptionPausedState, now)
+	require.NoError(t, db.Save(paused).Error)
+
+	provider.onRenew = func(order *models.Order) {
+		// the attempt is recorded before the card is charged
+		pending := &models.Transaction{}
+		require.NoError(t, db.First(pending, "order_id = ?", order.ID).Error)
+		assert.Equal(t, models.PendingState, pending.Status)
+	}
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+
+	require.Len(t, provider.renewCalls, 1)
+	assert.Equal(t, renewCall{"pi_1", 2140, 1}, provider.renewCalls[0])
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", due.ID).Error)
+	assert.Equal(t, models.SubscriptionActiveState, saved.Status)
+	assert.Equal(t, "pi_2", saved.PaymentID)
+	assert.True(t, periodEnd.Equal(saved.CurrentPeriodStart))
+	assert.True(t, periodEnd.AddDate(0, 1, 0).Equal(saved.CurrentPeriodEnd))
+
+	renewal := &models.Order{}
+	require.NoError(t, db.Preload("LineItems").Preload("Transactions").First(renewal, "id = ?", saved.LastOrderID).Error)
+	assert.NotEqual(t, due.OrderID, renewal.ID)
+	assert.Equal(t, due.ID, renewal.SubscriptionID)
+	assert.Equal(t, models.PaidState, renewal.PaymentState)
+	assert.EqualValues(t, 1, renewal.InvoiceNumber)
+	assert.EqualValues(t, 140, renewal.Taxes)
+	assert.EqualValues(t, 2140, renewal.Total)
+	require.Len(t, renewal.LineItems, 1)
+	assert.EqualValues(t, 2, renewal.LineItems[0].Quantity)
+	require.Len(t, renewal.Transactions, 1)
+	assert.Equal(t, models.PaidState, renewal.Transactions[0].Status)
+	assert.Equal(t, "pi_2", renewal.Transactions[0].ProcessorID)
+
+	for _, subscription := range []*models.Subscription{current, paused} {
+		unchanged := &models.Subscription{}
+		require.NoError(t, db.First(unchanged, "id = ?", subscription.ID).Error)
+		assert.Equal(t, subscription.OrderID, unchanged.LastOrderID)
+	}
+
+	// the renewed subscription isn't due again until the end of its new period
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	assert.Len(t, provider.renewCalls, 1)
+}
+
+func TestRenewSubscriptionsKeepsPriceData(t *testing.T) {
+	db := testDB(t)
+	settingsJSON := `{
+		"taxes": [
+			{"percentage": 7, "product_types": ["Magazine"], "countries": ["Germany"]},
+			{"percentage": 19, "product_types": ["Download"], "countries": ["Germany"]}
+		],
+		"member_discounts": [{"claims": {"app_metadata.plan": "member"}, "percentage": 10}]
+	}`
+	instance, provider := subscriptionInstanceWithSettings(t, settingsJSON)
+	now := time.Now()
+
+	order := models.NewOrder("", "session", "subscriber@example.com", "EUR")
+	order.PaymentState = models.PaidState
+	order.PaymentProcessor = payments.StripeProvider
+	order.ShippingAddress = models.Address{
+		ID:             "member-address",
+		AddressRequest: models.AddressRequest{Name: "Member", Address1: "Street 1", City: "Berlin", Country: "Germany", Zip: "10115"},
+	}
+	order.BillingAddress = order.ShippingAddress
+	order.Claims = map[string]interface{}{"app_metadata": map[string]interface{}{"plan": "member"}}
+	order.LineItems = []*models.LineItem{{
+		OrderID:  order.ID,
+		Sku:      "bundle",
+		Title:    "Magazine with downloads",
+		Type:     "Magazine",
+		Price:    1500,
+		Quantity: 1,
+		Interval: models.MonthInterval,
+		PriceItems: []*models.PriceItem{
+			{Amount: 600, Type: "Magazine"},
+			{Amount: 400, Type: "Download"},
+		},
+		AddonItems: []*models.AddonItem{{Sku: "poster", Title: "Poster", Price: 500}},
+		AddonPrice: 500,
+	}}
+	settings := &calculator.Settings{}
+	require.NoError(t, json.Unmarshal([]byte(settingsJSON), settings))
+	order.CalculateTotal(settings, order.Claims, testLogger)
+	require.NoError(t, db.Create(order).Error)
+
+	subscription := models.NewSubscriptions(order, "pi_1", now.AddDate(0, -1, -1))[0]
+	require.NoError(t, db.Create(subscription).Error)
+
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	require.Len(t, provider.renewCalls, 1)
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", subscription.ID).Error)
+	renewal := &models.Order{}
+	require.NoError(t, db.Preload("LineItems").Preload("LineItems.PriceItems").Preload("LineItems.AddonItems").First(renewal, "id = ?", saved.LastOrderID).Error)
+	assert.NotZero(t, renewal.Discount)
+	assert.Equal(t, order.Discount, renewal.Discount)
+	assert.Equal(t, order.Taxes, renewal.Taxes)
+	assert.Equal(t, order.Total, renewal.Total)
+	assert.Equal(t, order.TaxItems, renewal.TaxItems)
+	assert.Equal(t, order.Total, provider.renewCalls[0].amount)
+	require.Len(t, renewal.LineItems, 1)
+	assert.Len(t, renewal.LineItems[0].PriceItems, 2)
+	require.Len(t, renewal.LineItems[0].AddonItems, 1)
+	assert.Equal(t, "poster", renewal.LineItems[0].AddonItems[0].Sku)
+}
+
+func TestRenewSubscriptionsPaymentFailed(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	provider.fail = true
+	now := time.Now()
+
+	subscription := createSubscription(t, db, now.Add(-time.Hour))
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	require.Len(t, provider.renewCalls, 1)
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", subscription.ID).Error)
+	assert.Equal(t, models.SubscriptionPastDueState, saved.Status)
+	assert.Equal(t, "pi_1", saved.PaymentID)
+	assert.Equal(t, subscription.OrderID, saved.LastOrderID)
+
+	renewal := &models.Order{}
+	require.NoError(t, db.Preload("Transactions").First(renewal, "subscription_id = ?", subscription.ID).Error)
+	assert.Equal(t, models.FailedState, renewal.PaymentState)
+	require.Len(t, renewal.Transactions, 1)
+	assert.Equal(t, models.FailedState, renewal.Transactions[0].Status)
+	assert.Equal(t, "Your card was declined", renewal.Transactions[0].FailureDescription)
+	assert.Equal(t, renewal.ID, saved.PastDueOrderID)
+	assert.Nil(t, saved.NextRetryAt)
+
+	mailer := instance.Mailer.(*recordingMailer)
+	assert.Equal(t, []paymentFailure{{renewal.ID, 1, models.SubscriptionPastDueState}}, mailer.paymentFailures)
+
+	// past due subscriptions aren't renewed by the regular schedule, and
+	// without dunning they aren't retried either
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(time.Hour), testLogger))
+	assert.Len(t, provider.renewCalls, 1)
+}
+
+func TestRetrySubscriptionPayments(t *testing.T) {
+	for _, finalAction := range []string{"cancel", "pause"} {
+		t.Run(finalAction, func(t *testing.T) {
+			db := testDB(t)
+			instance, provider := subscriptionInstance(t)
+			instance.Config.Dunning.RetryDelays = []int{24, 72}
+			instance.Config.Dunning.FinalAction = finalAction
+			provider.fail = true
+			now := time.Now()
+
+			subscription := createSubscription(t, db, now.Add(-time.Hour))
+			require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+
+			// nothing to retry before the first delay passed
+			require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(23*time.Hour), testLogger))
+			require.Len(t, provider.renewCalls, 1)
+
+			require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(24*time.Hour), testLogger))
+			require.Len(t, provider.renewCalls, 2)
+			saved := &models.Subscription{}
+			require.NoError(t, db.First(saved, "id = ?", subscription.ID).Error)
+			assert.Equal(t, models.SubscriptionPastDueState, saved.Status)
+			assert.Equal(t, 2, saved.FailedAttempts)
+			require.NotNil(t, saved.NextRetryAt)
+			assert.WithinDuration(t, now.Add(72*time.Hour), *saved.NextRetryAt, time.Second)
+
+			require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(72*time.Hour), testLogger))
+			require.Len(t, provider.renewCalls, 3)
+			assert.Equal(t, provider.renewCalls[0], provider.renewCalls[2])
+			// every attempt is a new charge for the payment provider
+			assert.NotEqual(t, provider.keys[0], provider.keys[1])
+			assert.NotEqual(t, provider.keys[1], provider.keys[2])
+
+			final := &models.Subscription{}
+			require.NoError(t, db.First(final, "id = ?", subscription.ID).Error)
+			expected := models.SubscriptionCanceledState
+			if finalAction == "pause" {
+				expected = models.SubscriptionPausedState
+			}
+			assert.Equal(t, expected, final.Status)
+			assert.Nil(t, final.NextRetryAt)
+
+			renewal := &models.Order{}
+			require.NoError(t, db.Preload("Transactions").First(renewal, "id = ?", final.PastDueOrderID).Error)
+			assert.Equal(t, models.FailedState, renewal.PaymentState)
+			assert.Len(t, renewal.Transactions, 3)
+
+			mailer := instance.Mailer.(*recordingMailer)
+			assert.Equal(t, []paymentFailure{
+				{renewal.ID, 1, models.SubscriptionPastDueState},
+				{renewal.ID, 2, models.SubscriptionPastDueState},
+				{renewal.ID, 3, expected},
+			}, mailer.paymentFailures)
+
+			require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(100*time.Hour), testLogger))
+			assert.Len(t, provider.renewCalls, 3)
+		})
+	}
+}
+
+func TestRetrySubscriptionPaymentsRecovered(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	instance.Config.Dunning.RetryDelays = []int{24}
+	provider.fail = true
+	now := time.Now()
+	periodEnd := now.Add(-time.Hour).Truncate(time.Second)
+
+	subscription := createSubscription(t, db, periodEnd)
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+
+	provider.fail = false
+	require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(24*time.Hour), testLogger))
+	require.Len(t, provider.renewCalls, 2)
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", subscription.ID).Error)
+	assert.Equal(t, models.SubscriptionActiveState, saved.Status)
+	assert.Equal(t, "pi_3", saved.PaymentID)
+	assert.Empty(t, saved.PastDueOrderID)
+	assert.Zero(t, saved.FailedAttempts)
+	assert.True(t, periodEnd.AddDate(0, 1, 0).Equal(saved.CurrentPeriodEnd))
+
+	renewal := &models.Order{}
+	require.NoError(t, db.Preload("Transactions").First(renewal, "id = ?", saved.LastOrderID).Error)
+	assert.Equal(t, subscription.ID, renewal.SubscriptionID)
+	assert.Equal(t, models.PaidState, renewal.PaymentState)
+	require.Len(t, renewal.Transactions, 2)
+}
+
+func TestRetrySubscriptionPaymentsAlreadyPaid(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	instance.Config.Dunning.RetryDelays = []int{24}
+	provider.fail = true
+	now := time.Now()
+
+	subscription := createSubscription(t, db, now.Add(-time.Hour))
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+
+	renewal := &models.Order{}
+	require.NoError(t, db.First(renewal, "subscription_id = ?", subscription.ID).Error)
+	require.NoError(t, db.Model(renewal).UpdateColumn("payment_state", models.PaidState).Error)
+	paid := models.NewTransaction(renewal)
+	paid.ProcessorID = "pi_by_hand"
+	paid.Status = models.PaidState
+	require.NoError(t, db.Create(paid).Error)
+
+	require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(24*time.Hour), testLogger))
+	assert.Len(t, provider.renewCalls, 1)
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", subscription.ID).Error)
+	assert.Equal(t, models.SubscriptionActiveState, saved.Status)
+	assert.Equal(t, "pi_by_hand", saved.PaymentID)
+	assert.Equal(t, renewal.ID, saved.LastOrderID)
+	assert.Empty(t, saved.PastDueOrderID)
+	assert.Nil(t, saved.NextRetryAt)
+}
+
+func TestRenewSubscriptionsClaimed(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	now := time.Now()
+
+	subscription := createSubscription(t, db, now.Add(-time.Hour))
+	other := &models.Subscription{}
+	require.NoError(t, db.First(other, "id = ?", subscription.ID).Error)
+
+	// another process renews the subscription first
+	claimed, err := other.ClaimRenewal(db, now)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = subscription.ClaimRenewal(db, now)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	assert.Empty(t, provider.renewCalls)
+}
+
+func TestRenewSubscriptionsBroken(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	now := time.Now()
+
+	broken := createSubscription(t, db, now.Add(-2*time.Hour))
+	require.NoError(t, db.Delete(&models.Order{ID: broken.LastOrderID}).Error)
+	due := createSubscription(t, db, now.Add(-time.Hour))
+
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	assert.Len(t, provider.renewCalls, 1)
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", broken.ID).Error)
+	assert.Equal(t, models.SubscriptionPausedState, saved.Status)
+	renewed := &models.Subscription{}
+	require.NoError(t, db.First(renewed, "id = ?", due.ID).Error)
+	assert.Equal(t, models.SubscriptionActiveState, renewed.Status)
+	assert.NotEqual(t, due.OrderID, renewed.LastOrderID)
+
+	// the paused subscription isn't picked up again
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	assert.Len(t, provider.renewCalls, 1)
+}
+
+func TestRetrySubscriptionPaymentsBroken(t *testing.T) {
+	db := testDB(t)
+	instance, provider := subscriptionInstance(t)
+	instance.Config.Dunning.RetryDelays = []int{24}
+	provider.fail = true
+	now := time.Now()
+
+	broken := createSubscription(t, db, now.Add(-2*time.Hour))
+	due := createSubscription(t, db, now.Add(-time.Hour))
+	require.NoError(t, RenewSubscriptions(db, instance, now, testLogger))
+	require.Len(t, provider.renewCalls, 2)
+
+	saved := &models.Subscription{}
+	require.NoError(t, db.First(saved, "id = ?", broken.ID).Error)
+	require.NoError(t, db.Delete(&models.Order{ID: saved.PastDueOrderID}).Error)
+
+	require.NoError(t, RetrySubscriptionPayments(db, instance, now.Add(24*time.Hour), testLogger))
+	assert.Len(t, provider.renewCalls, 3)
+
+	paused := &models.Subscription{}
+	require.NoError(t, db.First(paused, "id = ?", broken.ID).Error)
+	assert.Equal(t, models.SubscriptionPausedState, paused.Status)
+	retried := &models.Subscription{}
+	require.NoError(t, db.First(retried, "id = ?", due.ID).Error)
+	assert.Equal(t, 2, retried.FailedAttempts)
+}
@@ -1,12 +1,12 @@
 package mailer
 
 import (
-	"fmt"
 	"log"
 	"time"
 
 	"github.com/netlify/gocommerce/conf"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
 	"github.com/netlify/mailme"
 	"github.com/sirupsen/logrus"
 )
@@ -16,6 +16,10 @@ type Mailer interface {
 	OrderConfirmationMail(transaction *models.Transaction) error
 	OrderReceivedMail(transaction *models.Transaction) error
 	OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error)
+	AbandonedCheckoutMail(order *models.Order, resumeURL string) error
+	PaymentFailedMail(order *models.Order, subscription *models.Subscription) error
+	InvoiceOverdueMail(order *models.Order, final bool) error
+	GiftCardMail(card *models.GiftCard, order *models.Order) error
 }
 
 type mailer struct {
@@ -66,7 +70,7 @@ func NewMailer(smtp conf.SMTPConfiguration, instanceConfig *conf.Configuration)
 			BaseURL: instanceConfig.SiteURL,
 			FuncMap: map[string]interface{}{
 				"dateFormat":     dateFormat,
-				"price":          price,
+				"price":          priceFormatter(instanceConfig.Locale),
 				"hasProductType": hasProductType,
 			},
 			Logger: logrus.New(),
@@ -78,14 +82,9 @@ func dateFormat(layout string, date time.Time) string {
 	return date.Format(layout)
 }
 
-func price(amount uint64, currency string) string {
-	switch currency {
-	case "USD":
-		return fmt.Sprintf("$%.2f", float64(amount)/100)
-	case "EUR":
-		return fmt.Sprintf("%.2f€", float64(amount)/100)
-	default:
-		return fmt.Sprintf("%.2f %v", float64(amount)/100, currency)
+func priceFormatter(locale string) func(uint64, string) string {
+	return func(amount uint64, currency string) string {
+		return money.Format(amount, currency, locale)
 	}
 }
 
@@ -102,12 +101,21 @@ const defaultConfirmationTemplate = `<h2>Thank you for your order!</h2>
 
 <ul>
 {{ range .Order.LineItems }}
-<li>{{ .Title }} <strong>{{ .Quantity }} x {{ .Price }}</strong></li>
+<li>{{ .Title }} <strong>{{ .Quantity }} x {{ price .Price $.Order.Currency }}</strong></li>
 {{ end }}
 </ul>
-
-<p>Total amount: <strong>{{ .Order.Total }}</strong></p>
-`
+{{ if .Order.TaxItems }}
+<p>Net amount: {{ price .Order.NetTotal .Order.Currency }}{{ if .Order.PricesIncludeTaxes }} (item prices include taxes){{ end }}</p>
+<ul>
+{{ range .Order.TaxItems }}
+<li>{{ if .Name }}{{ .Name }} {{ end }}{{ .Percentage }}% on {{ price .Taxable $.Order.Currency }}: {{ price .Amount $.Order.Currency }}</li>
+{{ end }}
+</ul>
+{{ end }}
+<p>Total amount: <strong>{{ price .Order.Total .Order.Currency }}</strong></p>
+{{ if .Order.ReverseCharge }}
+<p>VAT reverse charge: VAT is due by the buyer with VAT number {{ .Order.VATNumber }}.</p>
+{{ end }}`
 
 // OrderConfirmationMail sends an order confirmation to the user
 func (m *mailer) OrderConfirmationMail(transaction *models.Transaction) error {
@@ -129,12 +137,21 @@ const defaultReceivedTemplate = `<h2>Order Received From {{ .Order.Email }}</h2>
 
 <ul>
 {{ range .Order.LineItems }}
-<li>{{ .Title }} <strong>{{ .Quantity }} x {{ .Price }}</strong></li>
+<li>{{ .Title }} <strong>{{ .Quantity }} x {{ price .Price $.Order.Currency }}</strong></li>
 {{ end }}
 </ul>
-
-<p>Total amount: <strong>{{ .Order.Total }}</strong></p>
-`
+{{ if .Order.TaxItems }}
+<p>Net amount: {{ price .Order.NetTotal .Order.Currency }}{{ if .Order.PricesIncludeTaxes }} (item prices include taxes){{ end }}</p>
+<ul>
+{{ range .Order.TaxItems }}
+<li>{{ if .Name }}{{ .Name }} {{ end }}{{ .Percentage }}% on {{ price .Taxable $.Order.Currency }}: {{ price .Amount $.Order.Currency }}</li>
+{{ end }}
+</ul>
+{{ end }}
+<p>Total amount: <strong>{{ price .Order.Total .Order.Currency }}</strong></p>
+{{ if .Order.ReverseCharge }}
+<p>VAT reverse charge: VAT is due by the buyer with VAT number {{ .Order.VATNumber }}.</p>
+{{ end }}`
 
 // OrderReceivedMail sends a notification to the shop admin
 func (m *mailer) OrderReceivedMail(transaction *models.Transaction) error {
@@ -151,6 +168,135 @@ func (m *mailer) OrderReceivedMail(transaction *models.Transaction) error {
 	)
 }
 
+const defaultAbandonedCheckoutTemplate = `<h2>You didn't finish your order</h2>
+
+<ul>
+{{ range .Order.LineItems }}
+<li>{{ .Title }} <strong>{{ .Quantity }} x {{ price .Price $.Order.Currency }}</strong></li>
+{{ end }}
+</ul>
+
+<p>Total amount: <strong>{{ price .Order.Total .Order.Currency }}</strong></p>
+
+<p><a href="{{ .ResumeURL }}">Complete your order</a></p>
+`
+
+// AbandonedCheckoutMail reminds the customer of an unpaid order
+func (m *mailer) AbandonedCheckoutMail(order *models.Order, resumeURL string) error {
+	return m.TemplateMailer.Mail(
+		order.Email,
+		withDefault(m.Config.Mailer.Subjects.AbandonedCheckout, "Complete your order"),
+		m.Config.Mailer.Templates.AbandonedCheckout,
+		defaultAbandonedCheckoutTemplate,
+		map[string]interface{}{
+			"SiteURL":   m.Config.SiteURL,
+			"Order":     order,
+			"ResumeURL": resumeURL,
+		},
+	)
+}
+
+const defaultPaymentFailedSubject = `{{ if .Final }}Your subscription is {{ .Subscription.Status }}` +
+	`{{ else if gt .Attempt 1 }}Reminder: we still could not renew your subscription` +
+	`{{ else }}We could not renew your subscription{{ end }}`
+
+const defaultPaymentFailedTemplate = `{{ if .Final }}
+<h2>Your subscription to {{ .Subscription.Title }} is {{ .Subscription.Status }}</h2>
+<p>We tried to charge your renewal {{ .Attempt }} times without success.</p>
+{{ else }}
+<h2>The payment for your subscription to {{ .Subscription.Title }} failed</h2>
+{{ if .Subscription.NextRetryAt }}<p>We will try again on {{ dateFormat "January 2, 2006" .Subscription.NextRetryAt }}.</p>{{ end }}
+{{ end }}
+<p>Amount due: <strong>{{ price .Order.Total .Order.Currency }}</strong></p>
+`
+
+// PaymentFailedMail tells the customer that the renewal of a subscription
+// couldn't be charged. Reminders for later attempts escalate, up to the mail
+// for the subscription being canceled or paused once all retries failed.
+func (m *mailer) PaymentFailedMail(order *models.Order, subscription *models.Subscription) error {
+	return m.TemplateMailer.Mail(
+		order.Email,
+		withDefault(m.Config.Mailer.Subjects.PaymentFailed, defaultPaymentFailedSubject),
+		m.Config.Mailer.Templates.PaymentFailed,
+		defaultPaymentFailedTemplate,
+		m.paymentFailedData(order, subscription),
+	)
+}
+
+func (m *mailer) paymentFailedData(order *models.Order, subscription *models.Subscription) map[string]interface{} {
+	return map[string]interface{}{
+		"SiteURL":      m.Config.SiteURL,
+		"Order":        order,
+		"Subscription": subscription,
+		"Attempt":      subscription.FailedAttempts,
+		"Final":        subscription.Status == models.SubscriptionCanceledState || subscription.Status == models.SubscriptionPausedState,
+	}
+}
+
+const defaultInvoiceOverdueSubject = `{{ if .Canceled }}Your order was canceled` +
+	`{{ else if .Final }}Final reminder: your invoice is overdue` +
+	`{{ else if gt .Attempt 1 }}Reminder: your invoice is still unpaid` +
+	`{{ else }}Your invoice is overdue{{ end }}`
+
+const defaultInvoiceOverdueTemplate = `{{ if .Canceled }}
+<h2>Your order was canceled</h2>
+<p>We reminded you of invoice {{ .Order.InvoiceNumber }} {{ .Attempt }} times without receiving your payment.</p>
+{{ else }}
+<h2>Invoice {{ .Order.InvoiceNumber }} is overdue</h2>
+<p>It was due on {{ dateFormat "January 2, 2006" .Order.PaymentDueAt }}.{{ if .Final }} Please pay it right away.{{ end }}</p>
+{{ end }}
+<p>Amount due: <strong>{{ price .Order.Total .Order.Currency }}</strong></p>
+`
+
+// InvoiceOverdueMail reminds the customer of an unpaid manual invoice. Like
+// PaymentFailedMail the reminders escalate, and the final one tells the
+// customer that the order was canceled, or that no more reminders follow.
+func (m *mailer) InvoiceOverdueMail(order *models.Order, final bool) error {
+	return m.TemplateMailer.Mail(
+		order.Email,
+		withDefault(m.Config.Mailer.Subjects.InvoiceOverdue, defaultInvoiceOverdueSubject),
+		m.Config.Mailer.Templates.InvoiceOverdue,
+		defaultInvoiceOverdueTemplate,
+		m.invoiceOverdueData(order, final),
+	)
+}
+
+func (m *mailer) invoiceOverdueData(order *models.Order, final bool) map[string]interface{} {
+	return map[string]interface{}{
+		"SiteURL":  m.Config.SiteURL,
+		"Order":    order,
+		"Attempt":  order.InvoiceReminders,
+		"Final":    final,
+		"Canceled": order.PaymentState == models.FailedState,
+	}
+}
+
+const defaultGiftCardTemplate = `<h2>You received a gift card{{ if .Order }} from {{ .Order.Email }}{{ end }}</h2>
+
+{{ if .GiftCard.Message }}<p>{{ .GiftCard.Message }}</p>{{ end }}
+
+<p>Balance: <strong>{{ price .GiftCard.Balance .GiftCard.Currency }}</strong></p>
+
+<p>Redeem it at checkout with the code <strong>{{ .GiftCard.Code }}</strong></p>
+`
+
+// GiftCardMail sends the code of a gift card to its recipient. The order is
+// the one the gift card was bought with, and nil for gift cards issued by an
+// admin.
+func (m *mailer) GiftCardMail(card *models.GiftCard, order *models.Order) error {
+	return m.TemplateMailer.Mail(
+		card.RecipientEmail,
+		withDefault(m.Config.Mailer.Subjects.GiftCard, "You received a gift card"),
+		m.Config.Mailer.Templates.GiftCard,
+		defaultGiftCardTemplate,
+		map[string]interface{}{
+			"SiteURL":  m.Config.SiteURL,
+			"GiftCard": card,
+			"Order":    order,
+		},
+	)
+}
+
 func (m *mailer) OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error) {
 	if templateURL == "" {
 		templateURL = m.Config.Mailer.Templates.OrderConfirmation
@@ -1,10 +1,16 @@
 package mailer
 
 import (
+	"bytes"
+	"html/template"
 	"testing"
+	"time"
 
+	"github.com/netlify/gocommerce/calculator"
 	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNoopMailer(t *testing.T) {
@@ -24,3 +30,148 @@ func TestTemplateMailer(t *testing.T) {
 	m := NewMailer(smtp, conf)
 	assert.IsType(t, &mailer{}, m)
 }
+
+func TestPriceFormatter(t *testing.T) {
+	assert.Equal(t, "$9.99", priceFormatter("")(999, "USD"))
+	assert.Equal(t, "9,99 €", priceFormatter("de-DE")(999, "EUR"))
+	assert.Equal(t, "¥999", priceFormatter("en-US")(999, "JPY"))
+}
+
+func TestOrderMailTaxBreakdown(t *testing.T) {
+	smtp := conf.SMTPConfiguration{
+		Host: "localhost",
+		Port: 25,
+	}
+	m := NewMailer(smtp, &conf.Configuration{})
+
+	order := &models.Order{
+		Currency: "EUR",
+		NetTotal: 4500,
+		Total:    5115,
+		TaxItems: []calculator.TaxItem{
+			{Percentage: 7, Taxable: 2000, Amount: 140},
+			{Name: "VAT", Percentage: 19, Taxable: 2500, Amount: 475},
+		},
+	}
+	body, err := m.OrderConfirmationMailBody(&models.Transaction{Order: order}, "")
+	require.NoError(t, err)
+	assert.Contains(t, body, "Net amount: €45.00</p>")
+	assert.Contains(t, body, "<li>7% on €20.00: €1.40</li>")
+	assert.Contains(t, body, "<li>VAT 19% on €25.00: €4.75</li>")
+}
+
+func TestPaymentFailedMail(t *testing.T) {
+	smtp := conf.SMTPConfiguration{
+		Host: "localhost",
+		Port: 25,
+	}
+	m := NewMailer(smtp, &conf.Configuration{}).(*mailer)
+	subject, err := template.New("Subject").Funcs(template.FuncMap(m.TemplateMailer.FuncMap)).Parse(defaultPaymentFailedSubject)
+	require.NoError(t, err)
+
+	order := &models.Order{Currency: "USD", Total: 499}
+	retryAt := time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)
+	subscription := &models.Subscription{
+		Title:          "Magazine",
+		Status:         models.SubscriptionPastDueState,
+		FailedAttempts: 1,
+		NextRetryAt:    &retryAt,
+	}
+	render := func() (string, string) {
+		data := m.paymentFailedData(order, subscription)
+		subjectText := &bytes.Buffer{}
+		require.NoError(t, subject.Execute(subjectText, data))
+		body, err := m.TemplateMailer.MailBody("", defaultPaymentFailedTemplate, data)
+		require.NoError(t, err)
+		return subjectText.String(), body
+	}
+
+	subjectText, body := render()
+	assert.Equal(t, "We could not renew your subscription", subjectText)
+	assert.Contains(t, body, "We will try again on March 4, 2020.")
+	assert.Contains(t, body, "Amount due: <strong>$4.99</strong>")
+
+	subscription.FailedAttempts = 2
+	subjectText, _ = render()
+	assert.Equal(t, "Reminder: we still could not renew your subscription", subjectText)
+
+	subscription.FailedAttempts = 3
+	subscription.Status = models.SubscriptionCanceledState
+	subscription.NextRetryAt = nil
+	subjectText, body = render()
+	assert.Equal(t, "Your subscription is canceled", subjectText)
+	assert.Contains(t, body, "We tried to charge your renewal 3 times without success.")
+}
+
+func TestInvoiceOverdueMail(t *testing.T) {
+	smtp := conf.SMTPConfiguration{
+		Host: "localhost",
+		Port: 25,
+	}
+	m := NewMailer(smtp, &conf.Configuration{}).(*mailer)
+	subject, err := template.New("Subject").Funcs(template.FuncMap(m.TemplateMailer.FuncMap)).Parse(defaultInvoiceOverdueSubject)
+	require.NoError(t, err)
+
+	dueAt := time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)
+	order := &models.Order{
+		Currency:         "USD",
+		Total:            499,
+		InvoiceNumber:    12,
+		PaymentState:     models.PendingState,
+		PaymentDueAt:     &dueAt,
+		InvoiceReminders: 1,
+	}
+	render := func(final bool) (string, string) {
+		data := m.invoiceOverdueData(order, final)
+		subjectText := &bytes.Buffer{}
+		require.NoError(t, subject.Execute(subjectText, data))
+		body, err := m.TemplateMailer.MailBody("", defaultInvoiceOverdueTemplate, data)
+		require.NoError(t, err)
+		return subjectText.String(), body
+	}
+
+	subjectText, body := render(false)
+	assert.Equal(t, "Your invoice is overdue", subjectText)
+	assert.Contains(t, body, "Invoice 12 is overdue")
+	assert.Contains(t, body, "It was due on March 4, 2020.")
+	assert.Contains(t, body, "Amount due: <strong>$4.99</strong>")
+
+	order.InvoiceReminders = 2
+	subjectText, _ = render(false)
+	assert.Equal(t, "Reminder: your invoice is still unpaid", subjectText)
+
+	order.InvoiceReminders = 3
+	subjectText, body = render(true)
+	assert.Equal(t, "Final reminder: your invoice is overdue", subjectText)
+	assert.Contains(t, body, "Please pay it right away.")
+
+	order.PaymentState = models.FailedState
+	subjectText, body = render(true)
+	assert.Equal(t, "Your order was canceled", subjectText)
+	assert.Contains(t, body, "We reminded you of invoice 12 3 times without receiving your payment.")
+}
+
+func TestGiftCardMail(t *testing.T) {
+	smtp := conf.SMTPConfiguration{
+		Host: "localhost",
+		Port: 25,
+	}
+	m := NewMailer(smtp, &conf.Configuration{}).(*mailer)
+
+	card := &models.GiftCard{
+		Code:     "ABCD-EFGH-JKLM-NPQR",
+		Currency: "EUR",
+		Balance:  2500,
+		Message:  "Happy birthday!",
+	}
+	order := &models.Order{Email: "bruce@wayneindustries.com"}
+	body, err := m.TemplateMailer.MailBody("", defaultGiftCardTemplate, map[string]interface{}{
+		"GiftCard": card,
+		"Order":    order,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, body, "You received a gift card from bruce@wayneindustries.com")
+	assert.Contains(t, body, "<p>Happy birthday!</p>")
+	assert.Contains(t, body, "Balance: <strong>€25.00</strong>")
+	assert.Contains(t, body, "<strong>ABCD-EFGH-JKLM-NPQR</strong>")
+}
@@ -18,3 +18,19 @@ func (m *noopMailer) OrderReceivedMail(transaction *models.Transaction) error {
 func (m *noopMailer) OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error) {
 	return "Order Confirmed", nil
 }
+
+func (m *noopMailer) AbandonedCheckoutMail(order *models.Order, resumeURL string) error {
+	return nil
+}
+
+func (m *noopMailer) PaymentFailedMail(order *models.Order, subscription *models.Subscription) error {
+	return nil
+}
+
+func (m *noopMailer) InvoiceOverdueMail(order *models.Order, final bool) error {
+	return nil
+}
+
+func (m *noopMailer) GiftCardMail(card *models.GiftCard, order *models.Order) error {
+	return nil
+}
@@ -74,6 +74,15 @@ func AutoMigrate(db *gorm.DB) error {
 		Event{},
 		Instance{},
 		InvoiceNumber{},
+		Return{},
+		ReturnItem{},
+		Inventory{},
+		ExchangeRate{},
+		Promotion{},
+		Subscription{},
+		PaymentMethod{},
+		GiftCard{},
+		GiftCardEntry{},
 	)
 	return db.Error
 }
@@ -1,9 +1,9 @@
 package models
 
 import (
-	"math"
-	"strconv"
 	"time"
+
+	"github.com/netlify/gocommerce/money"
 )
 
 // FixedAmount represents an amount and currency pair
@@ -92,28 +92,11 @@ func (c *Coupon) FixedDiscount(currency string) uint64 {
 	if c.FixedAmount != nil {
 		for _, discount := range c.FixedAmount {
 			if discount.Currency == currency {
-				amount, _ := strconv.ParseFloat(discount.Amount, 64)
-				return rint(amount * 100)
+				amount, _ := money.Parse(discount.Amount, currency)
+				return amount
 			}
 		}
 	}
 
 	return 0
 }
-
-// Nopes - no `round` method in go
-// See https://gist.github.com/siddontang/1806573b9a8574989ccb
-func rint(x float64) uint64 {
-	v, frac := math.Modf(x)
-	if x > 0.0 {
-		if frac > 0.5 || (frac == 0.5 && uint64(v)%2 != 0) {
-			v += 1.0
-		}
-	} else {
-		if frac < -0.5 || (frac == -0.5 && uint64(v)%2 != 0) {
-			v -= 1.0
-		}
-	}
-
-	return uint64(v)
-}
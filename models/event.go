@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"strings"
 	"time"
 
@@ -22,7 +23,17 @@ type Event struct {
 	Type    string `json:"type"`
 	Changes string `json:"data"`
 
-	CreatedAt time.Time `json:"created_at"`
+	Values    []FieldChange `json:"values,omitempty" sql:"-"`
+	RawValues string        `json:"-" sql:"type:text"`
+
+	CreatedAt time.Time `json:"created_at" sql:"index"`
+}
+
+// FieldChange is the old and new value of a field changed by an event.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
 }
 
 // TableName returns the database table name for the Event model.
@@ -30,6 +41,26 @@ func (Event) TableName() string {
 	return tableName("events")
 }
 
+// AfterFind database callback.
+func (e *Event) AfterFind() error {
+	if e.RawValues != "" {
+		return json.Unmarshal([]byte(e.RawValues), &e.Values)
+	}
+	return nil
+}
+
+// BeforeSave database callback.
+func (e *Event) BeforeSave() error {
+	if e.Values != nil {
+		data, err := json.Marshal(e.Values)
+		if err != nil {
+			return err
+		}
+		e.RawValues = string(data)
+	}
+	return nil
+}
+
 // EventType is the type of change that occurred.
 type EventType string
 
@@ -40,6 +71,20 @@ const (
 	EventUpdated EventType = "updated"
 	// EventDeleted is the EventType when an order is deleted.
 	EventDeleted EventType = "deleted"
+	// EventReturnRequested is the EventType when a return is requested for an order.
+	EventReturnRequested EventType = "return_requested"
+	// EventReturnApproved is the EventType when a return is approved and refunded.
+	EventReturnApproved EventType = "return_approved"
+	// EventReturnRejected is the EventType when a return is rejected.
+	EventReturnRejected EventType = "return_rejected"
+	// EventReturnReceived is the EventType when the items of a return arrived back.
+	EventReturnReceived EventType = "return_received"
+	// EventSubscriptionPaused is the EventType when the subscription started by an order is paused.
+	EventSubscriptionPaused EventType = "subscription_paused"
+	// EventSubscriptionResumed is the EventType when the subscription started by an order is resumed.
+	EventSubscriptionResumed EventType = "subscription_resumed"
+	// EventSubscriptionCanceled is the EventType when the subscription started by an order is canceled.
+	EventSubscriptionCanceled EventType = "subscription_canceled"
 )
 
 // LogEvent logs a new event
@@ -55,3 +100,21 @@ func LogEvent(db *gorm.DB, ip, userID, orderID string, eventType EventType, chan
 	}
 	db.Create(event)
 }
+
+// LogChanges logs a new event including the old and new value of every
+// changed field.
+func LogChanges(db *gorm.DB, ip, userID, orderID string, eventType EventType, values []FieldChange) {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = v.Field
+	}
+	event := &Event{
+		IP:      ip,
+		UserID:  userID,
+		OrderID: orderID,
+		Type:    string(eventType),
+		Changes: strings.Join(fields, ","),
+		Values:  values,
+	}
+	db.Create(event)
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/money"
+	"github.com/pkg/errors"
+)
+
+// ExchangeRate is the price of one major unit of a currency in another. It is
+// used to price products that don't list a price in the order currency.
+type ExchangeRate struct {
+	InstanceID   string `json:"-" gorm:"unique_index:exchange_rate_instance_pair"`
+	ID           int64  `json:"-"`
+	FromCurrency string `json:"from" gorm:"unique_index:exchange_rate_instance_pair"`
+	ToCurrency   string `json:"to" gorm:"unique_index:exchange_rate_instance_pair"`
+	Rate         string `json:"rate"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the database table name for the ExchangeRate model.
+func (ExchangeRate) TableName() string {
+	return tableName("exchange_rates")
+}
+
+// GetExchangeRate loads the exchange rate between two currencies. It returns
+// nil if there is none.
+func GetExchangeRate(db *gorm.DB, instanceID, from, to string) (*ExchangeRate, error) {
+	rate := &ExchangeRate{}
+	result := db.Where("instance_id = ? AND from_currency = ? AND to_currency = ?", instanceID, from, to).First(rate)
+	if result.RecordNotFound() {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return rate, nil
+}
+
+// AppliedExchangeRate records an exchange rate used to price an order, so the
+// prices can be audited after the rate changed.
+type AppliedExchangeRate struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Rate     string `json:"rate"`
+	Rounding string `json:"rounding"`
+}
+
+// CurrencyConverter derives prices in currencies a product isn't listed in
+// from the exchange rates of an instance.
+type CurrencyConverter struct {
+	rates    map[string]*ExchangeRate
+	parsed   map[string]*big.Rat
+	rounding string
+}
+
+// LoadCurrencyConverter loads all exchange rates of the instance. Converted
+// prices are rounded with the rounding strategy. An unknown strategy only fails
+// the conversions, so orders that don't need one still go through.
+func LoadCurrencyConverter(db *gorm.DB, instanceID, rounding string) (*CurrencyConverter, error) {
+	var rates []*ExchangeRate
+	if result := db.Where("instance_id = ?", instanceID).Find(&rates); result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error loading exchange rates")
+	}
+
+	c := &CurrencyConverter{
+		rates:    map[string]*ExchangeRate{},
+		parsed:   map[string]*big.Rat{},
+		rounding: rounding,
+	}
+	if c.rounding == "" {
+		c.rounding = money.RoundNearest
+	}
+	for _, rate := range rates {
+		parsed, err := money.ParseRate(rate.Rate)
+		if err != nil {
+			return nil, err
+		}
+		key := pairKey(rate.FromCurrency, rate.ToCurrency)
+		c.rates[key] = rate
+		c.parsed[key] = parsed
+	}
+	return c, nil
+}
+
+// Convert converts an amount in minor units between two currencies. It
+// returns false if there is no exchange rate between them.
+func (c *CurrencyConverter) Convert(amount uint64, from, to string) (uint64, *AppliedExchangeRate, bool, error) {
+	if c == nil {
+		return 0, nil, false, nil
+	}
+	key := pairKey(from, to)
+	rate, ok := c.parsed[key]
+	if !ok {
+		return 0, nil, false, nil
+	}
+
+	converted, err := money.Convert(amount, from, to, rate, c.rounding)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return converted, &AppliedExchangeRate{
+		From:     c.rates[key].FromCurrency,
+		To:       c.rates[key].ToCurrency,
+		Rate:     c.rates[key].Rate,
+		Rounding: c.rounding,
+	}, true, nil
+}
+
+func pairKey(from, to string) string {
+	return strings.ToUpper(from) + "/" + strings.ToUpper(to)
+}
@@ -0,0 +1,412 @@
+package models
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// GiftCardActiveState is the state of a GiftCard that can be redeemed.
+const GiftCardActiveState = "active"
+
+// GiftCardVoidedState is the state of a GiftCard that was voided by an admin.
+const GiftCardVoidedState = "voided"
+
+// Types of the entries in the ledger of a GiftCard.
+const (
+	GiftCardIssueEntry  = "issue"
+	GiftCardRedeemEntry = "redeem"
+	GiftCardRefundEntry = "refund"
+	GiftCardAdjustEntry = "adjust"
+	GiftCardVoidEntry   = "void"
+)
+
+// codes leave out characters that are easily confused, like 0 and O
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ErrInsufficientBalance is returned when a gift card entry would make the
+// balance negative.
+var ErrInsufficientBalance = errors.New("Gift card balance is insufficient")
+
+// GiftCard is a balance in a currency that can be redeemed with its code to
+// pay for orders. Every change of the balance is an entry in its ledger.
+type GiftCard struct {
+	InstanceID string `json:"-" sql:"index"`
+	ID         string `json:"id"`
+	Code       string `json:"code" sql:"unique_index"`
+
+	Currency string `json:"currency"`
+	Balance  uint64 `json:"balance"`
+	Status   string `json:"status"`
+
+	// OrderID is the order the gift card was bought with, if it wasn't
+	// issued by an admin.
+	OrderID        string `json:"order_id,omitempty" sql:"index"`
+	RecipientEmail string `json:"recipient_email,omitempty"`
+	RecipientName  string `json:"recipient_name,omitempty"`
+	Message        string `json:"message,omitempty" sql:"type:text"`
+
+	Entries []GiftCardEntry `json:"entries,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"-" sql:"index"`
+}
+
+// TableName returns the database table name for the GiftCard model.
+func (GiftCard) TableName() string {
+	return tableName("gift_cards")
+}
+
+// BeforeDelete database callback.
+func (g *GiftCard) BeforeDelete(tx *gorm.DB) error {
+	tx = deleteScope(tx)
+	if result := tx.Delete(GiftCardEntry{}, "gift_card_id = ?", g.ID); result.Error != nil {
+		return errors.Wrap(result.Error, "Error deleting gift card entry records")
+	}
+	return nil
+}
+
+// GiftCardEntry is a change of the balance of a GiftCard.
+type GiftCardEntry struct {
+	ID         int64  `json:"id"`
+	GiftCardID string `json:"gift_card_id" sql:"index"`
+	OrderID    string `json:"order_id,omitempty" sql:"index"`
+	UserID     string `json:"user_id,omitempty"`
+
+	Type    string `json:"type"`
+	Amount  int64  `json:"amount"`
+	Balance uint64 `json:"balance"`
+	Note    string `json:"note,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName returns the database table name for the GiftCardEntry model.
+func (GiftCardEntry) TableName() string {
+	return tableName("gift_card_entries")
+}
+
+// NewGiftCard creates an active gift card with a new code. Its balance is set
+// by issuing it.
+func NewGiftCard(instanceID, currency string) (*GiftCard, error) {
+	code, err := newGiftCardCode()
+	if err != nil {
+		return nil, err
+	}
+	return &GiftCard{
+		InstanceID: instanceID,
+		ID:         uuid.NewRandom().String(),
+		Code:       code,
+		Currency:   strings.ToUpper(currency),
+		Status:     GiftCardActiveState,
+	}, nil
+}
+
+func newGiftCardCode() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Error generating gift card code")
+	}
+	code := make([]byte, 0, 19)
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			code = append(code, '-')
+		}
+		code = append(code, giftCardAlphabet[int(c)%len(giftCardAlphabet)])
+	}
+	return string(code), nil
+}
+
+// NormalizeGiftCardCode turns a code the way customers enter it into the way
+// it is stored.
+func NormalizeGiftCardCode(code string) string {
+	code = strings.ToUpper(strings.Replace(strings.Replace(code, "-", "", -1), " ", "", -1))
+	parts := []string{}
+	for len(code) > 4 {
+		parts = append(parts, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(parts, code), "-")
+}
+
+// Issue creates the gift card, recording its balance as the issued amount.
+func (g *GiftCard) Issue(tx *gorm.DB, orderID, userID, note string) error {
+	if result := tx.Create(g); result.Error != nil {
+		return errors.Wrap(result.Error, "Error creating gift card")
+	}
+	return g.addEntry(tx, GiftCardIssueEntry, int64(g.Balance), orderID, userID, note)
+}
+
+// Post changes the balance of the gift card by the amount and records it in
+// the ledger. It fails with ErrInsufficientBalance if the balance would
+// become negative, and for voided gift cards.
+func (g *GiftCard) Post(tx *gorm.DB, entryType string, amount int64, orderID, userID, note string) error {
+	if g.Status != GiftCardActiveState {
+		return fmt.Errorf("Gift card is %s", g.Status)
+	}
+	// the status and balance are checked by the update itself, so concurrent
+	// redemptions can't spend it twice or after it was voided
+	query := tx.Model(g).Where("status = ?", GiftCardActiveState)
+	var result *gorm.DB
+	if amount < 0 {
+		result = query.Where("balance >= ?", -amount).UpdateColumn("balance", gorm.Expr("balance - ?", -amount))
+	} else {
+		result = query.UpdateColumn("balance", gorm.Expr("balance + ?", amount))
+	}
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "Error updating gift card balance")
+	}
+
+	updated, err := g.reload(tx)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		if updated.Status != GiftCardActiveState {
+			return fmt.Errorf("Gift card is %s", updated.Status)
+		}
+		return ErrInsufficientBalance
+	}
+	g.Balance = updated.Balance
+	return g.addEntry(tx, entryType, amount, orderID, userID, note)
+}
+
+// Void takes the remaining balance off the gift card and stops it from being
+// redeemed.
+func (g *GiftCard) Void(tx *gorm.DB, userID, note string) error {
+	// voiding first stops redemptions, so the balance read afterwards is
+	// what is left
+	result := tx.Model(g).Where("status = ?", GiftCardActiveState).UpdateColumn("status", GiftCardVoidedState)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "Error voiding gift card")
+	}
+	updated, err := g.reload(tx)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("Gift card is %s", updated.Status)
+	}
+	if result := tx.Model(g).UpdateColumn("balance", gorm.Expr("balance - ?", updated.Balance)); result.Error != nil {
+		return errors.Wrap(result.Error, "Error voiding gift card")
+	}
+	g.Balance = 0
+	g.Status = GiftCardVoidedState
+	return g.addEntry(tx, GiftCardVoidEntry, -int64(updated.Balance), "", userID, note)
+}
+
+func (g *GiftCard) reload(tx *gorm.DB) (*GiftCard, error) {
+	updated := &GiftCard{}
+	if result := tx.First(updated, "id = ?", g.ID); result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error loading gift card")
+	}
+	return updated, nil
+}
+
+func (g *GiftCard) addEntry(tx *gorm.DB, entryType string, amount int64, orderID, userID, note string) error {
+	entry := &GiftCardEntry{
+		GiftCardID: g.ID,
+		OrderID:    orderID,
+		UserID:     userID,
+		Type:       entryType,
+		Amount:     amount,
+		Balance:    g.Balance,
+		Note:       note,
+	}
+	if result := tx.Create(entry); result.Error != nil {
+		return errors.Wrap(result.Error, "Error creating gift card entry")
+	}
+	return nil
+}
+
+// FindGiftCard loads the gift card of the instance with the code, or nil if
+// there is none.
+func FindGiftCard(db *gorm.DB, instanceID, code string) (*GiftCard, error) {
+	card := &GiftCard{}
+	result := db.First(card, "instance_id = ? AND code = ?", instanceID, NormalizeGiftCardCode(code))
+	if result.RecordNotFound() {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error loading gift card")
+	}
+	return card, nil
+}
+
+// RedeemGiftCard pays as much of the order total as the balance of the gift
+// card allows and takes it off the balance.
+func RedeemGiftCard(tx *gorm.DB, order *Order, card *GiftCard) error {
+	if card.Currency != order.Currency {
+		return fmt.Errorf("Gift card is in %s, not %s", card.Currency, order.Currency)
+	}
+	amount := card.Balance
+	if amount > order.Total {
+		amount = order.Total
+	}
+	if amount == 0 {
+		return ErrInsufficientBalance
+	}
+	if err := card.Post(tx, GiftCardRedeemEntry, -int64(amount), order.ID, order.UserID, ""); err != nil {
+		return err
+	}
+	order.GiftCardCode = card.Code
+	order.GiftCardAmount = amount
+	order.Total -= amount
+	return nil
+}
+
+// ReleaseGiftCard puts the amount an unpaid order redeemed back on its gift
+// card.
+func ReleaseGiftCard(tx *gorm.DB, order *Order, note string) error {
+	if order.GiftCardAmount == 0 {
+		return nil
+	}
+	card, err := FindGiftCard(tx, order.InstanceID, order.GiftCardCode)
+	if err != nil {
+		return err
+	}
+	if card == nil {
+		return fmt.Errorf("Gift card of order %s not found", order.ID)
+	}
+	if card.Status != GiftCardActiveState {
+		return nil
+	}
+	if err := card.Post(tx, GiftCardRefundEntry, int64(order.GiftCardAmount), order.ID, order.UserID, note); err != nil {
+		return err
+	}
+	order.Total += order.GiftCardAmount
+	order.GiftCardAmount = 0
+	return nil
+}
+
+// CreditGiftCard puts an amount refunded for a paid order back on the gift
+// card it was paid with, and returns the amount credited. Gift cards that
+// were voided since aren't credited.
+func CreditGiftCard(tx *gorm.DB, order *Order, amount uint64, userID, note string) (uint64, error) {
+	if amount == 0 || order.GiftCardCode == "" {
+		return 0, nil
+	}
+	card, err := FindGiftCard(tx, order.InstanceID, order.GiftCardCode)
+	if err != nil {
+		return 0, err
+	}
+	if card == nil {
+		return 0, fmt.Errorf("Gift card of order %s not found", order.ID)
+	}
+	if card.Status != GiftCardActiveState {
+		return 0, nil
+	}
+	if err := card.Post(tx, GiftCardRefundEntry, int64(amount), order.ID, userID, note); err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
+
+// NewGiftCards creates the gift cards bought with the line items of the paid
+// order, one for every unit. The balance is what was paid for the unit after
+// discounts, so discounted gift cards aren't worth more than they cost. The
+// recipient is taken from the line item meta data, and defaults to the
+// customer.
+func NewGiftCards(order *Order) ([]*GiftCard, error) {
+	cards := []*GiftCard{}
+	for _, item := range order.LineItems {
+		if !item.GiftCard || item.Quantity == 0 {
+			continue
+		}
+		paid := item.Price * item.Quantity
+		if detail := item.CalculationDetail; detail != nil {
+			paid = 0
+			if detail.Subtotal > detail.Discount {
+				paid = (detail.Subtotal - detail.Discount) * item.Quantity
+			}
+		}
+		for i := uint64(0); i < item.Quantity; i++ {
+			balance := paid / item.Quantity
+			if i == 0 {
+				balance += paid % item.Quantity
+			}
+			if balance == 0 {
+				continue
+			}
+			card, err := NewGiftCard(order.InstanceID, order.Currency)
+			if err != nil {
+				return nil, err
+			}
+			card.OrderID = order.ID
+			card.Balance = balance
+			card.RecipientEmail = metaString(item.MetaData, "recipient_email")
+			if card.RecipientEmail == "" {
+				card.RecipientEmail = order.Email
+			}
+			card.RecipientName = metaString(item.MetaData, "recipient_name")
+			card.Message = metaString(item.MetaData, "message")
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+// RevokeGiftCards takes the part of a refund of the order that exceeds the
+// rest of the order off the gift cards bought with it, so customers can't keep
+// both the money and the gift cards. The refunded amount is what was refunded
+// for the order before this refund.
+func RevokeGiftCards(tx *gorm.DB, order *Order, refunded, amount uint64, note string) error {
+	var cards []*GiftCard
+	result := tx.Preload("Entries", "type = ?", GiftCardIssueEntry).
+		Where("order_id = ?", order.ID).
+		Order("created_at asc").
+		Find(&cards)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "Error loading gift cards")
+	}
+
+	var issued uint64
+	for _, card := range cards {
+		for _, entry := range card.Entries {
+			issued += uint64(entry.Amount)
+		}
+	}
+	var rest uint64
+	if order.Total > issued {
+		rest = order.Total - issued
+	}
+	revoke := overRest(refunded+amount, rest) - overRest(refunded, rest)
+
+	for _, card := range cards {
+		if revoke == 0 {
+			break
+		}
+		if card.Status != GiftCardActiveState || card.Balance == 0 {
+			continue
+		}
+		deduct := card.Balance
+		if deduct > revoke {
+			deduct = revoke
+		}
+		if err := card.Post(tx, GiftCardAdjustEntry, -int64(deduct), order.ID, order.UserID, note); err != nil {
+			return err
+		}
+		revoke -= deduct
+	}
+	return nil
+}
+
+func overRest(amount, rest uint64) uint64 {
+	if amount > rest {
+		return amount - rest
+	}
+	return 0
+}
+
+func metaString(meta map[string]interface{}, key string) string {
+	if value, ok := meta[key].(string); ok {
+		return value
+	}
+	return ""
+}
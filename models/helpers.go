@@ -8,8 +8,25 @@ import (
 	"github.com/pkg/errors"
 )
 
+const hardDeleteKey = "gocommerce:hard_delete"
+
+// HardDelete permanently removes a record instead of marking it as deleted.
+// Records deleted by its BeforeDelete cascades are removed permanently too.
+func HardDelete(db *gorm.DB, value interface{}) error {
+	return db.Set(hardDeleteKey, true).Unscoped().Delete(value).Error
+}
+
+// deleteScope returns the db to use for deletes within BeforeDelete callbacks.
+func deleteScope(tx *gorm.DB) *gorm.DB {
+	if _, ok := tx.Get(hardDeleteKey); ok {
+		return tx.Unscoped()
+	}
+	return tx
+}
+
 // cm should be pointer to a slice, e.g. &[]User{}
 func cascadeDelete(tx *gorm.DB, query string, id interface{}, name string, cm interface{}) error {
+	tx = deleteScope(tx)
 	if result := tx.Where(query, id).Find(cm); result.Error != nil {
 		return errors.Wrap(result.Error, fmt.Sprintf("Error deleting %s records", name))
 	}
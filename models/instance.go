@@ -108,9 +108,11 @@ func DeleteInstance(db *gorm.DB, instance *Instance) error {
 }
 
 func (i *Instance) BeforeDelete(tx *gorm.DB) error {
+	tx = deleteScope(tx)
 	cascadeModels := map[string]interface{}{
-		"order": &[]Order{},
-		"user":  &[]User{},
+		"order":     &[]Order{},
+		"user":      &[]User{},
+		"gift card": &[]GiftCard{},
 	}
 	for name, cm := range cascadeModels {
 		if err := cascadeDelete(tx, "instance_id = ?", i.ID, name, cm); err != nil {
@@ -121,6 +123,11 @@ func (i *Instance) BeforeDelete(tx *gorm.DB) error {
 	delModels := map[string]interface{}{
 		"transaction":    Transaction{},
 		"invoice number": InvoiceNumber{},
+		"inventory":      Inventory{},
+		"exchange rate":  ExchangeRate{},
+		"promotion":      Promotion{},
+		"subscription":   Subscription{},
+		"payment method": PaymentMethod{},
 	}
 
 	for name, dm := range delModels {
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// InventoryReservedState is the InventoryState of an Order holding stock.
+const InventoryReservedState = "reserved"
+
+// InventoryReleasedState is the InventoryState of an Order that gave back its reserved stock.
+const InventoryReleasedState = "released"
+
+// InventoryCommittedState is the InventoryState of an Order whose stock was taken out of the inventory.
+const InventoryCommittedState = "committed"
+
+// InventoryOversoldState is the InventoryState of a paid Order whose stock ran out before the payment completed.
+const InventoryOversoldState = "oversold"
+
+// Inventory tracks the stock of a single SKU. Products without an inventory
+// record are not tracked and never run out of stock.
+type Inventory struct {
+	InstanceID string `json:"-" gorm:"unique_index:inventory_instance_sku"`
+	ID         int64  `json:"-"`
+	Sku        string `json:"sku" gorm:"unique_index:inventory_instance_sku"`
+
+	// Stock is the amount on hand, including reserved items.
+	Stock int64 `json:"stock"`
+	// Reserved is the amount held by unpaid orders.
+	Reserved int64 `json:"reserved"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the database table name for the Inventory model.
+func (Inventory) TableName() string {
+	return tableName("inventory")
+}
+
+// Available returns the stock that can still be ordered.
+func (i *Inventory) Available() int64 {
+	return i.Stock - i.Reserved
+}
+
+// MarshalJSON adds the available stock to the JSON representation.
+func (i Inventory) MarshalJSON() ([]byte, error) {
+	type alias Inventory
+	return json.Marshal(&struct {
+		alias
+		Available int64 `json:"available"`
+	}{alias(i), i.Available()})
+}
+
+// OutOfStockError is returned when an order asks for more of a SKU than is available.
+type OutOfStockError struct {
+	Sku       string
+	Requested uint64
+	Available int64
+}
+
+func (e *OutOfStockError) Error() string {
+	available := e.Available
+	if available < 0 {
+		available = 0
+	}
+	return fmt.Sprintf("Not enough stock for %s: %d requested but only %d available", e.Sku, e.Requested, available)
+}
+
+// GetInventory finds the inventory of a SKU. It returns nil if the SKU isn't tracked.
+func GetInventory(db *gorm.DB, instanceID, sku string) (*Inventory, error) {
+	inventory := &Inventory{}
+	if result := db.First(inventory, "instance_id = ? AND sku = ?", instanceID, sku); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.Wrap(result.Error, "Error querying inventory")
+	}
+	return inventory, nil
+}
+
+// inventoryQuantities sums up the quantities of an order per SKU. Items that
+// allow backorders are returned separately, as they don't need stock to be
+// available.
+func inventoryQuantities(order *Order) (required map[string]uint64, backordered map[string]uint64) {
+	required = map[string]uint64{}
+	backordered = map[string]uint64{}
+	for _, item := range order.LineItems {
+		if item.Sku == "" || item.Quantity == 0 {
+			continue
+		}
+		if item.Backorder {
+			backordered[item.Sku] += item.Quantity
+		} else {
+			required[item.Sku] += item.Quantity
+		}
+	}
+	return required, backordered
+}
+
+// ReserveInventory holds stock for the line items of a new order. It fails
+// with an OutOfStockError if a tracked SKU doesn't have enough stock, unless
+// the product allows backorders.
+func ReserveInventory(tx *gorm.DB, order *Order) error {
+	if order.InventoryState == InventoryReservedState || order.InventoryState == InventoryCommittedState {
+		return nil
+	}
+
+	required, backordered := inventoryQuantities(order)
+	reserved := map[string]uint64{}
+	for sku, quantity := range required {
+		result := tx.Model(&Inventory{}).
+			Where("instance_id = ? AND sku = ? AND stock - reserved >= ?", order.InstanceID, sku, quantity).
+			UpdateColumn("reserved", gorm.Expr("reserved + ?", quantity))
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "Error reserving stock for %s", sku)
+		}
+		if result.RowsAffected > 0 {
+			reserved[sku] = quantity
+			continue
+		}
+
+		inventory, err := GetInventory(tx, order.InstanceID, sku)
+		if err != nil {
+			return err
+		}
+		if inventory != nil {
+			// don't hold the stock of the other items of an order that fails
+			if err := releaseStock(tx, order.InstanceID, reserved); err != nil {
+				return err
+			}
+			return &OutOfStockError{Sku: sku, Requested: quantity, Available: inventory.Available()}
+		}
+	}
+	for sku, quantity := range backordered {
+		result := tx.Model(&Inventory{}).
+			Where("instance_id = ? AND sku = ?", order.InstanceID, sku).
+			UpdateColumn("reserved", gorm.Expr("reserved + ?", quantity))
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "Error reserving stock for %s", sku)
+		}
+	}
+
+	order.InventoryState = InventoryReservedState
+	return nil
+}
+
+// ReleaseInventory gives back the stock reserved for an order that won't be paid.
+func ReleaseInventory(tx *gorm.DB, order *Order) error {
+	if order.InventoryState != InventoryReservedState {
+		return nil
+	}
+
+	if err := releaseStock(tx, order.InstanceID, orderQuantities(order)); err != nil {
+		return err
+	}
+
+	order.InventoryState = InventoryReleasedState
+	return nil
+}
+
+func releaseStock(tx *gorm.DB, instanceID string, quantities map[string]uint64) error {
+	for sku, quantity := range quantities {
+		result := tx.Model(&Inventory{}).
+			Where("instance_id = ? AND sku = ? AND reserved >= ?", instanceID, sku, quantity).
+			UpdateColumn("reserved", gorm.Expr("reserved - ?", quantity))
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "Error releasing stock for %s", sku)
+		}
+	}
+	return nil
+}
+
+// CommitInventory takes the items of a paid order out of the stock. Orders
+// that don't hold their stock anymore, like ones released after a failed
+// payment, reserve it again first, and fail with an OutOfStockError without
+// touching the stock if it's gone in the meantime.
+func CommitInventory(tx *gorm.DB, order *Order) error {
+	if order.InventoryState == InventoryCommittedState {
+		return nil
+	}
+	if err := ReserveInventory(tx, order); err != nil {
+		return err
+	}
+
+	for sku, quantity := range orderQuantities(order) {
+		result := tx.Model(&Inventory{}).
+			Where("instance_id = ? AND sku = ?", order.InstanceID, sku).
+			UpdateColumns(map[string]interface{}{
+				"stock":    gorm.Expr("stock - ?", quantity),
+				"reserved": gorm.Expr("CASE WHEN reserved >= ? THEN reserved - ? ELSE 0 END", quantity, quantity),
+			})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "Error updating stock for %s", sku)
+		}
+	}
+
+	order.InventoryState = InventoryCommittedState
+	return nil
+}
+
+func orderQuantities(order *Order) map[string]uint64 {
+	required, backordered := inventoryQuantities(order)
+	for sku, quantity := range backordered {
+		required[sku] += quantity
+	}
+	return required
+}
@@ -4,15 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/jinzhu/gorm"
 	"github.com/netlify/gocommerce/calculator"
 	"github.com/netlify/gocommerce/claims"
-	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/money"
 	"github.com/pborman/uuid"
 )
 
@@ -39,6 +36,9 @@ type CalculationDetail struct {
 	NetTotal uint64 `json:"net_total"`
 	Taxes    uint64 `json:"taxes"`
 	Total    int64  `json:"total"`
+
+	TaxItems    []calculator.TaxItem `json:"tax_items" sql:"-"`
+	RawTaxItems string               `json:"-" sql:"type:text"`
 }
 
 // LineItem is a single item in an Order.
@@ -53,8 +53,12 @@ type LineItem struct {
 
 	Path string `json:"path"`
 
-	Price uint64 `json:"price"`
-	VAT   uint64 `json:"vat"`
+	Price     uint64 `json:"price"`
+	TierPrice uint64 `json:"tier_price,omitempty"`
+	VAT       uint64 `json:"vat"`
+	// ChosenPrice is the unit price the customer chose for a pay what you
+	// want product, without its addons.
+	ChosenPrice *uint64 `json:"chosen_price,omitempty"`
 
 	*CalculationDetail `json:"calculation" gorm:"embedded;embedded_prefix:calculation_"`
 
@@ -62,7 +66,18 @@ type LineItem struct {
 	AddonItems []*AddonItem `json:"addons"`
 	AddonPrice uint64       `json:"addon_price"`
 
-	Quantity uint64 `json:"quantity"`
+	Quantity  uint64 `json:"quantity"`
+	Backorder bool   `json:"backorder,omitempty"`
+
+	// Plan, Interval and IntervalCount are set for recurring products, which
+	// start a subscription once the order is paid.
+	Plan          string `json:"plan,omitempty"`
+	Interval      string `json:"interval,omitempty"`
+	IntervalCount uint64 `json:"interval_count,omitempty"`
+
+	// GiftCard is set for gift card products, which issue a gift card for
+	// every unit once the order is paid.
+	GiftCard bool `json:"gift_card,omitempty"`
 
 	MetaData    map[string]interface{} `sql:"-" json:"meta"`
 	RawMetaData string                 `json:"-" sql:"type:text"`
@@ -78,6 +93,17 @@ func (LineItem) TableName() string {
 
 // BeforeSave database callback.
 func (i *LineItem) BeforeSave() error {
+	if i.CalculationDetail != nil {
+		i.CalculationDetail.RawTaxItems = ""
+		if len(i.CalculationDetail.TaxItems) > 0 {
+			data, err := json.Marshal(i.CalculationDetail.TaxItems)
+			if err != nil {
+				return err
+			}
+			i.CalculationDetail.RawTaxItems = string(data)
+		}
+	}
+
 	if len(i.MetaData) == 0 {
 		i.RawMetaData = ""
 		return nil
@@ -92,6 +118,11 @@ func (i *LineItem) BeforeSave() error {
 
 // AfterFind database callback.
 func (i *LineItem) AfterFind() error {
+	if i.CalculationDetail != nil && i.CalculationDetail.RawTaxItems != "" {
+		if err := json.Unmarshal([]byte(i.CalculationDetail.RawTaxItems), &i.CalculationDetail.TaxItems); err != nil {
+			return err
+		}
+	}
 	if i.RawMetaData != "" {
 		return json.Unmarshal([]byte(i.RawMetaData), &i.MetaData)
 	}
@@ -99,6 +130,7 @@ func (i *LineItem) AfterFind() error {
 }
 
 func (i *LineItem) BeforeDelete(tx *gorm.DB) error {
+	tx = deleteScope(tx)
 	for _, p := range i.PriceItems {
 		if r := tx.Delete(p); r.Error != nil {
 			return r.Error
@@ -114,7 +146,8 @@ func (i *LineItem) BeforeDelete(tx *gorm.DB) error {
 
 // PriceItem represent the subcomponent price items of a LineItem.
 type PriceItem struct {
-	ID int64 `json:"id"`
+	ID         int64 `json:"id"`
+	LineItemID int64 `json:"-" sql:"index"`
 
 	Amount uint64 `json:"amount"`
 	Type   string `json:"type"`
@@ -156,9 +189,15 @@ func (i *PriceItem) GetQuantity() uint64 {
 	return 1
 }
 
+// TierDiscount implements part of the calculator.Item interface.
+func (i *PriceItem) TierDiscount() uint64 {
+	return 0
+}
+
 // AddonItem are additional items for a LineItem.
 type AddonItem struct {
-	ID int64 `json:"id"`
+	ID         int64 `json:"id"`
+	LineItemID int64 `json:"-" sql:"index"`
 
 	Sku         string `json:"sku"`
 	Title       string `json:"title"`
@@ -179,8 +218,22 @@ type PriceMetadata struct {
 	VAT      string            `json:"vat"`
 	Items    []PriceMetaItem   `json:"items"`
 	Claims   map[string]string `json:"claims"`
+	Tiers    []PriceTier       `json:"tiers,omitempty"`
+	// Minimum lets customers choose the price, as long as they pay at least
+	// the minimum. The amount is then the suggested price.
+	Minimum string `json:"minimum,omitempty"`
 
 	cents uint64
+	// tierCents is the unit price for the quantity ordered
+	tierCents uint64
+	// sourceCents is the amount in the listed currency of a converted price
+	sourceCents uint64
+}
+
+// PriceTier is a lower unit price for ordering at least the minimum quantity.
+type PriceTier struct {
+	MinQuantity uint64 `json:"min_quantity"`
+	Amount      string `json:"amount"`
 }
 
 // PriceMetaItem model
@@ -206,11 +259,124 @@ type LineItemMetadata struct {
 	VAT         uint64          `json:"vat"`
 	Prices      []PriceMetadata `json:"prices"`
 	Type        string          `json:"type"`
+	Backorder   bool            `json:"backorder"`
 
 	Downloads []Download      `json:"downloads"`
 	Addons    []AddonMetaItem `json:"addons"`
 
 	Webhook string `json:"webhook"`
+
+	Recurring *RecurringMetadata `json:"recurring,omitempty"`
+	GiftCard  bool               `json:"gift_card"`
+}
+
+// RecurringMetadata marks a product as a subscription, renewed every
+// interval count intervals.
+type RecurringMetadata struct {
+	Plan          string `json:"plan"`
+	Interval      string `json:"interval"`
+	IntervalCount uint64 `json:"interval_count"`
+}
+
+// Validate checks the metadata for mistakes that would make orders for the
+// product fail.
+func (m *LineItemMetadata) Validate() []error {
+	errs := []error{}
+	if m.Sku == "" {
+		errs = append(errs, errors.New("Missing sku"))
+	}
+	if m.Title == "" {
+		errs = append(errs, errors.New("Missing title"))
+	}
+	if len(m.Prices) == 0 {
+		errs = append(errs, errors.New("No prices"))
+	}
+	errs = append(errs, validatePrices("", m.Prices)...)
+
+	addonSkus := map[string]bool{}
+	for index, addon := range m.Addons {
+		name := fmt.Sprintf("Addon %d", index+1)
+		if addon.Sku == "" {
+			errs = append(errs, fmt.Errorf("%s is missing a sku", name))
+		} else if addonSkus[addon.Sku] {
+			errs = append(errs, fmt.Errorf("%s has the duplicate sku %s", name, addon.Sku))
+		}
+		addonSkus[addon.Sku] = true
+		if len(addon.Prices) == 0 {
+			errs = append(errs, fmt.Errorf("%s has no prices", name))
+		}
+		errs = append(errs, validatePrices(name, addon.Prices)...)
+	}
+
+	if m.Recurring != nil && !ValidInterval(m.Recurring.Interval) {
+		errs = append(errs, fmt.Errorf("Recurring interval '%s' is unknown", m.Recurring.Interval))
+	}
+	if m.Recurring != nil && m.GiftCard {
+		errs = append(errs, errors.New("Gift cards can't be recurring"))
+	}
+
+	for index, download := range m.Downloads {
+		if download.URL == "" {
+			errs = append(errs, fmt.Errorf("Download %d is missing a url", index+1))
+		}
+	}
+	return errs
+}
+
+func validatePrices(prefix string, prices []PriceMetadata) []error {
+	errs := []error{}
+	for index, price := range prices {
+		name := fmt.Sprintf("Price %d", index+1)
+		if prefix != "" {
+			name = fmt.Sprintf("%s price %d", prefix, index+1)
+		}
+		if price.Currency == "" {
+			errs = append(errs, fmt.Errorf("%s is missing a currency", name))
+		}
+		amount, err := money.Parse(price.Amount, price.Currency)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s has an invalid amount '%s'", name, price.Amount))
+		}
+		if price.Minimum != "" {
+			if prefix != "" {
+				errs = append(errs, fmt.Errorf("%s can't have a minimum, only products can", name))
+			} else if minimum, minErr := money.Parse(price.Minimum, price.Currency); minErr != nil {
+				errs = append(errs, fmt.Errorf("%s has an invalid minimum '%s'", name, price.Minimum))
+			} else if err == nil && minimum > amount {
+				errs = append(errs, fmt.Errorf("%s has a minimum above its amount", name))
+			}
+			if len(price.Tiers) > 0 {
+				errs = append(errs, fmt.Errorf("%s can't have both a minimum and tiers", name))
+			}
+		}
+		for itemIndex, item := range price.Items {
+			if _, err := money.Parse(item.Amount, price.Currency); err != nil {
+				errs = append(errs, fmt.Errorf("%s item %d has an invalid amount '%s'", name, itemIndex+1, item.Amount))
+			}
+		}
+		for claim, value := range price.Claims {
+			if claim == "" || value == "" {
+				errs = append(errs, fmt.Errorf("%s has an empty claim", name))
+				break
+			}
+		}
+		for tierIndex, tier := range price.Tiers {
+			if tier.MinQuantity < 2 {
+				errs = append(errs, fmt.Errorf("%s tier %d has a min_quantity below 2", name, tierIndex+1))
+			}
+			if _, err := money.Parse(tier.Amount, price.Currency); err != nil {
+				errs = append(errs, fmt.Errorf("%s tier %d has an invalid amount '%s'", name, tierIndex+1, tier.Amount))
+			}
+		}
+	}
+	return errs
+}
+
+// ProductSource looks up the metadata of the products sold on a site.
+type ProductSource interface {
+	// Lookup finds the product with the SKU at the path. If the SKU is empty
+	// and the path only has a single product, that product is returned.
+	Lookup(path, sku string) (*LineItemMetadata, error)
 }
 
 // ProductSku returns the Sku of the line item to match the calculator.Item interface
@@ -250,9 +416,22 @@ func (i *LineItem) GetQuantity() uint64 {
 	return i.Quantity
 }
 
+// Recurring tells if the line item starts a subscription.
+func (i *LineItem) Recurring() bool {
+	return i.Interval != ""
+}
+
+// TierDiscount implements part of the calculator.Item interface.
+func (i *LineItem) TierDiscount() uint64 {
+	if i.TierPrice == 0 || i.TierPrice >= i.Price {
+		return 0
+	}
+	return i.Price - i.TierPrice
+}
+
 // Process calculates the price of a LineItem.
-func (i *LineItem) Process(config *conf.Configuration, userClaims map[string]interface{}, order *Order) error {
-	meta, err := i.FetchMeta(config.SiteURL)
+func (i *LineItem) Process(products ProductSource, userClaims map[string]interface{}, order *Order) error {
+	meta, err := i.FetchMeta(products)
 	if err != nil {
 		return err
 	}
@@ -262,6 +441,13 @@ func (i *LineItem) Process(config *conf.Configuration, userClaims map[string]int
 	i.Description = meta.Description
 	i.VAT = meta.VAT
 	i.Type = meta.Type
+	i.Backorder = meta.Backorder
+	i.GiftCard = meta.GiftCard
+	if meta.Recurring != nil {
+		i.Plan = meta.Recurring.Plan
+		i.Interval = meta.Recurring.Interval
+		i.IntervalCount = meta.Recurring.IntervalCount
+	}
 
 	for index, addon := range i.AddonItems {
 		var metaAddon *AddonMetaItem
@@ -275,10 +461,11 @@ func (i *LineItem) Process(config *conf.Configuration, userClaims map[string]int
 			return fmt.Errorf("Unkown addon %v for item %v", addon.Sku, i.Sku)
 		}
 
-		lowestPrice, err := determineLowestPrice(userClaims, metaAddon.Prices, order.Currency)
+		lowestPrice, rate, err := determineLowestPrice(userClaims, metaAddon.Prices, order.Currency, 1, order.currencyConverter)
 		if err != nil {
 			return err
 		}
+		order.addExchangeRate(rate)
 
 		i.AddonItems[index].Title = metaAddon.Title
 		i.AddonItems[index].Description = metaAddon.Description
@@ -289,52 +476,19 @@ func (i *LineItem) Process(config *conf.Configuration, userClaims map[string]int
 	order.Downloads = append(order.Downloads, i.MissingDownloads(order, meta)...)
 	order.ModificationLock.Unlock()
 
-	return i.calculatePrice(userClaims, meta.Prices, order.Currency)
+	return i.calculatePrice(userClaims, meta.Prices, order)
 }
 
 // FetchMeta determines the product metadata for the item based on its path
-func (i *LineItem) FetchMeta(siteURL string) (*LineItemMetadata, error) {
-	resp, err := http.Get(siteURL + i.Path)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	doc, err := goquery.NewDocumentFromResponse(resp)
+func (i *LineItem) FetchMeta(products ProductSource) (*LineItemMetadata, error) {
+	meta, err := products.Lookup(i.Path, i.Sku)
 	if err != nil {
 		return nil, err
 	}
-
-	metaTag := doc.Find(".gocommerce-product")
-	if metaTag.Length() == 0 {
-		return nil, fmt.Errorf("No script tag with class gocommerce-product tag found for '%v'", i.Title)
-	}
-	metaProducts := []*LineItemMetadata{}
-	var parsingErr error
-	metaTag.EachWithBreak(func(_ int, tag *goquery.Selection) bool {
-		meta := &LineItemMetadata{}
-		parsingErr = json.Unmarshal([]byte(tag.Text()), meta)
-		if parsingErr != nil {
-			return false
-		}
-		metaProducts = append(metaProducts, meta)
-		return true
-	})
-	if parsingErr != nil {
-		return nil, fmt.Errorf("Error parsing product metadata: %v", parsingErr)
-	}
-
-	if len(metaProducts) == 1 && i.Sku == "" {
-		i.Sku = metaProducts[0].Sku
-	}
-
-	for _, meta := range metaProducts {
-		if meta.Sku == i.Sku {
-			return meta, nil
-		}
+	if i.Sku == "" {
+		i.Sku = meta.Sku
 	}
-
-	return nil, fmt.Errorf("No product Sku from path matched: %v", i.Sku)
+	return meta, nil
 }
 
 // MissingDownloads returns all downloads that are not yet listed in the order
@@ -363,19 +517,35 @@ func (i *LineItem) MissingDownloads(order *Order, meta *LineItemMetadata) []Down
 	return downloads
 }
 
-func (i *LineItem) calculatePrice(userClaims map[string]interface{}, prices []PriceMetadata, currency string) error {
-	lowestPrice, err := determineLowestPrice(userClaims, prices, currency)
+func (i *LineItem) calculatePrice(userClaims map[string]interface{}, prices []PriceMetadata, order *Order) error {
+	lowestPrice, rate, err := determineLowestPrice(userClaims, prices, order.Currency, i.Quantity, order.currencyConverter)
 	if err != nil {
 		return err
 	}
+	order.addExchangeRate(rate)
 	i.Price = lowestPrice.cents
+	i.TierPrice = 0
+	if lowestPrice.tierCents < lowestPrice.cents {
+		i.TierPrice = lowestPrice.tierCents
+	}
+	if err := i.choosePrice(lowestPrice, order); err != nil {
+		return err
+	}
 	i.PriceItems = make([]*PriceItem, len(lowestPrice.Items))
 	for index, item := range lowestPrice.Items {
-		amount, err := strconv.ParseFloat(item.Amount, 64)
+		amount, err := money.Parse(item.Amount, lowestPrice.Currency)
 		if err != nil {
 			return err
 		}
-		i.PriceItems[index] = &PriceItem{Amount: uint64(amount * 100), Type: item.Type, VAT: item.VAT}
+		if rate != nil {
+			// split the converted price like the listed one
+			amount = money.Scale(amount, lowestPrice.cents, lowestPrice.sourceCents)
+		}
+		if i.Price != lowestPrice.cents {
+			// and a chosen price like the suggested one
+			amount = money.Scale(amount, i.Price, lowestPrice.cents)
+		}
+		i.PriceItems[index] = &PriceItem{Amount: amount, Type: item.Type, VAT: item.VAT}
 	}
 	for _, addon := range i.AddonItems {
 		i.AddonPrice += addon.Price
@@ -384,24 +554,137 @@ func (i *LineItem) calculatePrice(userClaims map[string]interface{}, prices []Pr
 	return nil
 }
 
-func determineLowestPrice(userClaims map[string]interface{}, prices []PriceMetadata, currency string) (PriceMetadata, error) {
+// choosePrice sets the price the customer chose, if the price allows it and
+// the customer paid at least the minimum.
+func (i *LineItem) choosePrice(price PriceMetadata, order *Order) error {
+	if i.ChosenPrice == nil {
+		return nil
+	}
+	if price.Minimum == "" {
+		return &ChosenPriceError{Sku: i.Sku}
+	}
+	minimum, err := money.Parse(price.Minimum, price.Currency)
+	if err != nil {
+		return err
+	}
+	if price.Currency != order.Currency {
+		converted, _, ok, err := order.currencyConverter.Convert(minimum, price.Currency, order.Currency)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("No exchange rate for the minimum price of %s", i.Sku)
+		}
+		minimum = converted
+	}
+	if *i.ChosenPrice < minimum {
+		return &ChosenPriceError{Sku: i.Sku, Minimum: minimum, Currency: order.Currency}
+	}
+	i.Price = *i.ChosenPrice
+	i.TierPrice = 0
+	return nil
+}
+
+// ChosenPriceError is returned when a customer chooses the price of a
+// product that doesn't allow it, or a price below its minimum.
+type ChosenPriceError struct {
+	Sku      string
+	Minimum  uint64
+	Currency string
+}
+
+func (e *ChosenPriceError) Error() string {
+	if e.Currency == "" {
+		return fmt.Sprintf("The price of %s can't be chosen", e.Sku)
+	}
+	return fmt.Sprintf("The price of %s must be at least %s %s", e.Sku, money.Decimal(e.Minimum, e.Currency), e.Currency)
+}
+
+// determineLowestPrice finds the lowest price the user can get in the
+// currency for the quantity, taking quantity tiers into account. If the
+// product isn't listed in the currency, the price is derived from the prices
+// in other currencies, and the exchange rate used is returned.
+func determineLowestPrice(userClaims map[string]interface{}, prices []PriceMetadata, currency string, quantity uint64, converter *CurrencyConverter) (PriceMetadata, *AppliedExchangeRate, error) {
 	lowestPrice := PriceMetadata{}
 	found := false
 	for _, price := range prices {
 		if price.Currency == currency {
-			amount, err := strconv.ParseFloat(price.Amount, 64)
+			amount, err := money.Parse(price.Amount, price.Currency)
 			if err != nil {
-				return lowestPrice, err
+				return lowestPrice, nil, err
 			}
-			price.cents = uint64(amount * 100)
-			if (!found || price.cents < lowestPrice.cents) && claims.HasClaims(userClaims, price.Claims) {
+			tierAmount, err := price.tierAmount(amount, quantity)
+			if err != nil {
+				return lowestPrice, nil, err
+			}
+			price.cents = amount
+			price.tierCents = tierAmount
+			if (!found || price.tierCents < lowestPrice.tierCents) && claims.HasClaims(userClaims, price.Claims) {
 				lowestPrice = price
 				found = true
 			}
 		}
 	}
+	if found {
+		return lowestPrice, nil, nil
+	}
+
+	var lowestRate *AppliedExchangeRate
+	for _, price := range prices {
+		if !claims.HasClaims(userClaims, price.Claims) {
+			continue
+		}
+		amount, err := money.Parse(price.Amount, price.Currency)
+		if err != nil {
+			return lowestPrice, nil, err
+		}
+		converted, rate, ok, err := converter.Convert(amount, price.Currency, currency)
+		if err != nil {
+			return lowestPrice, nil, err
+		}
+		if !ok {
+			continue
+		}
+		tierAmount, err := price.tierAmount(amount, quantity)
+		if err != nil {
+			return lowestPrice, nil, err
+		}
+		convertedTier := converted
+		if tierAmount < amount {
+			if convertedTier, _, _, err = converter.Convert(tierAmount, price.Currency, currency); err != nil {
+				return lowestPrice, nil, err
+			}
+		}
+		if !found || convertedTier < lowestPrice.tierCents {
+			price.cents = converted
+			price.tierCents = convertedTier
+			price.sourceCents = amount
+			lowestPrice = price
+			lowestRate = rate
+			found = true
+		}
+	}
 	if !found {
-		return lowestPrice, errors.New("No valid price found for item")
+		return lowestPrice, nil, errors.New("No valid price found for item")
+	}
+	return lowestPrice, lowestRate, nil
+}
+
+// tierAmount returns the lowest unit price of the tiers reached by the
+// quantity, or the amount if none is.
+func (p *PriceMetadata) tierAmount(amount, quantity uint64) (uint64, error) {
+	lowest := amount
+	for _, tier := range p.Tiers {
+		if quantity < tier.MinQuantity {
+			continue
+		}
+		tierAmount, err := money.Parse(tier.Amount, p.Currency)
+		if err != nil {
+			return 0, err
+		}
+		if tierAmount < lowest {
+			lowest = tierAmount
+		}
 	}
-	return lowestPrice, nil
+	return lowest, nil
 }
@@ -8,7 +8,6 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/netlify/gocommerce/calculator"
-	"github.com/netlify/gocommerce/conf"
 	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -29,11 +28,15 @@ const ShippedState = "shipped"
 // FailedState is the failed state of an Order
 const FailedState = "failed"
 
+// ExpiredState is the state of an Order that was never paid
+const ExpiredState = "expired"
+
 // PaymentState are the possible values for the PaymentState field
 var PaymentStates = []string{
 	PendingState,
 	PaidState,
 	FailedState,
+	ExpiredState,
 }
 
 // FulfillmentStates are the possible values for the FulfillmentState field
@@ -43,6 +46,10 @@ var FulfillmentStates = []string{
 	ShippedState,
 }
 
+// CheckoutReminderOptOutKey is the order meta data key that disables
+// abandoned checkout reminders for an order when set to true.
+const CheckoutReminderOptOutKey = "abandoned_checkout_opt_out"
+
 // NumberType | StringType | BoolType are the different types supported in custom data for orders
 const (
 	NumberType = iota
@@ -77,11 +84,37 @@ type Order struct {
 
 	Total uint64 `json:"total"`
 
+	TaxItems           []calculator.TaxItem `json:"tax_items" sql:"-"`
+	RawTaxItems        string               `json:"-" sql:"type:text"`
+	PricesIncludeTaxes bool                 `json:"prices_include_taxes"`
+
 	PaymentState     string `json:"payment_state"`
 	FulfillmentState string `json:"fulfillment_state"`
 	State            string `json:"state"`
+	InventoryState   string `json:"inventory_state,omitempty"`
+
+	// ExpiredAt is when a pending order expired. Expired orders are kept for
+	// the retention period from then on.
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
 
 	PaymentProcessor string `json:"payment_processor"`
+	// PaymentCustomerID is the provider's customer that keeps the payment
+	// method of a recurring order for its renewals.
+	PaymentCustomerID string `json:"-"`
+
+	// PaymentDueAt is when the manual invoice of the order is due. Unpaid
+	// invoices are reminded on the dunning schedule from then on.
+	PaymentDueAt          *time.Time `json:"payment_due_at,omitempty"`
+	InvoiceReminders      int        `json:"invoice_reminders,omitempty"`
+	NextInvoiceReminderAt *time.Time `json:"next_invoice_reminder_at,omitempty" sql:"index"`
+
+	// SubscriptionID is set on the orders renewing a subscription.
+	SubscriptionID string `json:"subscription_id,omitempty" sql:"index"`
+
+	// GiftCardAmount is the part of the order paid with the gift card, which
+	// isn't included in the Total anymore.
+	GiftCardCode   string `json:"gift_card_code,omitempty"`
+	GiftCardAmount uint64 `json:"gift_card_amount,omitempty"`
 
 	Transactions []*Transaction `json:"transactions"`
 	Notes        []*OrderNote   `json:"notes"`
@@ -92,21 +125,37 @@ type Order struct {
 	BillingAddress   Address `json:"billing_address" gorm:"ForeignKey:BillingAddressID"`
 	BillingAddressID string  `json:"billing_address_id"`
 
-	VATNumber string `json:"vatnumber"`
+	VATNumber           string `json:"vatnumber"`
+	VATNumberValid      bool   `json:"vatnumber_valid"`
+	VATNumberUnverified bool   `json:"vatnumber_unverified"`
+	ReverseCharge       bool   `json:"reverse_charge"`
 
 	MetaData    map[string]interface{} `sql:"-" json:"meta"`
 	RawMetaData string                 `json:"-" sql:"type:text"`
 
+	// Claims are the claims of the buyer that recurring orders were priced
+	// with, so renewals are priced the same.
+	Claims    map[string]interface{} `json:"-" sql:"-"`
+	RawClaims string                 `json:"-" sql:"type:text"`
+
 	CouponCode string `json:"coupon_code,omitempty"`
 
+	CheckoutRemindersSent int        `json:"checkout_reminders_sent,omitempty"`
+	CheckoutRemindedAt    *time.Time `json:"checkout_reminded_at,omitempty"`
+
 	Coupon    *Coupon `json:"coupon,omitempty" sql:"-"`
 	RawCoupon string  `json:"-" sql:"type:text"`
 
+	ExchangeRates    []*AppliedExchangeRate `json:"exchange_rates,omitempty" sql:"-"`
+	RawExchangeRates string                 `json:"-" sql:"type:text"`
+
 	CreatedAt time.Time  `json:"created_at" sql:"index"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `json:"-" sql:"index"`
 
 	ModificationLock sync.Mutex `json:"-" sql:"-"`
+
+	currencyConverter *CurrencyConverter
 }
 
 // TableName returns the database table name for the Order model.
@@ -122,6 +171,12 @@ func (o *Order) AfterFind() error {
 			return err
 		}
 	}
+	if o.RawClaims != "" {
+		err := json.Unmarshal([]byte(o.RawClaims), &o.Claims)
+		if err != nil {
+			return err
+		}
+	}
 	if o.RawCoupon != "" {
 		o.Coupon = &Coupon{}
 		err := json.Unmarshal([]byte(o.RawCoupon), &o.Coupon)
@@ -129,6 +184,18 @@ func (o *Order) AfterFind() error {
 			return err
 		}
 	}
+	if o.RawExchangeRates != "" {
+		err := json.Unmarshal([]byte(o.RawExchangeRates), &o.ExchangeRates)
+		if err != nil {
+			return err
+		}
+	}
+	if o.RawTaxItems != "" {
+		err := json.Unmarshal([]byte(o.RawTaxItems), &o.TaxItems)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -142,6 +209,13 @@ func (o *Order) BeforeSave() error {
 		}
 		o.RawMetaData = string(data)
 	}
+	if o.Claims != nil {
+		data, err := json.Marshal(o.Claims)
+		if err != nil {
+			return err
+		}
+		o.RawClaims = string(data)
+	}
 	if o.Coupon != nil {
 		data, err := json.Marshal(o.Coupon)
 		if err != nil {
@@ -149,6 +223,21 @@ func (o *Order) BeforeSave() error {
 		}
 		o.RawCoupon = string(data)
 	}
+	if o.ExchangeRates != nil {
+		data, err := json.Marshal(o.ExchangeRates)
+		if err != nil {
+			return err
+		}
+		o.RawExchangeRates = string(data)
+	}
+	o.RawTaxItems = ""
+	if len(o.TaxItems) > 0 {
+		data, err := json.Marshal(o.TaxItems)
+		if err != nil {
+			return err
+		}
+		o.RawTaxItems = string(data)
+	}
 
 	return nil
 }
@@ -168,6 +257,37 @@ func NewOrder(instanceID, sessionID, email, currency string) *Order {
 	return order
 }
 
+// UseCurrencyConverter lets the line items of the order be priced from prices
+// in other currencies, when they aren't listed in the order currency.
+func (o *Order) UseCurrencyConverter(converter *CurrencyConverter) {
+	o.currencyConverter = converter
+}
+
+// addExchangeRate records an exchange rate used to price the order.
+func (o *Order) addExchangeRate(rate *AppliedExchangeRate) {
+	if rate == nil {
+		return
+	}
+	o.ModificationLock.Lock()
+	defer o.ModificationLock.Unlock()
+	for _, existing := range o.ExchangeRates {
+		if *existing == *rate {
+			return
+		}
+	}
+	o.ExchangeRates = append(o.ExchangeRates, rate)
+}
+
+// Recurring tells if the order has line items that start a subscription.
+func (o *Order) Recurring() bool {
+	for _, item := range o.LineItems {
+		if item.Recurring() {
+			return true
+		}
+	}
+	return false
+}
+
 // CalculateTotal calculates the total price of an Order.
 func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]interface{}, log logrus.FieldLogger) {
 	items := make([]calculator.Item, len(o.LineItems))
@@ -175,13 +295,24 @@ func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]
 		items[i] = item
 	}
 
-	params := calculator.PriceParameters{o.ShippingAddress.Country, o.Currency, o.Coupon, items}
+	params := calculator.PriceParameters{
+		Country:        o.ShippingAddress.Country,
+		Region:         o.ShippingAddress.State,
+		PostalCode:     o.ShippingAddress.Zip,
+		Currency:       o.Currency,
+		Coupon:         o.Coupon,
+		Items:          items,
+		ValidVATNumber: o.VATNumberValid,
+	}
 	price := calculator.CalculatePrice(settings, claims, params, log)
 
 	o.SubTotal = price.Subtotal
 	o.Taxes = price.Taxes
 	o.Discount = price.Discount
 	o.NetTotal = price.NetTotal
+	o.ReverseCharge = price.ReverseCharge
+	o.TaxItems = price.TaxItems
+	o.PricesIncludeTaxes = price.PricesIncludeTaxes
 
 	// apply price details to line items
 	for i, item := range price.Items {
@@ -190,6 +321,7 @@ func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]
 			Subtotal: item.Subtotal,
 			NetTotal: item.NetTotal,
 			Taxes:    item.Taxes,
+			TaxItems: item.TaxItems,
 			Total:    item.Total,
 		}
 
@@ -208,19 +340,21 @@ func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]
 
 // UpdateDownloads will refetch downloads for all line items in the order and
 // update the downloads in the order
-func (o *Order) UpdateDownloads(config *conf.Configuration, log logrus.FieldLogger) error {
+func (o *Order) UpdateDownloads(products ProductSource, log logrus.FieldLogger) error {
 	updateMap := downloadRefreshItemSet{}
 	for _, item := range o.LineItems {
 		updateMap.Add(item, o)
 	}
-	updates, err := updateMap.Update(nil, config, log)
+	updates, err := updateMap.Update(products, log)
 	log.Debugf("Updated downloads of %d orders", len(updates))
 	return err
 }
 
 func (o *Order) BeforeDelete(tx *gorm.DB) error {
+	tx = deleteScope(tx)
 	cascadeModels := map[string]interface{}{
 		"line item": &[]LineItem{},
+		"return":    &[]Return{},
 	}
 	for name, cm := range cascadeModels {
 		if err := cascadeDelete(tx, "order_id = ?", o.ID, name, cm); err != nil {
@@ -269,23 +403,11 @@ func (m downloadRefreshItemSet) Add(item *LineItem, order *Order) {
 	mapping.orders = append(mapping.orders, order)
 }
 
-// UpdateDownloads fetches downloads for all line items and updates orders with new downloads
-func (m downloadRefreshItemSet) Update(db *gorm.DB, config *conf.Configuration, log logrus.FieldLogger) (updates []*Order, err error) {
+// UpdateDownloads fetches downloads for all line items and updates orders with new downloads.
+// All orders must belong to the instance the products are looked up for.
+func (m downloadRefreshItemSet) Update(products ProductSource, log logrus.FieldLogger) (updates []*Order, err error) {
 	// @todo: run in parallel with goroutines, lock orders with mutexes
-	for instanceID, items := range m {
-		if config == nil {
-			if db == nil {
-				err = errors.New("Instance config or database connection missing")
-				return
-			}
-			instance := Instance{}
-			if queryErr := db.First(&instance, Instance{ID: instanceID}).Error; queryErr != nil {
-				err = errors.Wrap(queryErr, "Failed fetching instance for order")
-				return
-			}
-			config = instance.BaseConfig
-		}
-
+	for _, items := range m {
 		for _, entry := range items {
 			if entry.item.Sku == "" {
 				log.Warningf(
@@ -295,7 +417,7 @@ func (m downloadRefreshItemSet) Update(db *gorm.DB, config *conf.Configuration,
 				continue
 			}
 			log.Debugf("Updating downloads for item with sku '%s'", entry.item.Sku)
-			meta, fetchErr := entry.item.FetchMeta(config.SiteURL)
+			meta, fetchErr := entry.item.FetchMeta(products)
 			if fetchErr != nil {
 				// item might not be offered anymore, preserve downloads
 				log.WithError(fetchErr).
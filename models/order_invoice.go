@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// InvoicePaymentProcessor is the payment processor of orders paid by a manual
+// invoice, like a bank transfer on net terms.
+const InvoicePaymentProcessor = "invoice"
+
+// InvoiceOverdueFailureCode is the failure code of the transactions recording
+// the reminders of an overdue invoice.
+const InvoiceOverdueFailureCode = "invoice_overdue"
+
+// IssueInvoice lets the order be paid by a manual invoice due after netDays.
+// The first reminder is sent once the invoice is due.
+func (o *Order) IssueInvoice(now time.Time, netDays int) {
+	due := now.AddDate(0, 0, netDays)
+	o.PaymentProcessor = InvoicePaymentProcessor
+	o.PaymentDueAt = &due
+	o.InvoiceReminders = 0
+	o.NextInvoiceReminderAt = &due
+}
+
+// InvoiceOverdue records a reminder of the overdue invoice and schedules the
+// next one. The retry delays are hours after the invoice was due. Like
+// Subscription.PaymentFailed it returns true once all reminders were sent,
+// and without retry delays the invoice is reminded only once.
+func (o *Order) InvoiceOverdue(now time.Time, retryDelays []int) bool {
+	o.InvoiceReminders++
+	o.NextInvoiceReminderAt = nil
+	if o.InvoiceReminders <= len(retryDelays) {
+		next := o.PaymentDueAt.Add(time.Duration(retryDelays[o.InvoiceReminders-1]) * time.Hour)
+		if next.Before(now) {
+			next = now
+		}
+		o.NextInvoiceReminderAt = &next
+	}
+	return len(retryDelays) > 0 && o.NextInvoiceReminderAt == nil
+}
+
+// ClaimInvoiceReminder takes the due reminder of an unpaid invoice, so
+// concurrent runs never send it twice.
+func (o *Order) ClaimInvoiceReminder(tx *gorm.DB, now time.Time) (bool, error) {
+	result := tx.Model(o).
+		Where("payment_state = ? AND next_invoice_reminder_at <= ?", PendingState, now).
+		UpdateColumn("next_invoice_reminder_at", gorm.Expr("NULL"))
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "Error claiming invoice reminder")
+	}
+	o.NextInvoiceReminderAt = nil
+	return result.RowsAffected == 1, nil
+}
+
+// OverdueInvoices returns the unpaid invoices of the instance whose next
+// reminder is due.
+func OverdueInvoices(db *gorm.DB, instanceID string, now time.Time) ([]*Order, error) {
+	orders := []*Order{}
+	result := db.
+		Preload("LineItems").
+		Where("instance_id = ? AND payment_state = ? AND payment_processor = ?", instanceID, PendingState, InvoicePaymentProcessor).
+		Where("next_invoice_reminder_at <= ?", now).
+		Find(&orders)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error querying for overdue invoices")
+	}
+	return orders, nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// PaymentMethod is a card a user saved with a payment provider to pay later
+// orders with. Only the details needed to show the card to the user are
+// stored, the card itself stays with the provider.
+type PaymentMethod struct {
+	InstanceID string `json:"-" sql:"index"`
+	ID         string `json:"id"`
+	UserID     string `json:"user_id" sql:"index"`
+
+	PaymentProcessor string `json:"payment_processor"`
+	// ProcessorID is the id of the payment method with the provider, and
+	// CustomerID the provider's customer it is saved on.
+	ProcessorID string `json:"-"`
+	CustomerID  string `json:"-"`
+
+	Brand    string `json:"brand"`
+	Last4    string `json:"last4"`
+	ExpMonth uint64 `json:"exp_month"`
+	ExpYear  uint64 `json:"exp_year"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"-" sql:"index"`
+}
+
+// TableName returns the database table name for the PaymentMethod model.
+func (PaymentMethod) TableName() string {
+	return tableName("payment_methods")
+}
+
+// PaymentCustomerID returns the provider's customer the payment methods of the
+// user in the instance are saved on, or the customer of their subscriptions.
+// It returns an empty string if the user has neither yet.
+func PaymentCustomerID(db *gorm.DB, instanceID, userID, processor string) (string, error) {
+	method := &PaymentMethod{}
+	result := db.Where("instance_id = ? AND user_id = ? AND payment_processor = ? AND customer_id <> ''", instanceID, userID, processor).
+		Order("created_at desc").
+		First(method)
+	if result.RecordNotFound() {
+		return SubscriptionCustomerID(db, instanceID, userID, processor)
+	}
+	if result.Error != nil {
+		return "", errors.Wrap(result.Error, "Error loading payment methods")
+	}
+	return method.CustomerID, nil
+}
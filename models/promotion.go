@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/pkg/errors"
+)
+
+// Promotion is a promotion rule managed through the API. Stored promotions
+// apply after the promotions of the site settings.
+type Promotion struct {
+	InstanceID  string `json:"-" gorm:"unique_index:promotion_instance_id"`
+	ID          int64  `json:"-"`
+	PromotionID string `json:"id" gorm:"unique_index:promotion_instance_id"`
+
+	Rule    *calculator.Promotion `json:"rule" sql:"-"`
+	RawRule string                `json:"-" sql:"type:text"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the database table name for the Promotion model.
+func (Promotion) TableName() string {
+	return tableName("promotions")
+}
+
+// AfterFind database callback.
+func (p *Promotion) AfterFind() error {
+	if p.RawRule != "" {
+		if err := json.Unmarshal([]byte(p.RawRule), &p.Rule); err != nil {
+			return err
+		}
+		p.Rule.ID = p.PromotionID
+	}
+	return nil
+}
+
+// BeforeSave database callback.
+func (p *Promotion) BeforeSave() error {
+	if p.Rule != nil {
+		p.Rule.ID = p.PromotionID
+		data, err := json.Marshal(p.Rule)
+		if err != nil {
+			return err
+		}
+		p.RawRule = string(data)
+	}
+	return nil
+}
+
+// GetPromotion loads a stored promotion. It returns nil if there is none.
+func GetPromotion(db *gorm.DB, instanceID, promotionID string) (*Promotion, error) {
+	promotion := &Promotion{}
+	result := db.Where("instance_id = ? AND promotion_id = ?", instanceID, promotionID).First(promotion)
+	if result.RecordNotFound() {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return promotion, nil
+}
+
+// LoadPromotions loads the promotion rules stored for the instance, in the
+// order they were created.
+func LoadPromotions(db *gorm.DB, instanceID string) ([]*calculator.Promotion, error) {
+	var promotions []*Promotion
+	if result := db.Where("instance_id = ?", instanceID).Order("id asc").Find(&promotions); result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error loading promotions")
+	}
+
+	rules := make([]*calculator.Promotion, 0, len(promotions))
+	for _, promotion := range promotions {
+		if promotion.Rule != nil {
+			rules = append(rules, promotion.Rule)
+		}
+	}
+	return rules, nil
+}
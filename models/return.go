@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// ReturnRequestedState is the state of a Return awaiting a decision.
+const ReturnRequestedState = "requested"
+
+// ReturnApprovedState is the state of an approved and refunded Return.
+const ReturnApprovedState = "approved"
+
+// ReturnRejectedState is the state of a rejected Return.
+const ReturnRejectedState = "rejected"
+
+// ReturnReceivedState is the state of a Return whose items arrived back.
+const ReturnReceivedState = "received"
+
+// ReturnStates are the possible values for the State field of a Return
+var ReturnStates = []string{
+	ReturnRequestedState,
+	ReturnApprovedState,
+	ReturnRejectedState,
+	ReturnReceivedState,
+}
+
+var returnTransitions = map[string][]string{
+	ReturnRequestedState: {ReturnApprovedState, ReturnRejectedState},
+	ReturnApprovedState:  {ReturnReceivedState},
+}
+
+// Return is a customer request to send back items of a paid order.
+type Return struct {
+	InstanceID string `json:"-" sql:"index"`
+	ID         string `json:"id"`
+
+	Order   *Order `json:"-"`
+	OrderID string `json:"order_id" sql:"index"`
+
+	UserID string `json:"user_id,omitempty"`
+
+	Reason string `json:"reason" sql:"type:text"`
+	Note   string `json:"note,omitempty" sql:"type:text"`
+	State  string `json:"state"`
+
+	Items []*ReturnItem `json:"items"`
+
+	Currency            string `json:"currency"`
+	RefundAmount        uint64 `json:"refund_amount"`
+	RefundTransactionID string `json:"refund_transaction_id,omitempty"`
+	// GiftCardAmount is the part of the refund put back on the gift card the
+	// order was paid with.
+	GiftCardAmount uint64 `json:"gift_card_amount,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"-" sql:"index"`
+}
+
+// TableName returns the database table name for the Return model.
+func (Return) TableName() string {
+	return tableName("returns")
+}
+
+// ReturnItem is a quantity of a single line item included in a Return.
+type ReturnItem struct {
+	ID       int64  `json:"id"`
+	ReturnID string `json:"-"`
+
+	LineItemID int64  `json:"line_item_id"`
+	Sku        string `json:"sku"`
+	Quantity   uint64 `json:"quantity"`
+}
+
+// TableName returns the database table name for the ReturnItem model.
+func (ReturnItem) TableName() string {
+	return tableName("return_items")
+}
+
+// NewReturn creates a new return request for an order.
+func NewReturn(order *Order, reason string) *Return {
+	return &Return{
+		InstanceID: order.InstanceID,
+		ID:         uuid.NewRandom().String(),
+		Order:      order,
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		Reason:     reason,
+		State:      ReturnRequestedState,
+		Currency:   order.Currency,
+	}
+}
+
+// AddItem adds a quantity of a line item to the return and accounts for its
+// share of the amount paid, including taxes and discounts.
+func (r *Return) AddItem(item *LineItem, quantity uint64) {
+	r.Items = append(r.Items, &ReturnItem{
+		LineItemID: item.ID,
+		Sku:        item.Sku,
+		Quantity:   quantity,
+	})
+	if item.CalculationDetail != nil && item.CalculationDetail.Total > 0 {
+		r.RefundAmount += uint64(item.CalculationDetail.Total) * quantity
+	}
+}
+
+// CanTransition returns whether the return can move to the given state.
+func (r *Return) CanTransition(state string) bool {
+	for _, next := range returnTransitions[r.State] {
+		if next == state {
+			return true
+		}
+	}
+	return false
+}
+
+// BeforeDelete database callback.
+func (r *Return) BeforeDelete(tx *gorm.DB) error {
+	tx = deleteScope(tx)
+	if result := tx.Delete(ReturnItem{}, "return_id = ?", r.ID); result.Error != nil {
+		return errors.Wrap(result.Error, "Error deleting return item records")
+	}
+	return nil
+}
+
+// ReturnedQuantities sums up the quantities per line item that are part of
+// returns for an order which haven't been rejected.
+func ReturnedQuantities(db *gorm.DB, orderID string) (map[int64]uint64, error) {
+	returns := []Return{}
+	if result := db.Preload("Items").Where("order_id = ? AND state != ?", orderID, ReturnRejectedState).Find(&returns); result.Error != nil {
+		return nil, errors.Wrap(result.Error, fmt.Sprintf("Error querying returns for order %s", orderID))
+	}
+
+	quantities := map[int64]uint64{}
+	for _, ret := range returns {
+		for _, item := range ret.Items {
+			quantities[item.LineItemID] += item.Quantity
+		}
+	}
+	return quantities, nil
+}
+
+// ReturnedToGiftCard sums up what the returns of an order put back on the gift
+// card it was paid with.
+func ReturnedToGiftCard(db *gorm.DB, orderID string) (uint64, error) {
+	returns := []Return{}
+	if result := db.Where("order_id = ? AND gift_card_amount > 0", orderID).Find(&returns); result.Error != nil {
+		return 0, errors.Wrap(result.Error, fmt.Sprintf("Error querying returns for order %s", orderID))
+	}
+
+	var amount uint64
+	for _, ret := range returns {
+		amount += ret.GiftCardAmount
+	}
+	return amount, nil
+}
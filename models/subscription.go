@@ -0,0 +1,380 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// SubscriptionActiveState is the state of a Subscription that renews at the
+// end of its current period.
+const SubscriptionActiveState = "active"
+
+// SubscriptionPastDueState is the state of a Subscription whose last renewal
+// payment failed.
+const SubscriptionPastDueState = "past_due"
+
+// SubscriptionPausedState is the state of a Subscription that doesn't renew
+// until it is resumed.
+const SubscriptionPausedState = "paused"
+
+// SubscriptionCanceledState is the state of a Subscription that ended.
+const SubscriptionCanceledState = "canceled"
+
+// SubscriptionStates are the possible values for the Status field of a Subscription
+var SubscriptionStates = []string{
+	SubscriptionActiveState,
+	SubscriptionPastDueState,
+	SubscriptionPausedState,
+	SubscriptionCanceledState,
+}
+
+var subscriptionTransitions = map[string][]string{
+	SubscriptionActiveState:   {SubscriptionPausedState, SubscriptionCanceledState},
+	SubscriptionPastDueState:  {SubscriptionPausedState, SubscriptionCanceledState},
+	SubscriptionPausedState:   {SubscriptionActiveState, SubscriptionCanceledState},
+	SubscriptionCanceledState: {},
+}
+
+// Billing intervals of recurring products.
+const (
+	DayInterval   = "day"
+	WeekInterval  = "week"
+	MonthInterval = "month"
+	YearInterval  = "year"
+)
+
+// ValidInterval tells if the billing interval is known.
+func ValidInterval(interval string) bool {
+	switch interval {
+	case DayInterval, WeekInterval, MonthInterval, YearInterval:
+		return true
+	}
+	return false
+}
+
+// Subscription is a recurring product bought by a customer. It renews at the
+// end of every period with a new paid order.
+type Subscription struct {
+	InstanceID string `json:"-" sql:"index"`
+	ID         string `json:"id"`
+
+	UserID string `json:"user_id,omitempty" sql:"index"`
+	Email  string `json:"email"`
+
+	// OrderID is the order that started the subscription and LastOrderID
+	// the order of its latest renewal.
+	OrderID     string `json:"order_id" sql:"index"`
+	LastOrderID string `json:"last_order_id"`
+
+	Sku           string `json:"sku"`
+	Title         string `json:"title"`
+	Path          string `json:"path"`
+	Plan          string `json:"plan,omitempty"`
+	Interval      string `json:"interval"`
+	IntervalCount uint64 `json:"interval_count"`
+	Quantity      uint64 `json:"quantity"`
+
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+
+	CurrentPeriodStart time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time `json:"current_period_end" sql:"index"`
+
+	PaymentProcessor string `json:"payment_processor"`
+	// PaymentID is the processor id of the last successful payment, which
+	// renewals charge again.
+	PaymentID string `json:"-"`
+	// CustomerID is the provider's customer the payment method is kept on.
+	CustomerID string `json:"-"`
+
+	// PastDueOrderID is the renewal order whose payment failed, which is
+	// retried at NextRetryAt.
+	PastDueOrderID string     `json:"past_due_order_id,omitempty"`
+	PastDueAt      *time.Time `json:"past_due_at,omitempty"`
+	FailedAttempts int        `json:"failed_attempts,omitempty"`
+	NextRetryAt    *time.Time `json:"next_retry_at,omitempty" sql:"index"`
+
+	PausedAt   *time.Time `json:"paused_at,omitempty"`
+	CanceledAt *time.Time `json:"canceled_at,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"-" sql:"index"`
+}
+
+// TableName returns the database table name for the Subscription model.
+func (Subscription) TableName() string {
+	return tableName("subscriptions")
+}
+
+// NewSubscriptions starts a subscription for every recurring line item of a
+// paid order, paid for with the payment.
+func NewSubscriptions(order *Order, paymentID string, now time.Time) []*Subscription {
+	subscriptions := []*Subscription{}
+	for _, item := range order.LineItems {
+		if !item.Recurring() {
+			continue
+		}
+		subscription := &Subscription{
+			InstanceID:       order.InstanceID,
+			ID:               uuid.NewRandom().String(),
+			UserID:           order.UserID,
+			Email:            order.Email,
+			OrderID:          order.ID,
+			LastOrderID:      order.ID,
+			Sku:              item.Sku,
+			Title:            item.Title,
+			Path:             item.Path,
+			Plan:             item.Plan,
+			Interval:         item.Interval,
+			IntervalCount:    item.IntervalCount,
+			Quantity:         item.Quantity,
+			Currency:         order.Currency,
+			Status:           SubscriptionActiveState,
+			PaymentProcessor: order.PaymentProcessor,
+			PaymentID:        paymentID,
+			CustomerID:       order.PaymentCustomerID,
+		}
+		subscription.startPeriod(now)
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+// CanTransition returns whether the subscription can move to the given state.
+func (s *Subscription) CanTransition(state string) bool {
+	for _, next := range subscriptionTransitions[s.Status] {
+		if next == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the subscription to the state. A resumed subscription
+// whose period ended while it was paused renews right away, and one with an
+// unpaid renewal retries its payment right away.
+func (s *Subscription) Transition(state string, now time.Time) {
+	switch state {
+	case SubscriptionPausedState:
+		s.PausedAt = &now
+	case SubscriptionActiveState:
+		s.PausedAt = nil
+		if s.PastDueOrderID != "" {
+			s.Status = SubscriptionPastDueState
+			s.NextRetryAt = &now
+			return
+		}
+		if s.CurrentPeriodEnd.Before(now) {
+			s.CurrentPeriodEnd = now
+		}
+	case SubscriptionCanceledState:
+		s.CanceledAt = &now
+		s.NextRetryAt = nil
+	}
+	s.Status = state
+}
+
+// ClaimRenewal starts the next period of a due subscription, before its
+// renewal is charged. Of several processes renewing the subscription at the
+// same time only the first one claims it, the others get false and must leave
+// it alone.
+func (s *Subscription) ClaimRenewal(tx *gorm.DB, now time.Time) (bool, error) {
+	s.startPeriod(s.CurrentPeriodEnd)
+	result := tx.Model(s).
+		Where("status = ? AND current_period_end <= ?", SubscriptionActiveState, now).
+		UpdateColumns(map[string]interface{}{
+			"current_period_start": s.CurrentPeriodStart,
+			"current_period_end":   s.CurrentPeriodEnd,
+		})
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "Error claiming subscription renewal")
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ClaimRetry takes the due payment retry of a past due subscription, like
+// ClaimRenewal does for renewals.
+func (s *Subscription) ClaimRetry(tx *gorm.DB, now time.Time) (bool, error) {
+	result := tx.Model(s).
+		Where("status = ? AND next_retry_at <= ?", SubscriptionPastDueState, now).
+		UpdateColumn("next_retry_at", gorm.Expr("NULL"))
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "Error claiming subscription payment retry")
+	}
+	s.NextRetryAt = nil
+	return result.RowsAffected == 1, nil
+}
+
+// RenewalKey identifies the current payment attempt of the subscription, so
+// the payment provider never charges the same attempt twice.
+func (s *Subscription) RenewalKey() string {
+	return fmt.Sprintf("renewal-%s-%d-%d", s.ID, s.CurrentPeriodStart.Unix(), s.FailedAttempts)
+}
+
+// Renewed records the payment of the renewal order for the current period of
+// the subscription. A paused subscription stays paused.
+func (s *Subscription) Renewed(order *Order, paymentID string) {
+	s.LastOrderID = order.ID
+	s.PaymentID = paymentID
+	if order.PaymentCustomerID != "" {
+		s.CustomerID = order.PaymentCustomerID
+	}
+	if s.Status == SubscriptionPastDueState {
+		s.Status = SubscriptionActiveState
+	}
+	s.PastDueOrderID = ""
+	s.PastDueAt = nil
+	s.FailedAttempts = 0
+	s.NextRetryAt = nil
+}
+
+// PaymentFailed records a failed payment of the renewal order and schedules
+// the next retry. The retry delays are hours after the first failure. It
+// returns true once all retries failed. Without retry delays the payment is
+// never retried and the subscription stays past due.
+func (s *Subscription) PaymentFailed(order *Order, now time.Time, retryDelays []int) bool {
+	if s.PastDueOrderID != order.ID {
+		s.PastDueOrderID = order.ID
+		s.PastDueAt = &now
+		s.FailedAttempts = 0
+	}
+	s.FailedAttempts++
+	s.Status = SubscriptionPastDueState
+	s.NextRetryAt = nil
+	if s.FailedAttempts <= len(retryDelays) {
+		next := s.PastDueAt.Add(time.Duration(retryDelays[s.FailedAttempts-1]) * time.Hour)
+		if next.Before(now) {
+			next = now
+		}
+		s.NextRetryAt = &next
+	}
+	return len(retryDelays) > 0 && s.NextRetryAt == nil
+}
+
+func (s *Subscription) startPeriod(start time.Time) {
+	count := int(s.IntervalCount)
+	if count == 0 {
+		count = 1
+	}
+
+	s.CurrentPeriodStart = start
+	switch s.Interval {
+	case DayInterval:
+		s.CurrentPeriodEnd = start.AddDate(0, 0, count)
+	case WeekInterval:
+		s.CurrentPeriodEnd = start.AddDate(0, 0, 7*count)
+	case YearInterval:
+		s.CurrentPeriodEnd = start.AddDate(count, 0, 0)
+	default:
+		s.CurrentPeriodEnd = start.AddDate(0, count, 0)
+	}
+}
+
+// NewRenewalOrder creates a pending order for the next period of the
+// subscription, with the line item, addresses, VAT number and claims of the
+// previous order. Renewals keep the price the subscription was bought for,
+// including the price items and addons of the line item.
+func (s *Subscription) NewRenewalOrder(previous *Order) (*Order, error) {
+	var item *LineItem
+	for _, lineItem := range previous.LineItems {
+		if lineItem.Sku == s.Sku && lineItem.Recurring() {
+			item = lineItem
+			break
+		}
+	}
+	if item == nil {
+		return nil, errors.Errorf("Order %s has no line item for %s", previous.ID, s.Sku)
+	}
+
+	order := NewOrder(s.InstanceID, "", s.Email, s.Currency)
+	order.UserID = s.UserID
+	order.SubscriptionID = s.ID
+	order.PaymentProcessor = s.PaymentProcessor
+	order.PaymentCustomerID = s.CustomerID
+	order.ShippingAddress = previous.ShippingAddress
+	order.ShippingAddressID = previous.ShippingAddressID
+	order.BillingAddress = previous.BillingAddress
+	order.BillingAddressID = previous.BillingAddressID
+	order.VATNumber = previous.VATNumber
+	order.VATNumberValid = previous.VATNumberValid
+	order.VATNumberUnverified = previous.VATNumberUnverified
+	order.Claims = previous.Claims
+
+	priceItems := make([]*PriceItem, len(item.PriceItems))
+	for i, priceItem := range item.PriceItems {
+		priceItems[i] = &PriceItem{Amount: priceItem.Amount, Type: priceItem.Type, VAT: priceItem.VAT}
+	}
+	addonItems := make([]*AddonItem, len(item.AddonItems))
+	for i, addon := range item.AddonItems {
+		addonItems[i] = &AddonItem{Sku: addon.Sku, Title: addon.Title, Description: addon.Description, Price: addon.Price}
+	}
+	order.LineItems = []*LineItem{{
+		OrderID:       order.ID,
+		Title:         item.Title,
+		Sku:           item.Sku,
+		Type:          item.Type,
+		Description:   item.Description,
+		Path:          item.Path,
+		Price:         item.Price,
+		TierPrice:     item.TierPrice,
+		VAT:           item.VAT,
+		ChosenPrice:   item.ChosenPrice,
+		PriceItems:    priceItems,
+		AddonItems:    addonItems,
+		AddonPrice:    item.AddonPrice,
+		Quantity:      s.Quantity,
+		MetaData:      item.MetaData,
+		Plan:          item.Plan,
+		Interval:      item.Interval,
+		IntervalCount: item.IntervalCount,
+	}}
+	return order, nil
+}
+
+// SubscriptionCustomerID returns the provider's customer of the latest
+// subscription of the user in the instance, or an empty string if there is
+// none.
+func SubscriptionCustomerID(db *gorm.DB, instanceID, userID, processor string) (string, error) {
+	subscription := &Subscription{}
+	result := db.Where("instance_id = ? AND user_id = ? AND payment_processor = ? AND customer_id <> ''", instanceID, userID, processor).
+		Order("created_at desc").
+		First(subscription)
+	if result.RecordNotFound() {
+		return "", nil
+	}
+	if result.Error != nil {
+		return "", errors.Wrap(result.Error, "Error loading subscriptions")
+	}
+	return subscription.CustomerID, nil
+}
+
+// DueSubscriptions loads the active subscriptions of the instance whose
+// current period ended.
+func DueSubscriptions(db *gorm.DB, instanceID string, now time.Time) ([]*Subscription, error) {
+	var subscriptions []*Subscription
+	result := db.Where("instance_id = ? AND status = ? AND current_period_end <= ?", instanceID, SubscriptionActiveState, now).
+		Order("current_period_end asc").
+		Find(&subscriptions)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error loading due subscriptions")
+	}
+	return subscriptions, nil
+}
+
+// RetryDueSubscriptions loads the past due subscriptions of the instance
+// whose next payment retry is due.
+func RetryDueSubscriptions(db *gorm.DB, instanceID string, now time.Time) ([]*Subscription, error) {
+	var subscriptions []*Subscription
+	result := db.Where("instance_id = ? AND status = ? AND next_retry_at <= ?", instanceID, SubscriptionPastDueState, now).
+		Order("next_retry_at asc").
+		Find(&subscriptions)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "Error loading past due subscriptions")
+	}
+	return subscriptions, nil
+}
@@ -13,6 +13,10 @@ const ChargeTransactionType = "charge"
 // RefundTransactionType is the refund transaction type.
 const RefundTransactionType = "refund"
 
+// FreeTransactionType is the transaction type of orders completed without a
+// payment, because their total is zero.
+const FreeTransactionType = "free"
+
 // Transaction is an transaction with a payment provider
 type Transaction struct {
 	InstanceID    string `json:"-"`
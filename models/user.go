@@ -97,6 +97,7 @@ func GetUser(db *gorm.DB, userID string) (*User, error) {
 }
 
 func (u *User) BeforeDelete(tx *gorm.DB) error {
+	tx = deleteScope(tx)
 	cascadeModels := map[string]interface{}{
 		"order": &[]Order{},
 	}
@@ -107,10 +108,11 @@ func (u *User) BeforeDelete(tx *gorm.DB) error {
 	}
 
 	delModels := map[string]interface{}{
-		"address":     Address{},
-		"hook":        Hook{},
-		"transaction": Transaction{},
-		"order note":  OrderNote{},
+		"address":        Address{},
+		"hook":           Hook{},
+		"transaction":    Transaction{},
+		"order note":     OrderNote{},
+		"payment method": PaymentMethod{},
 	}
 	for name, dm := range delModels {
 		if result := tx.Delete(dm, "user_id = ?", u.ID); result.Error != nil {
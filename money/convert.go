@@ -0,0 +1,117 @@
+package money
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Rounding strategies for converted amounts.
+const (
+	// RoundNearest rounds to the nearest minor unit.
+	RoundNearest = "nearest"
+	// RoundUp rounds up to the next minor unit.
+	RoundUp = "up"
+	// RoundWhole rounds to the nearest whole major unit, like 13.00.
+	RoundWhole = "whole"
+	// RoundNinetyNine rounds up to the next amount ending in .99, like 12.99.
+	// Currencies with less than two decimals end in 99 minor units instead.
+	RoundNinetyNine = "99"
+)
+
+// ValidRounding tells if the rounding strategy is known. An empty strategy
+// rounds to the nearest minor unit.
+func ValidRounding(rounding string) bool {
+	switch rounding {
+	case "", RoundNearest, RoundUp, RoundWhole, RoundNinetyNine:
+		return true
+	}
+	return false
+}
+
+// ParseRate parses a positive decimal exchange rate like "0.9215".
+func ParseRate(rate string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("Invalid exchange rate '%s'", rate)
+	}
+	return r, nil
+}
+
+// Convert converts an amount in minor units of one currency into the minor
+// units of another, with the rate being the price of one major unit of the
+// first currency in the second. The result is rounded with the strategy.
+func Convert(amount uint64, from, to string, rate *big.Rat, rounding string) (uint64, error) {
+	value := new(big.Rat).SetInt(new(big.Int).SetUint64(amount))
+	value.Mul(value, rate)
+	value.Mul(value, pow10Rat(Exponent(to)-Exponent(from)))
+
+	var result *big.Int
+	switch rounding {
+	case "", RoundNearest:
+		result = roundHalfUp(value)
+	case RoundUp:
+		result = ceil(value)
+	case RoundWhole:
+		step := pow10Int(Exponent(to))
+		result = roundHalfUp(new(big.Rat).Quo(value, new(big.Rat).SetInt(step)))
+		result.Mul(result, step)
+	case RoundNinetyNine:
+		exponent := Exponent(to)
+		step, unit := pow10Int(2), big.NewInt(1)
+		if exponent > 2 {
+			step, unit = pow10Int(exponent), pow10Int(exponent-2)
+		}
+		result = ceil(new(big.Rat).Quo(value, new(big.Rat).SetInt(step)))
+		result.Mul(result, step)
+		result.Sub(result, unit)
+		if result.Sign() < 0 {
+			result.SetInt64(0)
+		}
+	default:
+		return 0, fmt.Errorf("Unknown rounding '%s'", rounding)
+	}
+
+	if !result.IsUint64() {
+		return 0, fmt.Errorf("Converted amount of %s %s is too large", Decimal(amount, from), from)
+	}
+	return result.Uint64(), nil
+}
+
+// Scale multiplies an amount by the ratio of two others, rounded to the
+// nearest minor unit. It splits converted prices in the same proportions as
+// the original.
+func Scale(amount, numerator, denominator uint64) uint64 {
+	if denominator == 0 {
+		return 0
+	}
+	value := new(big.Rat).SetFrac(
+		new(big.Int).Mul(new(big.Int).SetUint64(amount), new(big.Int).SetUint64(numerator)),
+		new(big.Int).SetUint64(denominator),
+	)
+	return roundHalfUp(value).Uint64()
+}
+
+func pow10Int(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+func pow10Rat(exponent int) *big.Rat {
+	if exponent < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), pow10Int(-exponent))
+	}
+	return new(big.Rat).SetInt(pow10Int(exponent))
+}
+
+func ceil(value *big.Rat) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient
+}
+
+func roundHalfUp(value *big.Rat) *big.Int {
+	half := new(big.Rat).SetFrac64(1, 2)
+	shifted := new(big.Rat).Add(value, half)
+	return new(big.Int).Quo(shifted.Num(), shifted.Denom())
+}
@@ -0,0 +1,65 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("0.92")
+	require.NoError(t, err)
+	assert.Equal(t, "23/25", rate.String())
+
+	for _, invalid := range []string{"", "0", "-1.5", "abc"} {
+		_, err := ParseRate(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	for _, test := range []struct {
+		amount   uint64
+		from     string
+		to       string
+		rate     string
+		rounding string
+		expected uint64
+	}{
+		{1000, "USD", "EUR", "0.9215", "", 922},
+		{1000, "USD", "EUR", "0.9215", RoundNearest, 922},
+		{1000, "USD", "EUR", "0.9211", RoundUp, 922},
+		{1000, "USD", "EUR", "0.9215", RoundWhole, 900},
+		{1000, "USD", "EUR", "1.26", RoundWhole, 1300},
+		{1000, "USD", "EUR", "1.26", RoundNinetyNine, 1299},
+		{1000, "USD", "EUR", "1.299", RoundNinetyNine, 1299},
+		{1000, "USD", "EUR", "1.3", RoundNinetyNine, 1299},
+		{1000, "USD", "EUR", "1.30001", RoundNinetyNine, 1399},
+		{1999, "USD", "JPY", "149.5", "", 2989},
+		{1999, "USD", "JPY", "149.5", RoundWhole, 2989},
+		{1999, "USD", "JPY", "149.5", RoundNinetyNine, 2999},
+		{2989, "JPY", "USD", "0.00669", "", 2000},
+		{1000, "USD", "KWD", "0.3075", "", 3075},
+		{1000, "USD", "KWD", "0.3075", RoundNinetyNine, 3990},
+		{0, "USD", "EUR", "0.92", RoundNinetyNine, 0},
+	} {
+		rate, err := ParseRate(test.rate)
+		require.NoError(t, err)
+		converted, err := Convert(test.amount, test.from, test.to, rate, test.rounding)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, converted, "%d %s at %s with %s", test.amount, test.from, test.rate, test.rounding)
+	}
+
+	rate, _ := ParseRate("2")
+	_, err := Convert(1000, "USD", "EUR", rate, "sideways")
+	assert.Error(t, err)
+	assert.False(t, ValidRounding("sideways"))
+	assert.True(t, ValidRounding(RoundNinetyNine))
+}
+
+func TestScale(t *testing.T) {
+	assert.Equal(t, uint64(700), Scale(500, 1400, 1000))
+	assert.Equal(t, uint64(333), Scale(1000, 1, 3))
+	assert.Equal(t, uint64(0), Scale(1000, 1, 0))
+}
@@ -0,0 +1,70 @@
+package money
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	xcurrency "golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// DefaultLocale is used to format amounts when no locale is configured.
+const DefaultLocale = "en-US"
+
+// symbolAfter lists the languages that put the currency symbol after the
+// amount, like "1.234,56 €".
+var symbolAfter = map[string]bool{
+	"bg": true, "cs": true, "da": true, "de": true, "el": true, "es": true,
+	"et": true, "fi": true, "fr": true, "hr": true, "hu": true, "is": true,
+	"it": true, "lt": true, "lv": true, "nb": true, "nn": true, "no": true,
+	"pl": true, "ro": true, "ru": true, "sk": true, "sl": true, "sv": true,
+	"uk": true,
+}
+
+// Decimal formats an amount in minor units as a plain decimal number in the
+// major unit of the currency, like "19.99" for 1999 USD or "1999" for 1999
+// JPY, as expected by payment provider APIs.
+func Decimal(amount uint64, currency string) string {
+	exponent := Exponent(currency)
+	digits := strconv.FormatUint(amount, 10)
+	if exponent == 0 {
+		return digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// Format formats an amount in minor units for people, with the digit
+// grouping, decimal separator and currency symbol of the locale, like
+// "$1,234.56" for en-US or "1.234,56 €" for de-DE. Unknown locales are
+// formatted like DefaultLocale.
+func Format(amount uint64, currency, locale string) string {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.MustParse(DefaultLocale)
+	}
+	printer := message.NewPrinter(tag)
+
+	exponent := Exponent(currency)
+	value := float64(amount) / math.Pow10(exponent)
+	formatted := printer.Sprint(number.Decimal(value, number.Scale(exponent)))
+
+	symbol := strings.ToUpper(currency)
+	if unit, err := xcurrency.ParseISO(currency); err == nil {
+		symbol = printer.Sprint(xcurrency.Symbol(unit))
+	}
+
+	if base, _ := tag.Base(); symbolAfter[base.String()] {
+		return formatted + " " + symbol
+	}
+	if last := []rune(symbol); len(last) > 0 && unicode.IsLetter(last[len(last)-1]) {
+		return symbol + " " + formatted
+	}
+	return symbol + formatted
+}
@@ -0,0 +1,38 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimal(t *testing.T) {
+	assert.Equal(t, "19.99", Decimal(1999, "USD"))
+	assert.Equal(t, "0.05", Decimal(5, "USD"))
+	assert.Equal(t, "0.00", Decimal(0, "EUR"))
+	assert.Equal(t, "1999", Decimal(1999, "JPY"))
+	assert.Equal(t, "1.005", Decimal(1005, "KWD"))
+	assert.Equal(t, "0.050", Decimal(50, "BHD"))
+}
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		amount   uint64
+		currency string
+		locale   string
+		expected string
+	}{
+		{123456, "USD", "en-US", "$1,234.56"},
+		{123456, "USD", "", "$1,234.56"},
+		{123456, "EUR", "de-DE", "1.234,56 €"},
+		{123456, "EUR", "fr-FR", "1\u00a0234,56 €"},
+		{123456, "USD", "en-GB", "US$1,234.56"},
+		{123456, "GBP", "en-GB", "£1,234.56"},
+		{1500, "JPY", "ja-JP", "￥1,500"},
+		{1500, "JPY", "en-US", "¥1,500"},
+		{1005, "KWD", "en-US", "KWD 1.005"},
+		{1000, "XYZ", "en-US", "XYZ 10.00"},
+	} {
+		assert.Equal(t, test.expected, Format(test.amount, test.currency, test.locale))
+	}
+}
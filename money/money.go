@@ -0,0 +1,77 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	xcurrency "golang.org/x/text/currency"
+)
+
+const defaultExponent = 2
+
+// exponents lists the ISO 4217 currencies whose minor unit doesn't have 2
+// decimal digits.
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+
+	"CLF": 4, "UYW": 4,
+}
+
+// Exponent returns the number of decimal digits of the minor unit of the
+// currency as defined by ISO 4217, like 2 for USD cents or 0 for JPY. Unknown
+// currencies have 2 digits.
+func Exponent(currency string) int {
+	if exponent, ok := exponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return defaultExponent
+}
+
+// KnownCurrency tells if the currency is an ISO 4217 currency code.
+func KnownCurrency(currency string) bool {
+	_, err := xcurrency.ParseISO(currency)
+	return err == nil
+}
+
+// Parse converts a decimal amount like "19.99" into the minor units of the
+// currency, 1999 for USD, without any rounding. It fails for negative or
+// malformed amounts, and for amounts more precise than the currency's minor
+// unit.
+func Parse(amount, currency string) (uint64, error) {
+	exponent := Exponent(currency)
+	whole, fraction := strings.TrimSpace(amount), ""
+	if i := strings.IndexByte(whole, '.'); i >= 0 {
+		whole, fraction = whole[:i], whole[i+1:]
+	}
+	if (whole == "" && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("Invalid amount '%s'", amount)
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("Amount '%s' is more precise than the minor unit of %s", amount, currency)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	var value uint64
+	for _, digit := range whole + fraction {
+		if value > (math.MaxUint64-9)/10 {
+			return 0, fmt.Errorf("Amount '%s' is too large", amount)
+		}
+		value = value*10 + uint64(digit-'0')
+	}
+	return value, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponent(t *testing.T) {
+	assert.Equal(t, 2, Exponent("USD"))
+	assert.Equal(t, 2, Exponent(""))
+	assert.Equal(t, 0, Exponent("JPY"))
+	assert.Equal(t, 0, Exponent("jpy"))
+	assert.Equal(t, 3, Exponent("KWD"))
+	assert.Equal(t, 3, Exponent("IQD"))
+	assert.Equal(t, 4, Exponent("CLF"))
+	for _, currency := range []string{"IDR", "IRR", "ALL", "RSD", "HUF", "MGA"} {
+		assert.Equal(t, 2, Exponent(currency), currency)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		amount   string
+		currency string
+		expected uint64
+	}{
+		{"19.99", "USD", 1999},
+		{"0.29", "USD", 29},
+		{"1.005", "KWD", 1005},
+		{"1.5", "KWD", 1500},
+		{"10", "EUR", 1000},
+		{"10.", "EUR", 1000},
+		{".5", "EUR", 50},
+		{"9.990", "USD", 999},
+		{" 4.20 ", "USD", 420},
+		{"1500", "JPY", 1500},
+		{"1500.00", "JPY", 1500},
+		{"0", "USD", 0},
+	} {
+		amount, err := Parse(test.amount, test.currency)
+		require.NoError(t, err, test.amount)
+		assert.Equal(t, test.expected, amount, test.amount)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, test := range []struct {
+		amount   string
+		currency string
+	}{
+		{"", "USD"},
+		{".", "USD"},
+		{"-1.00", "USD"},
+		{"1,99", "USD"},
+		{"1.2.3", "USD"},
+		{"1e3", "USD"},
+		{"19.999", "USD"},
+		{"1500.5", "JPY"},
+		{"1.0005", "KWD"},
+		{"184467440737095516.16", "USD"},
+	} {
+		_, err := Parse(test.amount, test.currency)
+		assert.Error(t, err, test.amount)
+	}
+}
+
+func TestKnownCurrency(t *testing.T) {
+	assert.True(t, KnownCurrency("EUR"))
+	assert.True(t, KnownCurrency("jpy"))
+	assert.False(t, KnownCurrency("EURO"))
+	assert.False(t, KnownCurrency(""))
+}
@@ -16,16 +16,23 @@ const (
 )
 
 // Provider represents a payment provider that can optionally charge, refund,
-// preauthorize payments.
+// preauthorize, void and renew payments, and save payment methods of users.
 type Provider interface {
 	Name() string
 	NewCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (Charger, error)
 	NewRefunder(ctx context.Context, r *http.Request, log logrus.FieldLogger) (Refunder, error)
 	NewPreauthorizer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (Preauthorizer, error)
 	NewConfirmer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (Confirmer, error)
+	NewVoider(ctx context.Context, r *http.Request, log logrus.FieldLogger) (Voider, error)
+	NewRenewer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (Renewer, error)
+	NewMethodSaver(ctx context.Context, r *http.Request, log logrus.FieldLogger) (MethodSaver, error)
+	NewMethodRemover(ctx context.Context, r *http.Request, log logrus.FieldLogger) (MethodRemover, error)
+	NewMethodCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (MethodCharger, error)
 }
 
 // Charger wraps the Charge method which creates new payments with the provider.
+// Charges of recurring orders keep the payment method on the order's
+// PaymentCustomerID, which is set to a new customer if it is empty.
 type Charger func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error)
 
 // Refunder wraps the Refund method which refunds payments with the provider.
@@ -43,6 +50,27 @@ type PreauthorizationResult struct {
 // Confirmer wraps a confirm method used for checking two-step payments in a synchronous flow
 type Confirmer func(paymentID string) error
 
+// Voider wraps a void method used for cancelling pending payments that were never completed
+type Voider func(paymentID string) error
+
+// Renewer wraps a renew method used for charging subscription renewals with the
+// payment details of an earlier payment, without the customer being present.
+// Attempts with the same idempotency key are only charged once.
+type Renewer func(previousPaymentID string, amount uint64, currency string, order *models.Order, invoiceNumber int64, idempotencyKey string) (string, error)
+
+// MethodSaver wraps a save method used for keeping a payment method of a user
+// with the provider. Methods are saved on the provider's customer with the
+// customerID, or on a new customer for the user if it is empty.
+type MethodSaver func(customerID string, user *models.User) (*models.PaymentMethod, error)
+
+// MethodRemover wraps a remove method used for deleting a saved payment method
+// from the provider
+type MethodRemover func(method *models.PaymentMethod) error
+
+// MethodCharger wraps a charge method used for paying an order with a saved
+// payment method
+type MethodCharger func(method *models.PaymentMethod, amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error)
+
 // PaymentPendingError is returned when the payment provider requests additional action
 // e.g. 2-step authorization through 3D secure
 type PaymentPendingError struct {
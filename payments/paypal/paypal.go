@@ -5,11 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
 	"github.com/pariz/gountries"
 	"github.com/sirupsen/logrus"
 
@@ -102,7 +102,7 @@ func prepareItemsFromOrder(order *models.Order) []paypalsdk.Item {
 		item := paypalsdk.Item{
 			Quantity:    int(lineItem.GetQuantity()),
 			Name:        lineItem.Title,
-			Price:       formatAmount(lineItem.PriceInLowestUnit()),
+			Price:       formatAmount(lineItem.PriceInLowestUnit(), order.Currency),
 			Currency:    order.Currency,
 			SKU:         lineItem.ProductSku(),
 			Description: lineItem.Description,
@@ -170,7 +170,7 @@ func (p *paypalPaymentProvider) charge(log logrus.FieldLogger, paymentID string,
 		return "", fmt.Errorf("No amount in this transaction %v", payment.Transactions[0])
 	}
 
-	transactionValue := fmt.Sprintf("%.2f", float64(amount)/100)
+	transactionValue := formatAmount(amount, currency)
 
 	if transactionValue != payment.Transactions[0].Amount.Total || payment.Transactions[0].Amount.Currency != currency {
 		return "", fmt.Errorf("The Amount in the transaction doesn't match the amount for the order: %v", payment.Transactions[0].Amount)
@@ -199,7 +199,7 @@ func (p *paypalPaymentProvider) NewRefunder(ctx context.Context, r *http.Request
 
 func (p *paypalPaymentProvider) refund(transactionID string, amount uint64, currency string) (string, error) {
 	amt := &paypalsdk.Amount{
-		Total:    formatAmount(amount),
+		Total:    formatAmount(amount, currency),
 		Currency: currency,
 	}
 	ref, err := p.client.RefundSale(transactionID, amt)
@@ -232,7 +232,7 @@ func (p *paypalPaymentProvider) preauthorize(config *conf.Configuration, amount
 		ExperienceProfileID: profile.ID,
 		Transactions: []paypalsdk.Transaction{paypalsdk.Transaction{
 			Amount: &paypalsdk.Amount{
-				Total:    formatAmount(amount),
+				Total:    formatAmount(amount, currency),
 				Currency: currency,
 			},
 			Description: description,
@@ -275,10 +275,52 @@ func (p *paypalPaymentProvider) getExperience() (*paypalsdk.WebProfile, error) {
 	return profile, nil
 }
 
-func formatAmount(amount uint64) string {
-	return strconv.FormatFloat(float64(amount)/100, 'f', 2, 64)
+// formatAmount converts an amount in minor units to the decimal amount PayPal expects.
+func formatAmount(amount uint64, currency string) string {
+	return money.Decimal(amount, currency)
 }
 
 func (p *paypalPaymentProvider) NewConfirmer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Confirmer, error) {
 	return nil, errors.New("Paypal does not provide manual 2-step confirmation")
 }
+
+func (p *paypalPaymentProvider) NewVoider(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Voider, error) {
+	return p.void, nil
+}
+
+// void releases the authorizations held for a payment. Payments that were
+// never approved expire by themselves, so there is nothing to void for them.
+func (p *paypalPaymentProvider) void(paymentID string) error {
+	payment, err := p.client.GetPayment(paymentID)
+	if err != nil {
+		return err
+	}
+	for _, transaction := range payment.Transactions {
+		for _, related := range transaction.RelatedResources {
+			auth := related.Authorization
+			if auth == nil || auth.State != "authorized" {
+				continue
+			}
+			if _, err := p.client.VoidAuthorization(auth.ID); err != nil {
+				return errors.Wrapf(err, "Error voiding authorization %s", auth.ID)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *paypalPaymentProvider) NewRenewer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Renewer, error) {
+	return nil, errors.New("Paypal does not support renewing subscriptions")
+}
+
+func (p *paypalPaymentProvider) NewMethodSaver(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodSaver, error) {
+	return nil, errors.New("Paypal does not support saving payment methods")
+}
+
+func (p *paypalPaymentProvider) NewMethodRemover(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodRemover, error) {
+	return nil, errors.New("Paypal does not support saving payment methods")
+}
+
+func (p *paypalPaymentProvider) NewMethodCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodCharger, error) {
+	return nil, errors.New("Paypal does not support saving payment methods")
+}
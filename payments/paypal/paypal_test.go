@@ -0,0 +1,48 @@
+package paypal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoid(t *testing.T) {
+	voided := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/oauth2/token":
+			fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600}`)
+		case "/v1/payments/payment/PAY-1":
+			fmt.Fprint(w, `{"id": "PAY-1", "transactions": [{"related_resources": [
+				{"authorization": {"id": "AUTH-1", "state": "authorized"}},
+				{"authorization": {"id": "AUTH-2", "state": "captured"}}
+			]}]}`)
+		case "/v1/payments/payment/PAY-2":
+			fmt.Fprint(w, `{"id": "PAY-2", "state": "created", "transactions": [{}]}`)
+		case "/v1/payments/authorization/AUTH-1/void":
+			assert.Equal(t, http.MethodPost, r.Method)
+			voided = append(voided, "AUTH-1")
+			fmt.Fprint(w, `{"id": "AUTH-1", "state": "voided"}`)
+		default:
+			t.Errorf("unexpected PayPal API call to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewPaymentProvider(Config{ClientID: "client", Secret: "secret", Env: server.URL})
+	require.NoError(t, err)
+	void, err := provider.NewVoider(context.Background(), nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, void("PAY-1"))
+	assert.Equal(t, []string{"AUTH-1"}, voided)
+
+	require.NoError(t, void("PAY-2"))
+	assert.Equal(t, []string{"AUTH-1"}, voided)
+}
@@ -0,0 +1,37 @@
+// Package providers creates the payment providers enabled in a configuration.
+// It is kept apart from the payments package, which the providers import, so
+// the API and the background jobs can share it.
+package providers
+
+import (
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/netlify/gocommerce/payments/paypal"
+	"github.com/netlify/gocommerce/payments/stripe"
+)
+
+// New creates instance(s) of Provider based on the configuration provided.
+func New(c *conf.Configuration) (map[string]payments.Provider, error) {
+	provs := map[string]payments.Provider{}
+	if c.Payment.Stripe.Enabled {
+		p, err := stripe.NewPaymentProvider(stripe.Config{
+			SecretKey: c.Payment.Stripe.SecretKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		provs[p.Name()] = p
+	}
+	if c.Payment.PayPal.Enabled {
+		p, err := paypal.NewPaymentProvider(paypal.Config{
+			Env:      c.Payment.PayPal.Env,
+			ClientID: c.Payment.PayPal.ClientID,
+			Secret:   c.Payment.PayPal.Secret,
+		})
+		if err != nil {
+			return nil, err
+		}
+		provs[p.Name()] = p
+	}
+	return provs, nil
+}
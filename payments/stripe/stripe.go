@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"encoding/json"
 
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/money"
 	"github.com/netlify/gocommerce/payments"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -15,6 +17,15 @@ import (
 	"github.com/stripe/stripe-go/client"
 )
 
+// stripeExponents lists the currencies whose smallest unit at Stripe differs
+// from their ISO 4217 minor unit. Stripe expects ISK and UGX with two decimals,
+// and MGA without any.
+var stripeExponents = map[string]int{
+	"ISK": 2,
+	"MGA": 0,
+	"UGX": 2,
+}
+
 type stripePaymentProvider struct {
 	client *client.API
 }
@@ -80,23 +91,37 @@ func prepareShippingAddress(addr models.Address) *stripe.ShippingDetailsParams {
 }
 
 func (s *stripePaymentProvider) chargePaymentIntent(paymentMethodID string, amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
-	params := &stripe.PaymentIntentParams{
-		PaymentMethod: stripe.String(paymentMethodID),
-		Amount:        stripe.Int64(int64(amount)),
-		Currency:      stripe.String(currency),
-		Description:   stripe.String(fmt.Sprintf("Invoice No. %d", invoiceNumber)),
-		Shipping:      prepareShippingAddress(order.ShippingAddress),
-		Params: stripe.Params{
-			Metadata: map[string]string{
-				"order_id":       order.ID,
-				"invoice_number": fmt.Sprintf("%d", invoiceNumber),
-			},
-		},
-		ConfirmationMethod: stripe.String(string(
-			stripe.PaymentIntentConfirmationMethodManual,
-		)),
-		Confirm: stripe.Bool(true),
+	params, err := paymentIntentParams(amount, currency, order, invoiceNumber)
+	if err != nil {
+		return "", err
+	}
+	params.PaymentMethod = stripe.String(paymentMethodID)
+	params.ConfirmationMethod = stripe.String(string(
+		stripe.PaymentIntentConfirmationMethodManual,
+	))
+	if order.Recurring() {
+		// keep the card on a customer for charging the renewals, which is
+		// only created if the buyer has none yet
+		if order.PaymentCustomerID == "" {
+			customer, err := s.client.Customers.New(&stripe.CustomerParams{
+				Email:         stripe.String(order.Email),
+				Name:          stripe.String(order.BillingAddress.Name),
+				PaymentMethod: stripe.String(paymentMethodID),
+			})
+			if err != nil {
+				return "", err
+			}
+			order.PaymentCustomerID = customer.ID
+		}
+		params.Customer = stripe.String(order.PaymentCustomerID)
+		params.SetupFutureUsage = stripe.String(string(stripe.PaymentIntentSetupFutureUsageOffSession))
 	}
+	return s.createPaymentIntent(params)
+}
+
+// createPaymentIntent creates and confirms the PaymentIntent. Intents that need
+// further actions by the customer are pending.
+func (s *stripePaymentProvider) createPaymentIntent(params *stripe.PaymentIntentParams) (string, error) {
 	intent, err := s.client.PaymentIntents.New(params)
 	if err != nil {
 		return "", err
@@ -115,15 +140,59 @@ func (s *stripePaymentProvider) chargePaymentIntent(paymentMethodID string, amou
 	return "", fmt.Errorf("Invalid PaymentIntent status: %s", intent.Status)
 }
 
+func paymentIntentParams(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*stripe.PaymentIntentParams, error) {
+	stripeAmount, err := stripeAmount(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &stripe.PaymentIntentParams{
+		Amount:      stripe.Int64(stripeAmount),
+		Currency:    stripe.String(currency),
+		Description: stripe.String(fmt.Sprintf("Invoice No. %d", invoiceNumber)),
+		Shipping:    prepareShippingAddress(order.ShippingAddress),
+		Params: stripe.Params{
+			Metadata: map[string]string{
+				"order_id":       order.ID,
+				"invoice_number": fmt.Sprintf("%d", invoiceNumber),
+			},
+		},
+		Confirm: stripe.Bool(true),
+	}, nil
+}
+
+// stripeAmount converts an amount in minor units to the smallest unit Stripe
+// uses for the currency. It fails for amounts Stripe can't represent, like
+// fractions of an MGA.
+func stripeAmount(amount uint64, currency string) (int64, error) {
+	exponent := money.Exponent(currency)
+	stripeExponent, ok := stripeExponents[strings.ToUpper(currency)]
+	if !ok {
+		return int64(amount), nil
+	}
+	for ; exponent < stripeExponent; exponent++ {
+		amount *= 10
+	}
+	for ; exponent > stripeExponent; exponent-- {
+		if amount%10 != 0 {
+			return 0, fmt.Errorf("Stripe can't charge fractions of the smallest unit of %s", strings.ToUpper(currency))
+		}
+		amount /= 10
+	}
+	return int64(amount), nil
+}
+
 func (s *stripePaymentProvider) NewRefunder(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Refunder, error) {
 	return s.refund, nil
 }
 
 func (s *stripePaymentProvider) refund(transactionID string, amount uint64, currency string) (string, error) {
-	stripeAmount := int64(amount)
+	stripeAmount, err := stripeAmount(amount, currency)
+	if err != nil {
+		return "", err
+	}
 	ref, err := s.client.Refunds.New(&stripe.RefundParams{
 		Charge: &transactionID,
-		Amount: &stripeAmount,
+		Amount: stripe.Int64(stripeAmount),
 	})
 	if err != nil {
 		return "", err
@@ -149,3 +218,134 @@ func (s *stripePaymentProvider) confirm(paymentID string) error {
 
 	return err
 }
+
+func (s *stripePaymentProvider) NewVoider(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Voider, error) {
+	return s.void, nil
+}
+
+func (s *stripePaymentProvider) void(paymentID string) error {
+	_, err := s.client.PaymentIntents.Cancel(paymentID, nil)
+	return err
+}
+
+func (s *stripePaymentProvider) NewRenewer(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.Renewer, error) {
+	return s.renew, nil
+}
+
+// renew charges the card of an earlier payment again, which was kept on its
+// customer for future use.
+func (s *stripePaymentProvider) renew(previousPaymentID string, amount uint64, currency string, order *models.Order, invoiceNumber int64, idempotencyKey string) (string, error) {
+	previous, err := s.client.PaymentIntents.Get(previousPaymentID, nil)
+	if err != nil {
+		return "", err
+	}
+	if previous.Customer == nil || previous.PaymentMethod == nil {
+		return "", fmt.Errorf("PaymentIntent %s wasn't set up for future payments", previousPaymentID)
+	}
+
+	params, err := paymentIntentParams(amount, currency, order, invoiceNumber)
+	if err != nil {
+		return "", err
+	}
+	params.Customer = stripe.String(previous.Customer.ID)
+	params.PaymentMethod = stripe.String(previous.PaymentMethod.ID)
+	params.OffSession = stripe.Bool(true)
+	params.SetIdempotencyKey(idempotencyKey)
+	intent, err := s.client.PaymentIntents.New(params)
+	if err != nil {
+		return "", err
+	}
+	if intent.Status != stripe.PaymentIntentStatusSucceeded {
+		return "", fmt.Errorf("Invalid PaymentIntent status: %s", intent.Status)
+	}
+	return intent.ID, nil
+}
+
+func (s *stripePaymentProvider) NewMethodSaver(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodSaver, error) {
+	var bp stripeBodyParams
+	bod, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewDecoder(bod).Decode(&bp)
+	if err != nil {
+		return nil, err
+	}
+
+	if bp.StripePaymentMethodID == "" {
+		return nil, errors.New("Stripe requires a stripe_payment_method_id for saving a payment method")
+	}
+	return func(customerID string, user *models.User) (*models.PaymentMethod, error) {
+		return s.saveMethod(bp.StripePaymentMethodID, customerID, user)
+	}, nil
+}
+
+// saveMethod attaches the payment method to the customer of the user, which
+// is created with the first saved method.
+func (s *stripePaymentProvider) saveMethod(paymentMethodID, customerID string, user *models.User) (*models.PaymentMethod, error) {
+	if customerID == "" {
+		customer, err := s.client.Customers.New(&stripe.CustomerParams{
+			Email: stripe.String(user.Email),
+			Name:  stripe.String(user.Name),
+			Params: stripe.Params{
+				Metadata: map[string]string{"user_id": user.ID},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		customerID = customer.ID
+	}
+
+	pm, err := s.client.PaymentMethods.Attach(paymentMethodID, &stripe.PaymentMethodAttachParams{
+		Customer: stripe.String(customerID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pm.Card == nil {
+		return nil, fmt.Errorf("PaymentMethod %s is not a card", paymentMethodID)
+	}
+
+	return &models.PaymentMethod{
+		PaymentProcessor: payments.StripeProvider,
+		ProcessorID:      pm.ID,
+		CustomerID:       customerID,
+		Brand:            string(pm.Card.Brand),
+		Last4:            pm.Card.Last4,
+		ExpMonth:         pm.Card.ExpMonth,
+		ExpYear:          pm.Card.ExpYear,
+	}, nil
+}
+
+func (s *stripePaymentProvider) NewMethodRemover(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodRemover, error) {
+	return s.removeMethod, nil
+}
+
+func (s *stripePaymentProvider) removeMethod(method *models.PaymentMethod) error {
+	_, err := s.client.PaymentMethods.Detach(method.ProcessorID, nil)
+	return err
+}
+
+func (s *stripePaymentProvider) NewMethodCharger(ctx context.Context, r *http.Request, log logrus.FieldLogger) (payments.MethodCharger, error) {
+	return s.chargeMethod, nil
+}
+
+// chargeMethod pays the order with a saved payment method. Like cards entered
+// at checkout it may still require the customer to authenticate the payment.
+func (s *stripePaymentProvider) chargeMethod(method *models.PaymentMethod, amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
+	params, err := paymentIntentParams(amount, currency, order, invoiceNumber)
+	if err != nil {
+		return "", err
+	}
+	params.Customer = stripe.String(method.CustomerID)
+	params.PaymentMethod = stripe.String(method.ProcessorID)
+	params.ConfirmationMethod = stripe.String(string(
+		stripe.PaymentIntentConfirmationMethodManual,
+	))
+	if order.Recurring() {
+		order.PaymentCustomerID = method.CustomerID
+		params.SetupFutureUsage = stripe.String(string(stripe.PaymentIntentSetupFutureUsageOffSession))
+	}
+	return s.createPaymentIntent(params)
+}
@@ -0,0 +1,31 @@
+package stripe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripeAmount(t *testing.T) {
+	for _, test := range []struct {
+		amount   uint64
+		currency string
+		expected int64
+	}{
+		{1999, "USD", 1999},
+		{1999, "JPY", 1999},
+		{1999, "IDR", 1999},
+		{1500, "KWD", 1500},
+		{1999, "isk", 199900},
+		{1999, "UGX", 199900},
+		{199900, "MGA", 1999},
+	} {
+		amount, err := stripeAmount(test.amount, test.currency)
+		require.NoError(t, err, test.currency)
+		assert.Equal(t, test.expected, amount, test.currency)
+	}
+
+	_, err := stripeAmount(199950, "MGA")
+	assert.Error(t, err)
+}
@@ -0,0 +1,275 @@
+package products
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/httpcache"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+)
+
+const fetchTimeout = 10 * time.Second
+
+const (
+	// HTMLSource reads products from script tags on the product pages.
+	HTMLSource = "html"
+	// JSONSource reads products from a single JSON catalog.
+	JSONSource = "json"
+)
+
+// PageNotFound is an error when a product page doesn't exist on the site.
+type PageNotFound struct {
+	Path string
+}
+
+func (e *PageNotFound) Error() string {
+	return fmt.Sprintf("Product page '%s' not found", e.Path)
+}
+
+type catalog struct {
+	Products []*models.LineItemMetadata `json:"products"`
+}
+
+type noProductsError struct {
+	path string
+}
+
+func (e *noProductsError) Error() string {
+	return fmt.Sprintf("No script tag with class gocommerce-product tag found for '%v'", e.path)
+}
+
+// Cache keeps the product metadata found on the pages of a site, or in its
+// JSON catalog, so orders don't fetch a product page for every line item.
+// Pages are cached for the max-age of their Cache-Control header, or the
+// configured TTL, and are revalidated with their ETag or Last-Modified date
+// once stale. The last known version of a page is used while the site can't
+// be reached.
+type Cache struct {
+	siteURL    string
+	source     string
+	catalogURL string
+	ttl        time.Duration
+	client     *http.Client
+
+	mutex sync.Mutex
+	pages map[string]*page
+}
+
+type page struct {
+	products   []*models.LineItemMetadata
+	validators httpcache.Validators
+	lifetime   time.Duration
+	expires    time.Time
+}
+
+// NewCache creates a product cache for the site of the configuration.
+func NewCache(config *conf.Configuration) *Cache {
+	return &Cache{
+		siteURL:    config.SiteURL,
+		source:     source(config),
+		catalogURL: config.Products.CatalogURL,
+		ttl:        time.Duration(config.Products.CacheTTL) * time.Second,
+		client:     &http.Client{Timeout: fetchTimeout},
+		pages:      map[string]*page{},
+	}
+}
+
+// UsesConfig tells if the cache was created for the site and product
+// settings of the configuration.
+func (c *Cache) UsesConfig(config *conf.Configuration) bool {
+	return c.siteURL == config.SiteURL &&
+		c.source == source(config) &&
+		c.catalogURL == config.Products.CatalogURL &&
+		c.ttl == time.Duration(config.Products.CacheTTL)*time.Second
+}
+
+func source(config *conf.Configuration) string {
+	if config.Products.Source == "" {
+		return HTMLSource
+	}
+	return config.Products.Source
+}
+
+// Lookup finds the product with the SKU. Products from HTML pages are looked
+// up on the page at the path, and if the SKU is empty and the page only has a
+// single product, that product is returned. Products from the JSON catalog
+// are only found by SKU. The returned metadata is shared and must not be
+// modified.
+func (c *Cache) Lookup(path, sku string) (*models.LineItemMetadata, error) {
+	switch c.source {
+	case HTMLSource:
+	case JSONSource:
+		if sku == "" {
+			return nil, errors.New("Line items need a SKU to be found in the product catalog")
+		}
+		path = c.catalogURL
+	default:
+		return nil, fmt.Errorf("Unknown product source '%s'", c.source)
+	}
+
+	products, err := c.products(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(products) == 1 && sku == "" {
+		return products[0], nil
+	}
+	for _, meta := range products {
+		if meta.Sku == sku {
+			return meta, nil
+		}
+	}
+	return nil, fmt.Errorf("No product Sku from path matched: %v", sku)
+}
+
+// Purge removes the page at the path from the cache, or every page if the
+// path is empty. It returns the number of pages removed.
+func (c *Cache) Purge(path string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if path == "" {
+		count := len(c.pages)
+		c.pages = map[string]*page{}
+		return count
+	}
+	if _, ok := c.pages[path]; !ok {
+		return 0
+	}
+	delete(c.pages, path)
+	return 1
+}
+
+func (c *Cache) products(path string) ([]*models.LineItemMetadata, error) {
+	c.mutex.Lock()
+	cached := c.pages[path]
+	c.mutex.Unlock()
+
+	if cached != nil && time.Now().Before(cached.expires) {
+		return cached.products, nil
+	}
+
+	fetched, store, err := c.fetch(path, cached)
+	if err != nil {
+		if _, ok := err.(*PageNotFound); ok {
+			c.Purge(path)
+			return nil, err
+		}
+		if cached != nil {
+			// keep selling with the last known metadata while the site is down
+			return cached.products, nil
+		}
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if store {
+		c.pages[path] = fetched
+	} else {
+		delete(c.pages, path)
+	}
+	c.mutex.Unlock()
+
+	return fetched.products, nil
+}
+
+func (c *Cache) fetch(path string, cached *page) (*page, bool, error) {
+	req, err := c.newRequest(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if cached != nil {
+		cached.validators.Apply(req)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Failed to fetch product page '%s'", path)
+	}
+	defer resp.Body.Close()
+
+	maxAge, store := httpcache.Freshness(resp.Header, c.ttl)
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		revalidated := *cached
+		if resp.Header.Get("Cache-Control") != "" {
+			revalidated.lifetime = maxAge
+		}
+		revalidated.expires = time.Now().Add(revalidated.lifetime)
+		return &revalidated, store, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, &PageNotFound{Path: path}
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("Product page '%s' returned %v", path, resp.StatusCode)
+	}
+
+	var products []*models.LineItemMetadata
+	if c.source == JSONSource {
+		products, err = parseCatalog(resp.Body)
+	} else {
+		products, err = parseProducts(path, resp.Body)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &page{
+		products:   products,
+		validators: httpcache.ValidatorsOf(resp),
+		lifetime:   maxAge,
+		expires:    time.Now().Add(maxAge),
+	}, store, nil
+}
+
+func (c *Cache) newRequest(path string) (*http.Request, error) {
+	url := path
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = c.siteURL + path
+	}
+	return http.NewRequest(http.MethodGet, url, nil)
+}
+
+func parseProducts(path string, body io.Reader) ([]*models.LineItemMetadata, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, err
+	}
+
+	metaTag := doc.Find(".gocommerce-product")
+	if metaTag.Length() == 0 {
+		return nil, &noProductsError{path}
+	}
+
+	products := []*models.LineItemMetadata{}
+	var parsingErr error
+	metaTag.EachWithBreak(func(_ int, tag *goquery.Selection) bool {
+		meta := &models.LineItemMetadata{}
+		parsingErr = json.Unmarshal([]byte(tag.Text()), meta)
+		if parsingErr != nil {
+			return false
+		}
+		products = append(products, meta)
+		return true
+	})
+	if parsingErr != nil {
+		return nil, fmt.Errorf("Error parsing product metadata: %v", parsingErr)
+	}
+	return products, nil
+}
+
+func parseCatalog(body io.Reader) ([]*models.LineItemMetadata, error) {
+	catalog := &catalog{}
+	if err := json.NewDecoder(body).Decode(catalog); err != nil {
+		return nil, fmt.Errorf("Error parsing product catalog: %v", err)
+	}
+	return catalog.Products, nil
+}
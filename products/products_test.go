@@ -0,0 +1,184 @@
+package products
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+)
+
+const productPage = `<!doctype html>
+<html>
+<body>
+	<script class="gocommerce-product">
+		{"sku": "batarang", "title": "Batarang", "prices": [{"amount": "9.99", "currency": "USD"}]}
+	</script>
+	%s
+</body>
+</html>`
+
+func testCache(url string, ttl int) *Cache {
+	config := &conf.Configuration{SiteURL: url}
+	config.Products.CacheTTL = ttl
+	return NewCache(config)
+}
+
+func TestLookup(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/single":
+			fmt.Fprintf(w, productPage, "")
+		case "/multiple":
+			fmt.Fprintf(w, productPage, `<script class="gocommerce-product">{"sku": "grapple", "title": "Grapple"}</script>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	meta, err := cache.Lookup("/single", "")
+	require.NoError(t, err)
+	assert.Equal(t, "batarang", meta.Sku)
+
+	meta, err = cache.Lookup("/multiple", "grapple")
+	require.NoError(t, err)
+	assert.Equal(t, "Grapple", meta.Title)
+
+	_, err = cache.Lookup("/multiple", "")
+	assert.Error(t, err)
+
+	_, err = cache.Lookup("/missing", "")
+	assert.IsType(t, &PageNotFound{}, err)
+}
+
+func TestLookupCatalog(t *testing.T) {
+	var callCount int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		assert.Equal(t, "/gocommerce/products.json", r.URL.Path)
+		fmt.Fprint(w, `{"products": [
+			{"sku": "batarang", "title": "Batarang", "prices": [{"amount": "9.99", "currency": "USD"}]},
+			{"sku": "grapple", "title": "Grapple"}
+		]}`)
+	}))
+	defer svr.Close()
+	config := &conf.Configuration{SiteURL: svr.URL}
+	config.Products.Source = JSONSource
+	config.Products.CatalogURL = "/gocommerce/products.json"
+	config.Products.CacheTTL = 60
+	cache := NewCache(config)
+
+	meta, err := cache.Lookup("", "grapple")
+	require.NoError(t, err)
+	assert.Equal(t, "Grapple", meta.Title)
+
+	meta, err = cache.Lookup("/some/page", "batarang")
+	require.NoError(t, err)
+	assert.Equal(t, "Batarang", meta.Title)
+
+	_, err = cache.Lookup("", "batmobile")
+	assert.Error(t, err)
+	_, err = cache.Lookup("/some/page", "")
+	assert.Error(t, err)
+	assert.Equal(t, 1, callCount)
+}
+
+func TestCaching(t *testing.T) {
+	var callCount int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		fmt.Fprintf(w, productPage, "")
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	for i := 0; i < 3; i++ {
+		_, err := cache.Lookup("/product", "batarang")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, callCount)
+
+	assert.Equal(t, 1, cache.Purge("/product"))
+	assert.Equal(t, 0, cache.Purge("/product"))
+	_, err := cache.Lookup("/product", "batarang")
+	require.NoError(t, err)
+	assert.Equal(t, 2, callCount)
+
+	assert.Equal(t, 1, cache.Purge(""))
+}
+
+func TestRevalidation(t *testing.T) {
+	var callCount, notModified int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "public, max-age=0, must-revalidate")
+		fmt.Fprintf(w, productPage, "")
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	for i := 0; i < 3; i++ {
+		meta, err := cache.Lookup("/product", "batarang")
+		require.NoError(t, err)
+		assert.Equal(t, "Batarang", meta.Title)
+	}
+	assert.Equal(t, 3, callCount)
+	assert.Equal(t, 2, notModified)
+}
+
+func TestNoStore(t *testing.T) {
+	var callCount int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintf(w, productPage, "")
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.Lookup("/product", "batarang")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, callCount)
+	assert.Equal(t, 0, cache.Purge(""))
+}
+
+func TestStaleWhileSiteIsDown(t *testing.T) {
+	broken := false
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintf(w, productPage, "")
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	_, err := cache.Lookup("/product", "batarang")
+	require.NoError(t, err)
+
+	broken = true
+	cache.pages["/product"].expires = time.Now().Add(-time.Second)
+	meta, err := cache.Lookup("/product", "batarang")
+	require.NoError(t, err)
+	assert.Equal(t, "Batarang", meta.Title)
+
+	cache.Purge("")
+	_, err = cache.Lookup("/product", "batarang")
+	assert.Error(t, err)
+}
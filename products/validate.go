@@ -0,0 +1,128 @@
+package products
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Problem is a mistake found in the product metadata of a site.
+type Problem struct {
+	Path    string
+	Sku     string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Sku == "" {
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s [%s]: %s", p.Path, p.Sku, p.Message)
+}
+
+type sitemap struct {
+	URLs     []sitemapLocation `xml:"url"`
+	Sitemaps []sitemapLocation `xml:"sitemap"`
+}
+
+type sitemapLocation struct {
+	Loc string `xml:"loc"`
+}
+
+// Validate fetches the products at the paths, bypassing the cache, and checks
+// their metadata. With the JSON source the catalog is checked instead. Pages
+// without any products are skipped, unless products are required on every
+// page.
+func (c *Cache) Validate(paths []string, requireProducts bool) []Problem {
+	if c.source == JSONSource {
+		paths = []string{c.catalogURL}
+	}
+
+	problems := []Problem{}
+	skus := map[string]string{}
+	for _, path := range paths {
+		page, _, err := c.fetch(path, nil)
+		if err != nil {
+			if _, ok := err.(*noProductsError); ok && !requireProducts {
+				continue
+			}
+			problems = append(problems, Problem{Path: path, Message: err.Error()})
+			continue
+		}
+
+		for _, meta := range page.products {
+			for _, err := range meta.Validate() {
+				problems = append(problems, Problem{Path: path, Sku: meta.Sku, Message: err.Error()})
+			}
+			if meta.Sku == "" {
+				continue
+			}
+			if other, ok := skus[meta.Sku]; ok {
+				problems = append(problems, Problem{Path: path, Sku: meta.Sku, Message: "Duplicate sku, also found at " + other})
+				continue
+			}
+			skus[meta.Sku] = path
+		}
+	}
+	return problems
+}
+
+// SitemapPaths reads the paths of all pages listed in the sitemap at the path,
+// following sitemap indexes. Pages are fetched from the configured site, even
+// if the sitemap lists them with another host.
+func (c *Cache) SitemapPaths(path string) ([]string, error) {
+	paths := []string{}
+	seen := map[string]bool{}
+	queue := []string{path}
+	for len(queue) > 0 {
+		path, queue = queue[0], queue[1:]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		sitemap, err := c.fetchSitemap(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, location := range sitemap.Sitemaps {
+			queue = append(queue, sitePath(location.Loc))
+		}
+		for _, location := range sitemap.URLs {
+			paths = append(paths, sitePath(location.Loc))
+		}
+	}
+	return paths, nil
+}
+
+func (c *Cache) fetchSitemap(path string) (*sitemap, error) {
+	req, err := c.newRequest(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to fetch sitemap '%s'", path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Sitemap '%s' returned %v", path, resp.StatusCode)
+	}
+
+	sitemap := &sitemap{}
+	if err := xml.NewDecoder(resp.Body).Decode(sitemap); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing sitemap '%s'", path)
+	}
+	return sitemap, nil
+}
+
+func sitePath(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return location
+	}
+	return u.RequestURI()
+}
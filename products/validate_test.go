@@ -0,0 +1,106 @@
+package products
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+)
+
+func TestSitemapPaths(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/products.xml</loc></sitemap>
+	<sitemap><loc>https://example.com/sitemap.xml</loc></sitemap>
+</sitemapindex>`)
+		case "/products.xml":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://example.com/batarang</loc></url>
+	<url><loc>https://example.com/grapple?color=black</loc></url>
+</urlset>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+
+	paths, err := testCache(svr.URL, 0).SitemapPaths("/sitemap.xml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/batarang", "/grapple?color=black"}, paths)
+
+	_, err = testCache(svr.URL, 0).SitemapPaths("/missing.xml")
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/batarang":
+			fmt.Fprintf(w, productPage, "")
+		case "/broken":
+			fmt.Fprintf(w, productPage, `<script class="gocommerce-product">
+				{"sku": "grapple", "prices": [{"amount": "9,99"}, {"amount": "9.99", "currency": "USD", "tiers": [{"min_quantity": 1, "amount": "8.99"}, {"min_quantity": 10, "amount": "8,99"}]}, {"amount": "5.00", "currency": "EUR", "minimum": "6.00"}], "addons": [{"sku": "rope"}, {"sku": "rope", "prices": [{"amount": "1.00", "currency": "USD"}]}], "downloads": [{"title": "Manual"}], "recurring": {"interval": "fortnight"}}
+			</script>`)
+		case "/about":
+			fmt.Fprint(w, "<html><body>About</body></html>")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 0)
+
+	problems := cache.Validate([]string{"/batarang", "/about"}, false)
+	assert.Empty(t, problems)
+
+	problems = cache.Validate([]string{"/about"}, true)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "/about", problems[0].Path)
+
+	problems = cache.Validate([]string{"/batarang", "/broken", "/missing"}, false)
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	assert.Equal(t, []string{
+		"/broken [batarang]: Duplicate sku, also found at /batarang",
+		"/broken [grapple]: Missing title",
+		"/broken [grapple]: Price 1 is missing a currency",
+		"/broken [grapple]: Price 1 has an invalid amount '9,99'",
+		"/broken [grapple]: Price 2 tier 1 has a min_quantity below 2",
+		"/broken [grapple]: Price 2 tier 2 has an invalid amount '8,99'",
+		"/broken [grapple]: Price 3 has a minimum above its amount",
+		"/broken [grapple]: Addon 1 has no prices",
+		"/broken [grapple]: Addon 2 has the duplicate sku rope",
+		"/broken [grapple]: Recurring interval 'fortnight' is unknown",
+		"/broken [grapple]: Download 1 is missing a url",
+		"/missing: Product page '/missing' not found",
+	}, messages)
+}
+
+func TestValidateCatalog(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"products": [
+			{"sku": "batarang", "title": "Batarang", "prices": [{"amount": "9.99", "currency": "USD"}]},
+			{"sku": "batarang", "title": "Batarang", "prices": [{"amount": "9.99", "currency": "USD", "claims": {"": "gold"}}]}
+		]}`)
+	}))
+	defer svr.Close()
+	config := &conf.Configuration{SiteURL: svr.URL}
+	config.Products.Source = JSONSource
+	config.Products.CatalogURL = "/gocommerce/products.json"
+
+	problems := NewCache(config).Validate(nil, false)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "Price 1 has an empty claim", problems[0].Message)
+	assert.Equal(t, "Duplicate sku, also found at /gocommerce/products.json", problems[1].Message)
+}
@@ -0,0 +1,183 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/httpcache"
+	"github.com/pkg/errors"
+)
+
+const fetchTimeout = 10 * time.Second
+
+// Cache keeps the settings.json of a site, so orders don't fetch it for every
+// price calculation. The settings are cached for the max-age of their
+// Cache-Control header, or the configured TTL, and are revalidated with their
+// ETag or Last-Modified date once stale. The last known good settings are used
+// while the site can't be reached or serves broken settings. Sites without
+// valid settings fail to load them, so orders aren't priced without taxes.
+type Cache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mutex     sync.Mutex
+	current   *version
+	lastError error
+}
+
+type version struct {
+	settings   *calculator.Settings
+	validators httpcache.Validators
+	fetchedAt  time.Time
+	lifetime   time.Duration
+	expires    time.Time
+}
+
+// Status describes the settings in the cache.
+type Status struct {
+	FetchedAt  *time.Time `json:"fetched_at,omitempty"`
+	Errors     []string   `json:"errors"`
+	FetchError string     `json:"fetch_error,omitempty"`
+}
+
+// InvalidError is returned for settings that don't validate.
+type InvalidError struct {
+	Problems []string
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("Site settings are invalid: %s", strings.Join(e.Problems, "; "))
+}
+
+// NewCache creates a settings cache for the site of the configuration.
+func NewCache(config *conf.Configuration) *Cache {
+	return &Cache{
+		url:    config.SettingsURL(),
+		ttl:    time.Duration(config.Settings.CacheTTL) * time.Second,
+		client: &http.Client{Timeout: fetchTimeout},
+	}
+}
+
+// UsesConfig tells if the cache was created for the site and settings of the
+// configuration.
+func (c *Cache) UsesConfig(config *conf.Configuration) bool {
+	return c.url == config.SettingsURL() &&
+		c.ttl == time.Duration(config.Settings.CacheTTL)*time.Second
+}
+
+// Load returns the settings of the site, fetching them if they are stale.
+// Settings that are missing or don't validate don't replace valid ones fetched
+// earlier. It fails if there are no valid settings to fall back to. The
+// returned settings are a copy that can be modified, but the slices in it are
+// shared.
+func (c *Cache) Load() (*calculator.Settings, error) {
+	c.mutex.Lock()
+	cached := c.current
+	c.mutex.Unlock()
+
+	if cached != nil && time.Now().Before(cached.expires) {
+		return cached.copySettings(), nil
+	}
+
+	fetched, store, err := c.fetch(cached)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastError = err
+	if err != nil {
+		if cached != nil {
+			// keep selling with the last known good settings while the site is down
+			return cached.copySettings(), nil
+		}
+		return nil, err
+	}
+	if !store {
+		// never serve uncacheable settings without fetching them again, but
+		// still keep them to fall back to
+		fetched.validators = httpcache.Validators{}
+		fetched.expires = time.Now()
+	}
+	c.current = fetched
+	return fetched.copySettings(), nil
+}
+
+// Status reports when the cached settings were fetched, and the error of the
+// last fetch if it failed, with the validation errors of settings that were
+// rejected.
+func (c *Cache) Status() *Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	status := &Status{Errors: []string{}}
+	if c.current != nil {
+		fetchedAt := c.current.fetchedAt
+		status.FetchedAt = &fetchedAt
+	}
+	if c.lastError != nil {
+		status.FetchError = c.lastError.Error()
+		if invalid, ok := c.lastError.(*InvalidError); ok {
+			status.Errors = invalid.Problems
+		}
+	}
+	return status
+}
+
+func (c *Cache) fetch(cached *version) (*version, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if cached != nil {
+		cached.validators.Apply(req)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Error loading site settings")
+	}
+	defer resp.Body.Close()
+
+	maxAge, store := httpcache.Freshness(resp.Header, c.ttl)
+	fetched := &version{
+		settings:   &calculator.Settings{},
+		validators: httpcache.ValidatorsOf(resp),
+		fetchedAt:  time.Now(),
+		lifetime:   maxAge,
+		expires:    time.Now().Add(maxAge),
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		revalidated := *cached
+		if resp.Header.Get("Cache-Control") != "" {
+			revalidated.lifetime = maxAge
+		}
+		revalidated.expires = time.Now().Add(revalidated.lifetime)
+		return &revalidated, store, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("Site settings returned %v", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(fetched.settings); err != nil {
+		return nil, false, errors.Wrap(err, "Error parsing site settings")
+	}
+	if errs := fetched.settings.Validate(); len(errs) > 0 {
+		invalid := &InvalidError{Problems: make([]string, len(errs))}
+		for i, err := range errs {
+			invalid.Problems[i] = err.Error()
+		}
+		return nil, false, invalid
+	}
+	return fetched, store, nil
+}
+
+func (v *version) copySettings() *calculator.Settings {
+	settings := *v.settings
+	return &settings
+}
@@ -0,0 +1,153 @@
+package settings
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+)
+
+const siteSettings = `{
+	"taxes": [{"percentage": 19, "product_types": ["E-Book"], "countries": ["DE"]}]
+}`
+
+func testCache(url string, ttl int) *Cache {
+	config := &conf.Configuration{SiteURL: url}
+	config.Settings.CacheTTL = ttl
+	return NewCache(config)
+}
+
+func TestCaching(t *testing.T) {
+	var callCount int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		assert.Equal(t, "/gocommerce/settings.json", r.URL.Path)
+		fmt.Fprint(w, siteSettings)
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	for i := 0; i < 3; i++ {
+		settings, err := cache.Load()
+		require.NoError(t, err)
+		require.Len(t, settings.Taxes, 1)
+		assert.EqualValues(t, 19, settings.Taxes[0].Percentage)
+		settings.PricesIncludeTaxes = true
+	}
+	assert.Equal(t, 1, callCount)
+
+	settings, err := cache.Load()
+	require.NoError(t, err)
+	assert.False(t, settings.PricesIncludeTaxes)
+}
+
+func TestRevalidation(t *testing.T) {
+	var callCount, notModified int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=0")
+		fmt.Fprint(w, siteSettings)
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	for i := 0; i < 3; i++ {
+		settings, err := cache.Load()
+		require.NoError(t, err)
+		assert.Len(t, settings.Taxes, 1)
+	}
+	assert.Equal(t, 3, callCount)
+	assert.Equal(t, 2, notModified)
+}
+
+func TestMissingSettings(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	_, err := cache.Load()
+	assert.EqualError(t, err, "Site settings returned 404")
+	assert.Equal(t, "Site settings returned 404", cache.Status().FetchError)
+}
+
+func TestFetchFailure(t *testing.T) {
+	response := siteSettings
+	status := http.StatusOK
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, response)
+	}))
+	defer svr.Close()
+
+	t.Run("WithoutCopy", func(t *testing.T) {
+		status = http.StatusBadGateway
+		defer func() { status = http.StatusOK }()
+		cache := testCache(svr.URL, 60)
+
+		_, err := cache.Load()
+		assert.Error(t, err)
+		assert.Equal(t, "Site settings returned 502", cache.Status().FetchError)
+	})
+
+	t.Run("WithLastKnownGood", func(t *testing.T) {
+		cache := testCache(svr.URL, 60)
+		_, err := cache.Load()
+		require.NoError(t, err)
+
+		for _, broken := range []struct {
+			status   int
+			response string
+			problems []string
+		}{
+			{http.StatusInternalServerError, siteSettings, []string{}},
+			{http.StatusOK, `{"taxes": [`, []string{}},
+			{http.StatusNotFound, "", []string{}},
+			{http.StatusOK, `{"taxes": [{"percentage": 119, "countries": ["DE"]}]}`, []string{"Tax 1 has a percentage above 100"}},
+		} {
+			status, response = broken.status, broken.response
+			cache.current.expires = time.Now().Add(-time.Second)
+			settings, err := cache.Load()
+			require.NoError(t, err)
+			require.Len(t, settings.Taxes, 1)
+			assert.EqualValues(t, 19, settings.Taxes[0].Percentage)
+			assert.NotEmpty(t, cache.Status().FetchError)
+			assert.Equal(t, broken.problems, cache.Status().Errors)
+		}
+		status, response = http.StatusOK, siteSettings
+	})
+}
+
+func TestStatus(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"taxes": [{"percentage": 119, "countries": ["Germany"]}]}`)
+	}))
+	defer svr.Close()
+	cache := testCache(svr.URL, 60)
+
+	status := cache.Status()
+	assert.Nil(t, status.FetchedAt)
+	assert.Empty(t, status.Errors)
+
+	// invalid settings without an earlier valid copy aren't used
+	_, err := cache.Load()
+	assert.Error(t, err)
+
+	status = cache.Status()
+	assert.Nil(t, status.FetchedAt)
+	assert.Equal(t, []string{"Tax 1 has a percentage above 100"}, status.Errors)
+	assert.Equal(t, "Site settings are invalid: Tax 1 has a percentage above 100", status.FetchError)
+}
@@ -0,0 +1,181 @@
+package vatnumbers
+
+import (
+	"regexp"
+)
+
+// formats are the VAT number formats of the VIES countries, without their
+// country prefix. Greece uses EL and Northern Ireland XI.
+var formats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U\d{8}$`),
+	"BE": regexp.MustCompile(`^[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^\d{9,10}$`),
+	"CY": regexp.MustCompile(`^\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^\d{8,10}$`),
+	"DE": regexp.MustCompile(`^\d{9}$`),
+	"DK": regexp.MustCompile(`^\d{8}$`),
+	"EE": regexp.MustCompile(`^\d{9}$`),
+	"EL": regexp.MustCompile(`^\d{9}$`),
+	"ES": regexp.MustCompile(`^[A-Z0-9]\d{7}[A-Z0-9]$`),
+	"FI": regexp.MustCompile(`^\d{8}$`),
+	"FR": regexp.MustCompile(`^[A-HJ-NP-Z0-9]{2}\d{9}$`),
+	"HR": regexp.MustCompile(`^\d{11}$`),
+	"HU": regexp.MustCompile(`^\d{8}$`),
+	"IE": regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^\d{11}$`),
+	"LT": regexp.MustCompile(`^(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^\d{8}$`),
+	"LV": regexp.MustCompile(`^\d{11}$`),
+	"MT": regexp.MustCompile(`^\d{8}$`),
+	"NL": regexp.MustCompile(`^\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^\d{10}$`),
+	"PT": regexp.MustCompile(`^\d{9}$`),
+	"RO": regexp.MustCompile(`^\d{2,10}$`),
+	"SE": regexp.MustCompile(`^\d{10}01$`),
+	"SI": regexp.MustCompile(`^\d{8}$`),
+	"SK": regexp.MustCompile(`^\d{10}$`),
+	"XI": regexp.MustCompile(`^(\d{9}|\d{12}|GD\d{3}|HA\d{3})$`),
+}
+
+// checksums verify the check digits of the countries whose algorithm is
+// public, on numbers that already have the right format.
+var checksums = map[string]func(string) bool{
+	"AT": checkAT,
+	"BE": checkBE,
+	"DE": checkDE,
+	"DK": checkDK,
+	"FI": checkFI,
+	"IT": checkIT,
+	"LU": checkLU,
+	"PL": checkPL,
+	"PT": checkPT,
+}
+
+// ValidFormat tells if a normalized VAT number has the format of its
+// country, and the right check digits where those can be verified. It
+// doesn't tell if the number was ever issued.
+func ValidFormat(number string) bool {
+	if len(number) < 3 {
+		return false
+	}
+	country, rest := number[:2], number[2:]
+	format, ok := formats[country]
+	if !ok || !format.MatchString(rest) {
+		return false
+	}
+	if check, ok := checksums[country]; ok {
+		return check(rest)
+	}
+	return true
+}
+
+func digits(s string) []int {
+	result := make([]int, 0, len(s))
+	for _, c := range s {
+		result = append(result, int(c-'0'))
+	}
+	return result
+}
+
+func weightedSum(d []int, weights ...int) int {
+	sum := 0
+	for i, weight := range weights {
+		sum += d[i] * weight
+	}
+	return sum
+}
+
+func checkAT(number string) bool {
+	d := digits(number[1:])
+	sum := 0
+	for i := 0; i < 7; i++ {
+		if i%2 == 0 {
+			sum += d[i]
+		} else {
+			doubled := d[i] * 2
+			sum += doubled/10 + doubled%10
+		}
+	}
+	return (10-(sum+4)%10)%10 == d[7]
+}
+
+func checkBE(number string) bool {
+	d := digits(number)
+	base := 0
+	for _, digit := range d[:8] {
+		base = base*10 + digit
+	}
+	return 97-base%97 == d[8]*10+d[9]
+}
+
+func checkDE(number string) bool {
+	d := digits(number)
+	product := 10
+	for _, digit := range d[:8] {
+		sum := (digit + product) % 10
+		if sum == 0 {
+			sum = 10
+		}
+		product = (2 * sum) % 11
+	}
+	check := 11 - product
+	if check == 10 {
+		check = 0
+	}
+	return check == d[8]
+}
+
+func checkDK(number string) bool {
+	return weightedSum(digits(number), 2, 7, 6, 5, 4, 3, 2, 1)%11 == 0
+}
+
+func checkFI(number string) bool {
+	d := digits(number)
+	remainder := weightedSum(d, 7, 9, 10, 5, 8, 4, 2) % 11
+	switch remainder {
+	case 0:
+		return d[7] == 0
+	case 1:
+		return false
+	}
+	return 11-remainder == d[7]
+}
+
+func checkIT(number string) bool {
+	// Luhn
+	d := digits(number)
+	sum := 0
+	for i, digit := range d {
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+func checkLU(number string) bool {
+	d := digits(number)
+	base := 0
+	for _, digit := range d[:6] {
+		base = base*10 + digit
+	}
+	return base%89 == d[6]*10+d[7]
+}
+
+func checkPL(number string) bool {
+	d := digits(number)
+	return weightedSum(d, 6, 5, 7, 2, 3, 4, 5, 6, 7)%11 == d[9]
+}
+
+func checkPT(number string) bool {
+	d := digits(number)
+	check := 11 - weightedSum(d, 9, 8, 7, 6, 5, 4, 3, 2)%11
+	if check > 9 {
+		check = 0
+	}
+	return check == d[8]
+}
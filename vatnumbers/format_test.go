@@ -0,0 +1,30 @@
+package vatnumbers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidFormat(t *testing.T) {
+	valid := []string{
+		"ATU13585627", "BE0428759497", "DE136695976", "DK13585628", "FI20774740",
+		"IT00743110157", "LU15027442", "PL8567346215", "PT501964843",
+		"NL004495445B01", "SE556188840401", "FRXX123456789", "ESX1234567X", "EL123456789",
+	}
+	for _, number := range valid {
+		assert.True(t, ValidFormat(number), number)
+	}
+
+	invalid := []string{
+		"", "DE", "DE13669597", "DE136695977", "ATU13585628", "BE0428759498",
+		"IT00743110158", "NL004495445A01", "GR123456789", "US123456789", "de136695976",
+	}
+	for _, number := range invalid {
+		assert.False(t, ValidFormat(number), number)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "DE136695976", Normalize(" de 136.695-976 "))
+}
@@ -0,0 +1,159 @@
+package vatnumbers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattes/vat"
+	"github.com/netlify/gocommerce/conf"
+)
+
+// Policies for VAT numbers that can't be verified because VIES is unavailable.
+const (
+	// PolicyReject fails the order.
+	PolicyReject = "reject"
+	// PolicyAccept treats the VAT number as valid.
+	PolicyAccept = "accept"
+	// PolicyFlag treats the VAT number as valid, but flags the order so it
+	// can be verified later.
+	PolicyFlag = "flag"
+)
+
+// ValidPolicy tells if the policy for unverifiable VAT numbers is known.
+func ValidPolicy(policy string) bool {
+	switch policy {
+	case PolicyReject, PolicyAccept, PolicyFlag:
+		return true
+	}
+	return false
+}
+
+// UnavailableError is returned when a VAT number can't be verified because
+// the validation service can't be reached or didn't answer.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("VAT number validation service is unavailable: %v", e.Err)
+}
+
+// Result is the outcome of a VAT number check, with the registered name and
+// address of the business if the service discloses them.
+type Result struct {
+	CountryCode string
+	Number      string
+	Valid       bool
+	Name        string
+	Address     string
+}
+
+// Checker verifies VAT numbers with a validation service. It returns an
+// UnavailableError if the service can't give an answer.
+type Checker interface {
+	Check(number string) (*Result, error)
+}
+
+// VIES checks VAT numbers with the VIES service of the European Commission.
+type VIES struct{}
+
+// Check verifies the VAT number with VIES.
+func (VIES) Check(number string) (*Result, error) {
+	resp, err := vat.CheckVAT(number)
+	if err == vat.ErrVATnumberNotValid {
+		return &Result{CountryCode: countryPrefix(number), Number: number}, nil
+	}
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	return &Result{
+		CountryCode: resp.CountryCode,
+		Number:      resp.CountryCode + resp.VATnumber,
+		Valid:       resp.Valid,
+		Name:        resp.Name,
+		Address:     resp.Address,
+	}, nil
+}
+
+// Cache keeps the VAT numbers confirmed by a checker for the configured TTL,
+// so repeated orders of a business don't depend on the checker being up.
+// Numbers that don't have the format of their country are refused without
+// asking the checker.
+type Cache struct {
+	checker Checker
+	ttl     time.Duration
+
+	mutex   sync.Mutex
+	results map[string]*cachedResult
+}
+
+type cachedResult struct {
+	result  *Result
+	expires time.Time
+}
+
+// NewCache creates a VAT number cache with the TTL of the configuration.
+func NewCache(config *conf.Configuration, checker Checker) *Cache {
+	return &Cache{
+		checker: checker,
+		ttl:     time.Duration(config.VAT.CacheTTL) * time.Second,
+		results: map[string]*cachedResult{},
+	}
+}
+
+// UsesConfig tells if the cache was created with the TTL of the configuration.
+func (c *Cache) UsesConfig(config *conf.Configuration) bool {
+	return c.ttl == time.Duration(config.VAT.CacheTTL)*time.Second
+}
+
+// Check verifies the VAT number, using a cached result if there is one.
+func (c *Cache) Check(number string) (*Result, error) {
+	number = Normalize(number)
+	if !ValidFormat(number) {
+		return &Result{CountryCode: countryPrefix(number), Number: number}, nil
+	}
+
+	c.mutex.Lock()
+	cached, ok := c.results[number]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		copied := *cached.result
+		return &copied, nil
+	}
+
+	result, err := c.checker.Check(number)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if result.Valid {
+		copied := *result
+		c.results[number] = &cachedResult{result: &copied, expires: time.Now().Add(c.ttl)}
+	} else {
+		delete(c.results, number)
+	}
+	return result, nil
+}
+
+// Normalize removes the spaces, dots and dashes people use to group the
+// digits of VAT numbers, and upper cases the letters.
+func Normalize(number string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(number)))
+}
+
+func countryPrefix(number string) string {
+	if len(number) < 2 {
+		return ""
+	}
+	return number[:2]
+}
@@ -0,0 +1,83 @@
+package vatnumbers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netlify/gocommerce/conf"
+)
+
+type testChecker struct {
+	valid       map[string]bool
+	unavailable bool
+	calls       int
+}
+
+func (c *testChecker) Check(number string) (*Result, error) {
+	c.calls++
+	if c.unavailable {
+		return nil, &UnavailableError{Err: errors.New("MS_UNAVAILABLE")}
+	}
+	return &Result{CountryCode: number[:2], Number: number, Valid: c.valid[number]}, nil
+}
+
+func testCache(checker Checker) *Cache {
+	config := &conf.Configuration{}
+	config.VAT.CacheTTL = 60
+	return NewCache(config, checker)
+}
+
+func TestCacheKeepsValidNumbers(t *testing.T) {
+	checker := &testChecker{valid: map[string]bool{"DE136695976": true}}
+	cache := testCache(checker)
+
+	result, err := cache.Check("DE 136 695 976")
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, "DE", result.CountryCode)
+
+	checker.unavailable = true
+	result, err = cache.Check("DE136695976")
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, 1, checker.calls)
+}
+
+func TestCacheRechecksInvalidNumbers(t *testing.T) {
+	checker := &testChecker{valid: map[string]bool{}}
+	cache := testCache(checker)
+
+	for i := 0; i < 2; i++ {
+		result, err := cache.Check("DK13585628")
+		require.NoError(t, err)
+		assert.False(t, result.Valid)
+	}
+	assert.Equal(t, 2, checker.calls)
+}
+
+func TestCacheExpires(t *testing.T) {
+	checker := &testChecker{valid: map[string]bool{"DK13585628": true}}
+	cache := testCache(checker)
+	cache.ttl = 0
+
+	_, err := cache.Check("DK13585628")
+	require.NoError(t, err)
+	checker.unavailable = true
+	_, err = cache.Check("DK13585628")
+	require.Error(t, err)
+	_, ok := err.(*UnavailableError)
+	assert.True(t, ok)
+}
+
+func TestCacheRefusesMalformedNumbers(t *testing.T) {
+	checker := &testChecker{unavailable: true}
+	cache := testCache(checker)
+
+	result, err := cache.Check("DE136695977")
+	require.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, 0, checker.calls)
+}